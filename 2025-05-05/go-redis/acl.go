@@ -0,0 +1,606 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ACL 同 Redis 6+: 每个连接以某个用户的身份执行命令, 用户有密码、可执行的命令和可访问的 key pattern。
+// 新连接默认是 default 用户, default 用户设置了密码 (requirepass) 时必须先 AUTH。
+// 用户定义保存在 aclPath 里, 每行是一条 ACL LIST 格式的 "user <name> <rules...>",
+// 启动时加载, ACL SETUSER / DELUSER 修改后立即写回
+
+var (
+	aclPath     = "users.acl"
+	requirepass = "" // 非空时作为 default 用户的密码, 同 Redis 的 requirepass 配置
+
+	// 以下都由 dbMu 保护
+	aclUsers = make(map[string]*aclUser)
+)
+
+const (
+	errNoAuth    = "NOAUTH Authentication required."
+	errWrongPass = "WRONGPASS invalid username-password pair or user is disabled."
+	errNoPermKey = "NOPERM No permissions to access a key"
+	errACLSave   = "ERR There was an error trying to save the ACLs. Please check the server logs for more information"
+)
+
+// aclUser 是一个 ACL 用户
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // sha256 的十六进制
+	allKeys   bool
+	keys      []string // key 的 glob pattern
+	allowed   map[string]bool
+	cmdRules  []string // 生成 allowed 的命令规则, 第一条总是 +@all 或 -@all, ACL LIST 原样输出
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{name: name, allowed: make(map[string]bool), cmdRules: []string{"-@all"}}
+}
+
+func (u *aclUser) clone() *aclUser {
+	cp := *u
+	cp.passwords = slices.Clone(u.passwords)
+	cp.keys = slices.Clone(u.keys)
+	cp.allowed = maps.Clone(u.allowed)
+	cp.cmdRules = slices.Clone(u.cmdRules)
+	return &cp
+}
+
+// categoryCommands 返回分类包含的命令, 分类不存在返回 nil。
+// 分类由命令表推导 (见 redisCommand.aclCategories), all 是全部命令
+func categoryCommands(cat string) []string {
+	var cmds []string
+	for name, cmd := range commands {
+		if cat == "all" || slices.Contains(cmd.categories, cat) {
+			cmds = append(cmds, name)
+		}
+	}
+	return cmds
+}
+
+// aclCategoryNames 返回所有 ACL 分类, 按名字排序
+func aclCategoryNames() []string {
+	var cats []string
+	for _, cmd := range commands {
+		cats = append(cats, cmd.categories...)
+	}
+	slices.Sort(cats)
+	return slices.Compact(cats)
+}
+
+// migrateKeys 取出 MIGRATE 的 key: 第 3 个参数, 为空时是 KEYS 之后的所有参数
+func migrateKeys(args []string) []string {
+	if len(args) < 6 {
+		return nil
+	}
+	if args[3] != "" {
+		return args[3:4]
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.EqualFold(args[i], "KEYS") {
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// streamKeys 取出 XREAD / XREADGROUP 的 key: STREAMS 之后参数的前一半
+func streamKeys(args []string) []string {
+	for i := 1; i < len(args); i++ {
+		if strings.EqualFold(args[i], "STREAMS") {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// keySpec 描述命令参数里哪些是 key, 同 Redis 命令表的 firstkey / lastkey / step,
+// last 为负数表示从末尾倒数
+type keySpec struct{ first, last, step int }
+
+// commandKeys 取出命令参数里的 key, 未知命令返回 nil
+func commandKeys(name string, args []string) []string {
+	cmd := lookupCommand(name)
+	if cmd == nil {
+		return nil
+	}
+	return cmd.keysOf(args)
+}
+
+// keysOf 按命令表里的 key 位置取出参数里的 key
+func (cmd *redisCommand) keysOf(args []string) []string {
+	if cmd.getKeys != nil {
+		return cmd.getKeys(args)
+	}
+	spec := cmd.keys
+	if spec.step == 0 {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := spec.first; i <= last && i < len(args); i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func hashPassword(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword 判断密码能否登录这个用户
+func (u *aclUser) checkPassword(pass string) bool {
+	if !u.enabled {
+		return false
+	}
+	return u.nopass || slices.Contains(u.passwords, hashPassword(pass))
+}
+
+// setCommandRule 按 +cmd / -cmd / +@cat / -@cat 修改可执行的命令
+func (u *aclUser) setCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+	var cmds []string
+	if cat, ok := strings.CutPrefix(name, "@"); ok {
+		cat = strings.ToLower(cat)
+		cmds = categoryCommands(cat)
+		if cmds == nil {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		if cat == "all" {
+			clear(u.allowed)
+			u.cmdRules = u.cmdRules[:0]
+		}
+	} else {
+		name = strings.ToUpper(name)
+		if _, ok := commands[name]; !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		cmds = []string{name}
+	}
+	for _, cmd := range cmds {
+		if allow {
+			u.allowed[cmd] = true
+		} else {
+			delete(u.allowed, cmd)
+		}
+	}
+	u.cmdRules = append(u.cmdRules, strings.ToLower(rule))
+	return nil
+}
+
+// setRule 应用一条 ACL SETUSER 规则
+func (u *aclUser) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+	case "allkeys", "~*":
+		u.allKeys, u.keys = true, nil
+	case "resetkeys":
+		u.allKeys, u.keys = false, nil
+	case "allcommands":
+		return u.setCommandRule("+@all")
+	case "nocommands":
+		return u.setCommandRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "-@all"} {
+			u.setRule(r)
+		}
+	default:
+		if rule == "" {
+			return errors.New("Syntax error")
+		}
+		switch rule[0] {
+		case '>':
+			if h := hashPassword(rule[1:]); !slices.Contains(u.passwords, h) {
+				u.passwords = append(u.passwords, h)
+			}
+			u.nopass = false
+		case '#':
+			h := strings.ToLower(rule[1:])
+			if _, err := hex.DecodeString(h); err != nil || len(h) != 2*sha256.Size {
+				return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			if !slices.Contains(u.passwords, h) {
+				u.passwords = append(u.passwords, h)
+			}
+			u.nopass = false
+		case '<', '!':
+			h := strings.ToLower(rule[1:])
+			if rule[0] == '<' {
+				h = hashPassword(rule[1:])
+			}
+			i := slices.Index(u.passwords, h)
+			if i < 0 {
+				return errors.New("no such password")
+			}
+			u.passwords = slices.Delete(u.passwords, i, i+1)
+		case '~':
+			if !u.allKeys && !slices.Contains(u.keys, rule[1:]) {
+				u.keys = append(u.keys, rule[1:])
+			}
+		case '+', '-':
+			if len(rule) == 1 {
+				return errors.New("Syntax error")
+			}
+			return u.setCommandRule(rule)
+		default:
+			return errors.New("Syntax error")
+		}
+	}
+	return nil
+}
+
+// describe 按 ACL LIST 的格式输出用户的规则, 也是 ACL 文件的格式
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name, "off"}
+	if u.enabled {
+		parts[2] = "on"
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, h := range u.passwords {
+		parts = append(parts, "#"+h)
+	}
+	if u.allKeys {
+		parts = append(parts, "~*")
+	}
+	for _, k := range u.keys {
+		parts = append(parts, "~"+k)
+	}
+	parts = append(parts, u.cmdRules...)
+	return strings.Join(parts, " ")
+}
+
+// defaultACLUser 是没有任何配置时的 default 用户: 免密码, 可以执行所有命令
+func defaultACLUser() *aclUser {
+	u := newACLUser("default")
+	for _, r := range []string{"on", "nopass", "allkeys", "allcommands"} {
+		u.setRule(r)
+	}
+	return u
+}
+
+// setUser 对用户依次应用规则, 用户不存在时创建。任何一条规则出错都不做修改
+func setUser(name string, rules []string) error {
+	old := aclUsers[name]
+	var u *aclUser
+	if old != nil {
+		u = old.clone()
+	} else {
+		u = newACLUser(name)
+	}
+	for _, r := range rules {
+		if err := u.setRule(r); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", r, err)
+		}
+	}
+	// 原地更新, 已经以这个用户登录的连接立即按新规则检查
+	if old != nil {
+		*old = *u
+	} else {
+		aclUsers[name] = u
+	}
+	return nil
+}
+
+// deleteUser 删除用户, 并断开以它登录的连接
+func deleteUser(name string) bool {
+	u, ok := aclUsers[name]
+	if !ok {
+		return false
+	}
+	delete(aclUsers, name)
+	for _, c := range clients {
+		if c.user == u {
+			c.closeAsync()
+		}
+	}
+	return true
+}
+
+// initClientAuth 给新连接设置 default 用户; default 用户免密码时无需 AUTH
+func initClientAuth(c *client) {
+	c.user = aclUsers["default"]
+	c.authenticated = c.user.enabled && c.user.nopass
+}
+
+// aclCheckCommand 检查当前用户能否执行这条命令, 返回错误回复, 允许时为空。
+// 没有用户的内部连接 (重放 AOF、master 复制流) 不做检查
+func aclCheckCommand(c *client, cmd *redisCommand, args []string) string {
+	u := c.user
+	if u == nil || cmd.flags&cmdNoAuth != 0 {
+		return ""
+	}
+	if !u.allowed[cmd.name] {
+		return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", u.name, strings.ToLower(cmd.name))
+	}
+	if u.allKeys {
+		return ""
+	}
+	for _, key := range cmd.keysOf(args) {
+		if !slices.ContainsFunc(u.keys, func(p string) bool { return globMatch(p, key) }) {
+			return errNoPermKey
+		}
+	}
+	return ""
+}
+
+// authRequired 判断连接是否还需要先 AUTH
+func authRequired(c *client) bool {
+	return c.user != nil && !c.authenticated
+}
+
+// authenticate 校验用户名和密码, 成功后连接切换为这个用户
+func authenticate(c *client, name, pass string) bool {
+	u := aclUsers[name]
+	if u == nil || !u.checkPassword(pass) {
+		return false
+	}
+	c.user, c.authenticated = u, true
+	return true
+}
+
+// AUTH [username] password
+func authCommand(c *client, args []string) {
+	w := c.w
+	if len(args) != 2 && len(args) != 3 {
+		w.writeError(errWrongArgs("AUTH"))
+		return
+	}
+	name, pass := "default", args[1]
+	if len(args) == 3 {
+		name, pass = args[1], args[2]
+	} else if d := aclUsers["default"]; d.nopass {
+		w.writeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	if !authenticate(c, name, pass) {
+		w.writeError(errWrongPass)
+		return
+	}
+	w.writeOK()
+}
+
+// loadACLFile 从 aclPath 加载用户, 文件不存在时只有 default 用户。
+// 文件有错时不做任何修改
+func loadACLFile() error {
+	users := map[string]*aclUser{"default": defaultACLUser()}
+	f, err := os.Open(aclPath)
+	if errors.Is(err, os.ErrNotExist) {
+		installACLUsers(users)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user keyword", aclPath, n)
+		}
+		u := users[fields[1]]
+		if u == nil || fields[1] == "default" {
+			// 文件里的定义是完整的, default 也从空白开始
+			u = newACLUser(fields[1])
+			users[fields[1]] = u
+		}
+		for _, r := range fields[2:] {
+			if err := u.setRule(r); err != nil {
+				return fmt.Errorf("%s:%d: %v. Use ACL SETUSER '%s' to check the rule", aclPath, n, err, r)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	installACLUsers(users)
+	return nil
+}
+
+// installACLUsers 用加载的用户替换当前的用户表: 同名用户原地更新, 已删除用户的连接断开
+func installACLUsers(users map[string]*aclUser) {
+	for name := range aclUsers {
+		if users[name] == nil {
+			deleteUser(name)
+		}
+	}
+	for name, u := range users {
+		if old := aclUsers[name]; old != nil {
+			*old = *u
+		} else {
+			aclUsers[name] = u
+		}
+	}
+}
+
+// saveACLFile 把所有用户写回 aclPath, 先写临时文件再 rename
+func saveACLFile() error {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(aclUsers)) {
+		b.WriteString(aclUsers[name].describe())
+		b.WriteByte('\n')
+	}
+	tmp := aclPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, aclPath)
+}
+
+// applyRequirepass 让 requirepass 成为 default 用户唯一的密码
+func applyRequirepass() {
+	if requirepass != "" {
+		setUser("default", []string{"resetpass", ">" + requirepass})
+	}
+}
+
+// initACL 启动时加载 ACL 文件
+func initACL() error {
+	if err := loadACLFile(); err != nil {
+		return fmt.Errorf("load ACL file error: %v", err)
+	}
+	applyRequirepass()
+	return nil
+}
+
+// ACL SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI|CAT|LOAD|SAVE ...
+func aclCommand(c *client, args []string) {
+	w := c.w
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"SETUSER": -3, "GETUSER": 3, "DELUSER": -3, "LIST": 2, "USERS": 2,
+		"WHOAMI": 2, "CAT": -2, "LOAD": 2, "SAVE": 2}[sub]
+	if arity == 0 {
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try ACL HELP.")
+		return
+	}
+	if !arityOK(arity, len(args)) {
+		w.writeError(errWrongArgs("ACL|" + sub))
+		return
+	}
+	switch sub {
+	case "SETUSER":
+		if err := setUser(args[2], args[3:]); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		aclSaveReply(w)
+	case "GETUSER":
+		aclGetuser(w, args[2])
+	case "DELUSER":
+		n := 0
+		for _, name := range args[2:] {
+			if name == "default" {
+				w.writeError("ERR The 'default' user cannot be removed")
+				return
+			}
+		}
+		for _, name := range args[2:] {
+			if deleteUser(name) {
+				n++
+			}
+		}
+		if n > 0 {
+			if err := saveACLFile(); err != nil {
+				log.Printf("save ACL file error: %v", err)
+				w.writeError(errACLSave)
+				return
+			}
+		}
+		w.writeInt(int64(n))
+	case "LIST", "USERS":
+		names := slices.Sorted(maps.Keys(aclUsers))
+		w.writeArray(len(names))
+		for _, name := range names {
+			if sub == "LIST" {
+				w.writeBulk(aclUsers[name].describe())
+			} else {
+				w.writeBulk(name)
+			}
+		}
+	case "WHOAMI":
+		name := "default"
+		if c.user != nil {
+			name = c.user.name
+		}
+		w.writeBulk(name)
+	case "CAT":
+		if len(args) == 2 {
+			w.writeBulks(aclCategoryNames()...)
+			return
+		}
+		cmds := categoryCommands(strings.ToLower(args[2]))
+		if cmds == nil || args[2] == "all" {
+			w.writeError("ERR Unknown category '" + args[2] + "'")
+			return
+		}
+		slices.Sort(cmds)
+		w.writeArray(len(cmds))
+		for _, cmd := range cmds {
+			w.writeBulk(strings.ToLower(cmd))
+		}
+	case "LOAD":
+		if err := loadACLFile(); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeOK()
+	case "SAVE":
+		aclSaveReply(w)
+	}
+}
+
+// aclSaveReply 写回 ACL 文件并回复 OK
+func aclSaveReply(w *replyWriter) {
+	if err := saveACLFile(); err != nil {
+		log.Printf("save ACL file error: %v", err)
+		w.writeError(errACLSave)
+		return
+	}
+	w.writeOK()
+}
+
+// aclGetuser 按 Redis 的格式回复用户的 flags、passwords、commands、keys
+func aclGetuser(w *replyWriter, name string) {
+	u := aclUsers[name]
+	if u == nil {
+		w.writeNull()
+		return
+	}
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, 0, len(u.keys))
+	if u.allKeys {
+		keys = append(keys, "~*")
+	}
+	for _, k := range u.keys {
+		keys = append(keys, "~"+k)
+	}
+	w.writeMap(4)
+	w.writeBulk("flags")
+	w.writeSet(len(flags))
+	for _, f := range flags {
+		w.writeBulk(f)
+	}
+	w.writeBulk("passwords")
+	w.writeBulks(u.passwords...)
+	w.writeBulk("commands")
+	w.writeBulk(strings.Join(u.cmdRules, " "))
+	w.writeBulk("keys")
+	w.writeBulk(strings.Join(keys, " "))
+}
@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuthClient 同 newTestClient, 但像网络连接一样以 default 用户的身份执行命令
+func newAuthClient() *testClient {
+	tc := newTestClient()
+	dbMu.Lock()
+	initClientAuth(tc.client)
+	dbMu.Unlock()
+	return tc
+}
+
+// resetACL 在测试结束后恢复成只有免密码的 default 用户
+func resetACL(t *testing.T) {
+	t.Cleanup(func() {
+		dbMu.Lock()
+		requirepass = ""
+		installACLUsers(map[string]*aclUser{"default": defaultACLUser()})
+		dbMu.Unlock()
+		os.Remove(aclPath)
+	})
+}
+
+func TestAuthRequirepass(t *testing.T) {
+	resetKeyspace()
+	resetACL(t)
+	expectReply(t, newAuthClient(), "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n", "AUTH", "x")
+
+	dbMu.Lock()
+	requirepass = "secret"
+	applyRequirepass()
+	dbMu.Unlock()
+	tc := newAuthClient()
+	expectReply(t, tc, "-"+errNoAuth+"\r\n", "GET", "k")
+	expectReply(t, tc, "-"+errNoAuth+"\r\n", "NOSUCHCOMMAND")
+	expectReply(t, tc, "-"+errWrongPass+"\r\n", "AUTH", "wrong")
+	expectReply(t, tc, "-"+errWrongPass+"\r\n", "AUTH", "nobody", "secret")
+	if got := tc.do("HELLO", "3"); !strings.HasPrefix(got, "-NOAUTH HELLO must be called") {
+		t.Fatalf("HELLO before AUTH: %q", got)
+	}
+	expectReply(t, tc, "+OK\r\n", "AUTH", "secret")
+	expectReply(t, tc, "$-1\r\n", "GET", "k")
+
+	// HELLO 可以同时认证和切换协议
+	tc = newAuthClient()
+	expectReply(t, tc, "-"+errWrongPass+"\r\n", "HELLO", "3", "AUTH", "default", "wrong")
+	if got := tc.do("HELLO", "3", "AUTH", "default", "secret"); !strings.HasPrefix(got, "%7\r\n") {
+		t.Fatalf("HELLO AUTH: %q", got)
+	}
+	expectReply(t, tc, "_\r\n", "GET", "k")
+}
+
+func TestACLPermissions(t *testing.T) {
+	resetKeyspace()
+	resetACL(t)
+	admin := newAuthClient()
+	expectReply(t, admin, "+OK\r\n", "ACL", "SETUSER", "alice", "on", ">pw", "~app:*", "+@read", "+set", "+multi", "+exec", "-keys")
+	tc := newAuthClient()
+	expectReply(t, tc, "+OK\r\n", "AUTH", "alice", "pw")
+	expectReply(t, tc, "+OK\r\n", "SET", "app:1", "v")
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "app:1")
+	expectReply(t, tc, "-"+errNoPermKey+"\r\n", "GET", "other")
+	expectReply(t, tc, "-"+errNoPermKey+"\r\n", "EXISTS", "app:1", "other")
+	expectReply(t, tc, "-NOPERM User alice has no permissions to run the 'del' command\r\n", "DEL", "app:1")
+	expectReply(t, tc, "-NOPERM User alice has no permissions to run the 'keys' command\r\n", "KEYS", "*")
+	expectReply(t, tc, "-NOPERM User alice has no permissions to run the 'acl' command\r\n", "ACL", "WHOAMI")
+
+	// 入队时被拒绝的命令让事务整体放弃
+	expectReply(t, tc, "+OK\r\n", "MULTI")
+	expectReply(t, tc, "-"+errNoPermKey+"\r\n", "GET", "other")
+	expectReply(t, tc, "-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+
+	// 入队之后权限被收回, EXEC 时逐条检查
+	expectReply(t, tc, "+OK\r\n", "MULTI")
+	expectReply(t, tc, "+QUEUED\r\n", "GET", "app:1")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "app:2", "v")
+	expectReply(t, admin, "+OK\r\n", "ACL", "SETUSER", "alice", "-get")
+	expectReply(t, tc, "*2\r\n-NOPERM User alice has no permissions to run the 'get' command\r\n+OK\r\n", "EXEC")
+
+	// 被禁用的用户不能再登录, 已经登录的连接不受影响
+	expectReply(t, admin, "+OK\r\n", "ACL", "SETUSER", "alice", "off")
+	expectReply(t, newAuthClient(), "-"+errWrongPass+"\r\n", "AUTH", "alice", "pw")
+	expectReply(t, tc, ":1\r\n", "STRLEN", "app:2")
+}
+
+func TestCommandKeys(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "a"}, "a"},
+		{[]string{"MSET", "a", "1", "b", "2"}, "a b"},
+		{[]string{"BLPOP", "a", "b", "0"}, "a b"},
+		{[]string{"RENAME", "a", "b"}, "a b"},
+		{[]string{"OBJECT", "ENCODING", "a"}, "a"},
+		{[]string{"ZADD", "z", "1", "m"}, "z"},
+		{[]string{"KEYS", "*"}, ""},
+		{[]string{"PING"}, ""},
+	} {
+		if got := strings.Join(commandKeys(tt.args[0], tt.args), " "); got != tt.want {
+			t.Errorf("%q: keys %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestACLUserManagement(t *testing.T) {
+	resetKeyspace()
+	resetACL(t)
+	tc := newAuthClient()
+	expectReply(t, tc, "$7\r\ndefault\r\n", "ACL", "WHOAMI")
+	expectReply(t, tc, "-ERR Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL\r\n", "ACL", "SETUSER", "bob", "on", "+nosuch")
+	expectReply(t, tc, "$-1\r\n", "ACL", "GETUSER", "bob")
+	expectReply(t, tc, "+OK\r\n", "ACL", "SETUSER", "bob", "on", ">pw", "allkeys", "+@string", "-set")
+
+	hash := hashPassword("pw")
+	expectReply(t, tc, "*8\r\n$5\r\nflags\r\n*1\r\n$2\r\non\r\n$9\r\npasswords\r\n*1\r\n$64\r\n"+hash+"\r\n"+
+		"$8\r\ncommands\r\n$19\r\n-@all +@string -set\r\n$4\r\nkeys\r\n$2\r\n~*\r\n", "ACL", "GETUSER", "bob")
+	expectReply(t, tc, "*2\r\n$"+strconv.Itoa(len("user bob on #"+hash+" ~* -@all +@string -set"))+"\r\nuser bob on #"+hash+" ~* -@all +@string -set\r\n"+
+		"$31\r\nuser default on nopass ~* +@all\r\n", "ACL", "LIST")
+	expectReply(t, tc, "*2\r\n$3\r\nbob\r\n$7\r\ndefault\r\n", "ACL", "USERS")
+	if got := tc.do("ACL", "CAT", "list"); !strings.Contains(got, "$5\r\nblpop\r\n") {
+		t.Fatalf("ACL CAT list: %q", got)
+	}
+
+	expectReply(t, tc, "-ERR The 'default' user cannot be removed\r\n", "ACL", "DELUSER", "default")
+	expectReply(t, tc, ":1\r\n", "ACL", "DELUSER", "bob", "nobody")
+	expectReply(t, tc, "-"+errWrongPass+"\r\n", "AUTH", "bob", "pw")
+}
+
+func TestACLFile(t *testing.T) {
+	resetKeyspace()
+	resetACL(t)
+	tc := newAuthClient()
+	expectReply(t, tc, "+OK\r\n", "ACL", "SETUSER", "bob", "on", ">pw", "~cache:*", "+get")
+	data, err := os.ReadFile(aclPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "user bob on #" + hashPassword("pw") + " ~cache:* -@all +get\nuser default on nopass ~* +@all\n"
+	if string(data) != want {
+		t.Fatalf("ACL file:\n%s\nwant:\n%s", data, want)
+	}
+
+	// 重启: 从文件恢复用户
+	dbMu.Lock()
+	installACLUsers(map[string]*aclUser{"default": defaultACLUser()})
+	err = loadACLFile()
+	dbMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := newAuthClient()
+	expectReply(t, bob, "+OK\r\n", "AUTH", "bob", "pw")
+	expectReply(t, bob, "$-1\r\n", "GET", "cache:1")
+	expectReply(t, bob, "-"+errNoPermKey+"\r\n", "GET", "x")
+
+	// 文件有错时 ACL LOAD 不做任何修改
+	os.WriteFile(aclPath, []byte("user carol on +nosuch\n"), 0600)
+	if got := tc.do("ACL", "LOAD"); !strings.HasPrefix(got, "-ERR "+aclPath+":1: Unknown command") {
+		t.Fatalf("ACL LOAD with bad file: %q", got)
+	}
+	expectReply(t, bob, "$-1\r\n", "GET", "cache:1")
+
+	// 文件里去掉的用户在 ACL LOAD 后失效
+	os.WriteFile(aclPath, []byte("user default on nopass ~* +@all\n"), 0600)
+	expectReply(t, tc, "+OK\r\n", "ACL", "LOAD")
+	expectReply(t, tc, "*1\r\n$7\r\ndefault\r\n", "ACL", "USERS")
+}
+
+func TestReplicaMasterauth(t *testing.T) {
+	resetKeyspace()
+	dbMu.Lock()
+	masteruser, masterauth = "repl", "pw"
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		masteruser, masterauth = "", ""
+		dbMu.Unlock()
+	})
+	handshake := make(chan []string, 4)
+	port := fakeMaster(t, func(conn net.Conn, r *bufio.Reader) {
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			handshake <- args
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+		}
+	})
+
+	tc := newTestClient()
+	expectReply(t, tc, "+OK\r\n", "REPLICAOF", "127.0.0.1", strconv.Itoa(port))
+	defer tc.do("REPLICAOF", "NO", "ONE")
+	for _, want := range []string{"PING", "AUTH repl pw"} {
+		select {
+		case args := <-handshake:
+			if got := strings.Join(args, " "); got != want {
+				t.Fatalf("handshake sent %q, want %q", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("replica did not send %s", want)
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	// 自动重写的触发条件, 对应 auto-aof-rewrite-percentage / auto-aof-rewrite-min-size:
+	// AOF 比上次重写后的大小增长了 100% 且至少 64MB
+	aofAutoRewritePercentage = 100
+	aofAutoRewriteMinSize    = 64 << 20
+
+	// 重写时每条命令最多携带的元素个数, 同 Redis AOF_REWRITE_ITEMS_PER_CMD
+	aofRewriteItemsPerCmd = 64
+)
+
+// appendfsync 策略
+const (
+	fsyncAlways   = iota // 回复前 fsync, 并发的写命令合并成一次 fsync (group commit)
+	fsyncEverysec        // 回复前只 write, 后台协程每秒 fsync 一次
+	fsyncNo              // 只 write, 什么时候落盘交给操作系统
+)
+
+var fsyncPolicyNames = []string{"always", "everysec", "no"}
+
+var (
+	aofPath     = "appendonly.aof"
+	appendfsync = fsyncEverysec
+	// 重写时先写一份 RDB 快照作为文件开头, 同 aof-use-rdb-preamble
+	aofUseRDBPreamble = true
+	// 结尾只有半条命令时截掉继续启动, 同 aof-load-truncated; 关掉时拒绝启动
+	aofLoadTruncated = true
+	aofFile          *os.File
+
+	// loading 为 true 时正在重放 AOF, 命令不再写回 AOF
+	loading bool
+	// aofMulti 为 true 时正在执行 EXEC, 第一条写命令前先往 AOF 写 MULTI
+	aofMulti, aofMultiEmitted bool
+	// cmdPropagated 为 true 时当前命令已经用 recordAOF 记录了改写后的命令, call 不再原样记录
+	cmdPropagated bool
+	// aofSelectedDB 是 AOF 和复制流里最近一次 SELECT 的库, 命令所在的库不同时先写 SELECT;
+	// -1 强制下一条命令前重新 SELECT (重写开始、有 replica 全量同步时)
+	aofSelectedDB = -1
+
+	aofCurrentSize int64 // 当前 AOF 文件大小 (含尚未写出的 aofBuf)
+	aofBaseSize    int64 // 上次重写 (或启动) 后的大小, 自动重写以它为基准
+
+	// 写命令先追加到 aofBuf (dbMu 保护), 由 flushAOF 在回复客户端之前写进文件。
+	// aofBufEnd 是累计追加过的字节数, 用作日志偏移, 判断某个客户端的写入是否已落盘
+	aofBuf    []byte
+	aofBufEnd int64
+
+	// aofWriteMu 串行化文件的 write / fsync 以及重写完成时的文件替换。
+	// 加锁顺序: aofWriteMu 在前, dbMu 在后
+	aofWriteMu    sync.Mutex
+	aofWritten    int64        // 已 write 到文件的偏移
+	aofSynced     int64        // 已 fsync 的偏移
+	aofLastFsync  atomic.Int64 // 上次 fsync 的时间, unix 毫秒
+	aofFsyncCount atomic.Int64
+
+	// 重写状态, 都由 dbMu 保护
+	aofRewriting        bool
+	aofRewriteScheduled bool   // 等当前命令 / 事务结束后再开始重写
+	aofRewriteBuf       []byte // 重写期间到达的写命令, 重写完成后追加到新文件
+)
+
+// loadAOF 打开 AOF 文件并重放。
+// SAVE/BGSAVE 会把 AOF 换成 "快照 + 之后的写命令" (见 beginAOFRebase), 所以 AOF 存在时
+// 它的 RDB 开头就是最近一次的快照, 加载之后只需要重放快照之后的尾巴
+func loadAOF() error {
+	var err error
+	aofFile, err = os.OpenFile(aofPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := aofFile.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() > 0 {
+		if err := loadAppendOnlyFile(); err != nil {
+			return err
+		}
+		// 结尾的半条命令可能被截掉了
+		if fi, err = aofFile.Stat(); err != nil {
+			return err
+		}
+	} else {
+		// AOF 是空的 (第一次启动或文件被删了), 退回到 RDB 快照,
+		// 并马上重写一次, 让 AOF 里也有这些数据
+		keys, err := loadRDBFile(rdbPath)
+		if err != nil {
+			return err
+		}
+		if keys > 0 {
+			log.Printf("DB loaded from %s: %d keys", rdbPath, keys)
+			dbMu.Lock()
+			startAOFRewrite()
+			dbMu.Unlock()
+		}
+	}
+	aofCurrentSize = fi.Size()
+	aofBaseSize = aofCurrentSize
+	aofLastFsync.Store(time.Now().UnixMilli())
+	go aofFlusher()
+	return nil
+}
+
+// recordAOF 记录一条在 db 上执行的写命令 (AOF 和复制流), 调用方持有 dbMu。
+// 和库无关的命令 (EXEC 等) db 传 nil。
+// 只有要把命令改写成重放安全的形式时才需要调用, 原样传播的写命令只需 dirty++, 由 call 记录
+func recordAOF(db *DB, args []string) {
+	if loading {
+		return
+	}
+	dirty++
+	cmdPropagated = true
+	feedAOF(db, args)
+}
+
+// propagateDeletion 以 DEL 传播过期或淘汰的 key, 不算作当前命令自己的传播
+func propagateDeletion(db *DB, key string) {
+	feedAOF(db, []string{"DEL", key})
+}
+
+// feedAOF 把命令写进 AOF 和复制流
+func feedAOF(db *DB, args []string) {
+	if loading {
+		return
+	}
+	if db != nil && db.id != aofSelectedDB {
+		aofSelectedDB = db.id
+		propagate(encodeCommand([]string{"SELECT", strconv.Itoa(db.id)}))
+	}
+	if aofMulti && !aofMultiEmitted {
+		aofMultiEmitted = true
+		propagate(encodeCommand([]string{"MULTI"}))
+	}
+	propagate(encodeCommand(args))
+}
+
+// propagate 把编码好的写命令传播到 AOF 和 replica
+func propagate(b []byte) {
+	appendAOF(b)
+	if !applyingMaster {
+		feedReplicationStream(b)
+	}
+}
+
+func appendAOF(b []byte) {
+	aofBuf = append(aofBuf, b...)
+	aofBufEnd += int64(len(b))
+	aofCurrentSize += int64(len(b))
+	if aofRewriting {
+		aofRewriteBuf = append(aofRewriteBuf, b...)
+		return
+	}
+	if aofCurrentSize >= aofAutoRewriteMinSize {
+		growth := (aofCurrentSize - aofBaseSize) * 100 / max(aofBaseSize, 1)
+		if growth >= aofAutoRewritePercentage {
+			aofRewriteScheduled = true
+		}
+	}
+}
+
+// flushAOF 把 aofBuf 写进文件, 直到偏移 target 为止都已写出;
+// appendfsync always 时还要保证 target 之前的内容都已 fsync。
+// 调用方不能持有 dbMu。多个客户端同时等待时, 拿到锁的那个把所有人的写入
+// 一起 write + fsync, 后面的发现自己的偏移已经落盘就直接返回, 即 group commit
+func flushAOF(target int64) {
+	aofWriteMu.Lock()
+	defer aofWriteMu.Unlock()
+	if aofWritten < target {
+		dbMu.Lock()
+		buf, end, f := aofBuf, aofBufEnd, aofFile
+		aofBuf = nil
+		dbMu.Unlock()
+		if _, err := f.Write(buf); err != nil {
+			// 和 Redis 一样, 写 AOF 失败时没有办法保证持久性, 只能退出
+			log.Fatalf("write AOF error: %v", err)
+		}
+		aofWritten = end
+	}
+	if appendfsync == fsyncAlways && aofSynced < target {
+		if err := aofFile.Sync(); err != nil {
+			log.Fatalf("fsync AOF error: %v", err)
+		}
+		aofSynced = aofWritten
+		aofLastFsync.Store(time.Now().UnixMilli())
+		aofFsyncCount.Add(1)
+	}
+}
+
+// flushAOFAll 写出当前所有缓冲的内容
+func flushAOFAll() {
+	dbMu.Lock()
+	end := aofBufEnd
+	dbMu.Unlock()
+	flushAOF(end)
+}
+
+// aofFlusher 每秒把没有客户端等待的缓冲 (过期删除等) 写出去;
+// everysec 策略下顺带 fsync, fsync 不持有 aofWriteMu, 不阻塞前台的 write
+func aofFlusher() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushAOFAll()
+		if appendfsync != fsyncEverysec {
+			continue
+		}
+		aofWriteMu.Lock()
+		f, end, synced := aofFile, aofWritten, aofSynced
+		aofWriteMu.Unlock()
+		if end == synced {
+			continue
+		}
+		// 重写完成时旧文件可能已被关闭, 这时新文件已经 fsync 过, 忽略错误即可
+		if err := f.Sync(); err == nil {
+			aofWriteMu.Lock()
+			if f == aofFile && end > aofSynced {
+				aofSynced = end
+			}
+			aofWriteMu.Unlock()
+			aofLastFsync.Store(time.Now().UnixMilli())
+			aofFsyncCount.Add(1)
+		}
+	}
+}
+
+// loadAppendOnlyFile 从头加载 AOF: 重写生成的文件以 RDB 快照开头 (aof-use-rdb-preamble),
+// 先整块加载快照, 再重放快照之后追加的命令, 启动时不用从头执行全部历史命令
+func loadAppendOnlyFile() error {
+	if _, err := aofFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	magic := make([]byte, len(rdbMagic))
+	n, _ := io.ReadFull(aofFile, magic)
+	if _, err := aofFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if n == len(magic) && bytes.Equal(magic, rdbMagic) {
+		loading = true
+		size, keys, err := loadRDB(aofFile)
+		loading = false
+		if err != nil {
+			return fmt.Errorf("load AOF preamble: %w", err)
+		}
+		log.Printf("AOF preamble loaded: %d keys", keys)
+		if _, err := aofFile.Seek(size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return replayAOF()
+}
+
+// replayAOF 从 aofFile 的当前位置重放 AOF: 和网络请求共用增量解析器和命令执行逻辑, 回复直接丢弃。
+// 格式错误时返回错误让启动失败, 不带着残缺的数据继续运行 (下次重写会让丢失变成永久的);
+// 只有结尾的半条命令 (写到一半时宕机) 在 aof-load-truncated 打开时截掉, 同 Redis
+func replayAOF() error {
+	start, err := aofFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	// 重放的命令不计入 dirty; saveCron 会并发读 dirty, 存取都要持有 dbMu
+	loading = true
+	dbMu.Lock()
+	savedDirty := dirty
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		loading, dirty = false, savedDirty
+		dbMu.Unlock()
+	}()
+
+	fake := &client{w: newReplyWriter(netpoll.NewWriter(io.Discard)), db: dbs[0]}
+	var dec decoder
+	var read int64
+	chunk := make([]byte, 64*1024)
+	for {
+		n, err := aofFile.Read(chunk)
+		read += int64(n)
+		dec.feed(chunk[:n])
+		for {
+			args, perr := dec.next()
+			if perr == errIncomplete {
+				break
+			}
+			if perr != nil {
+				return fmt.Errorf("bad file format reading the append only file at offset %d: %v", start+read-int64(dec.buffered()), perr)
+			}
+			if len(args) == 0 {
+				continue
+			}
+			execCommand(fake, args)
+			fake.w.flush()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read AOF: %w", err)
+		}
+	}
+	if dec.buffered() == 0 {
+		return nil
+	}
+	valid := start + read - int64(dec.buffered())
+	if !aofLoadTruncated {
+		return fmt.Errorf("unexpected end of file reading the append only file at offset %d, set aof-load-truncated to yes to load it", valid)
+	}
+	log.Printf("!!! Warning: short read while loading the AOF file, AOF was truncated to %d bytes (%d trailing bytes dropped)", valid, dec.buffered())
+	return aofFile.Truncate(valid)
+}
+
+// snapshotEntry 是 keyspace 快照里的一个 key, 用于 AOF 重写和 BGSAVE
+type snapshotEntry struct {
+	db       int
+	key      string
+	obj      *object
+	expireAt time.Time
+}
+
+// snapshotKeyspace 在持有 dbMu 时复制整个 keyspace。
+// Redis 用 fork 拿到写时复制的快照; 这里没有子进程, 只能深拷贝容器类型的值,
+// 拷贝比编码写盘快得多, 之后的序列化和写文件都在锁外进行
+func snapshotKeyspace() []snapshotEntry {
+	now := time.Now()
+	n := 0
+	for _, db := range dbs {
+		n += db.size()
+	}
+	entries := make([]snapshotEntry, 0, n)
+	for _, db := range dbs {
+		db.store.IterCb(func(key string, o *object) {
+			t, ok := db.expires.Get(key)
+			if ok && now.After(t) {
+				return
+			}
+			entries = append(entries, snapshotEntry{db: db.id, key: key, obj: o.dup(), expireAt: t})
+		})
+	}
+	return entries
+}
+
+// startAOFRewrite 开始后台重写, 调用方持有 dbMu。
+// 已经在重写时 (包括 SAVE/BGSAVE 触发的 rebase) 那次的快照可能已经过时, 等它结束后再来一次
+func startAOFRewrite() {
+	if aofRewriting {
+		aofRewriteScheduled = true
+		return
+	}
+	aofRewriteScheduled = false
+	entries := snapshotKeyspace()
+	beginAOFRewrite()
+	go func() {
+		if err := rewriteAOF(entries); err != nil {
+			abortAOFRewrite(err)
+		}
+	}()
+}
+
+// beginAOFRewrite 标记重写开始, 之后的写命令同时进重写缓冲。
+// 调用方持有 dbMu, 并且在同一个临界区里拍好了新文件用的快照
+func beginAOFRewrite() {
+	aofRewriting = true
+	aofRewriteBuf = nil
+	// 新文件在快照之后追加重写缓冲, 缓冲的第一条命令要重新 SELECT
+	aofSelectedDB = -1
+}
+
+func abortAOFRewrite(err error) {
+	if err != nil {
+		log.Printf("AOF rewrite failed: %v", err)
+	}
+	dbMu.Lock()
+	aofRewriting, aofRewriteBuf = false, nil
+	dbMu.Unlock()
+}
+
+// beginAOFRebase 在 SAVE/BGSAVE 拍快照的同一个 dbMu 临界区里调用。
+// 快照写盘成功后 finishAOFRebase 用同一份快照重写 AOF, AOF 变成 "快照 + 之后的写命令",
+// 重启时先加载快照 (AOF 的 RDB 开头) 再只重放快照之后的尾巴, 启动时间不再随历史增长。
+// 已经在重写时返回 false: 那次重写本身就会压缩 AOF
+func beginAOFRebase() bool {
+	if aofRewriting {
+		return false
+	}
+	beginAOFRewrite()
+	return true
+}
+
+// finishAOFRebase 在锁外调用, saveErr 是快照写盘的结果, 失败时放弃这次 rebase
+func finishAOFRebase(rebase bool, entries []snapshotEntry, saveErr error) {
+	if !rebase {
+		return
+	}
+	if saveErr != nil {
+		abortAOFRewrite(nil)
+		return
+	}
+	if err := rewriteAOF(entries); err != nil {
+		abortAOFRewrite(err)
+	}
+}
+
+// rewriteAOF 把快照写成临时文件, 再在 dbMu 下追加重写期间的增量并原子替换旧文件
+func rewriteAOF(entries []snapshotEntry) error {
+	tmpPath := filepath.Join(filepath.Dir(aofPath), fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if aofUseRDBPreamble {
+		err = writeRDB(f, entries, true)
+	} else {
+		bw := bufio.NewWriterSize(f, 64*1024)
+		for i, e := range entries {
+			if i == 0 || e.db != entries[i-1].db {
+				bw.Write(encodeCommand([]string{"SELECT", strconv.Itoa(e.db)}))
+			}
+			for _, args := range rewriteObject(e.key, e.obj, e.expireAt) {
+				bw.Write(encodeCommand(args))
+			}
+		}
+		err = bw.Flush()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+
+	aofWriteMu.Lock()
+	defer aofWriteMu.Unlock()
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if _, err := f.Write(aofRewriteBuf); err != nil {
+		aofRewriting, aofRewriteBuf = false, nil
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		aofRewriting, aofRewriteBuf = false, nil
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, aofPath); err != nil {
+		aofRewriting, aofRewriteBuf = false, nil
+		return fail(err)
+	}
+	aofFile.Close()
+	aofFile = f
+	// 还没写出的 aofBuf 在重写缓冲里已经有一份, 新文件 fsync 后全部算落盘
+	aofBuf = nil
+	aofWritten, aofSynced = aofBufEnd, aofBufEnd
+	aofLastFsync.Store(time.Now().UnixMilli())
+	if fi, err := f.Stat(); err == nil {
+		aofCurrentSize = fi.Size()
+		aofBaseSize = aofCurrentSize
+	}
+	aofRewriting, aofRewriteBuf = false, nil
+	log.Printf("Background AOF rewrite finished successfully, %d keys", len(entries))
+	return nil
+}
+
+// rewriteObject 生成重建一个 key 所需的最少命令
+func rewriteObject(key string, o *object, expireAt time.Time) [][]string {
+	var cmds [][]string
+	// batch 把元素按 aofRewriteItemsPerCmd 分批拼成命令
+	batch := func(cmd string, items []string, width int) {
+		for len(items) > 0 {
+			n := min(len(items), aofRewriteItemsPerCmd*width)
+			cmds = append(cmds, append([]string{cmd, key}, items[:n]...))
+			items = items[n:]
+		}
+	}
+	switch o.typ {
+	case typeString:
+		cmds = append(cmds, []string{"SET", key, o.str()})
+	case typeHash:
+		items := make([]string, 0, 2*o.hash().len())
+		for f, v := range o.hash().fields {
+			items = append(items, f, v)
+		}
+		batch("HSET", items, 2)
+	case typeList:
+		l := o.list()
+		batch("RPUSH", l.rangeOf(0, l.len()-1), 1)
+	case typeSet:
+		batch("SADD", o.set().members(), 1)
+	case typeZSet:
+		items := make([]string, 0, 2*o.zset().len())
+		for x := o.zset().zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			items = append(items, formatFloat(x.score), x.member)
+		}
+		batch("ZADD", items, 2)
+	case typeStream:
+		cmds = append(cmds, rewriteStream(key, o.stream())...)
+	}
+	if !expireAt.IsZero() {
+		cmds = append(cmds, []string{"PEXPIREAT", key, strconv.FormatInt(expireAt.UnixMilli(), 10)})
+	}
+	return cmds
+}
+
+// rewriteStream 生成重建 stream 的命令: 逐条 XADD, XSETID 恢复 last ID 等元数据,
+// 再重建消费组、消费者和 PEL
+func rewriteStream(key string, s *stream) [][]string {
+	var cmds [][]string
+	if s.len() == 0 {
+		// 空 stream 也要建出来: 加一条再裁掉, 真正的 last ID 由后面的 XSETID 设置
+		cmds = append(cmds, []string{"XADD", key, "MAXLEN", "0", "0-1", "x", "y"})
+	}
+	for _, e := range s.entries {
+		cmds = append(cmds, append([]string{"XADD", key, e.id.String()}, e.fields...))
+	}
+	cmds = append(cmds, []string{"XSETID", key, s.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(s.entriesAdded, 10), "MAXDELETEDID", s.maxDeletedID.String()})
+	for _, g := range s.sortedGroups() {
+		cmds = append(cmds, []string{"XGROUP", "CREATE", key, g.name, g.lastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10)})
+		for _, id := range sortedPEL(g.pel) {
+			nack := g.pel[id]
+			cmds = append(cmds, []string{"XCLAIM", key, g.name, nack.consumer.name, "0", id.String(),
+				"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10), "JUSTID", "FORCE"})
+		}
+		for _, sc := range g.sortedConsumers() {
+			if len(sc.pel) == 0 {
+				cmds = append(cmds, []string{"XGROUP", "CREATECONSUMER", key, g.name, sc.name})
+			}
+		}
+	}
+	return cmds
+}
+
+// BGREWRITEAOF
+func bgrewriteaofCommand(c *client, args []string) {
+	w := c.w
+	switch {
+	case aofRewriting:
+		w.writeError("ERR Background append only file rewriting already in progress")
+	case c.inExec:
+		// 事务执行到一半时快照会拆开事务, 等 EXEC 结束再开始
+		aofRewriteScheduled = true
+		w.writeSimple("Background append only file rewriting scheduled")
+	default:
+		startAOFRewrite()
+		w.writeSimple("Background append only file rewriting started")
+	}
+}
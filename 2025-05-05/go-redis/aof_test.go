@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitRewrite 等待后台重写结束
+func waitRewrite(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dbMu.Lock()
+		done := !aofRewriting && !aofRewriteScheduled
+		dbMu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("AOF rewrite did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func aofSize(t *testing.T) int64 {
+	t.Helper()
+	fi, err := aofFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	for i := range 200 {
+		tc.do("SET", "counter", strconv.Itoa(i))
+		tc.do("RPUSH", "list", strconv.Itoa(i))
+	}
+	tc.do("LTRIM", "list", "0", "99")
+	tc.do("HSET", "h", "a", "1", "b", "2")
+	tc.do("SADD", "s", "1", "2", "x")
+	tc.do("ZADD", "z", "1.5", "a", "-inf", "b")
+	tc.do("SET", "tmp", "v")
+	tc.do("EXPIRE", "tmp", "100")
+	before := aofSize(t)
+
+	expectReply(t, tc, "+Background append only file rewriting started\r\n", "BGREWRITEAOF")
+	// 重写期间的写命令先进重写缓冲, 最后追加到新文件
+	tc.do("SET", "late", "1")
+	tc.do("RPUSH", "list", "tail")
+	waitRewrite(t)
+
+	if after := aofSize(t); after >= before {
+		t.Fatalf("AOF not compacted: %d -> %d bytes", before, after)
+	}
+	replayFromStart(t)
+	expectReply(t, tc, "$3\r\n199\r\n", "GET", "counter")
+	expectReply(t, tc, "$1\r\n1\r\n", "GET", "late")
+	expectReply(t, tc, ":101\r\n", "LLEN", "list")
+	expectReply(t, tc, "$4\r\ntail\r\n", "LINDEX", "list", "-1")
+	expectReply(t, tc, "*4\r\n$1\r\nb\r\n$4\r\n-inf\r\n$1\r\na\r\n$3\r\n1.5\r\n", "ZRANGE", "z", "0", "-1", "WITHSCORES")
+	expectReply(t, tc, ":3\r\n", "SCARD", "s")
+	expectReply(t, tc, "$1\r\n2\r\n", "HGET", "h", "b")
+	if got := tc.do("TTL", "tmp"); got != ":100\r\n" && got != ":99\r\n" {
+		t.Fatalf("TTL after rewrite: %q", got)
+	}
+}
+
+func TestBgrewriteaofInExec(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("MULTI")
+	tc.do("SET", "k", "v")
+	tc.do("BGREWRITEAOF")
+	if got := tc.do("EXEC"); !strings.Contains(got, "rewriting scheduled") {
+		t.Fatalf("EXEC: %q", got)
+	}
+	waitRewrite(t)
+	replayFromStart(t)
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "k")
+}
+
+func TestRewriteObjectBatches(t *testing.T) {
+	items := make([]string, 150)
+	for i := range items {
+		items[i] = strconv.Itoa(i)
+	}
+	cmds := rewriteObject("s", newSetObjectFrom(items), time.Time{})
+	if len(cmds) != 3 || len(cmds[0]) != 2+aofRewriteItemsPerCmd || len(cmds[2]) != 2+150-2*aofRewriteItemsPerCmd {
+		t.Fatalf("unexpected batching: %d commands", len(cmds))
+	}
+}
+
+func TestAppendfsyncAlwaysGroupCommit(t *testing.T) {
+	restoreConfig(t)
+	resetKeyspace()
+	aofFile.Truncate(0)
+	expectReply(t, newTestClient(), "+OK\r\n", "CONFIG", "SET", "appendfsync", "always")
+
+	const clients, writes = 8, 50
+	before := aofFsyncCount.Load()
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tc := newTestClient()
+			for j := range writes {
+				tc.do("SET", fmt.Sprintf("k%d", i), strconv.Itoa(j))
+			}
+		}()
+	}
+	wg.Wait()
+	if n := aofFsyncCount.Load() - before; n == 0 || n > clients*writes {
+		t.Fatalf("unexpected fsync count %d", n)
+	}
+	// 没有并发写入时, 回复之后所有内容都已经 fsync
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	dbMu.Lock()
+	end := aofBufEnd
+	dbMu.Unlock()
+	aofWriteMu.Lock()
+	synced := aofSynced
+	aofWriteMu.Unlock()
+	if synced != end {
+		t.Fatalf("replied before fsync: synced %d, end %d", synced, end)
+	}
+
+	replayFromStart(t)
+	for i := range clients {
+		expectReply(t, tc, "$2\r\n49\r\n", "GET", fmt.Sprintf("k%d", i))
+	}
+}
+
+func TestAppendfsyncEverysecWritesBeforeReply(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	// everysec 不等 fsync, 但回复前已经 write 到文件
+	if size := aofSize(t); size != int64(len(encodeCommand([]string{"SELECT", "0"}))+len(encodeCommand([]string{"SET", "k", "v"}))) {
+		t.Fatalf("AOF size %d after reply", size)
+	}
+	got := tc.do("INFO", "persistence")
+	for _, want := range []string{"# Persistence\r\n", "aof_buffer_length:0\r\n", "aof_fsync_policy:everysec\r\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("INFO missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestAOFLoadCorrupted(t *testing.T) {
+	restoreConfig(t)
+	resetKeyspace()
+	flushAOFAll()
+	tc := newTestClient()
+	load := func(content string) error {
+		aofFile.Truncate(0)
+		aofFile.WriteString(content)
+		resetKeyspace()
+		return loadAppendOnlyFile()
+	}
+	t.Cleanup(func() {
+		resetKeyspace()
+		aofFile.Truncate(0)
+	})
+	set := string(encodeCommand([]string{"SET", "k", "v"}))
+	partial := "*3\r\n$3\r\nSET\r\n$1\r\nx"
+
+	// 中间的格式错误让启动失败, 不带着截断的数据继续运行
+	if err := load(set + "*1\r\n+bad\r\n" + set); err == nil || !strings.Contains(err.Error(), "bad file format") {
+		t.Fatalf("corrupted AOF loaded: %v", err)
+	}
+
+	// 结尾的半条命令截掉后照常启动
+	if err := load(set + partial); err != nil {
+		t.Fatalf("truncated AOF: %v", err)
+	}
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "k")
+	if fi, _ := aofFile.Stat(); fi.Size() != int64(len(set)) {
+		t.Fatalf("AOF not truncated to the last complete command: %d bytes", fi.Size())
+	}
+
+	// 关掉 aof-load-truncated 时半条命令也让启动失败
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "aof-load-truncated", "no")
+	if err := load(set + partial); err == nil || !strings.Contains(err.Error(), "aof-load-truncated") {
+		t.Fatalf("truncated AOF loaded with aof-load-truncated no: %v", err)
+	}
+}
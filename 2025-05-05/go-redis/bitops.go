@@ -0,0 +1,600 @@
+package redis
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// 位操作直接作用在字符串值上, 和 Redis 一样按字节从高位到低位编号:
+// 第 0 位是第一个字节的最高位
+
+const (
+	errBitOffset = "ERR bit offset is not an integer or out of range"
+	errBitValue  = "ERR bit is not an integer or out of range"
+)
+
+// parseBitOffset 解析位偏移, hash 为 true 时支持 BITFIELD 的 #N 写法 (第 N 个 bits 宽的整数)
+func parseBitOffset(s string, hash bool, bits int) (int64, string) {
+	mul := int64(1)
+	if hash && strings.HasPrefix(s, "#") {
+		s = s[1:]
+		mul = int64(bits)
+	}
+	n, ok := parseInt64(s)
+	if !ok || n < 0 || n > math.MaxInt64/mul {
+		return 0, errBitOffset
+	}
+	n *= mul
+	if n>>3 >= maxStringLen {
+		return 0, errBitOffset
+	}
+	return n, ""
+}
+
+func getBit(s string, offset int64) int {
+	i := offset >> 3
+	if i >= int64(len(s)) {
+		return 0
+	}
+	return int(s[i]>>(7-uint(offset&7))) & 1
+}
+
+func setBit(b []byte, offset int64, v int) {
+	i := offset >> 3
+	mask := byte(1) << (7 - uint(offset&7))
+	if v != 0 {
+		b[i] |= mask
+	} else {
+		b[i] &^= mask
+	}
+}
+
+// growBytes 把 b 用 0 补齐到至少 n 字节
+func growBytes(b []byte, n int) []byte {
+	if n > len(b) {
+		b = append(b, make([]byte, n-len(b))...)
+	}
+	return b
+}
+
+// SETBIT key offset value, 返回原来的位
+func setbitCommand(c *client, args []string) {
+	w := c.w
+	offset, err := parseBitOffset(args[2], false, 0)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if args[3] != "0" && args[3] != "1" {
+		w.writeError(errBitValue)
+		return
+	}
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var b []byte
+	if o != nil {
+		b = []byte(o.str())
+	}
+	b = growBytes(b, int(offset>>3)+1)
+	old := getBit(string(b), offset)
+	setBit(b, offset, int(args[3][0]-'0'))
+	setStringValue(c.db, args[1], o, string(b))
+	dirty++
+	w.writeInt(int64(old))
+}
+
+// GETBIT key offset, 超出字符串长度的位为 0
+func getbitCommand(c *client, args []string) {
+	w := c.w
+	offset, err := parseBitOffset(args[2], false, 0)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(getBit(o.str(), offset)))
+}
+
+// bitRange 把 BITCOUNT / BITPOS 的 start end [BYTE|BIT] 换算成闭区间的位下标,
+// 负数下标从末尾数起。区间为空时 empty 为 true
+func bitRange(s string, start, end int64, isBit bool) (first, last int64, empty bool) {
+	total := int64(len(s))
+	if isBit {
+		total <<= 3
+	}
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, true
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, true
+	}
+	if isBit {
+		return start, end, false
+	}
+	return start << 3, end<<3 + 7, false
+}
+
+// parseBitUnit 解析 BYTE | BIT
+func parseBitUnit(s string) (bool, bool) {
+	switch strings.ToUpper(s) {
+	case "BYTE":
+		return false, true
+	case "BIT":
+		return true, true
+	}
+	return false, false
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func bitcountCommand(c *client, args []string) {
+	w := c.w
+	var start, end int64
+	isBit := false
+	ranged := len(args) > 2
+	if ranged {
+		if len(args) != 4 && len(args) != 5 {
+			w.writeError(errSyntax)
+			return
+		}
+		var ok1, ok2 bool
+		start, ok1 = parseInt64(args[2])
+		end, ok2 = parseInt64(args[3])
+		if !ok1 || !ok2 {
+			w.writeError(errNotInt)
+			return
+		}
+		if len(args) == 5 {
+			var ok bool
+			if isBit, ok = parseBitUnit(args[4]); !ok {
+				w.writeError(errSyntax)
+				return
+			}
+		}
+	}
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeInt(0)
+		return
+	}
+	s := o.str()
+	if !ranged {
+		start, end = 0, -1
+	}
+	first, last, empty := bitRange(s, start, end, isBit)
+	if empty {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(countBits(s, first, last))
+}
+
+// countBits 统计 [first, last] 位区间里 1 的个数, 中间的整字节按字节统计
+func countBits(s string, first, last int64) int64 {
+	var n int64
+	for first <= last && first&7 != 0 {
+		n += int64(getBit(s, first))
+		first++
+	}
+	for first+7 <= last {
+		n += int64(bits.OnesCount8(s[first>>3]))
+		first += 8
+	}
+	for ; first <= last; first++ {
+		n += int64(getBit(s, first))
+	}
+	return n
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+// 找 0 且没有指定 end 时, 字符串右边视为补满了 0
+func bitposCommand(c *client, args []string) {
+	w := c.w
+	if args[2] != "0" && args[2] != "1" {
+		w.writeError("ERR The bit argument must be 1 or 0.")
+		return
+	}
+	bit := int(args[2][0] - '0')
+	if len(args) > 6 {
+		w.writeError(errSyntax)
+		return
+	}
+	start, end := int64(0), int64(-1)
+	endGiven, isBit := false, false
+	if len(args) > 3 {
+		var ok bool
+		if start, ok = parseInt64(args[3]); !ok {
+			w.writeError(errNotInt)
+			return
+		}
+	}
+	if len(args) > 4 {
+		var ok bool
+		if end, ok = parseInt64(args[4]); !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		endGiven = true
+	}
+	if len(args) > 5 {
+		var ok bool
+		if isBit, ok = parseBitUnit(args[5]); !ok {
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeInt(int64(-bit)) // 不存在的 key: 找 1 返回 -1, 找 0 返回 0
+		return
+	}
+	s := o.str()
+	first, last, empty := bitRange(s, start, end, isBit)
+	if empty {
+		w.writeInt(-1)
+		return
+	}
+	for i := first; i <= last; i++ {
+		if i&7 == 0 && i+7 <= last && s[i>>3] == byte(0xff*(1-bit)) {
+			// 整字节都不是要找的位, 跳过
+			i += 7
+			continue
+		}
+		if getBit(s, i) == bit {
+			w.writeInt(i)
+			return
+		}
+	}
+	if bit == 1 || endGiven {
+		w.writeInt(-1)
+		return
+	}
+	w.writeInt(last + 1)
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+// 短的输入视为补了 0, 结果长度取最长的输入; 结果为空时删除目标 key
+func bitopCommand(c *client, args []string) {
+	w := c.w
+	op := strings.ToUpper(args[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 4 {
+			w.writeError("ERR BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		w.writeError(errSyntax)
+		return
+	}
+	srcs := make([]string, 0, len(args)-3)
+	maxLen := 0
+	for _, key := range args[3:] {
+		o, err := stringForRead(c.db, key)
+		if err != "" {
+			w.writeError(err)
+			return
+		}
+		s := ""
+		if o != nil {
+			s = o.str()
+		}
+		srcs = append(srcs, s)
+		maxLen = max(maxLen, len(s))
+	}
+	res := make([]byte, maxLen)
+	for i := range res {
+		var v byte
+		for j, s := range srcs {
+			var b byte
+			if i < len(s) {
+				b = s[i]
+			}
+			switch {
+			case op == "NOT":
+				v = ^b
+			case j == 0:
+				v = b
+			case op == "AND":
+				v &= b
+			case op == "OR":
+				v |= b
+			default:
+				v ^= b
+			}
+		}
+		res[i] = v
+	}
+	dest := args[2]
+	c.db.removeKey(dest)
+	if maxLen > 0 {
+		c.db.setKey(dest, newStringObject(string(res)))
+	}
+	c.db.signalModifiedKey(dest)
+	dirty++
+	w.writeInt(int64(maxLen))
+}
+
+// BITFIELD 的溢出策略
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+const (
+	bitfieldGet = iota
+	bitfieldSet
+	bitfieldIncrBy
+)
+
+type bitfieldOp struct {
+	op       int
+	offset   int64
+	bits     int
+	signed   bool
+	value    int64
+	overflow int
+}
+
+// parseBitfieldType 解析 i1..i64 / u1..u63
+func parseBitfieldType(s string) (bits int, signed bool, ok bool) {
+	if len(s) < 2 {
+		return 0, false, false
+	}
+	switch s[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return 0, false, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return 0, false, false
+	}
+	return n, signed, true
+}
+
+// getBitfield 读出从 offset 开始 bits 位的无符号整数, 超出字符串的部分为 0
+func getBitfield(s string, offset int64, bits int) uint64 {
+	var v uint64
+	for j := range int64(bits) {
+		v = v<<1 | uint64(getBit(s, offset+j))
+	}
+	return v
+}
+
+func setBitfield(b []byte, offset int64, bits int, v uint64) {
+	for j := range bits {
+		setBit(b, offset+int64(j), int(v>>(bits-1-j))&1)
+	}
+}
+
+func signExtend(v uint64, bits int) int64 {
+	if bits < 64 && v&(1<<(bits-1)) != 0 {
+		v |= math.MaxUint64 << bits
+	}
+	return int64(v)
+}
+
+// checkUnsignedBitfieldOverflow 判断 value+incr 是否溢出 bits 位无符号整数,
+// 溢出时按策略返回回绕或饱和后的值, 同 Redis 的同名函数
+func checkUnsignedBitfieldOverflow(value uint64, incr int64, bits int, overflow int) (uint64, bool) {
+	maxVal := uint64(1)<<bits - 1
+	maxIncr := int64(maxVal - value)
+	minIncr := -int64(value)
+	wrap := func() uint64 { return (value + uint64(incr)) & maxVal }
+	if value > maxVal || (incr > 0 && incr > maxIncr) {
+		if overflow == overflowWrap {
+			return wrap(), true
+		}
+		return maxVal, true
+	}
+	if incr < 0 && incr < minIncr {
+		if overflow == overflowWrap {
+			return wrap(), true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func checkSignedBitfieldOverflow(value, incr int64, bits int, overflow int) (int64, bool) {
+	maxVal := int64(math.MaxInt64)
+	if bits < 64 {
+		maxVal = int64(1)<<(bits-1) - 1
+	}
+	minVal := -maxVal - 1
+	maxIncr := maxVal - value
+	minIncr := minVal - value
+	wrap := func() int64 {
+		return signExtend((uint64(value)+uint64(incr))&(math.MaxUint64>>(64-bits)), bits)
+	}
+	if value > maxVal || (bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if overflow == overflowWrap {
+			return wrap(), true
+		}
+		return maxVal, true
+	}
+	if value < minVal || (bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if overflow == overflowWrap {
+			return wrap(), true
+		}
+		return minVal, true
+	}
+	return 0, false
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment]
+// [OVERFLOW WRAP|SAT|FAIL] ..., readonly 时是 BITFIELD_RO, 只允许 GET
+func bitfieldCommand(c *client, args []string, readonly bool) {
+	w := c.w
+	name := "BITFIELD"
+	if readonly {
+		name = "BITFIELD_RO"
+	}
+	if len(args) < 2 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	writes := false
+	var maxBit int64
+	for i := 2; i < len(args); i++ {
+		sub := strings.ToUpper(args[i])
+		remaining := len(args) - i - 1
+		if sub == "OVERFLOW" && remaining >= 1 {
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				w.writeError("ERR Invalid OVERFLOW type specified")
+				return
+			}
+			i++
+			continue
+		}
+		var op bitfieldOp
+		switch {
+		case sub == "GET" && remaining >= 2:
+			op.op = bitfieldGet
+		case sub == "SET" && remaining >= 3:
+			op.op = bitfieldSet
+		case sub == "INCRBY" && remaining >= 3:
+			op.op = bitfieldIncrBy
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+		var ok bool
+		if op.bits, op.signed, ok = parseBitfieldType(args[i+1]); !ok {
+			w.writeError("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+			return
+		}
+		var err string
+		if op.offset, err = parseBitOffset(args[i+2], true, op.bits); err != "" {
+			w.writeError(err)
+			return
+		}
+		i += 2
+		if op.op != bitfieldGet {
+			if readonly {
+				w.writeError("ERR BITFIELD_RO only supports the GET subcommand")
+				return
+			}
+			i++
+			if op.value, ok = parseInt64(args[i]); !ok {
+				w.writeError(errNotInt)
+				return
+			}
+			writes = true
+			maxBit = max(maxBit, op.offset+int64(op.bits)-1)
+		}
+		op.overflow = overflow
+		ops = append(ops, op)
+	}
+
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var b []byte
+	if o != nil {
+		b = []byte(o.str())
+	}
+	if writes {
+		b = growBytes(b, int(maxBit>>3)+1)
+	}
+	changes := 0
+	w.writeArray(len(ops))
+	for _, op := range ops {
+		cur := getBitfield(string(b), op.offset, op.bits)
+		if op.op == bitfieldGet {
+			if op.signed {
+				w.writeInt(signExtend(cur, op.bits))
+			} else {
+				w.writeInt(int64(cur))
+			}
+			continue
+		}
+		var newVal uint64
+		var reply int64
+		var overflowed bool
+		if op.signed {
+			old := signExtend(cur, op.bits)
+			v, incr := op.value, int64(0)
+			if op.op == bitfieldIncrBy {
+				v, incr = old, op.value
+			}
+			limited, of := checkSignedBitfieldOverflow(v, incr, op.bits, op.overflow)
+			res := v + incr
+			if of {
+				res = limited
+			}
+			newVal, overflowed = uint64(res), of
+			if reply = old; op.op == bitfieldIncrBy {
+				reply = res
+			}
+		} else {
+			v, incr := uint64(op.value), int64(0)
+			if op.op == bitfieldIncrBy {
+				v, incr = cur, op.value
+			}
+			limited, of := checkUnsignedBitfieldOverflow(v, incr, op.bits, op.overflow)
+			res := v + uint64(incr)
+			if of {
+				res = limited
+			}
+			newVal, overflowed = res, of
+			if reply = int64(cur); op.op == bitfieldIncrBy {
+				reply = int64(res)
+			}
+		}
+		if overflowed && op.overflow == overflowFail {
+			w.writeNull()
+			continue
+		}
+		setBitfield(b, op.offset, op.bits, newVal)
+		changes++
+		w.writeInt(reply)
+	}
+	if changes > 0 {
+		setStringValue(c.db, args[1], o, string(b))
+		dirty++
+	}
+}
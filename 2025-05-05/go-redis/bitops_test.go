@@ -0,0 +1,114 @@
+package redis
+
+import "testing"
+
+func TestSetGetBit(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":0\r\n", "SETBIT", "k", "7", "1")
+	expectReply(t, tc, "$1\r\n\x01\r\n", "GET", "k")
+	expectReply(t, tc, ":1\r\n", "SETBIT", "k", "7", "0")
+	expectReply(t, tc, ":0\r\n", "SETBIT", "k", "16", "1")
+	expectReply(t, tc, "$3\r\n\x00\x00\x80\r\n", "GET", "k")
+	expectReply(t, tc, ":1\r\n", "GETBIT", "k", "16")
+	expectReply(t, tc, ":0\r\n", "GETBIT", "k", "1000")
+	expectReply(t, tc, ":0\r\n", "GETBIT", "missing", "0")
+
+	expectReply(t, tc, "-"+errBitValue+"\r\n", "SETBIT", "k", "0", "2")
+	expectReply(t, tc, "-"+errBitOffset+"\r\n", "SETBIT", "k", "-1", "1")
+	expectReply(t, tc, "-"+errBitOffset+"\r\n", "SETBIT", "k", "4294967296", "1")
+	tc.do("RPUSH", "l", "a")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "GETBIT", "l", "0")
+}
+
+func TestBitCountPos(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "s", "foobar")
+	expectReply(t, tc, ":26\r\n", "BITCOUNT", "s")
+	expectReply(t, tc, ":4\r\n", "BITCOUNT", "s", "0", "0")
+	expectReply(t, tc, ":6\r\n", "BITCOUNT", "s", "1", "1")
+	expectReply(t, tc, ":6\r\n", "BITCOUNT", "s", "1", "1", "BYTE")
+	expectReply(t, tc, ":17\r\n", "BITCOUNT", "s", "5", "30", "BIT")
+	expectReply(t, tc, ":4\r\n", "BITCOUNT", "s", "-1", "-1")
+	expectReply(t, tc, ":0\r\n", "BITCOUNT", "s", "-1", "-2")
+	expectReply(t, tc, ":0\r\n", "BITCOUNT", "missing")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "BITCOUNT", "s", "0")
+
+	tc.do("SET", "p", "\xff\xf0\x00")
+	expectReply(t, tc, ":12\r\n", "BITPOS", "p", "0")
+	tc.do("SET", "p", "\x00\xff\xf0")
+	expectReply(t, tc, ":8\r\n", "BITPOS", "p", "1", "0")
+	expectReply(t, tc, ":16\r\n", "BITPOS", "p", "1", "2")
+	expectReply(t, tc, ":16\r\n", "BITPOS", "p", "1", "2", "-1", "BYTE")
+	expectReply(t, tc, ":8\r\n", "BITPOS", "p", "1", "7", "15", "BIT")
+	tc.do("SET", "p", "\x00\x00\x00")
+	expectReply(t, tc, ":-1\r\n", "BITPOS", "p", "1")
+	expectReply(t, tc, ":-1\r\n", "BITPOS", "p", "1", "7", "-3", "BIT")
+	tc.do("SET", "p", "\xff\xff\xff")
+	// 没有指定 end 时右边视为补了 0, 指定了 end 则找不到
+	expectReply(t, tc, ":24\r\n", "BITPOS", "p", "0")
+	expectReply(t, tc, ":-1\r\n", "BITPOS", "p", "0", "0", "-1")
+	expectReply(t, tc, ":-1\r\n", "BITPOS", "missing", "1")
+	expectReply(t, tc, ":0\r\n", "BITPOS", "missing", "0")
+	expectReply(t, tc, "-ERR The bit argument must be 1 or 0.\r\n", "BITPOS", "p", "2")
+}
+
+func TestBitOp(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "a", "foobar")
+	tc.do("SET", "b", "abcdef")
+	expectReply(t, tc, ":6\r\n", "BITOP", "AND", "d", "a", "b")
+	expectReply(t, tc, "$6\r\n`bc`ab\r\n", "GET", "d")
+	expectReply(t, tc, ":6\r\n", "BITOP", "OR", "d", "a", "b")
+	expectReply(t, tc, "$6\r\ngoofev\r\n", "GET", "d")
+	tc.do("SET", "short", "\xff")
+	expectReply(t, tc, ":6\r\n", "BITOP", "XOR", "d", "short", "missing", "a")
+	expectReply(t, tc, "$6\r\n\x99oobar\r\n", "GET", "d")
+	expectReply(t, tc, ":1\r\n", "BITOP", "NOT", "d", "short")
+	expectReply(t, tc, "$1\r\n\x00\r\n", "GET", "d")
+	// 所有输入都为空时删除目标 key
+	expectReply(t, tc, ":0\r\n", "BITOP", "OR", "d", "missing")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "d")
+	expectReply(t, tc, "-ERR BITOP NOT must be called with a single source key.\r\n", "BITOP", "NOT", "d", "a", "b")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "BITOP", "NAND", "d", "a")
+}
+
+func TestBitField(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "*2\r\n:1\r\n:0\r\n", "BITFIELD", "k", "INCRBY", "i5", "100", "1", "GET", "u4", "0")
+	expectReply(t, tc, "*3\r\n:0\r\n:-100\r\n:101\r\n", "BITFIELD", "s", "SET", "i8", "0", "-100", "SET", "i8", "0", "101", "GET", "i8", "0")
+	expectReply(t, tc, "*4\r\n:0\r\n:255\r\n:200\r\n:44\r\n", "BITFIELD", "u",
+		"SET", "u8", "0", "255", "SET", "u8", "0", "100", "INCRBY", "u8", "0", "100", "INCRBY", "u8", "0", "100")
+	expectReply(t, tc, "$1\r\n,\r\n", "GET", "u")
+
+	// #N 偏移按类型宽度计算
+	expectReply(t, tc, "*2\r\n:0\r\n:0\r\n", "BITFIELD", "h", "SET", "u8", "#1", "65", "SET", "u8", "#0", "66")
+	expectReply(t, tc, "$2\r\nBA\r\n", "GET", "h")
+
+	expectReply(t, tc, "*4\r\n:1\r\n:2\r\n:3\r\n:0\r\n", "BITFIELD", "w",
+		"INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1", "INCRBY", "u2", "100", "1")
+	expectReply(t, tc, "*3\r\n:1\r\n:2\r\n:3\r\n", "BITFIELD", "sat", "OVERFLOW", "SAT",
+		"INCRBY", "u2", "0", "1", "INCRBY", "u2", "0", "1", "INCRBY", "u2", "0", "1")
+	expectReply(t, tc, "*2\r\n:3\r\n$-1\r\n", "BITFIELD", "sat", "OVERFLOW", "SAT", "INCRBY", "u2", "0", "1",
+		"OVERFLOW", "FAIL", "INCRBY", "u2", "0", "1")
+	expectReply(t, tc, "*3\r\n:0\r\n:-128\r\n:127\r\n", "BITFIELD", "i",
+		"SET", "i8", "0", "127", "INCRBY", "i8", "0", "1", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "1000")
+	expectReply(t, tc, "*1\r\n:-9223372036854775808\r\n", "BITFIELD", "big", "INCRBY", "i64", "0", "-9223372036854775808")
+	expectReply(t, tc, "*1\r\n:-9223372036854775808\r\n", "BITFIELD", "big", "OVERFLOW", "SAT", "INCRBY", "i64", "0", "-1")
+	expectReply(t, tc, "*1\r\n:9223372036854775807\r\n", "BITFIELD", "big", "INCRBY", "i64", "0", "-1")
+
+	// 全部 FAIL 的写操作不创建 key
+	expectReply(t, tc, "*1\r\n$-1\r\n", "BITFIELD", "none", "OVERFLOW", "FAIL", "SET", "u2", "0", "9")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "none")
+
+	expectReply(t, tc, "*1\r\n:44\r\n", "BITFIELD_RO", "u", "GET", "u8", "0")
+	expectReply(t, tc, "-ERR BITFIELD_RO only supports the GET subcommand\r\n", "BITFIELD_RO", "u", "SET", "u8", "0", "1")
+	expectReply(t, tc, "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n",
+		"BITFIELD", "u", "GET", "u64", "0")
+	expectReply(t, tc, "-ERR Invalid OVERFLOW type specified\r\n", "BITFIELD", "u", "OVERFLOW", "MAYBE")
+	expectReply(t, tc, "-"+errBitOffset+"\r\n", "BITFIELD", "u", "GET", "u8", "-1")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "BITFIELD", "u", "GET", "u8")
+}
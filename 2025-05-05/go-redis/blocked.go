@@ -0,0 +1,194 @@
+package redis
+
+import (
+	"slices"
+	"time"
+)
+
+// blockState 记录一个因 BLPOP/BRPOP 或 XREAD/XREADGROUP BLOCK 挂起的连接
+type blockState struct {
+	db       *DB
+	keys     []string
+	left     bool
+	retry    []string  // 非 nil 表示在等 stream: 被唤醒后重新执行这条命令
+	deadline time.Time // 零值表示永久阻塞
+	// 被 push 唤醒时写入 [key, value], 容量 1, 写入方不会阻塞
+	served chan [2]string
+}
+
+var (
+	// blockingKeys 是 key -> 按阻塞先后排队的客户端
+	blockingKeys = make(map[dbKey][]*client)
+	// readyKeys 是本条命令执行期间被 push 过、且有客户端在等的 key
+	readyKeys []dbKey
+)
+
+// blockForKeys 登记阻塞状态, 真正的等待在 processCommand 释放 dbMu 之后进行
+func blockForKeys(c *client, keys []string, left bool, secs float64) {
+	// 事务和 AOF 重放中不能阻塞, 直接按超时处理
+	if loading || c.inExec {
+		c.w.writeNullArray()
+		return
+	}
+	bs := &blockState{db: c.db, keys: keys, left: left, served: make(chan [2]string, 1)}
+	if secs > 0 {
+		bs.deadline = time.Now().Add(time.Duration(secs * float64(time.Second)))
+	}
+	block(c, bs)
+}
+
+// blockForStreams 登记在 stream 上的阻塞, ms 为 0 表示永久阻塞。
+// retry 是唤醒后重新执行的命令, 其中的 $ 已经换成了阻塞时的最后一个 ID
+func blockForStreams(c *client, keys []string, ms int64, retry []string) {
+	if loading || c.inExec {
+		c.w.writeNullArray()
+		return
+	}
+	bs := &blockState{db: c.db, keys: keys, retry: retry, served: make(chan [2]string, 1)}
+	if ms > 0 {
+		bs.deadline = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	}
+	block(c, bs)
+}
+
+func block(c *client, bs *blockState) {
+	c.blocked = bs
+	for _, key := range bs.keys {
+		k := dbKey{bs.db.id, key}
+		blockingKeys[k] = append(blockingKeys[k], c)
+	}
+}
+
+// unblockClient 把客户端从所有等待队列中摘掉, 调用方持有 dbMu
+func unblockClient(c *client) {
+	for _, key := range c.blocked.keys {
+		k := dbKey{c.blocked.db.id, key}
+		q := blockingKeys[k]
+		for i, other := range q {
+			if other == c {
+				q = append(q[:i], q[i+1:]...)
+				break
+			}
+		}
+		if len(q) == 0 {
+			delete(blockingKeys, k)
+		} else {
+			blockingKeys[k] = q
+		}
+	}
+}
+
+func (db *DB) signalKeyAsReady(key string) {
+	k := dbKey{db.id, key}
+	if _, ok := blockingKeys[k]; ok {
+		readyKeys = append(readyKeys, k)
+	}
+}
+
+// serveBlockedClients 在命令执行完后, 用 ready key 上的新元素按 FIFO 顺序唤醒等待者。
+// 调用方持有 dbMu
+func serveBlockedClients() {
+	for len(readyKeys) > 0 {
+		k := readyKeys[0]
+		readyKeys = readyKeys[1:]
+		db, key := dbs[k.db], k.key
+		if o, ok := db.lookupKeyNoTouch(key); ok && o.typ == typeStream {
+			// stream 的条目不会被读走, 所有在等它的连接都唤醒, 各自重新执行读取
+			for _, c := range slices.Clone(blockingKeys[k]) {
+				bs := c.blocked
+				if bs.retry == nil {
+					continue
+				}
+				unblockClient(c)
+				if c.conn == nil || c.conn.IsActive() {
+					bs.served <- [2]string{}
+				}
+			}
+			continue
+		}
+		for len(blockingKeys[k]) > 0 {
+			l, _ := listForRead(db, key)
+			if l == nil {
+				break
+			}
+			c := blockingKeys[k][0]
+			bs := c.blocked
+			unblockClient(c)
+			// 已经断开的连接直接跳过, 免得元素被弹出后无人接收
+			if c.conn != nil && !c.conn.IsActive() {
+				continue
+			}
+			v := listPop(db, key, l, bs.left)
+			db.signalModifiedKey(key)
+			recordAOF(db, []string{popName(bs.left), key})
+			bs.served <- [2]string{key, v}
+		}
+	}
+	readyKeys = readyKeys[:0]
+}
+
+// retryBlockedStream 在等待的 stream 有了新条目后重新执行 XREAD / XREADGROUP。
+// 仍然读不到时 (比如被同组的其他消费者先读走) 按原来的截止时间继续阻塞
+func retryBlockedStream(c *client, bs *blockState) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	before := aofBufEnd
+	xreadCommand(c, bs.retry)
+	if c.blocked != nil {
+		c.blocked.deadline = bs.deadline
+	}
+	updateMemoryAccounting()
+	if len(readyKeys) > 0 {
+		serveBlockedClients()
+	}
+	if aofBufEnd > before {
+		c.aofOffset = aofBufEnd
+	}
+}
+
+// waitBlocked 挂起当前连接的处理协程, 直到被唤醒、超时或连接断开。
+// 被唤醒时返回弹出的 [key, value]; 超时返回 ok=false
+func waitBlocked(c *client) (kv [2]string, ok bool) {
+	bs := c.blocked
+	// c.blocked 由 dbMu 保护, INFO / CLIENT LIST 会读
+	defer func() {
+		dbMu.Lock()
+		c.blocked = nil
+		dbMu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if !bs.deadline.IsZero() {
+		t := time.NewTimer(time.Until(bs.deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	// netpoll 在 onRequest 执行期间不会回调关闭事件, 只能定期检查连接状态
+	alive := time.NewTicker(100 * time.Millisecond)
+	defer alive.Stop()
+
+wait:
+	for {
+		select {
+		case kv = <-bs.served:
+			return kv, true
+		case <-timeout:
+			break wait
+		case <-alive.C:
+			if c.conn != nil && !c.conn.IsActive() {
+				break wait
+			}
+		}
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	// 超时和唤醒可能同时发生, 拿到锁后再确认一次
+	select {
+	case kv = <-bs.served:
+		return kv, true
+	default:
+		unblockClient(c)
+		return kv, false
+	}
+}
@@ -0,0 +1,389 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+type clientKey struct{}
+
+var nextClientID atomic.Int64
+
+// clients 是所有网络连接, 按 id 索引, 由 dbMu 保护
+var clients = make(map[int64]*client)
+
+// client 保存单个连接的状态, 放在 netpoll 的连接 context 里
+type client struct {
+	id   int64
+	name string
+	conn netpoll.Connection
+	addr string
+
+	// 以下由 dbMu 保护, 供 CLIENT LIST 展示
+	ctime           time.Time // 连接建立的时间
+	lastInteraction time.Time // 最近一次执行命令的时间
+	lastCmd         string
+	libName         string // CLIENT SETINFO 设置的客户端库信息
+	libVer          string
+	monitor         bool    // 执行过 MONITOR
+	closeAfterReply bool    // CLIENT KILL 了自己, 写完回复后关闭
+	dec             decoder // 跨多次 onRequest 的半包缓冲
+	w               *replyWriter
+	// wmu 串行化对连接 writer 的写入: 命令回复和 pub/sub 的异步推送可能来自不同协程
+	wmu sync.Mutex
+
+	db     *DB  // SELECT 选中的库
+	asking bool // 集群模式下执行过 ASKING, 只对下一条命令有效
+
+	user          *aclUser // 当前登录的 ACL 用户, 内部连接 (重放 AOF、master 复制流) 为 nil
+	authenticated bool
+
+	blocked *blockState // 非 nil 表示正阻塞在 BLPOP/BRPOP 或 XREAD/XREADGROUP 上
+
+	aofOffset int64 // 回复前需要等待写出的 AOF 偏移, 见 flushAOF
+
+	master bool          // replica 上执行 master 复制流的伪客户端
+	repl   *replicaState // master 上, 对端是 replica 时的复制状态
+
+	// MULTI/EXEC 事务状态
+	multi      bool
+	multiDirty bool // 入队时出过错, EXEC 直接放弃
+	inExec     bool
+	queued     [][]string
+	watched    map[dbKey]watchState
+
+	subs  map[string]struct{} // 订阅的 channel
+	psubs map[string]struct{} // 订阅的 pattern
+
+	// 异步输出缓冲, 见 pushAsync
+	outMu          sync.Mutex
+	out            []byte
+	outFlushing    int // 正在写出的字节数, 也计入缓冲上限
+	outClosed      bool
+	flushing       bool
+	softLimitSince time.Time
+}
+
+func newClient(conn netpoll.Connection) *client {
+	now := time.Now()
+	return &client{
+		id:              nextClientID.Add(1),
+		conn:            conn,
+		addr:            conn.RemoteAddr().String(),
+		ctime:           now,
+		lastInteraction: now,
+		w:               newReplyWriter(conn.Writer()),
+		db:              dbs[0],
+	}
+}
+
+func withClient(ctx context.Context, c *client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+func clientFrom(ctx context.Context) *client {
+	c, _ := ctx.Value(clientKey{}).(*client)
+	return c
+}
+
+// clientType 返回 CLIENT LIST / CLIENT KILL 里的连接类型
+func (c *client) clientType() string {
+	switch {
+	case c.master:
+		return "master"
+	case c.repl != nil:
+		return "replica"
+	case c.subscriptions() > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// flags 同 CLIENT LIST 的 flags 字段
+func (c *client) flags() string {
+	var b []byte
+	switch c.clientType() {
+	case "master":
+		b = append(b, 'M')
+	case "replica":
+		b = append(b, 'S')
+	case "pubsub":
+		b = append(b, 'P')
+	}
+	if c.monitor {
+		b = append(b, 'O')
+	}
+	if c.multi {
+		b = append(b, 'x')
+	}
+	if c.blocked != nil {
+		b = append(b, 'b')
+	}
+	if c.closeAfterReply {
+		b = append(b, 'A')
+	}
+	if len(b) == 0 {
+		return "N"
+	}
+	return string(b)
+}
+
+// info 按 CLIENT LIST 的格式描述连接, 调用方持有 dbMu 和 pubsubMu
+func (c *client) info() string {
+	now := time.Now()
+	laddr, user, multi, cmd := "", "", -1, "NULL"
+	if c.conn != nil {
+		laddr = c.conn.LocalAddr().String()
+	}
+	if c.user != nil {
+		user = c.user.name
+	}
+	if c.multi {
+		multi = len(c.queued)
+	}
+	if c.lastCmd != "" {
+		cmd = c.lastCmd
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d cmd=%s user=%s resp=%d lib-name=%s lib-ver=%s",
+		c.id, c.addr, laddr, c.name, int64(now.Sub(c.ctime).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), c.db.id, len(c.subs), len(c.psubs), multi, cmd, user, c.w.proto, c.libName, c.libVer)
+}
+
+// hasSubcommands 判断命令是否以子命令区分, CLIENT LIST 的 cmd 字段带上子命令
+func hasSubcommands(cmd string) bool {
+	switch cmd {
+	case "CLIENT", "ACL", "SLOWLOG", "OBJECT", "PUBSUB", "CONFIG", "CLUSTER", "XGROUP":
+		return true
+	}
+	return false
+}
+
+// killClient 断开一个连接; 断开自己时先写完这条命令的回复
+func killClient(c, self *client) {
+	if c == self {
+		c.closeAfterReply = true
+		return
+	}
+	c.closeAsync()
+}
+
+// closeAsync 断开连接。关闭回调要拿 dbMu 和 pubsubMu, 持锁时不能同步关闭
+func (c *client) closeAsync() {
+	if c.conn != nil {
+		go c.conn.Close()
+	}
+}
+
+// validClientName 判断名字能否用于 CLIENT SETNAME / HELLO SETNAME / CLIENT SETINFO
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+const errClientName = "ERR Client names cannot contain spaces, newlines or special characters."
+
+// CLIENT ID|GETNAME|SETNAME|SETINFO|INFO|LIST|KILL ...
+func clientCommand(c *client, args []string) {
+	w := c.w
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"ID": 2, "GETNAME": 2, "SETNAME": 3, "SETINFO": 4, "INFO": 2, "LIST": -2, "KILL": -3}[sub]
+	if arity == 0 {
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try CLIENT HELP.")
+		return
+	}
+	if !arityOK(arity, len(args)) {
+		w.writeError(errWrongArgs("CLIENT|" + sub))
+		return
+	}
+	switch sub {
+	case "ID":
+		w.writeInt(c.id)
+	case "GETNAME":
+		if c.name == "" {
+			w.writeNull()
+			return
+		}
+		w.writeBulk(c.name)
+	case "SETNAME":
+		if !validClientName(args[2]) {
+			w.writeError(errClientName)
+			return
+		}
+		c.name = args[2]
+		w.writeOK()
+	case "SETINFO":
+		if !validClientName(args[3]) {
+			w.writeError("ERR " + args[2] + " cannot contain spaces, newlines or special characters.")
+			return
+		}
+		switch strings.ToUpper(args[2]) {
+		case "LIB-NAME":
+			c.libName = args[3]
+		case "LIB-VER":
+			c.libVer = args[3]
+		default:
+			w.writeError("ERR Unrecognized option '" + args[2] + "'")
+			return
+		}
+		w.writeOK()
+	case "INFO":
+		pubsubMu.Lock()
+		s := c.info()
+		pubsubMu.Unlock()
+		w.writeVerbatim("txt", s+"\n")
+	case "LIST":
+		clientListCommand(c, args[2:])
+	case "KILL":
+		clientKillCommand(c, args[2:])
+	}
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID id [id ...]]
+func clientListCommand(c *client, args []string) {
+	w := c.w
+	typ := ""
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "TYPE" && i+1 < len(args):
+			i++
+			if typ = normalizeClientType(args[i]); typ == "" {
+				w.writeError("ERR Unknown client type '" + args[i] + "'")
+				return
+			}
+		case opt == "ID" && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, ok := parseInt64(args[i])
+				if !ok || id <= 0 {
+					w.writeError("ERR Invalid client ID")
+					return
+				}
+				ids[id] = true
+			}
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+
+	var b strings.Builder
+	pubsubMu.Lock()
+	for _, id := range slices.Sorted(maps.Keys(clients)) {
+		cl := clients[id]
+		if (typ != "" && cl.clientType() != typ) || (ids != nil && !ids[id]) {
+			continue
+		}
+		b.WriteString(cl.info())
+		b.WriteByte('\n')
+	}
+	pubsubMu.Unlock()
+	w.writeVerbatim("txt", b.String())
+}
+
+// normalizeClientType 解析 TYPE 参数, slave 是 replica 的别名, 不认识时返回空串
+func normalizeClientType(s string) string {
+	switch t := strings.ToLower(s); t {
+	case "normal", "master", "replica", "pubsub":
+		return t
+	case "slave":
+		return "replica"
+	}
+	return ""
+}
+
+// CLIENT KILL addr
+// CLIENT KILL [ID id] [ADDR addr] [LADDR laddr] [USER username] [TYPE type] [SKIPME yes|no] ...
+func clientKillCommand(c *client, args []string) {
+	w := c.w
+	if len(args) == 1 {
+		// 旧格式: 按地址断开一个连接
+		for _, cl := range clients {
+			if cl.addr == args[0] {
+				killClient(cl, c)
+				w.writeOK()
+				return
+			}
+		}
+		w.writeError("ERR No such client")
+		return
+	}
+	if len(args)%2 != 0 {
+		w.writeError(errSyntax)
+		return
+	}
+
+	var id int64
+	var addr, laddr, typ string
+	var user *aclUser
+	skipme := true
+	for i := 0; i < len(args); i += 2 {
+		v := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			n, ok := parseInt64(v)
+			if !ok || n <= 0 {
+				w.writeError("ERR client-id should be greater than 0")
+				return
+			}
+			id = n
+		case "ADDR":
+			addr = v
+		case "LADDR":
+			laddr = v
+		case "TYPE":
+			if typ = normalizeClientType(v); typ == "" {
+				w.writeError("ERR Unknown client type '" + v + "'")
+				return
+			}
+		case "USER":
+			if user = aclUsers[v]; user == nil {
+				w.writeError("ERR No such user '" + v + "'")
+				return
+			}
+		case "SKIPME":
+			switch strings.ToLower(v) {
+			case "yes":
+				skipme = true
+			case "no":
+				skipme = false
+			default:
+				w.writeError(errSyntax)
+				return
+			}
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+
+	killed := 0
+	pubsubMu.Lock()
+	for _, cl := range clients {
+		switch {
+		case id != 0 && cl.id != id,
+			addr != "" && cl.addr != addr,
+			laddr != "" && (cl.conn == nil || cl.conn.LocalAddr().String() != laddr),
+			typ != "" && cl.clientType() != typ,
+			user != nil && cl.user != user,
+			skipme && cl == c:
+			continue
+		}
+		killClient(cl, c)
+		killed++
+	}
+	pubsubMu.Unlock()
+	w.writeInt(int64(killed))
+}
@@ -0,0 +1,211 @@
+package redis
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientCommands(t *testing.T) {
+	resetKeyspace()
+	addr := startTestServer(t)
+	a, b := dialTestServer(t, addr), dialTestServer(t, addr)
+
+	idReply, _ := a.do("CLIENT", "ID")
+	id := strings.TrimSuffix(strings.TrimPrefix(idReply, ":"), "\r\n")
+	if got, _ := a.do("CLIENT", "GETNAME"); got != "$-1\r\n" {
+		t.Fatalf("GETNAME before SETNAME: %q", got)
+	}
+	if got, _ := a.do("CLIENT", "SETNAME", "bad name"); got != "-"+errClientName+"\r\n" {
+		t.Fatalf("SETNAME with space: %q", got)
+	}
+	a.do("CLIENT", "SETNAME", "worker-1")
+	a.do("CLIENT", "SETINFO", "LIB-NAME", "go-redis")
+	a.do("SELECT", "3")
+	a.do("SET", "k", "v")
+	if got, _ := a.do("CLIENT", "GETNAME"); got != "$8\r\nworker-1\r\n" {
+		t.Fatalf("GETNAME: %q", got)
+	}
+
+	list, _ := b.do("CLIENT", "LIST", "ID", id)
+	for _, want := range []string{"id=" + id + " ", "name=worker-1 ", "db=3 ", "cmd=client|getname ", "user=default ", "flags=N ", "lib-name=go-redis "} {
+		if !strings.Contains(list, want) {
+			t.Fatalf("CLIENT LIST missing %q:\n%s", want, list)
+		}
+	}
+	if !regexp.MustCompile(`addr=127\.0\.0\.1:\d+ `).MatchString(list) || strings.Count(list, "\n") != 3 {
+		t.Fatalf("CLIENT LIST:\n%q", list)
+	}
+	if got, _ := b.do("CLIENT", "LIST", "TYPE", "pubsub"); got != "$0\r\n\r\n" {
+		t.Fatalf("CLIENT LIST TYPE pubsub: %q", got)
+	}
+	if got, _ := b.do("CLIENT", "LIST", "TYPE", "bogus"); got != "-ERR Unknown client type 'bogus'\r\n" {
+		t.Fatalf("CLIENT LIST bad type: %q", got)
+	}
+	info, _ := b.do("INFO", "clients")
+	if !strings.Contains(info, "connected_clients:2\r\n") {
+		t.Fatalf("INFO clients:\n%s", info)
+	}
+
+	// 按 ID 断开别的连接, SKIPME 默认跳过自己
+	if got, _ := b.do("CLIENT", "KILL", "ID", id); got != ":1\r\n" {
+		t.Fatalf("CLIENT KILL ID: %q", got)
+	}
+	if _, err := a.do("PING"); err == nil {
+		t.Fatal("killed client still connected")
+	}
+	if got, _ := b.do("CLIENT", "KILL", "TYPE", "normal"); got != ":0\r\n" {
+		t.Fatalf("CLIENT KILL TYPE normal with SKIPME: %q", got)
+	}
+	if got, _ := b.do("CLIENT", "KILL", "1.2.3.4:5"); got != "-ERR No such client\r\n" {
+		t.Fatalf("CLIENT KILL unknown addr: %q", got)
+	}
+	// 断开自己: 先收到回复再断开
+	if got, _ := b.do("CLIENT", "KILL", "TYPE", "normal", "SKIPME", "no"); got != ":1\r\n" {
+		t.Fatalf("CLIENT KILL self: %q", got)
+	}
+	if _, err := b.readReply(); err == nil {
+		t.Fatal("self-killed client still connected")
+	}
+	waitFor(t, "clients unregistered", func() bool { return len(clients) == 0 })
+}
+
+func TestSlowlog(t *testing.T) {
+	resetKeyspace()
+	dbMu.Lock()
+	slowlogLogSlowerThan = 0
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		slowlogLogSlowerThan, slowlog = 10000, nil
+		dbMu.Unlock()
+	})
+	tc := newTestClient()
+	expectReply(t, tc, "+OK\r\n", "SLOWLOG", "RESET")
+	tc.do("SET", "k", strings.Repeat("x", 200))
+	tc.do("AUTH", "secret")
+	args := []string{"RPUSH", "l"}
+	for i := range 40 {
+		args = append(args, strconv.Itoa(i))
+	}
+	tc.do(args...)
+	expectReply(t, tc, ":4\r\n", "SLOWLOG", "LEN")
+
+	got := tc.do("SLOWLOG", "GET", "3")
+	// 最新的在前: SLOWLOG LEN、RPUSH、AUTH
+	for _, want := range []string{
+		"$7\r\nSLOWLOG\r\n$3\r\nLEN\r\n",
+		"*32\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n",
+		"$23\r\n... (11 more arguments)\r\n",
+		"*2\r\n$4\r\nAUTH\r\n$10\r\n(redacted)\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("SLOWLOG GET missing %q:\n%q", want, got)
+		}
+	}
+	if strings.Contains(got, "secret") || strings.Contains(got, "$3\r\nSET\r\n") {
+		t.Fatalf("SLOWLOG GET 3 returned too much:\n%q", got)
+	}
+	if got := tc.do("SLOWLOG", "GET", "-1"); !strings.HasPrefix(got, "*6\r\n") ||
+		!strings.Contains(got, "$147\r\n"+strings.Repeat("x", 128)+"... (72 more bytes)\r\n") {
+		t.Fatalf("SLOWLOG GET -1:\n%q", got)
+	}
+
+	dbMu.Lock()
+	slowlogLogSlowerThan = int64(time.Hour / time.Microsecond)
+	dbMu.Unlock()
+	expectReply(t, tc, "+OK\r\n", "SLOWLOG", "RESET")
+	tc.do("SET", "k", "v")
+	expectReply(t, tc, ":0\r\n", "SLOWLOG", "LEN")
+}
+
+func TestSlowlogRedaction(t *testing.T) {
+	resetKeyspace()
+	dbMu.Lock()
+	slowlogLogSlowerThan = 0
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		slowlogLogSlowerThan, slowlog = 10000, nil
+		dbMu.Unlock()
+	})
+	tc := newTestClient()
+	tc.do("MIGRATE", "127.0.0.1", "1", "k", "0", "100", "AUTH", "hunter2")
+	tc.do("MIGRATE", "127.0.0.1", "1", "", "0", "100", "AUTH2", "admin", "hunter2", "KEYS", "AUTH", "k")
+	tc.do("ACL", "SETUSER", "alice", "on", ">s3cret", "<hunter2", "~*")
+	tc.do("ACL", "DELUSER", "alice")
+	got := tc.do("SLOWLOG", "GET", "4")
+	if strings.Contains(got, "hunter2") || strings.Contains(got, "admin") || strings.Contains(got, "s3cret") {
+		t.Fatalf("SLOWLOG GET leaked a password:\n%q", got)
+	}
+	// KEYS 之后的参数是 key, 原样保留
+	if !strings.Contains(got, "$4\r\nKEYS\r\n$4\r\nAUTH\r\n$1\r\nk\r\n") {
+		t.Fatalf("SLOWLOG GET:\n%q", got)
+	}
+	if !strings.Contains(got, "*7\r\n$3\r\nACL\r\n$7\r\nSETUSER\r\n$5\r\nalice\r\n$10\r\n(redacted)\r\n") {
+		t.Fatalf("SLOWLOG GET:\n%q", got)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	resetKeyspace()
+	mon := newTestClient()
+	expectReply(t, mon, "+OK\r\n", "MONITOR")
+	t.Cleanup(func() {
+		dbMu.Lock()
+		removeMonitor(mon.client)
+		dbMu.Unlock()
+	})
+	tc := newTestClient()
+	tc.do("SELECT", "2")
+	tc.do("SET", "k", "a \"b\"\n")
+	tc.do("AUTH", "pw")
+	tc.do("SLOWLOG", "LEN") // 管理命令不推送
+
+	var got string
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(got, "\r\n") < 3 && time.Now().Before(deadline) {
+		got += mon.drain()
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	got += mon.drain()
+	lines := strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n")
+	// SELECT 执行之后才推送, 显示的已经是新库
+	want := []string{`[2 ] "SELECT" "2"`, `[2 ] "SET" "k" "a \"b\"\n"`, `[2 ] "AUTH" "(redacted)"`}
+	if len(lines) != len(want) {
+		t.Fatalf("monitor got %q", got)
+	}
+	for i, line := range lines {
+		if !regexp.MustCompile(`^\+\d+\.\d{6} `).MatchString(line) || !strings.HasSuffix(line, want[i]) {
+			t.Fatalf("line %d: %q, want suffix %q", i, line, want[i])
+		}
+	}
+}
+
+func TestInfoSections(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	got := tc.do("INFO")
+	var sections []string
+	for _, line := range strings.Split(got, "\r\n") {
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			sections = append(sections, name)
+		}
+	}
+	if strings.Join(sections, ",") != "Server,Clients,Memory,Persistence,Stats,Replication,Cluster,Keyspace" {
+		t.Fatalf("INFO sections %q", sections)
+	}
+	for _, want := range []string{"redis_version:" + serverVersion + "\r\n", "uptime_in_seconds:", "total_commands_processed:", "db0:keys=1,expires=0\r\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("INFO missing %q", want)
+		}
+	}
+	server := tc.do("INFO", "server")
+	if strings.Contains(server, "# Clients") || !strings.Contains(server, "tcp_port:") {
+		t.Fatalf("INFO server:\n%s", server)
+	}
+}
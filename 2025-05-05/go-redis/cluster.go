@@ -0,0 +1,590 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 集群模式沿用 Redis Cluster 的 16384 个 hash slot 和 MOVED / ASK 重定向, 但不做 gossip:
+// 拓扑来自一个静态文件 (cluster-config-file), 每行 "<node-id> <host:port> [slot | start-end ...]",
+// 几个节点可以共用同一个文件, 端口和 port 配置相同的那一行是自己。静态文件只读,
+// 运行期间 ADDSLOTS / SETSLOT 修改的拓扑 (包括迁移中的 slot) 写到同目录下每个节点自己的 nodes-<port>.conf,
+// 启动时这个文件存在就优先读它; 迁移 slot 时要在每个节点上执行 SETSLOT
+const clusterSlots = 16384
+
+// clusterNode 是拓扑里的一个节点
+type clusterNode struct {
+	id   string
+	host string
+	port int
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+var (
+	clusterEnabled    bool
+	clusterConfigPath = "nodes.conf"
+
+	// 以下由 dbMu 保护
+	myself         *clusterNode
+	clusterNodes   = make(map[string]*clusterNode)
+	slotOwners     [clusterSlots]*clusterNode // 负责每个 slot 的节点, nil 表示没有分配
+	migratingSlots [clusterSlots]*clusterNode // 正在迁出到哪个节点
+	importingSlots [clusterSlots]*clusterNode // 正在从哪个节点迁入
+)
+
+const (
+	errClusterDisabled = "ERR This instance has cluster support disabled"
+	errCrossSlot       = "CROSSSLOT Keys in request don't hash to the same slot"
+	errClusterDown     = "CLUSTERDOWN Hash slot not served"
+	errTryAgain        = "TRYAGAIN Multiple keys request during rehashing of slot"
+)
+
+// crc16 是 CRC16-CCITT (XMODEM), 同 Redis 的 crc16.c
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// keyHashSlot 计算 key 所在的 slot; key 里有非空的 {...} 时只对第一个花括号里的内容取 hash,
+// 让相关的 key 落在同一个 slot
+func keyHashSlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key)) & (clusterSlots - 1)
+}
+
+// parseSlot 解析 slot 编号
+func parseSlot(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= clusterSlots {
+		return 0, false
+	}
+	return n, true
+}
+
+// clusterStatePath 是本节点保存运行期拓扑的文件, 和静态拓扑放在同一个目录
+func clusterStatePath() string {
+	return filepath.Join(filepath.Dir(clusterConfigPath), fmt.Sprintf("nodes-%d.conf", port))
+}
+
+// loadClusterConfig 读取拓扑文件, 本节点保存过的拓扑优先于静态拓扑;
+// 文件都不存在时只有自己一个节点, 没有分配任何 slot
+func loadClusterConfig() error {
+	myself = nil
+	clusterNodes = make(map[string]*clusterNode)
+	slotOwners = [clusterSlots]*clusterNode{}
+	migratingSlots = [clusterSlots]*clusterNode{}
+	importingSlots = [clusterSlots]*clusterNode{}
+	path := clusterStatePath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		path = clusterConfigPath
+		data, err = os.ReadFile(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// 迁移标记引用的节点可能在后面的行里, 全部读完再解析
+	var marks []string
+	for n, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var ranges []string
+		for _, f := range fields {
+			if strings.HasPrefix(f, "[") {
+				marks = append(marks, f)
+			} else {
+				ranges = append(ranges, f)
+			}
+		}
+		if err := loadClusterNode(ranges); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n+1, err)
+		}
+	}
+	for _, m := range marks {
+		if err := loadSlotMark(m); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if myself == nil {
+		myself = &clusterNode{id: newReplID(), host: "127.0.0.1", port: port}
+		if bind != "" {
+			myself.host = bind
+		}
+		clusterNodes[myself.id] = myself
+		return saveClusterConfig()
+	}
+	return nil
+}
+
+func loadClusterNode(fields []string) error {
+	if len(fields) < 2 {
+		return errors.New("missing node address")
+	}
+	host, p, err := net.SplitHostPort(fields[1])
+	if err != nil {
+		return err
+	}
+	np, err := strconv.Atoi(p)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", p)
+	}
+	if clusterNodes[fields[0]] != nil {
+		return fmt.Errorf("duplicate node id %s", fields[0])
+	}
+	node := &clusterNode{id: fields[0], host: host, port: np}
+	clusterNodes[node.id] = node
+	if np == port && (bind == "" || bind == host) {
+		myself = node
+	}
+	for _, r := range fields[2:] {
+		lo, hi, ok := strings.Cut(r, "-")
+		if !ok {
+			hi = lo
+		}
+		start, ok1 := parseSlot(lo)
+		end, ok2 := parseSlot(hi)
+		if !ok1 || !ok2 || start > end {
+			return fmt.Errorf("invalid slot range %q", r)
+		}
+		for s := start; s <= end; s++ {
+			if slotOwners[s] != nil {
+				return fmt.Errorf("slot %d is assigned twice", s)
+			}
+			slotOwners[s] = node
+		}
+	}
+	return nil
+}
+
+// loadSlotMark 解析迁移标记, 格式同 CLUSTER NODES: [slot->-id] 迁出, [slot-<-id] 迁入
+func loadSlotMark(m string) error {
+	body, ok := strings.CutSuffix(m[1:], "]")
+	if !ok {
+		return fmt.Errorf("invalid slot mark %q", m)
+	}
+	marks := &migratingSlots
+	sl, id, ok := strings.Cut(body, "->-")
+	if !ok {
+		marks = &importingSlots
+		sl, id, ok = strings.Cut(body, "-<-")
+	}
+	slot, ok2 := parseSlot(sl)
+	node := clusterNodes[id]
+	if !ok || !ok2 || node == nil {
+		return fmt.Errorf("invalid slot mark %q", m)
+	}
+	marks[slot] = node
+	return nil
+}
+
+// slotRanges 返回 node 负责的连续 slot 区间
+func slotRanges(node *clusterNode) [][2]int {
+	var ranges [][2]int
+	for s := 0; s < clusterSlots; s++ {
+		if slotOwners[s] != node {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == s-1 {
+			ranges[n-1][1] = s
+		} else {
+			ranges = append(ranges, [2]int{s, s})
+		}
+	}
+	return ranges
+}
+
+func formatSlotRange(r [2]int) string {
+	if r[0] == r[1] {
+		return strconv.Itoa(r[0])
+	}
+	return fmt.Sprintf("%d-%d", r[0], r[1])
+}
+
+// sortedClusterNodes 按 id 排序, 输出稳定
+func sortedClusterNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(clusterNodes))
+	for _, n := range clusterNodes {
+		nodes = append(nodes, n)
+	}
+	slices.SortFunc(nodes, func(a, b *clusterNode) int { return strings.Compare(a.id, b.id) })
+	return nodes
+}
+
+// saveClusterConfig 把拓扑写到本节点自己的文件, 先写临时文件再 rename。
+// 自己那一行带上迁移标记, 重启后迁移可以接着做
+func saveClusterConfig() error {
+	var b strings.Builder
+	for _, n := range sortedClusterNodes() {
+		b.WriteString(n.id + " " + n.addr())
+		for _, r := range slotRanges(n) {
+			b.WriteString(" " + formatSlotRange(r))
+		}
+		if n == myself {
+			writeSlotMarks(&b)
+		}
+		b.WriteByte('\n')
+	}
+	path := clusterStatePath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeSlotMarks 写出迁移中的 slot, [slot->-id] 表示迁出, [slot-<-id] 表示迁入
+func writeSlotMarks(b *strings.Builder) {
+	for s := 0; s < clusterSlots; s++ {
+		if t := migratingSlots[s]; t != nil {
+			fmt.Fprintf(b, " [%d->-%s]", s, t.id)
+		}
+		if f := importingSlots[s]; f != nil {
+			fmt.Fprintf(b, " [%d-<-%s]", s, f.id)
+		}
+	}
+}
+
+// countKeysInSlot 和 keysInSlot 查 0 号库 (集群模式只有这一个库) 的 slot 索引
+func countKeysInSlot(slot int) int {
+	return len(dbs[0].keys.slotIndex()[slot])
+}
+
+func keysInSlot(slot, count int) []string {
+	var keys []string
+	for k := range dbs[0].keys.slotIndex()[slot] {
+		if len(keys) >= count {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// clusterRedirect 判断命令能否在本节点执行, 不能时返回要回复的错误 (MOVED / ASK / CROSSSLOT 等)。
+// 调用方持有 dbMu
+func clusterRedirect(c *client, cmd *redisCommand, args []string) string {
+	if !clusterEnabled || c.master || loading {
+		return ""
+	}
+	return clusterRedirectKeys(c, cmd, cmd.keysOf(args))
+}
+
+// clusterRedirectTx 在 EXEC 时把整个事务当作一条命令检查: 所有入队命令的 key 要落在同一个 slot,
+// 并且这个 slot 此刻仍由本节点负责 (入队之后拓扑可能变了), 同 Redis getNodeByQuery 对 EXEC 的处理
+func clusterRedirectTx(c *client, queued [][]string) string {
+	if !clusterEnabled || c.master || loading {
+		return ""
+	}
+	var keys []string
+	for _, q := range queued {
+		keys = append(keys, lookupCommand(q[0]).keysOf(q)...)
+	}
+	return clusterRedirectKeys(c, commands["EXEC"], keys)
+}
+
+func clusterRedirectKeys(c *client, cmd *redisCommand, keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := keyHashSlot(keys[0])
+	for _, k := range keys[1:] {
+		if keyHashSlot(k) != slot {
+			return errCrossSlot
+		}
+	}
+	owner := slotOwners[slot]
+	if owner == nil {
+		return errClusterDown
+	}
+	// MIGRATE 在迁移中的 slot 上总是由源节点执行
+	if cmd.name == "MIGRATE" && (migratingSlots[slot] != nil || importingSlots[slot] != nil) {
+		return ""
+	}
+	missing := 0
+	for _, k := range keys {
+		if _, ok := c.db.lookupKeyNoTouch(k); !ok {
+			missing++
+		}
+	}
+	if owner == myself {
+		// 迁出中: 本地没有的 key 可能已经搬到目标节点, 让客户端带 ASKING 去那边试
+		if target := migratingSlots[slot]; target != nil && missing > 0 {
+			return fmt.Sprintf("ASK %d %s", slot, target.addr())
+		}
+		return ""
+	}
+	if importingSlots[slot] != nil && (c.asking || cmd.name == "RESTORE-ASKING") {
+		// 多个 key 只迁过来一部分时, 两边都不完整, 只能稍后重试
+		if len(keys) > 1 && missing > 0 {
+			return errTryAgain
+		}
+		return ""
+	}
+	return fmt.Sprintf("MOVED %d %s", slot, owner.addr())
+}
+
+// ASKING
+func askingCommand(c *client, args []string) {
+	w := c.w
+	if !clusterEnabled {
+		w.writeError(errClusterDisabled)
+		return
+	}
+	c.asking = true
+	w.writeOK()
+}
+
+// CLUSTER KEYSLOT|SLOTS|NODES|MYID|INFO|ADDSLOTS|SETSLOT|COUNTKEYSINSLOT|GETKEYSINSLOT|SAVECONFIG ...
+func clusterCommand(c *client, args []string) {
+	w := c.w
+	if !clusterEnabled {
+		w.writeError(errClusterDisabled)
+		return
+	}
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"KEYSLOT": 3, "SLOTS": 2, "NODES": 2, "MYID": 2, "INFO": 2, "ADDSLOTS": -3,
+		"SETSLOT": -4, "COUNTKEYSINSLOT": 3, "GETKEYSINSLOT": 4, "SAVECONFIG": 2}[sub]
+	if arity == 0 {
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try CLUSTER HELP.")
+		return
+	}
+	if !arityOK(arity, len(args)) {
+		w.writeError(errWrongArgs("CLUSTER|" + sub))
+		return
+	}
+	switch sub {
+	case "KEYSLOT":
+		w.writeInt(int64(keyHashSlot(args[2])))
+	case "SLOTS":
+		clusterSlotsReply(w)
+	case "NODES":
+		w.writeVerbatim("txt", clusterNodesDescription())
+	case "MYID":
+		w.writeBulk(myself.id)
+	case "INFO":
+		clusterInfoReply(w)
+	case "ADDSLOTS":
+		clusterAddSlots(w, args[2:])
+	case "SETSLOT":
+		clusterSetSlot(w, args[2:])
+	case "COUNTKEYSINSLOT":
+		slot, ok := parseSlot(args[2])
+		if !ok {
+			w.writeError("ERR Invalid slot")
+			return
+		}
+		w.writeInt(int64(countKeysInSlot(slot)))
+	case "GETKEYSINSLOT":
+		slot, ok := parseSlot(args[2])
+		count, err := strconv.Atoi(args[3])
+		if !ok || err != nil || count < 0 {
+			w.writeError("ERR Invalid slot or number of keys")
+			return
+		}
+		w.writeBulks(keysInSlot(slot, count)...)
+	case "SAVECONFIG":
+		if err := saveClusterConfig(); err != nil {
+			w.writeError("ERR error saving the cluster node config: " + err.Error())
+			return
+		}
+		w.writeOK()
+	}
+}
+
+// clusterSlotsReply 每个连续区间一项: [start, end, [host, port, id]]
+func clusterSlotsReply(w *replyWriter) {
+	type slotRange struct {
+		r    [2]int
+		node *clusterNode
+	}
+	var ranges []slotRange
+	for _, n := range clusterNodes {
+		for _, r := range slotRanges(n) {
+			ranges = append(ranges, slotRange{r, n})
+		}
+	}
+	slices.SortFunc(ranges, func(a, b slotRange) int { return a.r[0] - b.r[0] })
+	w.writeArray(len(ranges))
+	for _, sr := range ranges {
+		w.writeArray(3)
+		w.writeInt(int64(sr.r[0]))
+		w.writeInt(int64(sr.r[1]))
+		w.writeArray(3)
+		w.writeBulk(sr.node.host)
+		w.writeInt(int64(sr.node.port))
+		w.writeBulk(sr.node.id)
+	}
+}
+
+// clusterNodesDescription 同 CLUSTER NODES 的格式:
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+// 迁移中的 slot 只出现在自己那一行
+func clusterNodesDescription() string {
+	var b strings.Builder
+	for _, n := range sortedClusterNodes() {
+		flags := "master"
+		if n == myself {
+			flags = "myself,master"
+		}
+		fmt.Fprintf(&b, "%s %s@%d %s - 0 0 0 connected", n.id, n.addr(), n.port+10000, flags)
+		for _, r := range slotRanges(n) {
+			b.WriteString(" " + formatSlotRange(r))
+		}
+		if n == myself {
+			writeSlotMarks(&b)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func clusterInfoReply(w *replyWriter) {
+	assigned := 0
+	masters := make(map[*clusterNode]bool)
+	for _, n := range slotOwners {
+		if n != nil {
+			assigned++
+			masters[n] = true
+		}
+	}
+	state := "ok"
+	if assigned < clusterSlots {
+		state = "fail"
+	}
+	var b strings.Builder
+	infoField(&b, "cluster_enabled", 1)
+	infoField(&b, "cluster_state", state)
+	infoField(&b, "cluster_slots_assigned", assigned)
+	infoField(&b, "cluster_known_nodes", len(clusterNodes))
+	infoField(&b, "cluster_size", len(masters))
+	w.writeVerbatim("txt", b.String())
+}
+
+// CLUSTER ADDSLOTS slot [slot ...], 把还没有分配的 slot 分给自己
+func clusterAddSlots(w *replyWriter, args []string) {
+	slots := make([]int, 0, len(args))
+	for _, a := range args {
+		s, ok := parseSlot(a)
+		if !ok {
+			w.writeError("ERR Invalid or out of range slot")
+			return
+		}
+		if slices.Contains(slots, s) {
+			w.writeError(fmt.Sprintf("ERR Slot %d specified multiple times", s))
+			return
+		}
+		if slotOwners[s] != nil {
+			w.writeError(fmt.Sprintf("ERR Slot %d is already busy", s))
+			return
+		}
+		slots = append(slots, s)
+	}
+	for _, s := range slots {
+		slotOwners[s] = myself
+		importingSlots[s] = nil
+	}
+	clusterSaveReply(w)
+}
+
+// CLUSTER SETSLOT slot IMPORTING node-id | MIGRATING node-id | STABLE | NODE node-id
+func clusterSetSlot(w *replyWriter, args []string) {
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		w.writeError("ERR Invalid or out of range slot")
+		return
+	}
+	action := strings.ToUpper(args[1])
+	if action == "STABLE" {
+		if len(args) != 2 {
+			w.writeError("ERR syntax error")
+			return
+		}
+		migratingSlots[slot], importingSlots[slot] = nil, nil
+		clusterSaveReply(w)
+		return
+	}
+	if len(args) != 3 || (action != "IMPORTING" && action != "MIGRATING" && action != "NODE") {
+		w.writeError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		return
+	}
+	node := clusterNodes[args[2]]
+	if node == nil {
+		w.writeError("ERR I don't know about node " + args[2])
+		return
+	}
+	switch action {
+	case "MIGRATING":
+		if slotOwners[slot] != myself {
+			w.writeError(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+			return
+		}
+		if node == myself {
+			w.writeError("ERR I can't migrate to myself")
+			return
+		}
+		migratingSlots[slot] = node
+		clusterSaveReply(w)
+	case "IMPORTING":
+		if slotOwners[slot] == myself {
+			w.writeError(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+			return
+		}
+		if node == myself {
+			w.writeError("ERR I can't import from myself")
+			return
+		}
+		importingSlots[slot] = node
+		clusterSaveReply(w)
+	case "NODE":
+		// 迁出的节点要等 key 全部搬走后才能把 slot 交出去
+		if slotOwners[slot] == myself && node != myself && countKeysInSlot(slot) > 0 {
+			w.writeError(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+			return
+		}
+		if migratingSlots[slot] != nil && node != myself {
+			migratingSlots[slot] = nil
+		}
+		if importingSlots[slot] != nil && node == myself {
+			importingSlots[slot] = nil
+		}
+		slotOwners[slot] = node
+		clusterSaveReply(w)
+	}
+}
+
+func clusterSaveReply(w *replyWriter) {
+	if err := saveClusterConfig(); err != nil {
+		w.writeError("ERR error saving the cluster node config: " + err.Error())
+		return
+	}
+	w.writeOK()
+}
+
+func infoCluster(b *strings.Builder) {
+	infoField(b, "cluster_enabled", boolInt(clusterEnabled))
+}
@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testMyID    = "1111111111111111111111111111111111111111"
+	testOtherID = "2222222222222222222222222222222222222222"
+)
+
+// enableCluster 用给定的拓扑文件打开集群模式, 测试结束后关闭
+func enableCluster(t *testing.T, topology string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	if err := os.WriteFile(path, []byte(topology), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	savedPath := clusterConfigPath
+	clusterConfigPath = path
+	if err := loadClusterConfig(); err != nil {
+		t.Fatal(err)
+	}
+	clusterEnabled = true
+	t.Cleanup(func() {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		clusterEnabled = false
+		clusterConfigPath = savedPath
+		myself, clusterNodes = nil, make(map[string]*clusterNode)
+		slotOwners = [clusterSlots]*clusterNode{}
+		migratingSlots = [clusterSlots]*clusterNode{}
+		importingSlots = [clusterSlots]*clusterNode{}
+	})
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	if crc16("123456789") != 0x31c3 {
+		t.Fatalf("crc16 = %#x", crc16("123456789"))
+	}
+	for key, want := range map[string]int{
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": keyHashSlot("user1000"),
+		"foo{}{bar}":           int(crc16("foo{}{bar}")) & (clusterSlots - 1),
+		"foo{{bar}}zap":        keyHashSlot("{bar"),
+		"foo{bar}{zap}":        keyHashSlot("bar"),
+		"{user1000}.followers": keyHashSlot("user1000"),
+		"no-closing-brace{abc": int(crc16("no-closing-brace{abc")) & (clusterSlots - 1),
+	} {
+		if got := keyHashSlot(key); got != want {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestClusterRedirect(t *testing.T) {
+	resetKeyspace()
+	enableCluster(t, "# static topology\n"+
+		testMyID+" 127.0.0.1:"+strconv.Itoa(port)+" 0-8191\n"+
+		testOtherID+" 127.0.0.1:7002 8192-16383\n")
+	tc := newTestClient()
+
+	expectReply(t, tc, "-MOVED 12182 127.0.0.1:7002\r\n", "GET", "foo")
+	expectReply(t, tc, "+OK\r\n", "SET", "bar", "v")
+	expectReply(t, tc, "-"+errCrossSlot+"\r\n", "MSET", "{bar}a", "1", "b", "2")
+	expectReply(t, tc, "+OK\r\n", "MSET", "{bar}a", "1", "{bar}b", "2")
+	expectReply(t, tc, "-ERR SELECT is not allowed in cluster mode\r\n", "SELECT", "1")
+	expectReply(t, tc, ":12182\r\n", "CLUSTER", "KEYSLOT", "foo")
+	expectReply(t, tc, ":3\r\n", "CLUSTER", "COUNTKEYSINSLOT", "5061")
+	expectReply(t, tc, "*2\r\n"+
+		"*3\r\n:0\r\n:8191\r\n*3\r\n$9\r\n127.0.0.1\r\n:"+strconv.Itoa(port)+"\r\n$40\r\n"+testMyID+"\r\n"+
+		"*3\r\n:8192\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:7002\r\n$40\r\n"+testOtherID+"\r\n",
+		"CLUSTER", "SLOTS")
+	if info := tc.do("CLUSTER", "INFO"); !strings.Contains(info, "cluster_state:ok\r\n") || !strings.Contains(info, "cluster_known_nodes:2\r\n") {
+		t.Fatalf("CLUSTER INFO %q", info)
+	}
+
+	// 事务里被重定向的命令让 EXEC 整体放弃
+	tc.do("MULTI")
+	expectReply(t, tc, "-MOVED 12182 127.0.0.1:7002\r\n", "SET", "foo", "1")
+	expectReply(t, tc, "-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+
+	// 迁出: 本地还有的 key 照常执行, 没有的 key 让客户端去目标节点 ASK
+	expectReply(t, tc, "+OK\r\n", "CLUSTER", "SETSLOT", "5061", "MIGRATING", testOtherID)
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "bar")
+	expectReply(t, tc, "-ASK 5061 127.0.0.1:7002\r\n", "GET", "{bar}missing")
+	if nodes := tc.do("CLUSTER", "NODES"); !strings.Contains(nodes, testMyID+" 127.0.0.1:"+strconv.Itoa(port)+"@") ||
+		!strings.Contains(nodes, "myself,master") || !strings.Contains(nodes, "[5061->-"+testOtherID+"]") {
+		t.Fatalf("CLUSTER NODES %q", nodes)
+	}
+	expectReply(t, tc, "-ERR Can't assign hashslot 5061 to a different node while I still hold keys for this hash slot.\r\n",
+		"CLUSTER", "SETSLOT", "5061", "NODE", testOtherID)
+	if got := tc.do("CLUSTER", "GETKEYSINSLOT", "5061", "10"); !strings.HasPrefix(got, "*3\r\n") {
+		t.Fatalf("GETKEYSINSLOT %q", got)
+	}
+	tc.do("DEL", "bar", "{bar}a", "{bar}b")
+	expectReply(t, tc, "+OK\r\n", "CLUSTER", "SETSLOT", "5061", "NODE", testOtherID)
+	expectReply(t, tc, "-MOVED 5061 127.0.0.1:7002\r\n", "GET", "bar")
+	expectReply(t, tc, ":0\r\n", "CLUSTER", "COUNTKEYSINSLOT", "5061")
+	// 修改后的拓扑写到本节点自己的文件, 共用的静态拓扑不动
+	data, _ := os.ReadFile(clusterStatePath())
+	if !strings.Contains(string(data), testOtherID+" 127.0.0.1:7002 5061 8192-16383\n") {
+		t.Fatalf("topology not saved:\n%s", data)
+	}
+	if data, _ := os.ReadFile(clusterConfigPath); strings.Contains(string(data), "5061") {
+		t.Fatalf("static topology was rewritten:\n%s", data)
+	}
+
+	// 迁入: 只有带 ASKING 的下一条命令在本地执行
+	expectReply(t, tc, "+OK\r\n", "CLUSTER", "SETSLOT", "5061", "IMPORTING", testOtherID)
+	// 迁入标记随拓扑保存, 重启后还在
+	dbMu.Lock()
+	err := loadClusterConfig()
+	dbMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes := tc.do("CLUSTER", "NODES"); !strings.Contains(nodes, "[5061-<-"+testOtherID+"]") {
+		t.Fatalf("CLUSTER NODES after reload %q", nodes)
+	}
+	expectReply(t, tc, "+OK\r\n", "ASKING")
+	expectReply(t, tc, "+OK\r\n", "SET", "bar", "imported")
+	expectReply(t, tc, "-MOVED 5061 127.0.0.1:7002\r\n", "GET", "bar")
+	expectReply(t, tc, "+OK\r\n", "ASKING")
+	expectReply(t, tc, "-"+errTryAgain+"\r\n", "MGET", "bar", "{bar}other")
+	expectReply(t, tc, "+OK\r\n", "CLUSTER", "SETSLOT", "5061", "NODE", testMyID)
+	expectReply(t, tc, "$8\r\nimported\r\n", "GET", "bar")
+
+	expectReply(t, tc, "-ERR Slot 0 is already busy\r\n", "CLUSTER", "ADDSLOTS", "0")
+	expectReply(t, tc, "-ERR I don't know about node nobody\r\n", "CLUSTER", "SETSLOT", "1", "NODE", "nobody")
+}
+
+func TestClusterExec(t *testing.T) {
+	resetKeyspace()
+	enableCluster(t, testMyID+" 127.0.0.1:"+strconv.Itoa(port)+" 0-8191\n"+
+		testOtherID+" 127.0.0.1:7002 8192-16383\n")
+	tc, admin := newTestClient(), newTestClient()
+
+	// 每条命令单独看都在本节点, 但整个事务跨了 slot (b 在 3300, c 在 7365)
+	tc.do("MULTI")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "b", "1")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "c", "1")
+	expectReply(t, tc, "-"+errCrossSlot+"\r\n", "EXEC")
+	expectReply(t, tc, "-ERR EXEC without MULTI\r\n", "EXEC")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "b")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "c")
+
+	// 入队之后 slot 被交给了别的节点
+	tc.do("MULTI")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "b", "1")
+	expectReply(t, admin, "+OK\r\n", "CLUSTER", "SETSLOT", "3300", "NODE", testOtherID)
+	expectReply(t, tc, "-MOVED 3300 127.0.0.1:7002\r\n", "EXEC")
+	expectReply(t, admin, "+OK\r\n", "CLUSTER", "SETSLOT", "3300", "NODE", testMyID)
+
+	tc.do("MULTI")
+	tc.do("SET", "{b}x", "1")
+	tc.do("INCR", "b")
+	expectReply(t, tc, "*2\r\n+OK\r\n:1\r\n", "EXEC")
+}
+
+func TestClusterDisabled(t *testing.T) {
+	tc := newTestClient()
+	expectReply(t, tc, "-"+errClusterDisabled+"\r\n", "CLUSTER", "SLOTS")
+	expectReply(t, tc, "-"+errClusterDisabled+"\r\n", "ASKING")
+}
+
+func TestDumpRestore(t *testing.T) {
+	resetKeyspace()
+	if err := aofFile.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	tc := newTestClient()
+	tc.do("RPUSH", "l", "a", "b", "1")
+	tc.do("SADD", "ints", "1", "2", "3")
+	tc.do("HSET", "h", "f", "v")
+	tc.do("ZADD", "z", "1.5", "m")
+	tc.do("SET", "s", "12345")
+
+	for _, key := range []string{"l", "ints", "h", "z", "s"} {
+		payload := tc.do("DUMP", key)
+		payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+		expectReply(t, tc, "-BUSYKEY Target key name already exists.\r\n", "RESTORE", key, "0", payload)
+		expectReply(t, tc, "+OK\r\n", "RESTORE", key+"-copy", "100000", payload)
+		expectReply(t, tc, tc.do("TYPE", key), "TYPE", key+"-copy")
+	}
+	expectReply(t, tc, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n1\r\n", "LRANGE", "l-copy", "0", "-1")
+	expectReply(t, tc, ":3\r\n", "SCARD", "ints-copy")
+	expectReply(t, tc, "$5\r\n12345\r\n", "GET", "s-copy")
+	if ttl := tc.do("PTTL", "h-copy"); ttl == ":-1\r\n" {
+		t.Fatalf("RESTORE ttl not set: %q", ttl)
+	}
+
+	payload := string(dumpPayload(newStringObject("v")))
+	corrupted := payload[:len(payload)-1] + "x"
+	expectReply(t, tc, "-ERR DUMP payload version or checksum are wrong\r\n", "RESTORE", "k", "0", corrupted)
+	expectReply(t, tc, "+OK\r\n", "RESTORE", "s", "0", payload, "REPLACE")
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "s")
+	expectReply(t, tc, "$-1\r\n", "DUMP", "missing")
+
+	// 传播出去的是 REPLACE + 绝对过期时间, 重放结果一致
+	replayFromStart(t)
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "s")
+	expectReply(t, tc, "$1\r\nv\r\n", "HGET", "h-copy", "f")
+	if ttl := tc.do("PTTL", "h-copy"); ttl == ":-1\r\n" || ttl == ":-2\r\n" {
+		t.Fatalf("TTL lost after replay: %q", ttl)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	resetKeyspace()
+	received := make(chan []string, 16)
+	port := fakeMaster(t, func(conn net.Conn, r *bufio.Reader) {
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			received <- args
+			if args[0] == "RESTORE-ASKING" && args[1] == "busy" {
+				conn.Write([]byte("-BUSYKEY Target key name already exists.\r\n"))
+			} else {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+	target := strconv.Itoa(port)
+	tc := newTestClient()
+	tc.do("SET", "a", "1")
+	tc.do("SET", "b", "2", "EX", "100")
+	tc.do("SET", "busy", "3")
+
+	expectReply(t, tc, "-ERR Target instance replied with error: BUSYKEY Target key name already exists.\r\n",
+		"MIGRATE", "127.0.0.1", target, "", "0", "1000", "AUTH", "pw", "KEYS", "a", "b", "busy", "missing")
+	want := [][]string{{"AUTH", "pw"}, {"SELECT", "0"}, {"RESTORE-ASKING", "a", "0"}, {"RESTORE-ASKING", "b"}, {"RESTORE-ASKING", "busy", "0"}}
+	for i, w := range want {
+		select {
+		case got := <-received:
+			if len(got) < len(w) || strings.Join(got[:len(w)], " ") != strings.Join(w, " ") {
+				t.Fatalf("command %d: got %q, want prefix %q", i, got, w)
+			}
+			if w[0] == "RESTORE-ASKING" && w[1] == "b" {
+				if ttl, _ := strconv.Atoi(got[2]); ttl <= 0 || ttl > 100000 {
+					t.Fatalf("migrated TTL %s", got[2])
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("target did not receive %q", w)
+		}
+	}
+	// 迁移成功的 key 从本地删除, 被拒绝的保留
+	expectReply(t, tc, ":1\r\n", "EXISTS", "a", "b", "busy")
+
+	expectReply(t, tc, "+NOKEY\r\n", "MIGRATE", "127.0.0.1", target, "missing", "0", "1000")
+	tc.do("SET", "c", "4")
+	expectReply(t, tc, "+OK\r\n", "MIGRATE", "127.0.0.1", target, "c", "0", "1000", "COPY", "REPLACE")
+	expectReply(t, tc, ":1\r\n", "EXISTS", "c")
+}
@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	serverName    = "go-redis"
+	serverVersion = "7.2.0" // 对外声明兼容的 Redis 版本, 客户端库据此决定能用哪些特性
+)
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// 切换当前连接的协议版本, 回复服务端信息
+func helloCommand(c *client, args []string) {
+	w := c.w
+	proto := w.proto
+	i := 1
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			w.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v != resp2 && v != resp3 {
+			w.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+		i = 2
+	}
+
+	name, setName := "", false
+	user, pass, auth := "", "", false
+	for ; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && i+2 < len(args):
+			user, pass, auth = args[i+1], args[i+2], true
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			name, setName = args[i+1], true
+			if !validClientName(name) {
+				w.writeError(errClientName)
+				return
+			}
+			i++
+		default:
+			w.writeError("ERR Syntax error in HELLO option '" + args[i] + "'")
+			return
+		}
+	}
+
+	if auth {
+		if !authenticate(c, user, pass) {
+			w.writeError(errWrongPass)
+			return
+		}
+	} else if authRequired(c) {
+		w.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+
+	// 所有参数校验通过后才生效, 回复本身就用新协议编码
+	w.proto = proto
+	if setName {
+		c.name = name
+	}
+	w.writeMap(7)
+	w.writeBulk("server")
+	w.writeBulk(serverName)
+	w.writeBulk("version")
+	w.writeBulk(serverVersion)
+	w.writeBulk("proto")
+	w.writeInt(int64(proto))
+	w.writeBulk("id")
+	w.writeInt(c.id)
+	w.writeBulk("mode")
+	w.writeBulk("standalone")
+	w.writeBulk("role")
+	if masterHost != "" {
+		w.writeBulk("replica")
+	} else {
+		w.writeBulk("master")
+	}
+	w.writeBulk("modules")
+	w.writeArray(0)
+}
+
+// PING [message], 订阅模式下以消息的形式回复
+func pingCommand(c *client, args []string) {
+	w := c.w
+	if len(args) > 2 {
+		w.writeError(errWrongArgs("PING"))
+		return
+	}
+	if w.proto == resp2 && c.subscriptions() > 0 {
+		msg := ""
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		w.writeBulks("pong", msg)
+	} else if len(args) == 2 {
+		w.writeBulk(args[1])
+	} else {
+		w.writeSimple("PONG")
+	}
+}
+
+// ECHO message
+func echoCommand(c *client, args []string) {
+	c.w.writeBulk(args[1])
+}
+
+// QUIT, 回复 OK 后关闭连接, 流水线里后面的命令不再执行
+func quitCommand(c *client, args []string) {
+	c.w.writeOK()
+	c.closeAfterReply = true
+}
+
+// RESET 把连接恢复到刚建立时的状态: 放弃事务和 WATCH, 退出订阅和 MONITOR,
+// 切回 RESP2 和 0 号库, 清掉连接名, 重新以 default 用户登录
+func resetCommand(c *client, args []string) {
+	discardTransaction(c)
+	unsubscribeAll(c)
+	if c.monitor {
+		removeMonitor(c)
+		c.monitor = false
+	}
+	c.w.proto = resp2
+	c.db = dbs[0]
+	c.name = ""
+	c.asking = false
+	if c.user != nil {
+		initClientAuth(c)
+	}
+	c.w.writeSimple("RESET")
+}
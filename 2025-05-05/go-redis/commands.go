@@ -0,0 +1,470 @@
+package redis
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 命令表: 每个命令的参数个数、标志、key 位置和处理函数都在这里声明,
+// 参数个数校验、ACL 分类、写命令的 AOF 传播和 COMMAND 的回复都从表里来, 同 Redis 的 commands.def
+
+// 命令标志, COMMAND 回复里的 flags
+const (
+	cmdWrite    = 1 << iota // 修改数据, replica 上拒绝执行, 改了数据时原样写进 AOF
+	cmdReadonly             // 只读数据, 属于 @read
+	cmdFast                 // O(1) 或 O(log N) 的命令, 属于 @fast, 其余属于 @slow
+	cmdBlocking             // 可能阻塞客户端
+	cmdDenyOOM              // 可能增加内存, 超过 maxmemory 时拒绝执行
+	cmdAdmin                // 管理命令, 属于 @admin 和 @dangerous, 不推给 MONITOR
+	cmdNoAuth               // 未认证时也能执行
+	// 以下两个只在内部使用, 不出现在 COMMAND 回复里
+	cmdTxControl // MULTI 期间仍然立即执行, 不入队
+	cmdPubsubOK  // RESP2 订阅模式下也能执行
+)
+
+var cmdFlagNames = []struct {
+	flag int
+	name string
+}{
+	{cmdWrite, "write"}, {cmdReadonly, "readonly"}, {cmdDenyOOM, "denyoom"}, {cmdAdmin, "admin"},
+	{cmdBlocking, "blocking"}, {cmdFast, "fast"}, {cmdNoAuth, "no_auth"},
+}
+
+// redisCommand 是命令表的一项
+type redisCommand struct {
+	name    string // 大写命令名
+	arity   int    // 参数个数 (含命令名), 负数表示至少 -arity 个
+	flags   int
+	keys    keySpec                      // step 为 0 表示没有 key
+	getKeys func(args []string) []string // key 位置不固定的命令 (movablekeys) 用它取 key
+	group   string                       // COMMAND DOCS 的 group, 数据类型的 group 同时是 ACL 分类
+	acl     []string                     // 标志和 group 之外的 ACL 分类
+	summary string                       // COMMAND DOCS 的 summary
+	proc    func(c *client, args []string)
+
+	categories []string // 由上面几项推导出的 ACL 分类, 启动时计算
+}
+
+// commands 按大写命令名索引命令表, 在 init 里从 commandTable 生成
+var commands = make(map[string]*redisCommand)
+
+// commandTable 只在 init 里使用, 处理函数里引用它会造成初始化循环
+var commandTable = []*redisCommand{
+	// connection
+	{name: "PING", arity: -1, flags: cmdFast | cmdPubsubOK, group: "connection", summary: "Returns the server's liveliness response.", proc: pingCommand},
+	{name: "ECHO", arity: 2, flags: cmdFast, group: "connection", summary: "Returns the given string.", proc: echoCommand},
+	{name: "HELLO", arity: -1, flags: cmdFast | cmdNoAuth, group: "connection", summary: "Handshakes with the Redis server.", proc: helloCommand},
+	{name: "AUTH", arity: -2, flags: cmdFast | cmdNoAuth, group: "connection", summary: "Authenticates the connection.", proc: authCommand},
+	{name: "SELECT", arity: 2, flags: cmdFast, group: "connection", summary: "Changes the selected database.", proc: selectCommand},
+	{name: "QUIT", arity: -1, flags: cmdFast | cmdNoAuth | cmdTxControl | cmdPubsubOK, group: "connection", summary: "Closes the connection.", proc: quitCommand},
+	{name: "RESET", arity: 1, flags: cmdFast | cmdNoAuth | cmdTxControl | cmdPubsubOK, group: "connection", summary: "Resets the connection.", proc: resetCommand},
+	{name: "CLIENT", arity: -2, flags: cmdAdmin, group: "connection", summary: "A container for client connection commands.", proc: clientCommand},
+
+	// string
+	{name: "SET", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "string", summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", proc: setCommand},
+	{name: "GET", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Returns the string value of a key.", proc: getCommand},
+	{name: "SETNX", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Set the string value of a key only when the key doesn't exist.", proc: setnxCommand},
+	{name: "GETDEL", arity: 2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Returns the string value of a key after deleting the key.", proc: getdelCommand},
+	{name: "GETEX", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Returns the string value of a key after setting its expiration time.", proc: getexCommand},
+	{name: "MGET", arity: -2, flags: cmdReadonly | cmdFast, keys: keySpec{1, -1, 1}, group: "string", summary: "Atomically returns the string values of one or more keys.", proc: mgetCommand},
+	{name: "MSET", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 2}, group: "string", summary: "Atomically creates or modifies the string values of one or more keys.",
+		proc: func(c *client, args []string) { msetCommand(c, args, false) }},
+	{name: "MSETNX", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 2}, group: "string", summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.",
+		proc: func(c *client, args []string) { msetCommand(c, args, true) }},
+	{name: "INCR", arity: 2, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", proc: incrCommand},
+	{name: "DECR", arity: 2, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", proc: incrCommand},
+	{name: "INCRBY", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", proc: incrCommand},
+	{name: "DECRBY", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", proc: incrCommand},
+	{name: "INCRBYFLOAT", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.", proc: incrbyfloatCommand},
+	{name: "APPEND", arity: 3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.", proc: appendCommand},
+	{name: "STRLEN", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "string", summary: "Returns the length of a string value.", proc: strlenCommand},
+	{name: "GETRANGE", arity: 4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "string", summary: "Returns a substring of the string stored at a key.", proc: getrangeCommand},
+	{name: "SETRANGE", arity: 4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "string", summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", proc: setrangeCommand},
+
+	// bitmap
+	{name: "SETBIT", arity: 4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.", proc: setbitCommand},
+	{name: "GETBIT", arity: 3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Returns a bit value by offset.", proc: getbitCommand},
+	{name: "BITCOUNT", arity: -2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Counts the number of set bits (population counting) in a string.", proc: bitcountCommand},
+	{name: "BITPOS", arity: -3, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Finds the first set (1) or clear (0) bit in a string.", proc: bitposCommand},
+	{name: "BITOP", arity: -4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{2, -1, 1}, group: "bitmap", summary: "Performs bitwise operations on multiple strings, and stores the result.", proc: bitopCommand},
+	{name: "BITFIELD", arity: -2, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Performs arbitrary bitfield integer operations on strings.",
+		proc: func(c *client, args []string) { bitfieldCommand(c, args, false) }},
+	{name: "BITFIELD_RO", arity: -2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "bitmap", summary: "Performs arbitrary read-only bitfield integer operations on strings.",
+		proc: func(c *client, args []string) { bitfieldCommand(c, args, true) }},
+
+	// hyperloglog
+	{name: "PFADD", arity: -2, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "hyperloglog", summary: "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist.", proc: pfaddCommand},
+	{name: "PFCOUNT", arity: -2, flags: cmdReadonly, keys: keySpec{1, -1, 1}, group: "hyperloglog", summary: "Returns the approximated cardinality of the set(s) observed by the HyperLogLog key(s).", proc: pfcountCommand},
+	{name: "PFMERGE", arity: -2, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 1}, group: "hyperloglog", summary: "Merges one or more HyperLogLog values into a single key.", proc: pfmergeCommand},
+
+	// hash
+	{name: "HSET", arity: -4, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Creates or modifies the value of a field in a hash.", proc: hsetCommand},
+	{name: "HGET", arity: 3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns the value of a field in a hash.", proc: hgetCommand},
+	{name: "HMGET", arity: -3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns the values of all fields in a hash.", proc: hmgetCommand},
+	{name: "HDEL", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", proc: hdelCommand},
+	{name: "HEXISTS", arity: 3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Determines whether a field exists in a hash.", proc: hexistsCommand},
+	{name: "HLEN", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns the number of fields in a hash.", proc: hlenCommand},
+	{name: "HGETALL", arity: 2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns all fields and values in a hash.", proc: hgetallCommand},
+	{name: "HKEYS", arity: 2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns all fields in a hash.", proc: hkeysCommand},
+	{name: "HVALS", arity: 2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "hash", summary: "Returns all values in a hash.", proc: hvalsCommand},
+	{name: "HINCRBY", arity: 4, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "hash", summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", proc: hincrbyCommand},
+	{name: "HSCAN", arity: -3, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "hash", summary: "Iterates over fields and values of a hash.", proc: hscanCommand},
+
+	// list
+	{name: "LPUSH", arity: -3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "list", summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		proc: func(c *client, args []string) { pushCommand(c, args, true) }},
+	{name: "RPUSH", arity: -3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "list", summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		proc: func(c *client, args []string) { pushCommand(c, args, false) }},
+	{name: "LPOP", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "list", summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		proc: func(c *client, args []string) { popCommand(c, args, true) }},
+	{name: "RPOP", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "list", summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+		proc: func(c *client, args []string) { popCommand(c, args, false) }},
+	{name: "LLEN", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "list", summary: "Returns the length of a list.", proc: llenCommand},
+	{name: "LRANGE", arity: 4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "list", summary: "Returns a range of elements from a list.", proc: lrangeCommand},
+	{name: "LINDEX", arity: 3, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "list", summary: "Returns an element from a list by its index.", proc: lindexCommand},
+	{name: "LSET", arity: 4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "list", summary: "Sets the value of an element in a list by its index.", proc: lsetCommand},
+	{name: "LTRIM", arity: 4, flags: cmdWrite, keys: keySpec{1, 1, 1}, group: "list", summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", proc: ltrimCommand},
+	{name: "LREM", arity: 4, flags: cmdWrite, keys: keySpec{1, 1, 1}, group: "list", summary: "Removes elements from a list. Deletes the list if the last element was removed.", proc: lremCommand},
+	{name: "BLPOP", arity: -3, flags: cmdWrite | cmdBlocking, keys: keySpec{1, -2, 1}, group: "list", summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise.",
+		proc: func(c *client, args []string) { bpopCommand(c, args, true) }},
+	{name: "BRPOP", arity: -3, flags: cmdWrite | cmdBlocking, keys: keySpec{1, -2, 1}, group: "list", summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise.",
+		proc: func(c *client, args []string) { bpopCommand(c, args, false) }},
+
+	// sorted-set
+	{name: "ZADD", arity: -4, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", proc: zaddCommand},
+	{name: "ZINCRBY", arity: 4, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Increments the score of a member in a sorted set.", proc: zincrbyCommand},
+	{name: "ZREM", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", proc: zremCommand},
+	{name: "ZCARD", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the number of members in a sorted set.", proc: zcardCommand},
+	{name: "ZSCORE", arity: 3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the score of a member in a sorted set.", proc: zscoreCommand},
+	{name: "ZRANK", arity: -3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the index of a member in a sorted set ordered by ascending scores.",
+		proc: func(c *client, args []string) { zrankCommand(c, args, false) }},
+	{name: "ZREVRANK", arity: -3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the index of a member in a sorted set ordered by descending scores.",
+		proc: func(c *client, args []string) { zrankCommand(c, args, true) }},
+	{name: "ZCOUNT", arity: 4, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the count of members in a sorted set that have scores within a range.", proc: zcountCommand},
+	{name: "ZRANGE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a range of indexes.", proc: zrangeProc(zrangeRank, false)},
+	{name: "ZREVRANGE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a range of indexes in reverse order.", proc: zrangeProc(zrangeRank, true)},
+	{name: "ZRANGEBYSCORE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a range of scores.", proc: zrangeProc(zrangeScore, false)},
+	{name: "ZREVRANGEBYSCORE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a range of scores in reverse order.", proc: zrangeProc(zrangeScore, true)},
+	{name: "ZRANGEBYLEX", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a lexicographical range.", proc: zrangeProc(zrangeLex, false)},
+	{name: "ZREVRANGEBYLEX", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns members in a sorted set within a lexicographical range in reverse order.", proc: zrangeProc(zrangeLex, true)},
+	{name: "ZPOPMIN", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+		proc: func(c *client, args []string) { zpopCommand(c, args, false) }},
+	{name: "ZPOPMAX", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "sorted-set", summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+		proc: func(c *client, args []string) { zpopCommand(c, args, true) }},
+
+	// set
+	{name: "SADD", arity: -3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", proc: saddCommand},
+	{name: "SREM", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", proc: sremCommand},
+	{name: "SISMEMBER", arity: 3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Determines whether a member belongs to a set.", proc: sismemberCommand},
+	{name: "SMISMEMBER", arity: -3, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Determines whether multiple members belong to a set.", proc: smismemberCommand},
+	{name: "SMEMBERS", arity: 2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "set", summary: "Returns all members of a set.", proc: smembersCommand},
+	{name: "SCARD", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Returns the number of members in a set.", proc: scardCommand},
+	{name: "SPOP", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "set", summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", proc: spopCommand},
+	{name: "SRANDMEMBER", arity: -2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "set", summary: "Get one or multiple random members from a set", proc: srandmemberCommand},
+	{name: "SUNION", arity: -2, flags: cmdReadonly, keys: keySpec{1, -1, 1}, group: "set", summary: "Returns the union of multiple sets.",
+		proc: func(c *client, args []string) { setOpCommand(c, args, setOpUnion) }},
+	{name: "SINTER", arity: -2, flags: cmdReadonly, keys: keySpec{1, -1, 1}, group: "set", summary: "Returns the intersect of multiple sets.",
+		proc: func(c *client, args []string) { setOpCommand(c, args, setOpInter) }},
+	{name: "SDIFF", arity: -2, flags: cmdReadonly, keys: keySpec{1, -1, 1}, group: "set", summary: "Returns the difference of multiple sets.",
+		proc: func(c *client, args []string) { setOpCommand(c, args, setOpDiff) }},
+	{name: "SUNIONSTORE", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 1}, group: "set", summary: "Stores the union of multiple sets in a key.",
+		proc: func(c *client, args []string) { setOpStoreCommand(c, args, setOpUnion) }},
+	{name: "SINTERSTORE", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 1}, group: "set", summary: "Stores the intersect of multiple sets in a key.",
+		proc: func(c *client, args []string) { setOpStoreCommand(c, args, setOpInter) }},
+	{name: "SDIFFSTORE", arity: -3, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, -1, 1}, group: "set", summary: "Stores the difference of multiple sets in a key.",
+		proc: func(c *client, args []string) { setOpStoreCommand(c, args, setOpDiff) }},
+	{name: "SSCAN", arity: -3, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "set", summary: "Iterates over members of a set.", proc: sscanCommand},
+
+	// stream
+	{name: "XADD", arity: -5, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Appends a new message to a stream. Creates the key if it doesn't exist.", proc: xaddCommand},
+	{name: "XRANGE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "stream", summary: "Returns the messages from a stream within a range of IDs.",
+		proc: func(c *client, args []string) { xrangeCommand(c, args, false) }},
+	{name: "XREVRANGE", arity: -4, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "stream", summary: "Returns the messages from a stream within a range of IDs in reverse order.",
+		proc: func(c *client, args []string) { xrangeCommand(c, args, true) }},
+	{name: "XLEN", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Return the number of messages in a stream.", proc: xlenCommand},
+	{name: "XDEL", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Returns the number of messages after removing them from a stream.", proc: xdelCommand},
+	{name: "XTRIM", arity: -4, flags: cmdWrite, keys: keySpec{1, 1, 1}, group: "stream", summary: "Deletes messages from the beginning of a stream.", proc: xtrimCommand},
+	{name: "XSETID", arity: -3, flags: cmdWrite | cmdDenyOOM | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "An internal command for replicating stream values.", proc: xsetidCommand},
+	{name: "XREAD", arity: -4, flags: cmdReadonly | cmdBlocking, getKeys: streamKeys, group: "stream", summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.", proc: xreadCommand},
+	{name: "XREADGROUP", arity: -7, flags: cmdWrite | cmdBlocking, getKeys: streamKeys, group: "stream", summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.", proc: xreadCommand},
+	{name: "XGROUP", arity: -2, flags: cmdWrite | cmdDenyOOM, keys: keySpec{2, 2, 1}, group: "stream", summary: "A container for consumer groups commands.", proc: xgroupCommand},
+	{name: "XACK", arity: -4, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.", proc: xackCommand},
+	{name: "XPENDING", arity: -3, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "stream", summary: "Returns the information and entries from a stream consumer group's pending entries list.", proc: xpendingCommand},
+	{name: "XCLAIM", arity: -6, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.", proc: xclaimCommand},
+	{name: "XAUTOCLAIM", arity: -6, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "stream", summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.", proc: xautoclaimCommand},
+
+	// generic
+	{name: "DEL", arity: -2, flags: cmdWrite, keys: keySpec{1, -1, 1}, group: "generic", summary: "Deletes one or more keys.", proc: delCommand},
+	{name: "UNLINK", arity: -2, flags: cmdWrite | cmdFast, keys: keySpec{1, -1, 1}, group: "generic", summary: "Asynchronously deletes one or more keys.", proc: delCommand},
+	{name: "EXISTS", arity: -2, flags: cmdReadonly | cmdFast, keys: keySpec{1, -1, 1}, group: "generic", summary: "Determines whether one or more keys exist.", proc: existsCommand},
+	{name: "TYPE", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Determines the type of value stored at a key.", proc: typeCommand},
+	{name: "RENAME", arity: 3, flags: cmdWrite, keys: keySpec{1, 2, 1}, group: "generic", summary: "Renames a key and overwrites the destination.",
+		proc: func(c *client, args []string) { renameCommand(c, args, false) }},
+	{name: "RENAMENX", arity: 3, flags: cmdWrite | cmdFast, keys: keySpec{1, 2, 1}, group: "generic", summary: "Renames a key only when the target key name doesn't exist.",
+		proc: func(c *client, args []string) { renameCommand(c, args, true) }},
+	{name: "RANDOMKEY", arity: 1, flags: cmdReadonly, group: "generic", summary: "Returns a random key name from the database.", proc: randomkeyCommand},
+	{name: "DBSIZE", arity: 1, flags: cmdReadonly | cmdFast, group: "generic", summary: "Returns the number of keys in the database.", proc: dbsizeCommand},
+	{name: "KEYS", arity: 2, flags: cmdReadonly, group: "generic", acl: []string{"dangerous"}, summary: "Returns all key names that match a pattern.", proc: keysCommand},
+	{name: "SCAN", arity: -2, flags: cmdReadonly, group: "generic", summary: "Iterates over the key names in the database.", proc: scanCommand},
+	{name: "OBJECT", arity: -2, flags: cmdReadonly, keys: keySpec{2, 2, 1}, group: "generic", summary: "A container for object introspection commands.", proc: objectCommand},
+	{name: "EXPIRE", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Sets the expiration time of a key in seconds.",
+		proc: func(c *client, args []string) { expireGenericCommand(c, args, true, time.Second) }},
+	{name: "PEXPIRE", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Sets the expiration time of a key in milliseconds.",
+		proc: func(c *client, args []string) { expireGenericCommand(c, args, true, time.Millisecond) }},
+	{name: "EXPIREAT", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Sets the expiration time of a key to a Unix timestamp.",
+		proc: func(c *client, args []string) { expireGenericCommand(c, args, false, time.Second) }},
+	{name: "PEXPIREAT", arity: -3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		proc: func(c *client, args []string) { expireGenericCommand(c, args, false, time.Millisecond) }},
+	{name: "TTL", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Returns the expiration time in seconds of a key.",
+		proc: func(c *client, args []string) { ttlGenericCommand(c, args, false, false) }},
+	{name: "PTTL", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Returns the expiration time in milliseconds of a key.",
+		proc: func(c *client, args []string) { ttlGenericCommand(c, args, true, false) }},
+	{name: "EXPIRETIME", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Returns the expiration time of a key as a Unix timestamp.",
+		proc: func(c *client, args []string) { ttlGenericCommand(c, args, false, true) }},
+	{name: "PEXPIRETIME", arity: 2, flags: cmdReadonly | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+		proc: func(c *client, args []string) { ttlGenericCommand(c, args, true, true) }},
+	{name: "PERSIST", arity: 2, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Removes the expiration time of a key.", proc: persistCommand},
+	{name: "MOVE", arity: 3, flags: cmdWrite | cmdFast, keys: keySpec{1, 1, 1}, group: "generic", summary: "Moves a key to another database.", proc: moveCommand},
+	{name: "FLUSHDB", arity: -1, flags: cmdWrite, group: "server", acl: []string{"keyspace", "dangerous"}, summary: "Remove all keys from the current database.", proc: flushdbCommand},
+	{name: "FLUSHALL", arity: -1, flags: cmdWrite, group: "server", acl: []string{"keyspace", "dangerous"}, summary: "Removes all keys from all databases.", proc: flushallCommand},
+	{name: "SWAPDB", arity: 3, flags: cmdWrite | cmdFast, group: "server", acl: []string{"keyspace", "dangerous"}, summary: "Swaps two Redis databases.", proc: swapdbCommand},
+	{name: "DUMP", arity: 2, flags: cmdReadonly, keys: keySpec{1, 1, 1}, group: "generic", summary: "Returns a serialized representation of the value stored at a key.", proc: dumpCommand},
+	{name: "RESTORE", arity: -4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "generic", acl: []string{"dangerous"}, summary: "Creates a key from the serialized representation of a value.", proc: restoreCommand},
+	{name: "RESTORE-ASKING", arity: -4, flags: cmdWrite | cmdDenyOOM, keys: keySpec{1, 1, 1}, group: "server", acl: []string{"keyspace", "dangerous"}, summary: "An internal command for migrating keys in a cluster.", proc: restoreCommand},
+	{name: "MIGRATE", arity: -6, flags: cmdWrite, getKeys: migrateKeys, group: "generic", acl: []string{"dangerous"}, summary: "Atomically transfers a key from one Redis instance to another.", proc: migrateCommand},
+
+	// pubsub
+	{name: "SUBSCRIBE", arity: -2, flags: cmdPubsubOK, group: "pubsub", summary: "Listens for messages published to channels.", proc: subscribeCommand},
+	{name: "UNSUBSCRIBE", arity: -1, flags: cmdPubsubOK, group: "pubsub", summary: "Stops listening to messages posted to channels.", proc: unsubscribeCommand},
+	{name: "PSUBSCRIBE", arity: -2, flags: cmdPubsubOK, group: "pubsub", summary: "Listens for messages published to channels that match one or more patterns.", proc: psubscribeCommand},
+	{name: "PUNSUBSCRIBE", arity: -1, flags: cmdPubsubOK, group: "pubsub", summary: "Stops listening to messages published to channels that match one or more patterns.", proc: punsubscribeCommand},
+	{name: "PUBLISH", arity: 3, flags: cmdFast, group: "pubsub", summary: "Posts a message to a channel.", proc: publishCommand},
+	{name: "PUBSUB", arity: -2, group: "pubsub", summary: "A container for Pub/Sub commands.", proc: pubsubCommand},
+
+	// transactions
+	{name: "MULTI", arity: 1, flags: cmdFast | cmdTxControl, group: "transactions", summary: "Starts a transaction.", proc: multiCommand},
+	{name: "EXEC", arity: 1, flags: cmdTxControl, group: "transactions", summary: "Executes all commands in a transaction.", proc: execTxCommand},
+	{name: "DISCARD", arity: 1, flags: cmdFast | cmdTxControl, group: "transactions", summary: "Discards a transaction.", proc: discardCommand},
+	{name: "WATCH", arity: -2, flags: cmdFast | cmdTxControl, keys: keySpec{1, -1, 1}, group: "transactions", summary: "Monitors changes to keys to determine the execution of a transaction.", proc: watchCommand},
+	{name: "UNWATCH", arity: 1, flags: cmdFast, group: "transactions", summary: "Forgets about watched keys of a transaction.", proc: unwatchCommand},
+
+	// server
+	{name: "COMMAND", arity: -1, group: "server", acl: []string{"connection"}, summary: "Returns detailed information about all commands.", proc: commandCommand},
+	{name: "INFO", arity: -1, group: "server", acl: []string{"dangerous"}, summary: "Returns information and statistics about the server.", proc: infoCommand},
+	{name: "ACL", arity: -2, flags: cmdAdmin, group: "server", summary: "A container for Access List Control commands.", proc: aclCommand},
+	{name: "BGREWRITEAOF", arity: 1, flags: cmdAdmin, group: "server", summary: "Asynchronously rewrites the append-only file to disk.", proc: bgrewriteaofCommand},
+	{name: "SAVE", arity: 1, flags: cmdAdmin, group: "server", summary: "Synchronously saves the database(s) to disk.", proc: saveCommand},
+	{name: "BGSAVE", arity: -1, flags: cmdAdmin, group: "server", summary: "Asynchronously saves the database(s) to disk.", proc: bgsaveCommand},
+	{name: "LASTSAVE", arity: 1, flags: cmdAdmin | cmdFast, group: "server", summary: "Returns the Unix timestamp of the last successful save to disk.", proc: lastsaveCommand},
+	{name: "REPLICAOF", arity: 3, flags: cmdAdmin, group: "server", summary: "Configures a server as replica of another, or promotes it to a master.", proc: replicaofCommand},
+	{name: "SLAVEOF", arity: 3, flags: cmdAdmin, group: "server", summary: "Sets a Redis server as a replica of another, or promotes it to being a master.", proc: replicaofCommand},
+	{name: "REPLCONF", arity: -1, flags: cmdAdmin, group: "server", summary: "An internal command for configuring the replication stream.", proc: replconfCommand},
+	{name: "PSYNC", arity: 3, flags: cmdAdmin, group: "server", summary: "An internal command used in replication.", proc: psyncCommand},
+	{name: "SLOWLOG", arity: -2, flags: cmdAdmin, group: "server", summary: "A container for slow log commands.", proc: slowlogCommand},
+	{name: "MONITOR", arity: 1, flags: cmdAdmin, group: "server", summary: "Listens for all requests received by the server in real-time.", proc: monitorCommand},
+	{name: "CONFIG", arity: -2, flags: cmdAdmin, group: "server", summary: "A container for server configuration commands.", proc: configCommand},
+
+	// cluster
+	{name: "CLUSTER", arity: -2, flags: cmdAdmin, group: "cluster", summary: "A container for Redis Cluster commands.", proc: clusterCommand},
+	{name: "ASKING", arity: 1, flags: cmdFast, group: "cluster", acl: []string{"connection"}, summary: "Signals that a cluster client is following an -ASK redirect.", proc: askingCommand},
+}
+
+// aclGroupCategories 是和 group 同名之外的数据类型 ACL 分类; server、cluster 等 group 不对应 ACL 分类
+var aclGroupCategories = map[string]string{
+	"generic": "keyspace", "sorted-set": "sortedset", "transactions": "transaction",
+	"string": "string", "bitmap": "bitmap", "hyperloglog": "hyperloglog", "hash": "hash", "list": "list",
+	"set": "set", "stream": "stream", "pubsub": "pubsub", "connection": "connection",
+}
+
+func init() {
+	for _, cmd := range commandTable {
+		cmd.categories = cmd.aclCategories()
+		commands[cmd.name] = cmd
+	}
+}
+
+// aclCategories 推导命令所属的 ACL 分类, 同 Redis 的 setImplicitACLCategories
+func (cmd *redisCommand) aclCategories() []string {
+	var cats []string
+	if cat := aclGroupCategories[cmd.group]; cat != "" {
+		cats = append(cats, cat)
+	}
+	cats = append(cats, cmd.acl...)
+	if cmd.flags&cmdWrite != 0 {
+		cats = append(cats, "write")
+	}
+	if cmd.flags&cmdReadonly != 0 {
+		cats = append(cats, "read")
+	}
+	if cmd.flags&cmdAdmin != 0 {
+		cats = append(cats, "admin", "dangerous")
+	}
+	if cmd.flags&cmdBlocking != 0 {
+		cats = append(cats, "blocking")
+	}
+	if cmd.flags&cmdFast != 0 {
+		cats = append(cats, "fast")
+	} else {
+		cats = append(cats, "slow")
+	}
+	slices.Sort(cats)
+	return slices.Compact(cats)
+}
+
+// lookupCommand 按名字 (不区分大小写) 查命令表, 不存在返回 nil
+func lookupCommand(name string) *redisCommand {
+	return commands[strings.ToUpper(name)]
+}
+
+// checkArity 检查参数个数, 返回错误回复
+func (cmd *redisCommand) checkArity(args []string) string {
+	if !arityOK(cmd.arity, len(args)) {
+		return errWrongArgs(cmd.name)
+	}
+	return ""
+}
+
+func errUnknownCommand(args []string) string {
+	return fmt.Sprintf("ERR unknown command '%s'", args[0])
+}
+
+func zrangeProc(kind int, rev bool) func(c *client, args []string) {
+	return func(c *client, args []string) {
+		zrangeGeneric(c, strings.ToUpper(args[0]), args[1:], kind, rev)
+	}
+}
+
+// COMMAND [COUNT | INFO [name ...] | DOCS [name ...]]
+func commandCommand(c *client, args []string) {
+	w := c.w
+	if len(args) == 1 {
+		names := sortedCommandNames()
+		w.writeArray(len(names))
+		for _, name := range names {
+			writeCommandInfo(w, commands[name])
+		}
+		return
+	}
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"COUNT": 2, "INFO": -2, "DOCS": -2}[sub]
+	if arity == 0 {
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try COMMAND HELP.")
+		return
+	}
+	if !arityOK(arity, len(args)) {
+		w.writeError(errWrongArgs("COMMAND|" + sub))
+		return
+	}
+	names := args[2:]
+	if len(names) == 0 {
+		names = sortedCommandNames()
+	}
+	switch sub {
+	case "COUNT":
+		w.writeInt(int64(len(commands)))
+	case "INFO":
+		w.writeArray(len(names))
+		for _, name := range names {
+			if cmd := lookupCommand(name); cmd != nil {
+				writeCommandInfo(w, cmd)
+			} else {
+				w.writeNull()
+			}
+		}
+	case "DOCS":
+		// 不存在的命令直接跳过
+		var found []*redisCommand
+		for _, name := range names {
+			if cmd := lookupCommand(name); cmd != nil {
+				found = append(found, cmd)
+			}
+		}
+		w.writeMap(len(found))
+		for _, cmd := range found {
+			w.writeBulk(strings.ToLower(cmd.name))
+			w.writeMap(2)
+			w.writeBulk("summary")
+			w.writeBulk(cmd.summary)
+			w.writeBulk("group")
+			w.writeBulk(cmd.group)
+		}
+	}
+}
+
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeCommandInfo 按 Redis 7 的格式写一条命令的信息:
+// 名字、参数个数、标志、第一个 key、最后一个 key、key 间隔、ACL 分类、tips、key specs、子命令
+func writeCommandInfo(w *replyWriter, cmd *redisCommand) {
+	w.writeArray(10)
+	w.writeBulk(strings.ToLower(cmd.name))
+	w.writeInt(int64(cmd.arity))
+
+	var flags []string
+	for _, f := range cmdFlagNames {
+		if cmd.flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	if cmd.getKeys != nil {
+		flags = append(flags, "movablekeys")
+	}
+	w.writeSet(len(flags))
+	for _, f := range flags {
+		w.writeSimple(f)
+	}
+
+	w.writeInt(int64(cmd.keys.first))
+	w.writeInt(int64(cmd.keys.last))
+	w.writeInt(int64(cmd.keys.step))
+
+	w.writeSet(len(cmd.categories))
+	for _, cat := range cmd.categories {
+		w.writeSimple("@" + cat)
+	}
+	w.writeArray(0) // tips
+
+	if cmd.keys.step == 0 {
+		w.writeArray(0)
+	} else {
+		// 固定位置的 key 写成一条 index + range 的 key spec, lastkey 相对于 begin_search 的位置
+		lastkey := cmd.keys.last
+		if lastkey >= 0 {
+			lastkey -= cmd.keys.first
+		}
+		w.writeArray(1)
+		w.writeMap(3)
+		w.writeBulk("flags")
+		w.writeSet(0)
+		w.writeBulk("begin_search")
+		w.writeMap(2)
+		w.writeBulk("type")
+		w.writeBulk("index")
+		w.writeBulk("spec")
+		w.writeMap(1)
+		w.writeBulk("index")
+		w.writeInt(int64(cmd.keys.first))
+		w.writeBulk("find_keys")
+		w.writeMap(2)
+		w.writeBulk("type")
+		w.writeBulk("range")
+		w.writeBulk("spec")
+		w.writeMap(3)
+		w.writeBulk("lastkey")
+		w.writeInt(int64(lastkey))
+		w.writeBulk("keystep")
+		w.writeInt(int64(cmd.keys.step))
+		w.writeBulk("limit")
+		w.writeInt(0)
+	}
+	w.writeArray(0) // 子命令
+}
@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommandInfo(t *testing.T) {
+	tc := newTestClient()
+	expectReply(t, tc, ":"+strconv.Itoa(len(commands))+"\r\n", "COMMAND", "COUNT")
+	if got := tc.do("COMMAND"); !strings.HasPrefix(got, "*"+strconv.Itoa(len(commands))+"\r\n") {
+		t.Fatalf("COMMAND: %q", got[:min(len(got), 20)])
+	}
+
+	get := "*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+		"*3\r\n+@fast\r\n+@read\r\n+@string\r\n*0\r\n" +
+		"*1\r\n*6\r\n$5\r\nflags\r\n*0\r\n" +
+		"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n" +
+		"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n" +
+		"*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n*0\r\n"
+	expectReply(t, tc, "*2\r\n"+get+"$-1\r\n", "COMMAND", "INFO", "get", "nosuch")
+	// 写命令的 ACL 分类由标志推导出来
+	info := tc.do("COMMAND", "INFO", "BLPOP")
+	for _, want := range []string{"+write\r\n", "+blocking\r\n", "+@list\r\n", "+@slow\r\n", "+@blocking\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("COMMAND INFO BLPOP missing %q: %q", want, info)
+		}
+	}
+
+	expectReply(t, tc, "*2\r\n$3\r\nget\r\n*4\r\n$7\r\nsummary\r\n$34\r\nReturns the string value of a key.\r\n$5\r\ngroup\r\n$6\r\nstring\r\n",
+		"COMMAND", "DOCS", "GET", "nosuch")
+	expectReply(t, tc, "-ERR unknown subcommand 'FOO'. Try COMMAND HELP.\r\n", "COMMAND", "FOO")
+	expectReply(t, tc, "-"+errWrongArgs("COMMAND|COUNT")+"\r\n", "COMMAND", "COUNT", "x")
+}
+
+func TestCommandArity(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "-"+errWrongArgs("GET")+"\r\n", "GET")
+	expectReply(t, tc, "-"+errWrongArgs("GET")+"\r\n", "get", "a", "b")
+	expectReply(t, tc, "-"+errWrongArgs("SADD")+"\r\n", "SADD", "s")
+	expectReply(t, tc, "-ERR unknown command 'NOSUCH'\r\n", "NOSUCH", "a")
+	// 回显在错误里的用户输入不能带出换行, 否则后半截会被当成另一条回复
+	expectReply(t, tc, "-ERR unknown command 'FOO  +OK'\r\n", "FOO\r\n+OK")
+	expectReply(t, tc, "-ERR Syntax error in HELLO option 'a b'\r\n", "HELLO", "2", "a\nb")
+
+	// 事务里的参数个数错误让 EXEC 整体放弃
+	tc.do("MULTI")
+	expectReply(t, tc, "-"+errWrongArgs("SET")+"\r\n", "SET", "k")
+	tc.do("SET", "k", "v")
+	expectReply(t, tc, "-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "k")
+}
+
+func TestCommandPropagation(t *testing.T) {
+	resetKeyspace()
+	if err := aofFile.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	tc := newTestClient()
+	aofEnd := func() int64 {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		return aofBufEnd
+	}
+	// 只有真的改动了数据的写命令才写 AOF
+	before := aofEnd()
+	tc.do("GET", "k")
+	tc.do("SREM", "s", "a")
+	if aofEnd() != before {
+		t.Fatal("no-op commands were propagated")
+	}
+	tc.do("SADD", "s", "a", "b")
+	if aofEnd() == before {
+		t.Fatal("SADD was not propagated")
+	}
+	before = aofEnd()
+	tc.do("SADD", "s", "a")
+	if aofEnd() != before {
+		t.Fatal("SADD of an existing member was propagated")
+	}
+
+	tc.do("HSET", "h", "f", "1")
+	tc.do("INCRBYFLOAT", "n", "1.5")
+	want := tc.do("SCARD", "s") + tc.do("SISMEMBER", "s", "b") + tc.do("HGET", "h", "f") + tc.do("GET", "n")
+	replayFromStart(t)
+	if got := tc.do("SCARD", "s") + tc.do("SISMEMBER", "s", "b") + tc.do("HGET", "h", "f") + tc.do("GET", "n"); got != want {
+		t.Fatalf("after replay: %q, want %q", got, want)
+	}
+}
+
+func TestQuitReset(t *testing.T) {
+	resetKeyspace()
+	tc, pub := newTestClient(), newTestClient()
+	for _, name := range []string{"QUIT", "RESET"} {
+		if info := tc.do("COMMAND", "INFO", name); !strings.Contains(info, "+no_auth\r\n") || !strings.Contains(info, "+@connection\r\n") {
+			t.Fatalf("COMMAND INFO %s: %q", name, info)
+		}
+	}
+	expectReply(t, tc, "-"+errWrongArgs("RESET")+"\r\n", "RESET", "x")
+
+	// 订阅模式和事务里都能执行 RESET, 执行后连接回到初始状态
+	tc.do("SELECT", "1")
+	tc.do("CLIENT", "SETNAME", "conn")
+	tc.do("SUBSCRIBE", "news")
+	expectReply(t, tc, "+RESET\r\n", "RESET")
+	expectReply(t, pub, ":0\r\n", "PUBLISH", "news", "hello")
+	tc.do("WATCH", "k")
+	tc.do("MULTI")
+	expectReply(t, tc, "+RESET\r\n", "RESET")
+	if tc.multi || tc.watched != nil || tc.db != dbs[0] || tc.name != "" {
+		t.Fatalf("state not reset: multi %v, watched %v, db %d, name %q", tc.multi, tc.watched, tc.db.id, tc.name)
+	}
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v")
+
+	tc.do("MULTI")
+	expectReply(t, tc, "+OK\r\n", "QUIT")
+	if !tc.closeAfterReply {
+		t.Fatal("QUIT did not close the connection")
+	}
+}
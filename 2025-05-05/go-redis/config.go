@@ -0,0 +1,541 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置沿用 redis.conf 的格式: 每行 "名字 参数...", # 开头是注释, 参数可以用引号。
+// 启动时先读配置文件, 再应用命令行上的 --name value 覆盖; 运行期间用 CONFIG GET/SET 读写,
+// CONFIG REWRITE 把当前值写回配置文件
+
+var (
+	bind        string // 为空时监听所有地址
+	readTimeout = 10 * time.Second
+	serverDir   = "." // 持久化文件 (AOF / RDB / ACL) 所在目录
+
+	// configFile 是启动时加载的配置文件的绝对路径, 为空表示没有配置文件
+	configFile string
+)
+
+// configEntry 是一项配置, get/set 在持有 dbMu 时调用 (启动阶段除外)。
+// set 只做解析和赋值; apply 是运行期间 CONFIG SET 之后的副作用, 启动阶段不执行
+type configEntry struct {
+	name    string
+	mutable bool
+	get     func() string
+	set     func(string) error
+	apply   func()
+	def     string // 默认值, CONFIG REWRITE 时默认值不追加到文件里
+}
+
+var (
+	configs     []*configEntry
+	configIndex = make(map[string]*configEntry)
+)
+
+func init() {
+	// CONFIG 命令要遍历 configs, 直接写成包级变量的初始化会形成初始化循环
+	configs = []*configEntry{
+		stringConfig("bind", false, &bind, func(v string) error {
+			if strings.ContainsAny(v, " \t") {
+				return errors.New("only one bind address is supported")
+			}
+			return nil
+		}),
+		intConfig("port", false, &port, 0, 65535),
+		{name: "read-timeout", get: func() string {
+			return strconv.FormatInt(int64(readTimeout/time.Second), 10)
+		}, set: func(v string) error {
+			n, err := parseConfigInt(v, 0, 1<<31-1)
+			if err == nil {
+				readTimeout = time.Duration(n) * time.Second
+			}
+			return err
+		}},
+		intConfig("databases", false, &databases, 1, 1<<20),
+		{name: "dir", get: func() string { return serverDir }, set: setServerDir},
+		pathConfig("dbfilename", true, &rdbPath),
+		pathConfig("appendfilename", false, &aofPath),
+		pathConfig("aclfile", false, &aclPath),
+		boolConfig("cluster-enabled", false, &clusterEnabled),
+		pathConfig("cluster-config-file", false, &clusterConfigPath),
+		enumConfig("appendfsync", &appendfsync, fsyncPolicyNames),
+		boolConfig("aof-use-rdb-preamble", true, &aofUseRDBPreamble),
+		boolConfig("aof-load-truncated", true, &aofLoadTruncated),
+		{name: "save", mutable: true, get: formatSaveParams, set: setSaveParams},
+		{name: "replicaof", get: func() string {
+			if masterHost == "" {
+				return ""
+			}
+			return masterHost + " " + strconv.Itoa(masterPort)
+		}, set: setReplicaof},
+		stringConfig("requirepass", true, &requirepass, nil),
+		stringConfig("masterauth", true, &masterauth, nil),
+		stringConfig("masteruser", true, &masteruser, nil),
+		memoryConfig("maxmemory", &maxmemory),
+		enumConfig("maxmemory-policy", &maxmemoryPolicy, evictPolicyNames),
+		intConfig("maxmemory-samples", true, &maxmemorySamples, 1, 64),
+		intConfig("lfu-log-factor", true, &lfuLogFactor, 0, 1<<31-1),
+		intConfig("lfu-decay-time", true, &lfuDecayTime, 0, 1<<31-1),
+		int64Config("slowlog-log-slower-than", &slowlogLogSlowerThan, -1, 1<<62),
+		intConfig("slowlog-max-len", true, &slowlogMaxLen, 0, 1<<31-1),
+		intConfig("hz", true, &hz, 1, 500),
+		intConfig("hll-sparse-max-bytes", true, &hllSparseMaxBytes, 0, 1<<31-1),
+	}
+	for _, e := range configs {
+		configIndex[e.name] = e
+		e.def = e.get()
+	}
+	configIndex["requirepass"].apply = func() {
+		if requirepass == "" {
+			setUser("default", []string{"resetpass", "nopass"})
+		} else {
+			applyRequirepass()
+		}
+	}
+	configIndex["maxmemory"].apply = func() { performEvictions() }
+	configIndex["slowlog-max-len"].apply = func() {
+		if len(slowlog) > slowlogMaxLen {
+			slowlog = slowlog[:slowlogMaxLen]
+		}
+	}
+}
+
+func parseConfigInt(v string, lo, hi int64) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < lo || n > hi {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", lo, hi)
+	}
+	return n, nil
+}
+
+func intConfig(name string, mutable bool, p *int, lo, hi int64) *configEntry {
+	return &configEntry{name: name, mutable: mutable,
+		get: func() string { return strconv.Itoa(*p) },
+		set: func(v string) error {
+			n, err := parseConfigInt(v, lo, hi)
+			if err == nil {
+				*p = int(n)
+			}
+			return err
+		}}
+}
+
+func int64Config(name string, p *int64, lo, hi int64) *configEntry {
+	return &configEntry{name: name, mutable: true,
+		get: func() string { return strconv.FormatInt(*p, 10) },
+		set: func(v string) error {
+			n, err := parseConfigInt(v, lo, hi)
+			if err == nil {
+				*p = n
+			}
+			return err
+		}}
+}
+
+func boolConfig(name string, mutable bool, p *bool) *configEntry {
+	return &configEntry{name: name, mutable: mutable,
+		get: func() string {
+			if *p {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(v string) error {
+			switch strings.ToLower(v) {
+			case "yes":
+				*p = true
+			case "no":
+				*p = false
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			return nil
+		}}
+}
+
+// stringConfig 的 check 可以为 nil
+func stringConfig(name string, mutable bool, p *string, check func(string) error) *configEntry {
+	return &configEntry{name: name, mutable: mutable,
+		get: func() string { return *p },
+		set: func(v string) error {
+			if check != nil {
+				if err := check(v); err != nil {
+					return err
+				}
+			}
+			*p = v
+			return nil
+		}}
+}
+
+func enumConfig(name string, p *int, names []string) *configEntry {
+	return &configEntry{name: name, mutable: true,
+		get: func() string { return names[*p] },
+		set: func(v string) error {
+			for i, n := range names {
+				if strings.EqualFold(v, n) {
+					*p = i
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: " + strings.Join(names, ", "))
+		}}
+}
+
+func memoryConfig(name string, p *int64) *configEntry {
+	return &configEntry{name: name, mutable: true,
+		get: func() string { return strconv.FormatInt(*p, 10) },
+		set: func(v string) error {
+			n, err := parseMemory(v)
+			if err == nil {
+				*p = n
+			}
+			return err
+		}}
+}
+
+// pathConfig 是 dir 下的一个文件名, *p 保存拼好的完整路径
+func pathConfig(name string, mutable bool, p *string) *configEntry {
+	return &configEntry{name: name, mutable: mutable,
+		get: func() string { return filepath.Base(*p) },
+		set: func(v string) error {
+			if v == "" || strings.ContainsRune(v, '/') {
+				return errors.New(name + " can't be a path, just a filename")
+			}
+			*p = filepath.Join(serverDir, v)
+			return nil
+		}}
+}
+
+// setServerDir 切换持久化目录, 已经配置的文件名跟着换到新目录下
+func setServerDir(v string) error {
+	fi, err := os.Stat(v)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("not a directory")
+	}
+	serverDir = v
+	for _, p := range []*string{&aofPath, &rdbPath, &aclPath, &clusterConfigPath} {
+		*p = filepath.Join(v, filepath.Base(*p))
+	}
+	return nil
+}
+
+// parseMemory 解析 "100mb"、"1g" 这样的内存大小, k/m/g 是 1000 的倍数, kb/mb/gb 是 1024 的倍数
+func parseMemory(v string) (int64, error) {
+	s := strings.ToLower(v)
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+func formatSaveParams() string {
+	parts := make([]string, 0, len(saveParams)*2)
+	for _, p := range saveParams {
+		parts = append(parts, strconv.FormatInt(p.seconds, 10), strconv.FormatInt(p.changes, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// setSaveParams 解析 "<seconds> <changes> ..." , 空字符串表示关闭自动保存
+func setSaveParams(v string) error {
+	fields := strings.Fields(v)
+	if len(fields)%2 != 0 {
+		return errors.New("invalid save parameters")
+	}
+	params := []saveParam{}
+	for i := 0; i < len(fields); i += 2 {
+		secs, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || secs < 1 || changes < 0 {
+			return errors.New("invalid save parameters")
+		}
+		params = append(params, saveParam{secs, changes})
+	}
+	saveParams = params
+	return nil
+}
+
+// setReplicaof 只记录 master 地址, 启动完成后由 newServer 开始复制
+func setReplicaof(v string) error {
+	fields := strings.Fields(v)
+	if len(fields) == 0 || len(fields) == 2 && strings.EqualFold(fields[0], "no") && strings.EqualFold(fields[1], "one") {
+		masterHost, masterPort = "", 0
+		return nil
+	}
+	if len(fields) != 2 {
+		return errors.New("wrong number of arguments")
+	}
+	p, err := strconv.Atoi(fields[1])
+	if err != nil || p <= 0 || p > 65535 {
+		return errors.New("Invalid master port")
+	}
+	masterHost, masterPort = fields[0], p
+	return nil
+}
+
+// listenAddr 是服务监听的地址
+func listenAddr() string {
+	return net.JoinHostPort(bind, strconv.Itoa(port))
+}
+
+// parseConfig 把配置文本切成一行行的参数, 多个参数合并成一个值 (save、replicaof 等)。
+// 多行 save 规则累加, 文件里出现 save 时不再保留默认规则
+func parseConfig(text string) ([][2]string, error) {
+	var lines [][2]string
+	saveAt := -1
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil || len(args) == 0 {
+			return nil, fmt.Errorf("line %d: '%s': unbalanced quotes in configuration line", n+1, line)
+		}
+		name := strings.ToLower(args[0])
+		if name == "slaveof" {
+			name = "replicaof"
+		}
+		if configIndex[name] == nil {
+			return nil, fmt.Errorf("line %d: '%s': Bad directive or wrong number of arguments", n+1, line)
+		}
+		v := strings.Join(args[1:], " ")
+		if name == "save" && saveAt >= 0 {
+			lines[saveAt][1] = strings.TrimSpace(lines[saveAt][1] + " " + v)
+			continue
+		}
+		if name == "save" {
+			saveAt = len(lines)
+		}
+		lines = append(lines, [2]string{name, v})
+	}
+	return lines, nil
+}
+
+// loadServerConfig 读取配置文件 (可以为空) 并应用命令行覆盖, 如 ["--port", "7000", "--save", "60", "1"]
+func loadServerConfig(file string, overrides []string) error {
+	var text strings.Builder
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("can't open config file '%s': %v", file, err)
+		}
+		text.Write(data)
+		text.WriteByte('\n')
+		if configFile, err = filepath.Abs(file); err != nil {
+			return err
+		}
+	}
+	// 命令行参数拼成配置行, 追加在文件内容之后, 后出现的覆盖前面的
+	for i, a := range overrides {
+		if strings.HasPrefix(a, "--") {
+			if i > 0 {
+				text.WriteByte('\n')
+			}
+			text.WriteString(a[2:])
+		} else if i == 0 {
+			return fmt.Errorf("invalid argument '%s', options must start with --", a)
+		} else {
+			text.WriteByte(' ')
+			text.WriteString(strconv.Quote(a))
+		}
+	}
+	lines, err := parseConfig(text.String())
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if err := configIndex[l[0]].set(l[1]); err != nil {
+			return fmt.Errorf("'%s %s': %v", l[0], l[1], err)
+		}
+	}
+	return nil
+}
+
+// CONFIG GET|SET|REWRITE ...
+func configCommand(c *client, args []string) {
+	w := c.w
+	sub := strings.ToUpper(args[1])
+	arity := map[string]int{"GET": -3, "SET": -4, "REWRITE": 2}[sub]
+	if arity == 0 {
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try CONFIG HELP.")
+		return
+	}
+	if !arityOK(arity, len(args)) {
+		w.writeError(errWrongArgs("CONFIG|" + sub))
+		return
+	}
+	switch sub {
+	case "GET":
+		configGetCommand(c, args[2:])
+	case "SET":
+		configSetCommand(c, args[2:])
+	case "REWRITE":
+		if err := rewriteConfig(); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeOK()
+	}
+}
+
+// CONFIG GET pattern [pattern ...], 回复按名字排序的 map
+func configGetCommand(c *client, patterns []string) {
+	matched := make(map[string]string)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		for _, e := range configs {
+			if globMatch(p, e.name) {
+				matched[e.name] = e.get()
+			}
+		}
+	}
+	c.w.writeMap(len(matched))
+	for _, name := range slices.Sorted(maps.Keys(matched)) {
+		c.w.writeBulk(name)
+		c.w.writeBulk(matched[name])
+	}
+}
+
+// CONFIG SET name value [name value ...], 全部成功才生效, 任何一项失败时已经设置的都回滚
+func configSetCommand(c *client, args []string) {
+	w := c.w
+	if len(args)%2 != 0 {
+		w.writeError(errWrongArgs("CONFIG|SET"))
+		return
+	}
+	var entries []*configEntry
+	for i := 0; i < len(args); i += 2 {
+		e := configIndex[strings.ToLower(args[i])]
+		if e == nil {
+			w.writeError("ERR Unknown option or number of arguments for CONFIG SET - '" + args[i] + "'")
+			return
+		}
+		if slices.Contains(entries, e) {
+			w.writeError("ERR CONFIG SET failed (possibly related to argument '" + args[i] + "') - duplicate parameter")
+			return
+		}
+		if !e.mutable {
+			w.writeError("ERR CONFIG SET failed (possibly related to argument '" + args[i] + "') - can't set immutable config")
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	old := make([]string, len(entries))
+	for i, e := range entries {
+		old[i] = e.get()
+		if err := e.set(args[i*2+1]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				entries[j].set(old[j])
+			}
+			w.writeError("ERR CONFIG SET failed (possibly related to argument '" + args[i*2] + "') - " + err.Error())
+			return
+		}
+	}
+	for _, e := range entries {
+		if e.apply != nil {
+			e.apply()
+		}
+	}
+	w.writeOK()
+}
+
+// quoteConfigArg 在需要时给配置值加引号, 保证 parseConfig 能原样读回
+func quoteConfigArg(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\r\n\"'\\#") {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
+// configLine 生成一项配置在文件里的写法, save 和 replicaof 的多个参数不加引号
+func configLine(e *configEntry) string {
+	v := e.get()
+	if (e.name == "save" || e.name == "replicaof") && v != "" {
+		return e.name + " " + v
+	}
+	return e.name + " " + quoteConfigArg(v)
+}
+
+// rewriteConfig 把当前配置写回配置文件: 注释和原有的行保持不动, 已有的配置项原地替换,
+// 同一项出现多次时只保留第一行; 文件里没有且不是默认值的配置项追加到末尾
+func rewriteConfig() error {
+	if configFile == "" {
+		return errors.New("The server is running without a config file")
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var out, lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			out = append(out, line)
+			continue
+		}
+		name := strings.ToLower(strings.Fields(trimmed)[0])
+		if name == "slaveof" {
+			name = "replicaof"
+		}
+		e := configIndex[name]
+		if e == nil {
+			out = append(out, line)
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, configLine(e))
+		}
+	}
+	appended := false
+	for _, e := range configs {
+		if seen[e.name] || e.get() == e.def {
+			continue
+		}
+		if !appended {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			appended = true
+		}
+		out = append(out, configLine(e))
+	}
+
+	tmp := configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFile)
+}
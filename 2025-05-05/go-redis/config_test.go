@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoreConfig 在测试结束后把所有配置项恢复成测试开始时的值
+func restoreConfig(t *testing.T) {
+	dbMu.Lock()
+	saved := make(map[*configEntry]string)
+	for _, e := range configs {
+		saved[e] = e.get()
+	}
+	savedFile := configFile
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		for e, v := range saved {
+			if err := e.set(v); err != nil {
+				t.Errorf("restore %s: %v", e.name, err)
+			}
+		}
+		configFile = savedFile
+	})
+}
+
+func writeConfigFile(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	restoreConfig(t)
+	file := writeConfigFile(t, `# comment
+port 7000
+requirepass "my secret"
+maxmemory 100mb
+MaxMemory-Policy allkeys-lfu
+save 900 1
+save 300 10
+slaveof 10.0.0.1 6380
+`)
+	// 命令行覆盖在文件之后应用
+	if err := loadServerConfig(file, []string{"--port", "7001", "--save", "60", "5", "--slowlog-max-len", "16"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"port":             "7001",
+		"requirepass":      "my secret",
+		"maxmemory":        "104857600",
+		"maxmemory-policy": "allkeys-lfu",
+		"save":             "900 1 300 10 60 5",
+		"replicaof":        "10.0.0.1 6380",
+		"slowlog-max-len":  "16",
+	}
+	for name, v := range want {
+		if got := configIndex[name].get(); got != v {
+			t.Errorf("%s = %q, want %q", name, got, v)
+		}
+	}
+	if configFile != file {
+		t.Errorf("configFile = %q", configFile)
+	}
+	// 只是记下了 master 地址, 复制在 newServer 里才开始
+	masterHost, masterPort = "", 0
+
+	for _, text := range []string{"no-such-option yes\n", "port abc\n", "requirepass \"unbalanced\n", "appendfsync sometimes\n", "save 60\n"} {
+		if err := loadServerConfig(writeConfigFile(t, text), nil); err == nil {
+			t.Errorf("config %q should fail", text)
+		}
+	}
+	if err := loadServerConfig("", []string{"port", "7000"}); err == nil {
+		t.Error("override without -- should fail")
+	}
+}
+
+func TestConfigGetSet(t *testing.T) {
+	restoreConfig(t)
+	resetACL(t)
+	tc := newTestClient()
+
+	expectReply(t, tc, "*4\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$16\r\nmaxmemory-policy\r\n$10\r\nnoeviction\r\n", "CONFIG", "GET", "maxmemory", "MAXMEMORY-POL*")
+	expectReply(t, tc, "*0\r\n", "CONFIG", "GET", "nothing*")
+
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "maxmemory", "1kb", "hz", "20", "save", "")
+	if maxmemory != 1024 || hz != 20 || len(saveParams) != 0 {
+		t.Fatalf("maxmemory=%d hz=%d save=%v", maxmemory, hz, saveParams)
+	}
+	expectReply(t, tc, "*2\r\n$4\r\nsave\r\n$0\r\n\r\n", "CONFIG", "GET", "save")
+
+	// 任何一项失败时整体回滚
+	expectReply(t, tc, "-ERR CONFIG SET failed (possibly related to argument 'hz') - argument must be between 1 and 500 inclusive\r\n",
+		"CONFIG", "SET", "maxmemory", "2kb", "hz", "1000")
+	if maxmemory != 1024 {
+		t.Fatalf("maxmemory not rolled back: %d", maxmemory)
+	}
+	expectReply(t, tc, "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n", "CONFIG", "SET", "port", "7000")
+	expectReply(t, tc, "-ERR Unknown option or number of arguments for CONFIG SET - 'nope'\r\n", "CONFIG", "SET", "nope", "1")
+	expectReply(t, tc, "-"+errWrongArgs("CONFIG|SET")+"\r\n", "CONFIG", "SET", "hz", "10", "save")
+
+	// requirepass 运行期间修改立即对 default 用户生效, 设为空恢复免密码
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "requirepass", "pw")
+	expectReply(t, newAuthClient(), "-"+errNoAuth+"\r\n", "PING")
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "requirepass", "")
+	expectReply(t, newAuthClient(), "+PONG\r\n", "PING")
+
+	expectReply(t, tc, "-ERR The server is running without a config file\r\n", "CONFIG", "REWRITE")
+}
+
+func TestConfigRewrite(t *testing.T) {
+	restoreConfig(t)
+	file := writeConfigFile(t, `# my redis
+port 6379
+save 900 1
+save 300 10
+
+# memory
+maxmemory 0
+`)
+	if err := loadServerConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	tc := newTestClient()
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "maxmemory", "1mb", "save", "60 1", "masterauth", "a b")
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "REWRITE")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 注释和已有的行原地保留, 重复的 save 只留一行, 新改的非默认值追加在末尾
+	// (dir 是 TestMain 换成的临时目录, 也不是默认值)
+	want := `# my redis
+port 6379
+save 60 1
+
+# memory
+maxmemory 1048576
+# Generated by CONFIG REWRITE
+dir ` + serverDir + `
+masterauth "a b"
+`
+	if string(data) != want {
+		t.Fatalf("rewritten config:\n%s\nwant:\n%s", data, want)
+	}
+	lines, err := parseConfig(string(data))
+	if err != nil || len(lines) != 5 || lines[4] != [2]string{"masterauth", "a b"} {
+		t.Fatalf("reload %v, %v", lines, err)
+	}
+}
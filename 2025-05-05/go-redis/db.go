@@ -0,0 +1,481 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// DB 是一个逻辑数据库, 对应 Redis 的 redisDb。除了 store 本身都由 dbMu 保护
+type DB struct {
+	id      int
+	store   cmap.ConcurrentMap[string, *object]
+	expires cmap.ConcurrentMap[string, time.Time]
+
+	// cmap 不支持随机访问, 单独维护一份可以 O(1) 随机取 key 的索引, 供淘汰和主动过期采样
+	keys         *keySampler
+	volatileKeys *keySampler
+}
+
+// dbKey 标识某个库里的一个 key, 用于 WATCH、阻塞等跨库的全局索引
+type dbKey struct {
+	db  int
+	key string
+}
+
+var (
+	databases = 16 // 同 Redis databases 配置
+	dbs       []*DB
+)
+
+func newDB(id int) *DB {
+	return &DB{
+		id:           id,
+		store:        cmap.New[*object](),
+		expires:      cmap.New[time.Time](),
+		keys:         newKeySampler(),
+		volatileKeys: newKeySampler(),
+	}
+}
+
+// initDatabases 按 databases 创建全部逻辑库
+func initDatabases() {
+	dbs = make([]*DB, databases)
+	for i := range dbs {
+		dbs[i] = newDB(i)
+	}
+}
+
+// selectDB 按编号取库, 编号越界返回 nil
+func selectDB(id int64) *DB {
+	if id < 0 || id >= int64(len(dbs)) {
+		return nil
+	}
+	return dbs[id]
+}
+
+// lookupKey 取 key 对应的值, 顺带做惰性过期并记录访问
+func (db *DB) lookupKey(key string) (*object, bool) {
+	o, ok := db.lookupKeyNoTouch(key)
+	if ok {
+		o.touch()
+	}
+	return o, ok
+}
+
+// lookupKeyNoTouch 同 lookupKey, 但不更新 LRU/LFU, 给 OBJECT 这类观察命令用
+func (db *DB) lookupKeyNoTouch(key string) (*object, bool) {
+	if t, ok := db.expires.Get(key); ok && time.Now().After(t) {
+		db.expireKey(key)
+		return nil, false
+	}
+	return db.store.Get(key)
+}
+
+// lookupKeyType 取 key 并检查类型, 类型不符时返回 errWrongType; key 不存在返回 nil, ""
+func (db *DB) lookupKeyType(key string, typ objType) (*object, string) {
+	o, ok := db.lookupKey(key)
+	if !ok {
+		return nil, ""
+	}
+	if o.typ != typ {
+		return nil, errWrongType
+	}
+	return o, ""
+}
+
+// setKey 设置 key 的值, 覆盖原有的值和过期时间
+func (db *DB) setKey(key string, o *object) {
+	if old, ok := db.store.Get(key); ok {
+		usedMemory -= old.size
+	} else {
+		db.keys.add(key)
+	}
+	o.initAccess()
+	db.store.Set(key, o)
+	db.removeExpire(key)
+	db.signalModifiedKey(key)
+	if o.typ == typeList || o.typ == typeStream {
+		// RENAME / MOVE 过来的 list / stream 也可能有客户端在等
+		db.signalKeyAsReady(key)
+	}
+}
+
+// removeKey 删除 key 及其过期时间, 返回 key 是否存在
+func (db *DB) removeKey(key string) bool {
+	db.removeExpire(key)
+	o, ok := db.store.Pop(key)
+	if ok {
+		usedMemory -= o.size
+		o.size = 0
+		db.keys.remove(key)
+		db.signalModifiedKey(key)
+	}
+	return ok
+}
+
+// setExpire 设置 key 的过期时间, 调用方保证 key 存在
+func (db *DB) setExpire(key string, t time.Time) {
+	db.expires.Set(key, t)
+	db.volatileKeys.add(key)
+}
+
+// removeExpire 去掉 key 的过期时间, 返回原来是否设置过
+func (db *DB) removeExpire(key string) bool {
+	if _, ok := db.expires.Pop(key); !ok {
+		return false
+	}
+	db.volatileKeys.remove(key)
+	return true
+}
+
+// keyLogicallyExpired 判断 key 是否已过期但还没被删除
+func (db *DB) keyLogicallyExpired(key string) bool {
+	t, ok := db.expires.Get(key)
+	return ok && time.Now().After(t)
+}
+
+// size 返回库里的 key 数, 包含已过期但还没被删除的
+func (db *DB) size() int { return db.keys.len() }
+
+// flush 清空这个库, WATCH 了其中任意 key 的事务都会失败
+func (db *DB) flush() {
+	db.store.IterCb(func(_ string, o *object) {
+		usedMemory -= o.size
+	})
+	db.store.Clear()
+	db.expires.Clear()
+	db.keys.reset()
+	db.volatileKeys.reset()
+	touchAllWatchedKeysInDB(db.id)
+}
+
+// flushKeyspace 清空所有库
+func flushKeyspace() {
+	for _, db := range dbs {
+		db.flush()
+	}
+	evictionPool = nil
+	clear(memDirtyKeys)
+	usedMemory = 0
+}
+
+// DEL key [key ...] / UNLINK key [key ...]
+// 没有后台释放线程, UNLINK 和 DEL 一样同步删除
+func delCommand(c *client, args []string) {
+	w := c.w
+	if len(args) < 2 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	var deleted int64
+	for _, key := range args[1:] {
+		if _, ok := c.db.lookupKeyNoTouch(key); ok && c.db.removeKey(key) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		dirty++
+	}
+	w.writeInt(deleted)
+}
+
+// EXISTS key [key ...], 重复的 key 重复计数
+func existsCommand(c *client, args []string) {
+	w := c.w
+	var n int64
+	for _, key := range args[1:] {
+		if _, ok := c.db.lookupKeyNoTouch(key); ok {
+			n++
+		}
+	}
+	w.writeInt(n)
+}
+
+// TYPE key
+func typeCommand(c *client, args []string) {
+	w := c.w
+	o, ok := c.db.lookupKeyNoTouch(args[1])
+	if !ok {
+		w.writeSimple("none")
+		return
+	}
+	w.writeSimple(o.typ.String())
+}
+
+// RENAME key newkey / RENAMENX key newkey
+// 值连同过期时间一起移到 newkey, newkey 原有的值被覆盖
+func renameCommand(c *client, args []string, nx bool) {
+	w := c.w
+	if len(args) != 3 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	src, dst := args[1], args[2]
+	o, ok := c.db.lookupKeyNoTouch(src)
+	if !ok {
+		w.writeError("ERR no such key")
+		return
+	}
+	if src == dst {
+		if nx {
+			w.writeInt(0)
+		} else {
+			w.writeOK()
+		}
+		return
+	}
+	if _, exists := c.db.lookupKeyNoTouch(dst); exists && nx {
+		w.writeInt(0)
+		return
+	}
+
+	at, hasTTL := c.db.expires.Get(src)
+	lru := o.lru
+	c.db.removeKey(src)
+	c.db.setKey(dst, o)
+	o.lru = lru // 改名不算访问
+	if hasTTL {
+		c.db.setExpire(dst, at)
+	}
+	dirty++
+	if nx {
+		w.writeInt(1)
+	} else {
+		w.writeOK()
+	}
+}
+
+// RANDOMKEY
+func randomkeyCommand(c *client, args []string) {
+	w := c.w
+	// 抽到已过期的 key 会被顺手删掉, 循环一定会结束
+	for c.db.keys.len() > 0 {
+		key := c.db.keys.random()
+		if _, ok := c.db.lookupKeyNoTouch(key); ok {
+			w.writeBulk(key)
+			return
+		}
+	}
+	w.writeNull()
+}
+
+// DBSIZE, 和 Redis 一样包含已过期但还没被删除的 key
+func dbsizeCommand(c *client, args []string) {
+	w := c.w
+	w.writeInt(int64(c.db.keys.len()))
+}
+
+// KEYS pattern
+func keysCommand(c *client, args []string) {
+	w := c.w
+	pattern := args[1]
+	var out []string
+	for _, key := range c.db.keys.keys {
+		if c.db.keyLogicallyExpired(key) {
+			continue
+		}
+		if pattern == "*" || globMatch(pattern, key) {
+			out = append(out, key)
+		}
+	}
+	w.writeBulks(out...)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// 游标和 HSCAN 一样按 key 的 hash 定位, 与 key 落在哪个分片、分片怎么扩缩容无关,
+// 所以整个遍历期间一直存在的 key 一定会被返回 (可能重复)
+func scanCommand(c *client, args []string) {
+	w := c.w
+	cursor, ok := parseCursor(args[1])
+	if !ok {
+		w.writeError("ERR invalid cursor")
+		return
+	}
+	typ := ""
+	opts, perr := parseScanOptions(args[2:], func(rest []string) int {
+		if strings.ToUpper(rest[0]) == "TYPE" && len(rest) > 1 {
+			typ = rest[1]
+			return 2
+		}
+		return 0
+	})
+	if perr != "" {
+		w.writeError(perr)
+		return
+	}
+
+	next, keys := c.db.keys.scan.scan(cursor, opts.count)
+
+	out := keys[:0]
+	for _, key := range keys {
+		if opts.match != "" && !globMatch(opts.match, key) {
+			continue
+		}
+		o, ok := c.db.lookupKeyNoTouch(key)
+		if !ok {
+			continue
+		}
+		if typ != "" && !strings.EqualFold(typ, o.typ.String()) {
+			continue
+		}
+		out = append(out, key)
+	}
+	w.writeArray(2)
+	w.writeBulk(strconv.FormatUint(next, 10))
+	w.writeBulks(out...)
+}
+
+// SELECT index
+func selectCommand(c *client, args []string) {
+	w := c.w
+	id, ok := parseInt64(args[1])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	// 集群模式只有 0 号库
+	if clusterEnabled && id != 0 {
+		w.writeError("ERR SELECT is not allowed in cluster mode")
+		return
+	}
+	db := selectDB(id)
+	if db == nil {
+		w.writeError(errDBIndex)
+		return
+	}
+	c.db = db
+	w.writeOK()
+}
+
+const errDBIndex = "ERR DB index is out of range"
+
+// parseFlushMode 解析 FLUSHDB / FLUSHALL 的 ASYNC | SYNC 参数, 没有后台释放线程, 两者一样同步清空
+func parseFlushMode(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	if len(args) > 1 {
+		return false
+	}
+	mode := strings.ToUpper(args[0])
+	return mode == "ASYNC" || mode == "SYNC"
+}
+
+// FLUSHDB [ASYNC | SYNC]
+func flushdbCommand(c *client, args []string) {
+	w := c.w
+	if !parseFlushMode(args[1:]) {
+		w.writeError(errSyntax)
+		return
+	}
+	c.db.flush()
+	recordAOF(c.db, []string{"FLUSHDB"})
+	w.writeOK()
+}
+
+// FLUSHALL [ASYNC | SYNC]
+func flushallCommand(c *client, args []string) {
+	w := c.w
+	if !parseFlushMode(args[1:]) {
+		w.writeError(errSyntax)
+		return
+	}
+	flushKeyspace()
+	recordAOF(nil, []string{"FLUSHALL"})
+	w.writeOK()
+}
+
+// SWAPDB index1 index2
+// 交换两个库的数据, 连接选中的库编号不变, 看到的数据随之交换
+func swapdbCommand(c *client, args []string) {
+	w := c.w
+	if clusterEnabled {
+		w.writeError("ERR SWAPDB is not allowed in cluster mode")
+		return
+	}
+	id1, ok := parseInt64(args[1])
+	if !ok {
+		w.writeError("ERR invalid first DB index")
+		return
+	}
+	id2, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError("ERR invalid second DB index")
+		return
+	}
+	a, b := selectDB(id1), selectDB(id2)
+	if a == nil || b == nil {
+		w.writeError(errDBIndex)
+		return
+	}
+	if a != b {
+		a.store, b.store = b.store, a.store
+		a.expires, b.expires = b.expires, a.expires
+		a.keys, b.keys = b.keys, a.keys
+		a.volatileKeys, b.volatileKeys = b.volatileKeys, a.volatileKeys
+		// 候选池里的 key 记的是交换前的库
+		evictionPool = nil
+		for _, db := range []*DB{a, b} {
+			touchAllWatchedKeysInDB(db.id)
+			// 换过来的库里可能有别的连接正在等的 list
+			for k := range blockingKeys {
+				if k.db != db.id {
+					continue
+				}
+				if o, ok := db.store.Get(k.key); ok && o.typ == typeList {
+					db.signalKeyAsReady(k.key)
+				}
+			}
+		}
+	}
+	recordAOF(nil, args)
+	w.writeOK()
+}
+
+// MOVE key db
+// 把 key 连同过期时间移到另一个库, 目标库里已有同名 key 时什么都不做
+func moveCommand(c *client, args []string) {
+	w := c.w
+	if clusterEnabled {
+		w.writeError("ERR MOVE is not allowed in cluster mode")
+		return
+	}
+	id, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	dst := selectDB(id)
+	if dst == nil {
+		w.writeError(errDBIndex)
+		return
+	}
+	src, key := c.db, args[1]
+	if dst == src {
+		w.writeError("ERR source and destination objects are the same")
+		return
+	}
+	o, ok := src.lookupKeyNoTouch(key)
+	if !ok {
+		w.writeInt(0)
+		return
+	}
+	if _, exists := dst.lookupKeyNoTouch(key); exists {
+		w.writeInt(0)
+		return
+	}
+	at, hasTTL := src.expires.Get(key)
+	lru := o.lru
+	src.removeKey(key)
+	dst.setKey(key, o)
+	o.lru = lru
+	if hasTTL {
+		dst.setExpire(key, at)
+	}
+	recordAOF(src, args)
+	w.writeInt(1)
+}
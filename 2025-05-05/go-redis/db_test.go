@@ -0,0 +1,409 @@
+package redis
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "a", "1")
+	tc.do("RPUSH", "l", "x")
+	tc.do("HSET", "h", "f", "v")
+
+	expectReply(t, tc, ":3\r\n", "EXISTS", "a", "a", "l", "missing")
+	expectReply(t, tc, "+string\r\n", "TYPE", "a")
+	expectReply(t, tc, "+list\r\n", "TYPE", "l")
+	expectReply(t, tc, "+none\r\n", "TYPE", "missing")
+	expectReply(t, tc, ":3\r\n", "DBSIZE")
+	expectReply(t, tc, ":2\r\n", "DEL", "a", "l", "missing")
+	expectReply(t, tc, ":0\r\n", "UNLINK", "a")
+	expectReply(t, tc, ":1\r\n", "UNLINK", "h")
+	expectReply(t, tc, ":0\r\n", "DBSIZE")
+	expectReply(t, tc, "$-1\r\n", "RANDOMKEY")
+	tc.do("SET", "only", "v")
+	expectReply(t, tc, "$4\r\nonly\r\n", "RANDOMKEY")
+}
+
+func TestRename(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	expectReply(t, tc, "-ERR no such key\r\n", "RENAME", "src", "dst")
+	tc.do("SET", "src", "v")
+	tc.do("EXPIRE", "src", "100")
+	tc.do("SET", "dst", "old")
+	expectReply(t, tc, ":0\r\n", "RENAMENX", "src", "dst")
+	expectReply(t, tc, "+OK\r\n", "RENAME", "src", "src")
+	expectReply(t, tc, "+OK\r\n", "RENAME", "src", "dst")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "src")
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "dst")
+	expectReply(t, tc, ":100\r\n", "TTL", "dst")
+	expectReply(t, tc, ":1\r\n", "RENAMENX", "dst", "other")
+	if o, _ := dbs[0].store.Get("other"); usedMemory != objectMemory(dbs[0], "other", o) {
+		t.Fatalf("usedMemory = %d after rename", usedMemory)
+	}
+
+	replayFromStart(t)
+	expectReply(t, tc, ":1\r\n", "DBSIZE")
+	expectReply(t, tc, ":100\r\n", "TTL", "other")
+}
+
+func TestKeys(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	for _, k := range []string{"hello", "hallo", "hxllo", "world", "h*"} {
+		tc.do("SET", k, "v")
+	}
+	tc.do("SET", "hexpired", "v")
+	tc.do("PEXPIRE", "hexpired", "1")
+	keysOf := func(pattern string) []string {
+		var out []string
+		for _, line := range strings.Split(tc.do("KEYS", pattern), "\r\n") {
+			if line != "" && line[0] != '*' && line[0] != '$' {
+				out = append(out, line)
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	if got := keysOf("h?llo"); !slices.Equal(got, []string{"hallo", "hello", "hxllo"}) {
+		t.Fatalf("KEYS h?llo = %q", got)
+	}
+	if got := keysOf("h[ae]llo"); !slices.Equal(got, []string{"hallo", "hello"}) {
+		t.Fatalf("KEYS h[ae]llo = %q", got)
+	}
+	if got := keysOf(`h\*`); !slices.Equal(got, []string{"h*"}) {
+		t.Fatalf(`KEYS h\* = %q`, got)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if got := keysOf("*"); len(got) != 5 {
+		t.Fatalf("KEYS * = %q", got)
+	}
+}
+
+// scanAll 用 SCAN 从头遍历一次, between 在每两次调用之间执行
+func scanAll(t *testing.T, tc *testClient, between func(), opts ...string) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		reply := tc.do(append([]string{"SCAN", cursor}, opts...)...)
+		lines := strings.Split(reply, "\r\n")
+		if len(lines) < 3 || lines[0] != "*2" {
+			t.Fatalf("SCAN reply %q", reply)
+		}
+		cursor = lines[2]
+		for _, line := range lines[4:] {
+			if line != "" && line[0] != '$' {
+				seen[line]++
+			}
+		}
+		if cursor == "0" {
+			return seen
+		}
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	for i := range 100 {
+		tc.do("SET", "s"+strconv.Itoa(i), "v")
+	}
+	for i := range 10 {
+		tc.do("RPUSH", "l"+strconv.Itoa(i), "v")
+	}
+
+	if seen := scanAll(t, tc, nil); len(seen) != 110 {
+		t.Fatalf("SCAN returned %d keys, want 110", len(seen))
+	}
+	seen := scanAll(t, tc, nil, "MATCH", "l*", "COUNT", "3")
+	if len(seen) != 10 {
+		t.Fatalf("SCAN MATCH l* returned %d keys", len(seen))
+	}
+	seen = scanAll(t, tc, nil, "TYPE", "list")
+	if len(seen) != 10 {
+		t.Fatalf("SCAN TYPE list returned %d keys", len(seen))
+	}
+	expectReply(t, tc, "-ERR invalid cursor\r\n", "SCAN", "abc")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "SCAN", "0", "TYPE")
+
+	// 遍历过程中 keyspace 反复扩容缩容, 一直存在的 key 仍然全部返回
+	n := 0
+	seen = scanAll(t, tc, func() {
+		for range 50 {
+			tc.do("SET", "tmp"+strconv.Itoa(n), "v")
+			n++
+		}
+		for i := n - 50; i < n; i += 2 {
+			tc.do("DEL", "tmp"+strconv.Itoa(i))
+		}
+	}, "COUNT", "5")
+	for i := range 100 {
+		if seen["s"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("s%d missing from SCAN while keyspace grew", i)
+		}
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	var x scanIndex
+	for i := range 1000 {
+		x.add("m" + strconv.Itoa(i))
+	}
+	// 每次调用只取 COUNT 附近个成员, 不走整个集合
+	cursor, got := x.scan(0, 10)
+	if cursor == 0 || len(got) < 10 || len(got) > 40 {
+		t.Fatalf("scan(0, 10): cursor %d, %d members", cursor, len(got))
+	}
+
+	// 遍历途中缩容到很小, 一直存在的成员仍然全部返回
+	seen := make(map[string]bool)
+	for _, m := range got {
+		seen[m] = true
+	}
+	for i := 10; i < 1000; i++ {
+		x.remove("m" + strconv.Itoa(i))
+	}
+	if len(x.tables[0]) > 64 {
+		t.Fatalf("index did not shrink: %d buckets for %d members", len(x.tables[0]), x.n)
+	}
+	for cursor != 0 {
+		cursor, got = x.scan(cursor, 10)
+		for _, m := range got {
+			seen[m] = true
+		}
+	}
+	for i := range 10 {
+		if !seen["m"+strconv.Itoa(i)] {
+			t.Fatalf("m%d was not returned", i)
+		}
+	}
+}
+
+func TestScanIndexIncrementalRehash(t *testing.T) {
+	var x scanIndex
+	for i := range 1024 {
+		x.add("m" + strconv.Itoa(i))
+	}
+	if x.rehashing() {
+		for x.rehashing() {
+			x.rehashStep()
+		}
+	}
+	// 越过 2 的幂的那次 add 只开始 rehash, 旧表里的成员留着慢慢搬
+	x.add("m1024")
+	if !x.rehashing() || len(x.tables[1]) != 2*len(x.tables[0]) {
+		t.Fatalf("add did not start an incremental rehash: %d/%d buckets", len(x.tables[0]), len(x.tables[1]))
+	}
+	moved := 0
+	for _, bk := range x.tables[1] {
+		moved += len(bk)
+	}
+	if moved > 64 {
+		t.Fatalf("one add moved %d members", moved)
+	}
+
+	// rehash 进行到一半时遍历, 每个成员都要返回
+	for i := 1025; i < 1200; i++ {
+		x.add("m" + strconv.Itoa(i))
+	}
+	if !x.rehashing() {
+		t.Fatal("rehash finished too early")
+	}
+	seen := make(map[string]bool)
+	cursor, got := x.scan(0, 10)
+	for {
+		for _, m := range got {
+			seen[m] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		cursor, got = x.scan(cursor, 10)
+	}
+	if len(seen) != 1200 {
+		t.Fatalf("scan during rehash returned %d of 1200 members", len(seen))
+	}
+	for x.rehashing() {
+		x.rehashStep()
+	}
+	if x.n != 1200 || len(x.tables[0]) != 2048 {
+		t.Fatalf("after rehash: %d members in %d buckets", x.n, len(x.tables[0]))
+	}
+}
+
+func TestSelect(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	other := newTestClient()
+	tc.do("SET", "k", "db0")
+	expectReply(t, tc, "+OK\r\n", "SELECT", "1")
+	expectReply(t, tc, "$-1\r\n", "GET", "k")
+	tc.do("SET", "k", "db1")
+	expectReply(t, tc, ":1\r\n", "DBSIZE")
+	// 选中的库是连接自己的状态
+	expectReply(t, other, "$3\r\ndb0\r\n", "GET", "k")
+	expectReply(t, tc, "-"+errDBIndex+"\r\n", "SELECT", "16")
+	expectReply(t, tc, "-"+errDBIndex+"\r\n", "SELECT", "-1")
+	expectReply(t, tc, "-"+errNotInt+"\r\n", "SELECT", "x")
+	expectReply(t, tc, "$3\r\ndb1\r\n", "GET", "k")
+	if got := tc.do("INFO", "keyspace"); !strings.Contains(got, "db0:keys=1,expires=0\r\ndb1:keys=1,expires=0\r\n") {
+		t.Fatalf("INFO keyspace:\n%s", got)
+	}
+}
+
+func TestFlushdbFlushall(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "a", "1")
+	tc.do("SELECT", "2")
+	tc.do("SET", "b", "2")
+	expectReply(t, tc, "-ERR syntax error\r\n", "FLUSHDB", "LATER")
+	expectReply(t, tc, "+OK\r\n", "FLUSHDB", "ASYNC")
+	expectReply(t, tc, ":0\r\n", "DBSIZE")
+	if dbs[0].size() != 1 {
+		t.Fatal("FLUSHDB cleared another database")
+	}
+	tc.do("SET", "b", "2")
+	expectReply(t, tc, "+OK\r\n", "FLUSHALL")
+	if dbs[0].size() != 0 || dbs[2].size() != 0 || usedMemory != 0 {
+		t.Fatalf("FLUSHALL left keys: db0=%d db2=%d mem=%d", dbs[0].size(), dbs[2].size(), usedMemory)
+	}
+}
+
+func TestMove(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	tc.do("EXPIRE", "k", "100")
+	tc.do("SET", "taken", "src")
+	expectReply(t, tc, "-ERR source and destination objects are the same\r\n", "MOVE", "k", "0")
+	expectReply(t, tc, "-"+errDBIndex+"\r\n", "MOVE", "k", "16")
+	expectReply(t, tc, ":0\r\n", "MOVE", "missing", "1")
+	expectReply(t, tc, ":1\r\n", "MOVE", "k", "1")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "k")
+
+	tc.do("SELECT", "1")
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "k")
+	expectReply(t, tc, ":100\r\n", "TTL", "k")
+	tc.do("SET", "taken", "dst")
+	tc.do("SELECT", "0")
+	// 目标库已有同名 key 时不移动
+	expectReply(t, tc, ":0\r\n", "MOVE", "taken", "1")
+	expectReply(t, tc, "$3\r\nsrc\r\n", "GET", "taken")
+}
+
+func TestSwapdb(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	other := newTestClient()
+	other.do("SELECT", "1")
+	tc.do("SET", "k", "zero")
+	tc.do("EXPIRE", "k", "100")
+	other.do("SET", "k", "one")
+
+	expectReply(t, tc, "+OK\r\n", "WATCH", "k")
+	expectReply(t, tc, "-ERR invalid first DB index\r\n", "SWAPDB", "x", "1")
+	expectReply(t, tc, "-"+errDBIndex+"\r\n", "SWAPDB", "0", "16")
+	expectReply(t, other, "+OK\r\n", "SWAPDB", "0", "1")
+	expectReply(t, tc, "$3\r\none\r\n", "GET", "k")
+	expectReply(t, tc, ":-1\r\n", "TTL", "k")
+	expectReply(t, other, "$4\r\nzero\r\n", "GET", "k")
+	expectReply(t, other, ":100\r\n", "TTL", "k")
+	// SWAPDB 让 WATCH 了相关库的事务失败
+	tc.do("MULTI")
+	tc.do("GET", "k")
+	expectReply(t, tc, "*-1\r\n", "EXEC")
+}
+
+func TestSwapdbServesBlockedClient(t *testing.T) {
+	resetKeyspace()
+	tc, consumer := newTestClient(), newTestClient()
+	tc.do("SELECT", "1")
+	tc.do("RPUSH", "q", "job")
+
+	done := make(chan string)
+	go func() { done <- consumer.do("BLPOP", "q", "0") }()
+	for {
+		dbMu.Lock()
+		n := len(blockingKeys[dbKey{0, "q"}])
+		dbMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 交换过来的 list 满足了在 db0 上阻塞的客户端
+	expectReply(t, tc, "+OK\r\n", "SWAPDB", "0", "1")
+	select {
+	case got := <-done:
+		if want := "*2\r\n$1\r\nq\r\n$3\r\njob\r\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPOP was not woken up by SWAPDB")
+	}
+}
+
+func TestAOFSelectReplay(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "k", "db0")
+	tc.do("SELECT", "3")
+	tc.do("SET", "k", "db3")
+	tc.do("RPUSH", "l", "x")
+	tc.do("MOVE", "l", "5")
+	tc.do("SWAPDB", "5", "6")
+	tc.do("SELECT", "0")
+	tc.do("SET", "j", "db0")
+
+	replayFromStart(t)
+	for id, want := range map[int]string{0: "db0", 3: "db3"} {
+		if o, ok := dbs[id].store.Get("k"); !ok || o.str() != want {
+			t.Fatalf("db%d k = %v", id, o)
+		}
+	}
+	if !dbs[0].store.Has("j") || dbs[3].store.Has("j") {
+		t.Fatal("j replayed into the wrong database")
+	}
+	if dbs[5].store.Has("l") || !dbs[6].store.Has("l") {
+		t.Fatal("MOVE / SWAPDB not replayed")
+	}
+
+	// 重写后的 AOF 同样按库还原
+	tc.do("BGREWRITEAOF")
+	waitRewrite(t)
+	replayFromStart(t)
+	if o, ok := dbs[3].store.Get("k"); !ok || o.str() != "db3" || !dbs[6].store.Has("l") {
+		t.Fatal("rewritten AOF lost database ids")
+	}
+}
+
+func TestRDBMultipleDatabases(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "db0")
+	tc.do("SELECT", "15")
+	tc.do("SET", "k", "db15")
+	tc.do("EXPIRE", "k", "100")
+	expectReply(t, tc, "+OK\r\n", "SAVE")
+
+	resetKeyspace()
+	if keys, err := loadRDBFile(rdbPath); err != nil || keys != 2 {
+		t.Fatalf("loaded %d keys, err %v", keys, err)
+	}
+	expectReply(t, tc, "$4\r\ndb15\r\n", "GET", "k")
+	expectReply(t, tc, ":100\r\n", "TTL", "k")
+	tc.do("SELECT", "0")
+	expectReply(t, tc, "$3\r\ndb0\r\n", "GET", "k")
+}
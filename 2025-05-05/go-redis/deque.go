@@ -0,0 +1,88 @@
+package redis
+
+// deque 是 list 类型的底层存储: 环形数组实现的双端队列 (没有做 quicklist 的分段压缩),
+// 两端 push/pop O(1), 按下标访问 O(1), 中间删除 O(n)
+type deque struct {
+	buf  []string
+	head int
+	n    int
+}
+
+func (l *deque) len() int { return l.n }
+
+func (l *deque) grow() {
+	size := len(l.buf) * 2
+	if size == 0 {
+		size = 8
+	}
+	buf := make([]string, size)
+	for i := range l.n {
+		buf[i] = l.at(i)
+	}
+	l.buf, l.head = buf, 0
+}
+
+func (l *deque) slot(i int) int { return (l.head + i) & (len(l.buf) - 1) }
+
+func (l *deque) at(i int) string { return l.buf[l.slot(i)] }
+
+func (l *deque) set(i int, v string) { l.buf[l.slot(i)] = v }
+
+func (l *deque) pushFront(v string) {
+	if l.n == len(l.buf) {
+		l.grow()
+	}
+	l.head = (l.head - 1) & (len(l.buf) - 1)
+	l.buf[l.head] = v
+	l.n++
+}
+
+func (l *deque) pushBack(v string) {
+	if l.n == len(l.buf) {
+		l.grow()
+	}
+	l.buf[l.slot(l.n)] = v
+	l.n++
+}
+
+func (l *deque) popFront() string {
+	v := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = l.slot(1)
+	l.n--
+	return v
+}
+
+func (l *deque) popBack() string {
+	i := l.slot(l.n - 1)
+	v := l.buf[i]
+	l.buf[i] = ""
+	l.n--
+	return v
+}
+
+// rangeOf 返回 [start, stop] 闭区间内的元素, 调用方保证下标合法
+func (l *deque) rangeOf(start, stop int) []string {
+	out := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		out = append(out, l.at(i))
+	}
+	return out
+}
+
+// filter 只保留 keep 返回 true 的元素, 保持原有顺序
+func (l *deque) filter(keep func(i int, v string) bool) {
+	n := l.n
+	kept := 0
+	for i := range n {
+		v := l.at(i)
+		if keep(i, v) {
+			l.set(kept, v)
+			kept++
+		}
+	}
+	for i := kept; i < n; i++ {
+		l.set(i, "")
+	}
+	l.n = kept
+}
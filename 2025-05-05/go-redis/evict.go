@@ -0,0 +1,466 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 内存上限和淘汰策略, 思路同 Redis evict.c:
+//   - 每个 key 的内存按近似值估算, 写命令结束后只重算被改过的 key, 汇总成 usedMemory
+//   - 淘汰不维护全局的 LRU 链表, 而是每次随机采样几个 key, 放进一个按"该淘汰程度"排序的
+//     候选池, 从池子里挑最该淘汰的删掉
+//   - object.lru 在 LRU 策略下是访问时间 (秒级时钟), 在 LFU 策略下高 16 位是分钟级的
+//     衰减时间、低 8 位是对数计数器
+
+const (
+	evictNoEviction = iota
+	evictAllKeysLRU
+	evictVolatileLRU
+	evictAllKeysLFU
+	evictVolatileLFU
+	evictVolatileTTL
+	evictAllKeysRandom
+	evictVolatileRandom
+)
+
+var evictPolicyNames = []string{
+	"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu",
+	"volatile-lfu", "volatile-ttl", "allkeys-random", "volatile-random",
+}
+
+const (
+	evictionPoolSize = 16 // 同 Redis EVPOOL_SIZE
+
+	lruClockMax = 1<<24 - 1 // lru 字段 24 位
+	lfuInitVal  = 5         // 新 key 的 LFU 计数, 避免刚写入就被淘汰
+)
+
+var (
+	// 以下都由 dbMu 保护
+	maxmemory        int64 // 0 表示不限制
+	maxmemoryPolicy  = evictNoEviction
+	maxmemorySamples = 5
+	lfuLogFactor     = 10
+	lfuDecayTime     = 1 // 分钟
+
+	usedMemory   int64 // 所有库里 key 估算内存之和
+	evictedKeys  int64
+	memDirtyKeys = make(map[dbKey]struct{}) // 本条命令改过、需要重算内存的 key
+
+	evictionPool []evictionCandidate
+	evictionDB   int // random 策略下轮流从各个库淘汰
+)
+
+// keySampler 是 key 的集合, 支持 O(1) 增删和随机取一个, 同时维护 SCAN 用的桶索引。
+// slots 是集群模式下按 slot 分组的 key, 第一次用到时才建立, 之后随增删维护
+type keySampler struct {
+	keys  []string
+	pos   map[string]int
+	scan  scanIndex
+	slots []map[string]struct{}
+}
+
+func newKeySampler() *keySampler {
+	return &keySampler{pos: make(map[string]int)}
+}
+
+func (s *keySampler) add(key string) {
+	if _, ok := s.pos[key]; ok {
+		return
+	}
+	s.pos[key] = len(s.keys)
+	s.keys = append(s.keys, key)
+	s.scan.add(key)
+	if s.slots != nil {
+		s.addSlotKey(key)
+	}
+}
+
+func (s *keySampler) remove(key string) {
+	i, ok := s.pos[key]
+	if !ok {
+		return
+	}
+	last := len(s.keys) - 1
+	s.keys[i] = s.keys[last]
+	s.pos[s.keys[i]] = i
+	s.keys = s.keys[:last]
+	delete(s.pos, key)
+	s.scan.remove(key)
+	if s.slots != nil {
+		delete(s.slots[keyHashSlot(key)], key)
+	}
+}
+
+func (s *keySampler) len() int { return len(s.keys) }
+
+func (s *keySampler) random() string { return s.keys[rand.IntN(len(s.keys))] }
+
+func (s *keySampler) reset() {
+	s.keys = nil
+	s.pos = make(map[string]int)
+	s.scan.reset()
+	if s.slots != nil {
+		s.slots = make([]map[string]struct{}, clusterSlots)
+	}
+}
+
+// slotIndex 返回按 slot 分组的 key, 没有建立过时先建立
+func (s *keySampler) slotIndex() []map[string]struct{} {
+	if s.slots == nil {
+		s.slots = make([]map[string]struct{}, clusterSlots)
+		for _, k := range s.keys {
+			s.addSlotKey(k)
+		}
+	}
+	return s.slots
+}
+
+func (s *keySampler) addSlotKey(key string) {
+	slot := keyHashSlot(key)
+	if s.slots[slot] == nil {
+		s.slots[slot] = make(map[string]struct{})
+	}
+	s.slots[slot][key] = struct{}{}
+}
+
+// ---------------- 访问信息: LRU 时钟和 LFU 计数 ----------------
+
+func lruClock() uint32 {
+	return uint32(time.Now().Unix()) & lruClockMax
+}
+
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xffff
+}
+
+func lfuPolicy() bool {
+	return maxmemoryPolicy == evictAllKeysLFU || maxmemoryPolicy == evictVolatileLFU
+}
+
+// initAccess 初始化新 key 的访问信息
+func (o *object) initAccess() {
+	if lfuPolicy() {
+		o.lru = lfuTimeInMinutes()<<8 | lfuInitVal
+	} else {
+		o.lru = lruClock()
+	}
+}
+
+// touch 记录一次访问
+func (o *object) touch() {
+	if lfuPolicy() {
+		counter := lfuLogIncr(o.lfuDecrAndReturn())
+		o.lru = lfuTimeInMinutes()<<8 | uint32(counter)
+	} else {
+		o.lru = lruClock()
+	}
+}
+
+// idleTime 估算 key 空闲了多久, 时钟回绕时按回绕处理
+func (o *object) idleTime() time.Duration {
+	now, lru := lruClock(), o.lru&lruClockMax
+	var secs uint32
+	if now >= lru {
+		secs = now - lru
+	} else {
+		secs = lruClockMax - lru + now
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// lfuLogIncr 对数递增: 计数越大, 再加一的概率越小
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return 255
+	}
+	base := max(float64(counter)-lfuInitVal, 0)
+	if rand.Float64() < 1/(base*float64(lfuLogFactor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn 按距离上次访问过去的分钟数衰减计数
+func (o *object) lfuDecrAndReturn() uint8 {
+	ldt, counter := o.lru>>8, uint8(o.lru&0xff)
+	now := lfuTimeInMinutes()
+	var elapsed uint32
+	if now >= ldt {
+		elapsed = now - ldt
+	} else {
+		elapsed = 0xffff - ldt + now
+	}
+	if lfuDecayTime > 0 {
+		periods := elapsed / uint32(lfuDecayTime)
+		if periods >= uint32(counter) {
+			return 0
+		}
+		counter -= uint8(periods)
+	}
+	return counter
+}
+
+// ---------------- 内存估算 ----------------
+
+// 估算用的固定开销: 字符串头、map 桶、跳表节点等, 数值按 64 位 Go 运行时粗略取
+const (
+	memKeyOverhead      = 96 // key 字符串头 + store / 索引里的条目 + object 结构体
+	memStringOverhead   = 16
+	memHashEntry        = 48
+	memListEntry        = 16
+	memSetEntry         = 32
+	memZSetEntry        = 120 // 跳表节点 + 字典条目
+	memStreamEntry      = 48  // ID + fields 切片头
+	memStreamNACK       = 80  // 组和消费者 PEL 里的两个 map 条目 + 记录本身
+	memExpireOverhead   = 48
+	memSamplesPerObject = 5 // 大容器按采样的平均元素长度估算, 同 MEMORY USAGE 默认的 SAMPLES 5
+)
+
+// objectMemory 估算一个 key (含 key 本身) 占用的内存。
+// 容器超过 memSamplesPerObject 个元素时只采样几个元素, 按平均长度乘以元素个数
+func objectMemory(db *DB, key string, o *object) int64 {
+	size := int64(memKeyOverhead + len(key))
+	switch o.typ {
+	case typeString:
+		size += int64(memStringOverhead + len(o.str()))
+	case typeHash:
+		h := o.hash()
+		var sum, n int
+		for f, v := range h.fields {
+			sum += len(f) + len(v)
+			if n++; n == memSamplesPerObject {
+				break
+			}
+		}
+		size += int64(h.len()) * int64(memHashEntry+sum/max(n, 1))
+	case typeList:
+		l := o.list()
+		var sum, n int
+		for i := 0; i < l.len() && n < memSamplesPerObject; i++ {
+			sum += len(l.at(rand.IntN(l.len())))
+			n++
+		}
+		size += int64(len(l.buf)*memListEntry + l.len()*(sum/max(n, 1)))
+	case typeSet:
+		s := o.set()
+		if s.is != nil {
+			size += int64(len(s.is.contents) + 24)
+			break
+		}
+		var sum, n int
+		for m := range s.dict {
+			sum += len(m)
+			if n++; n == memSamplesPerObject {
+				break
+			}
+		}
+		size += int64(len(s.dict)) * int64(memSetEntry+sum/max(n, 1))
+	case typeZSet:
+		z := o.zset()
+		var sum, n int
+		for x := z.zsl.header.level[0].forward; x != nil && n < memSamplesPerObject; x = x.level[0].forward {
+			sum += len(x.member)
+			n++
+		}
+		size += int64(z.len()) * int64(memZSetEntry+sum/max(n, 1))
+	case typeStream:
+		st := o.stream()
+		var sum, n int
+		for i := 0; i < st.len() && n < memSamplesPerObject; i++ {
+			for _, f := range st.entries[rand.IntN(st.len())].fields {
+				sum += memStringOverhead + len(f)
+			}
+			n++
+		}
+		size += int64(st.len()) * int64(memStreamEntry+sum/max(n, 1))
+		for _, g := range st.groups {
+			size += int64(memHashEntry + len(g.name) + len(g.pel)*memStreamNACK + len(g.consumers)*memHashEntry)
+		}
+	}
+	if _, ok := db.expires.Get(key); ok {
+		size += memExpireOverhead
+	}
+	return size
+}
+
+// updateMemoryAccounting 重算本条命令改过的 key 的内存, 调用方持有 dbMu
+func updateMemoryAccounting() {
+	for k := range memDirtyKeys {
+		db := dbs[k.db]
+		if o, ok := db.store.Get(k.key); ok {
+			n := objectMemory(db, k.key, o)
+			usedMemory += n - o.size
+			o.size = n
+		}
+		delete(memDirtyKeys, k)
+	}
+}
+
+// ---------------- 淘汰 ----------------
+
+// evictionCandidate 是候选池里的一个 key, idle 越大越该被淘汰
+type evictionCandidate struct {
+	db   *DB
+	key  string
+	idle uint64
+}
+
+// evictionScore 按策略计算 key 的"该淘汰程度"
+func evictionScore(db *DB, key string, o *object) uint64 {
+	switch maxmemoryPolicy {
+	case evictAllKeysLFU, evictVolatileLFU:
+		return 255 - uint64(o.lfuDecrAndReturn())
+	case evictVolatileTTL:
+		// 越早过期越先淘汰
+		t, _ := db.expires.Get(key)
+		return math.MaxUint64 - uint64(t.UnixMilli())
+	}
+	return uint64(o.idleTime() / time.Millisecond)
+}
+
+// evictionPoolPopulate 从 db 随机采样几个 key 放进候选池, 池子按 idle 升序, 最多 evictionPoolSize 个
+func evictionPoolPopulate(db *DB, sampler *keySampler) {
+	for range maxmemorySamples {
+		key := sampler.random()
+		o, ok := db.store.Get(key)
+		if !ok {
+			continue
+		}
+		idle := evictionScore(db, key, o)
+		if slices.ContainsFunc(evictionPool, func(c evictionCandidate) bool { return c.db == db && c.key == key }) {
+			continue
+		}
+		i := sort.Search(len(evictionPool), func(i int) bool { return evictionPool[i].idle >= idle })
+		if len(evictionPool) == evictionPoolSize {
+			if i == 0 {
+				// 比池子里所有的都新, 池子也满了
+				continue
+			}
+			// 挤掉最不该淘汰的那个
+			copy(evictionPool, evictionPool[1:i])
+			i--
+		} else {
+			evictionPool = append(evictionPool, evictionCandidate{})
+			copy(evictionPool[i+1:], evictionPool[i:])
+		}
+		evictionPool[i] = evictionCandidate{db: db, key: key, idle: idle}
+	}
+}
+
+// evictionSampler 返回当前策略下 db 里可淘汰的 key 集合
+func evictionSampler(db *DB) *keySampler {
+	switch maxmemoryPolicy {
+	case evictAllKeysLRU, evictAllKeysLFU, evictAllKeysRandom:
+		return db.keys
+	}
+	return db.volatileKeys
+}
+
+// selectEvictionKey 选出下一个要淘汰的 key, 没有可淘汰的返回 false
+func selectEvictionKey() (*DB, string, bool) {
+	if maxmemoryPolicy == evictAllKeysRandom || maxmemoryPolicy == evictVolatileRandom {
+		for range dbs {
+			db := dbs[evictionDB%len(dbs)]
+			evictionDB++
+			if sampler := evictionSampler(db); sampler.len() > 0 {
+				return db, sampler.random(), true
+			}
+		}
+		return nil, "", false
+	}
+	for {
+		// 每个库都采样一次, 候选池跨库比较
+		total := 0
+		for _, db := range dbs {
+			if sampler := evictionSampler(db); sampler.len() > 0 {
+				evictionPoolPopulate(db, sampler)
+				total += sampler.len()
+			}
+		}
+		if total == 0 {
+			return nil, "", false
+		}
+		// 从最该淘汰的一端取, 池子里的 key 可能已经被删掉或者不再是 volatile
+		for len(evictionPool) > 0 {
+			c := evictionPool[len(evictionPool)-1]
+			evictionPool = evictionPool[:len(evictionPool)-1]
+			if _, ok := evictionSampler(c.db).pos[c.key]; ok {
+				return c.db, c.key, true
+			}
+		}
+	}
+}
+
+// performEvictions 在内存超限时淘汰 key, 直到回到上限以内。
+// 返回 false 表示仍然超限 (noeviction 或者没有可淘汰的 key), 调用方持有 dbMu
+func performEvictions() bool {
+	if maxmemory <= 0 || usedMemory <= maxmemory {
+		return true
+	}
+	if maxmemoryPolicy == evictNoEviction {
+		return false
+	}
+	for usedMemory > maxmemory {
+		db, key, ok := selectEvictionKey()
+		if !ok {
+			return false
+		}
+		db.removeKey(key)
+		// 淘汰和过期一样以 DEL 的形式传播, AOF 和 replica 才能和内存一致
+		propagateDeletion(db, key)
+		evictedKeys++
+	}
+	return true
+}
+
+// rejectOnOOM 判断命令是否因内存超限被拒绝, 调用方持有 dbMu
+func rejectOnOOM(c *client, cmd *redisCommand) bool {
+	if maxmemory <= 0 || loading || c.master || masterHost != "" {
+		// replica 不自己淘汰, 由 master 传播过来的 DEL 删除
+		return false
+	}
+	if performEvictions() {
+		return false
+	}
+	if cmd.flags&cmdDenyOOM != 0 {
+		return true
+	}
+	// 事务里有会增加内存的命令时, EXEC 整体拒绝
+	if cmd.name == "EXEC" && c.multi {
+		for _, q := range c.queued {
+			if lookupCommand(q[0]).flags&cmdDenyOOM != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+func bytesToHuman(n int64) string {
+	f := float64(n)
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.2fK", f/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", f/(1024*1024))
+	}
+	return fmt.Sprintf("%.2fG", f/(1024*1024*1024))
+}
+
+func infoMemory(b *strings.Builder) {
+	infoField(b, "used_memory", usedMemory)
+	infoField(b, "used_memory_human", bytesToHuman(usedMemory))
+	infoField(b, "maxmemory", maxmemory)
+	infoField(b, "maxmemory_human", bytesToHuman(maxmemory))
+	infoField(b, "maxmemory_policy", evictPolicyNames[maxmemoryPolicy])
+}
@@ -0,0 +1,209 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setMaxmemory 设置内存上限和策略, 测试结束后恢复成不限制
+func setMaxmemory(t *testing.T, limit int64, policy int) {
+	t.Helper()
+	restoreConfig(t)
+	tc := newTestClient()
+	// 先设策略, 设 maxmemory 时会按新策略立即淘汰
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "maxmemory-policy", evictPolicyNames[policy])
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "maxmemory", strconv.FormatInt(limit, 10))
+}
+
+// fillKeys 写入 n 个 key, prefix 相同的 key 大小相同
+func fillKeys(tc *testClient, prefix string, n int) {
+	for i := range n {
+		tc.do("SET", prefix+strconv.Itoa(i), strings.Repeat("x", 100))
+	}
+}
+
+// ageKeys 把所有 key 的访问时间往前拨, 模拟它们很久没被访问
+func ageKeys(secs uint32) {
+	for _, key := range dbs[0].keys.keys {
+		o, _ := dbs[0].store.Get(key)
+		o.lru = (lruClock() - secs) & lruClockMax
+	}
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "s", "hello")
+	tc.do("HSET", "h", "f1", "v1", "f2", "v2")
+	tc.do("RPUSH", "l", "a", "b", "c")
+	tc.do("SADD", "set", "x", "y")
+	tc.do("ZADD", "z", "1", "m")
+	before := usedMemory
+	if before <= 0 {
+		t.Fatalf("usedMemory = %d", before)
+	}
+	tc.do("RPUSH", "l", strings.Repeat("x", 1000))
+	if usedMemory <= before {
+		t.Fatalf("usedMemory did not grow: %d -> %d", before, usedMemory)
+	}
+	tc.do("DEL", "s", "h", "l", "set", "z")
+	if usedMemory != 0 {
+		t.Fatalf("usedMemory = %d after deleting everything", usedMemory)
+	}
+}
+
+func TestNoEvictionOOM(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	fillKeys(tc, "k", 10)
+	evicted := evictedKeys
+	setMaxmemory(t, usedMemory-1, evictNoEviction)
+
+	expectReply(t, tc, "-"+errOOM+"\r\n", "SET", "new", "v")
+	// 只读和删除仍然可以执行
+	expectReply(t, tc, "$100\r\n"+strings.Repeat("x", 100)+"\r\n", "GET", "k0")
+	expectReply(t, tc, ":1\r\n", "DEL", "k0")
+	expectReply(t, tc, "+OK\r\n", "SET", "new", "v")
+
+	// 事务里的 denyoom 命令入队时就被拒绝, EXEC 整体放弃
+	setMaxmemory(t, usedMemory-1, evictNoEviction)
+	expectReply(t, tc, "+OK\r\n", "MULTI")
+	expectReply(t, tc, "-"+errOOM+"\r\n", "SET", "new2", "v")
+	expectReply(t, tc, "-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+	if got := evictedKeys - evicted; got != 0 {
+		t.Fatalf("noeviction evicted %d keys", got)
+	}
+}
+
+func TestEvictAllKeysLRU(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	fillKeys(tc, "k", 50)
+	ageKeys(1000)
+	tc.do("GET", "k7") // 最近访问过的不应该被淘汰
+	setMaxmemory(t, usedMemory, evictAllKeysLRU)
+
+	evicted := evictedKeys
+	fillKeys(tc, "new", 5)
+	tc.do("PING") // 淘汰发生在命令执行前, 最后一次写入之后还会超出一点
+	if usedMemory > maxmemory {
+		t.Fatalf("usedMemory %d > maxmemory %d", usedMemory, maxmemory)
+	}
+	if evictedKeys-evicted < 5 {
+		t.Fatalf("evicted %d keys, want >= 5", evictedKeys-evicted)
+	}
+	if _, ok := dbs[0].store.Get("k7"); !ok {
+		t.Fatal("recently used key was evicted")
+	}
+	for i := range 5 {
+		if _, ok := dbs[0].store.Get("new" + strconv.Itoa(i)); !ok {
+			t.Fatalf("new%d was evicted", i)
+		}
+	}
+}
+
+func TestEvictAllKeysLFU(t *testing.T) {
+	resetKeyspace()
+	setMaxmemory(t, 0, evictAllKeysLFU)
+	tc := newTestClient()
+	fillKeys(tc, "k", 50)
+	for _, key := range dbs[0].keys.keys {
+		o, _ := dbs[0].store.Get(key)
+		o.lru = lfuTimeInMinutes()<<8 | 1
+	}
+	hot, _ := dbs[0].store.Get("k3")
+	hot.lru = lfuTimeInMinutes()<<8 | 200
+	expectReply(t, tc, ":200\r\n", "OBJECT", "FREQ", "k3")
+	expectReply(t, tc, "-ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n", "OBJECT", "IDLETIME", "k3")
+
+	setMaxmemory(t, usedMemory, evictAllKeysLFU)
+	fillKeys(tc, "new", 5)
+	tc.do("PING") // 淘汰发生在命令执行前, 最后一次写入之后还会超出一点
+	if usedMemory > maxmemory {
+		t.Fatalf("usedMemory %d > maxmemory %d", usedMemory, maxmemory)
+	}
+	if _, ok := dbs[0].store.Get("k3"); !ok {
+		t.Fatal("frequently used key was evicted")
+	}
+}
+
+func TestEvictVolatileOnly(t *testing.T) {
+	for _, policy := range []int{evictVolatileLRU, evictVolatileTTL, evictVolatileRandom} {
+		t.Run(evictPolicyNames[policy], func(t *testing.T) {
+			resetKeyspace()
+			tc := newTestClient()
+			fillKeys(tc, "p", 20)
+			fillKeys(tc, "v", 20)
+			for i := range 20 {
+				tc.do("EXPIRE", "v"+strconv.Itoa(i), strconv.Itoa(100+i))
+			}
+			setMaxmemory(t, usedMemory, policy)
+			fillKeys(tc, "new", 3)
+			tc.do("PING")
+			for i := range 20 {
+				if _, ok := dbs[0].store.Get("p" + strconv.Itoa(i)); !ok {
+					t.Fatalf("persistent key p%d was evicted", i)
+				}
+			}
+			if dbs[0].volatileKeys.len() == 20 {
+				t.Fatal("no volatile key was evicted")
+			}
+
+			// 没有 volatile key 可淘汰时和 noeviction 一样报 OOM
+			for dbs[0].volatileKeys.len() > 0 {
+				tc.do("DEL", dbs[0].volatileKeys.keys[0])
+			}
+			setMaxmemory(t, usedMemory-1, policy)
+			expectReply(t, tc, "-"+errOOM+"\r\n", "SET", "more", "v")
+		})
+	}
+}
+
+func TestEvictionPropagatesDel(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	fillKeys(tc, "k", 10)
+	setMaxmemory(t, usedMemory, evictAllKeysRandom)
+	tc.do("SET", "extra", strings.Repeat("x", 100))
+	tc.do("PING")
+	flushAOFAll()
+
+	f, err := os.Open(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Contains(data, []byte("$3\r\nDEL\r\n")) {
+		t.Fatalf("evicted key not logged as DEL: %q", data)
+	}
+
+	keys := dbs[0].store.Count()
+	replayFromStart(t)
+	if dbs[0].store.Count() != keys {
+		t.Fatalf("replay restored %d keys, want %d", dbs[0].store.Count(), keys)
+	}
+}
+
+func TestObjectIdletime(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	ageKeys(100)
+	expectReply(t, tc, ":100\r\n", "OBJECT", "IDLETIME", "k")
+	// OBJECT 和查询 TTL 都不算访问
+	expectReply(t, tc, ":100\r\n", "OBJECT", "IDLETIME", "k")
+	tc.do("TTL", "k")
+	tc.do("PTTL", "k")
+	tc.do("EXPIRETIME", "k")
+	expectReply(t, tc, ":100\r\n", "OBJECT", "IDLETIME", "k")
+	tc.do("GET", "k")
+	expectReply(t, tc, ":0\r\n", "OBJECT", "IDLETIME", "k")
+	expectReply(t, tc, "-ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n", "OBJECT", "FREQ", "k")
+	expectReply(t, tc, "$-1\r\n", "OBJECT", "IDLETIME", "missing")
+}
@@ -0,0 +1,275 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 过期时间在内存、AOF、RDB 里都以绝对时间保存: EXPIRE 一族的命令统一改写成
+// PEXPIREAT key <unix 毫秒> 写进 AOF, 重放时 TTL 不会因为重启而重置,
+// 重放到已经过了截止时间的 PEXPIREAT 时 key 直接删除
+
+// EXPIRE 一族命令的 NX / XX / GT / LT 选项
+const (
+	expireNX = 1 << iota
+	expireXX
+	expireGT
+	expireLT
+)
+
+func parseExpireFlags(args []string) (int, string) {
+	flags := 0
+	for _, a := range args {
+		switch strings.ToUpper(a) {
+		case "NX":
+			flags |= expireNX
+		case "XX":
+			flags |= expireXX
+		case "GT":
+			flags |= expireGT
+		case "LT":
+			flags |= expireLT
+		default:
+			return 0, "ERR Unsupported option " + a
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, "ERR NX and XX, GT or LT options at the same time are not compatible"
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, "ERR GT and LT options at the same time are not compatible"
+	}
+	return flags, ""
+}
+
+// expireGenericCommand 实现 EXPIRE / PEXPIRE / EXPIREAT / PEXPIREAT:
+// relative 表示参数是相对当前时间的, unit 是参数的单位 (秒或毫秒)
+func expireGenericCommand(c *client, args []string, relative bool, unit time.Duration) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	if len(args) < 3 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	key := args[1]
+	n, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	flags, errStr := parseExpireFlags(args[3:])
+	if errStr != "" {
+		w.writeError(errStr)
+		return
+	}
+
+	// 换算成绝对毫秒时间戳, 溢出时报错
+	invalid := "ERR invalid expire time in '" + strings.ToLower(name) + "' command"
+	ms := n
+	if unit == time.Second {
+		if ms > math.MaxInt64/1000 || ms < math.MinInt64/1000 {
+			w.writeError(invalid)
+			return
+		}
+		ms *= 1000
+	}
+	if relative {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			w.writeError(invalid)
+			return
+		}
+		ms += now
+	}
+
+	if _, ok := c.db.lookupKey(key); !ok {
+		w.writeInt(0)
+		return
+	}
+	cur, hasTTL := c.db.expires.Get(key)
+	switch {
+	case flags&expireNX != 0 && hasTTL,
+		flags&expireXX != 0 && !hasTTL,
+		// 没有过期时间视为无穷大
+		flags&expireGT != 0 && (!hasTTL || ms <= cur.UnixMilli()),
+		flags&expireLT != 0 && hasTTL && ms >= cur.UnixMilli():
+		w.writeInt(0)
+		return
+	}
+
+	c.db.setExpireAt(key, ms)
+	recordAOF(c.db, []string{"PEXPIREAT", key, strconv.FormatInt(ms, 10)})
+	w.writeInt(1)
+}
+
+// ttlGenericCommand 实现 TTL / PTTL / EXPIRETIME / PEXPIRETIME:
+// ms 表示以毫秒回复, abs 表示回复绝对时间戳而不是剩余时间
+func ttlGenericCommand(c *client, args []string, ms, abs bool) {
+	w := c.w
+	if len(args) != 2 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	// 查询 TTL 不算访问, 不能让 key 在淘汰时显得刚被用过
+	if _, ok := c.db.lookupKeyNoTouch(args[1]); !ok {
+		w.writeInt(-2)
+		return
+	}
+	at, ok := c.db.expires.Get(args[1])
+	if !ok {
+		w.writeInt(-1)
+		return
+	}
+	v := at.UnixMilli()
+	if !abs {
+		v = max(v-time.Now().UnixMilli(), 0)
+	}
+	if !ms {
+		v = (v + 500) / 1000
+	}
+	w.writeInt(v)
+}
+
+// PERSIST key
+func persistCommand(c *client, args []string) {
+	w := c.w
+	key := args[1]
+	if _, ok := c.db.lookupKey(key); !ok {
+		w.writeInt(0)
+		return
+	}
+	if !c.db.removeExpire(key) {
+		w.writeInt(0)
+		return
+	}
+	c.db.signalModifiedKey(key)
+	dirty++
+	w.writeInt(1)
+}
+
+// 主动过期, 同 Redis activeExpireCycle: 每轮随机抽一批带过期时间的 key, 删掉其中过期的;
+// 过期比例超过 activeExpireStalePerc 说明还有很多没清理, 接着抽下一批, 直到比例降下来
+// 或者用完本次的时间预算。不再遍历整个 expireMap, 单次停顿有上限
+const (
+	activeExpireKeysPerLoop  = 20
+	activeExpireStalePerc    = 25
+	activeExpireCycleTimePct = 25 // 最多占用的 CPU 时间百分比
+)
+
+var (
+	// 以下都由 dbMu 保护
+	expiredKeys           int64
+	expiredStalePerc      float64 // 抽样中过期 key 比例的滑动平均
+	expiredTimeCapReached int64   // 因为时间预算用完而提前结束的轮数
+	expireCycleCPUMillis  int64
+	hz                    = 10   // 主动过期每秒执行的次数, 同 Redis hz 配置
+	activeExpireEnabled   = true // 同 DEBUG SET-ACTIVE-EXPIRE, 测试里关掉以免干扰
+	expireCurrentDB       int    // 下一轮从哪个库开始
+)
+
+// setExpireAt 设置绝对过期时间, 截止时间已过 (重放 AOF 时也一样) 的 key 直接删除
+func (db *DB) setExpireAt(key string, ms int64) {
+	at := time.UnixMilli(ms)
+	if !at.After(time.Now()) {
+		db.removeKey(key)
+		return
+	}
+	db.setExpire(key, at)
+	db.signalModifiedKey(key)
+}
+
+// expireKey 删除一个已经过期的 key, 并以 DEL 传播给 AOF 和 replica。
+// replica 上不传播, 由 master 的 DEL 保持一致
+func (db *DB) expireKey(key string) {
+	db.removeKey(key)
+	expiredKeys++
+	if masterHost == "" {
+		propagateDeletion(db, key)
+	}
+}
+
+// activeExpireCron 周期性地执行主动过期
+func activeExpireCron() {
+	cur := hz
+	ticker := time.NewTicker(time.Second / time.Duration(cur))
+	defer ticker.Stop()
+	for range ticker.C {
+		dbMu.Lock()
+		enabled := activeExpireEnabled
+		if hz != cur {
+			// CONFIG SET hz 之后按新的频率执行
+			cur = hz
+			ticker.Reset(time.Second / time.Duration(cur))
+		}
+		dbMu.Unlock()
+		if enabled {
+			activeExpireCycle()
+		}
+	}
+}
+
+// activeExpireCycle 执行一轮主动过期, 依次处理各个库; 时间预算用完时, 下一轮从没处理到的库接着来。
+// 每抽一批就释放一次 dbMu, 不会长时间挡住命令
+func activeExpireCycle() {
+	dbMu.Lock()
+	timeLimit := time.Second / time.Duration(hz) * activeExpireCycleTimePct / 100
+	dbMu.Unlock()
+	start := time.Now()
+	var sampled, expired int
+	timeout := false
+	for range dbs {
+		dbMu.Lock()
+		db := dbs[expireCurrentDB%len(dbs)]
+		expireCurrentDB++
+		dbMu.Unlock()
+
+		for {
+			dbMu.Lock()
+			if loading || masterHost != "" || db.volatileKeys.len() == 0 {
+				dbMu.Unlock()
+				break
+			}
+			now := time.Now()
+			n := min(db.volatileKeys.len(), activeExpireKeysPerLoop)
+			loopExpired := 0
+			for range n {
+				if db.volatileKeys.len() == 0 {
+					break
+				}
+				key := db.volatileKeys.random()
+				if t, ok := db.expires.Get(key); ok && now.After(t) {
+					db.expireKey(key)
+					loopExpired++
+				}
+			}
+			sampled += n
+			expired += loopExpired
+			// 这里没有等待回复的客户端, DEL 由 aofFlusher 写出
+			dbMu.Unlock()
+
+			if loopExpired*100/n <= activeExpireStalePerc {
+				break
+			}
+			if time.Since(start) > timeLimit {
+				timeout = true
+				break
+			}
+		}
+		if timeout {
+			break
+		}
+	}
+
+	dbMu.Lock()
+	if timeout {
+		expiredTimeCapReached++
+	}
+	if sampled > 0 {
+		perc := float64(expired) / float64(sampled)
+		expiredStalePerc = perc*0.05 + expiredStalePerc*0.95
+	}
+	expireCycleCPUMillis += time.Since(start).Milliseconds()
+	dbMu.Unlock()
+}
@@ -0,0 +1,151 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":0\r\n", "EXPIRE", "missing", "10")
+	expectReply(t, tc, ":-2\r\n", "TTL", "missing")
+	tc.do("SET", "k", "v")
+	expectReply(t, tc, ":-1\r\n", "TTL", "k")
+	expectReply(t, tc, ":0\r\n", "EXPIRE", "k", "10", "XX")
+	expectReply(t, tc, ":1\r\n", "EXPIRE", "k", "10", "NX")
+	expectReply(t, tc, ":0\r\n", "EXPIRE", "k", "20", "NX")
+	expectReply(t, tc, ":0\r\n", "EXPIRE", "k", "5", "GT")
+	expectReply(t, tc, ":1\r\n", "EXPIRE", "k", "20", "GT")
+	expectReply(t, tc, ":1\r\n", "EXPIRE", "k", "15", "LT")
+	expectReply(t, tc, ":15\r\n", "TTL", "k")
+	if got := tc.do("PTTL", "k"); got < ":14900\r\n" || got > ":15000\r\n" {
+		t.Fatalf("PTTL = %q", got)
+	}
+
+	at := time.Now().Add(time.Hour).Unix()
+	expectReply(t, tc, ":1\r\n", "EXPIREAT", "k", strconv.FormatInt(at, 10))
+	expectReply(t, tc, ":"+strconv.FormatInt(at, 10)+"\r\n", "EXPIRETIME", "k")
+	expectReply(t, tc, ":"+strconv.FormatInt(at*1000, 10)+"\r\n", "PEXPIRETIME", "k")
+	expectReply(t, tc, ":1\r\n", "PERSIST", "k")
+	expectReply(t, tc, ":0\r\n", "PERSIST", "k")
+	expectReply(t, tc, ":-1\r\n", "EXPIRETIME", "k")
+
+	// 覆盖写会清掉过期时间
+	tc.do("EXPIRE", "k", "100")
+	tc.do("SET", "k", "v2")
+	expectReply(t, tc, ":-1\r\n", "TTL", "k")
+
+	// 截止时间已过的直接删除
+	expectReply(t, tc, ":1\r\n", "PEXPIRE", "k", "-1")
+	expectReply(t, tc, ":-2\r\n", "TTL", "k")
+
+	expectReply(t, tc, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n", "EXPIRE", "k", "1", "NX", "GT")
+	expectReply(t, tc, "-ERR Unsupported option FOO\r\n", "EXPIRE", "k", "1", "FOO")
+	expectReply(t, tc, "-ERR invalid expire time in 'expire' command\r\n", "EXPIRE", "k", "9223372036854775807")
+	expectReply(t, tc, "-"+errNotInt+"\r\n", "EXPIRE", "k", "ten")
+}
+
+func TestExpireAOFAbsolute(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	tc.do("EXPIRE", "k", "100")
+	flushAOFAll()
+
+	f, err := os.Open(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Contains(data, []byte("PEXPIREAT")) || bytes.Contains(data, []byte("$6\r\nEXPIRE\r\n")) {
+		t.Fatalf("AOF should log absolute deadlines: %q", data)
+	}
+
+	at, _ := dbs[0].expires.Get("k")
+	replayFromStart(t)
+	if got, ok := dbs[0].expires.Get("k"); !ok || got.UnixMilli() != at.UnixMilli() {
+		t.Fatalf("deadline changed across replay: %v -> %v", at, got)
+	}
+}
+
+func TestExpireReplayDropsExpired(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "gone", "v")
+	tc.do("PEXPIRE", "gone", "20")
+	tc.do("SET", "kept", "v")
+	tc.do("EXPIRE", "kept", "100")
+	time.Sleep(30 * time.Millisecond)
+
+	replayFromStart(t)
+	if _, ok := dbs[0].store.Get("gone"); ok {
+		t.Fatal("expired key came back after replay")
+	}
+	if _, ok := dbs[0].store.Get("kept"); !ok {
+		t.Fatal("live key lost after replay")
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	for i := range 200 {
+		tc.do("SET", "gone"+strconv.Itoa(i), "v")
+		tc.do("PEXPIRE", "gone"+strconv.Itoa(i), "1")
+	}
+	for i := range 50 {
+		tc.do("SET", "kept"+strconv.Itoa(i), "v")
+		tc.do("EXPIRE", "kept"+strconv.Itoa(i), "100")
+	}
+	tc.do("SET", "persistent", "v")
+	time.Sleep(5 * time.Millisecond)
+	aofFile.Truncate(0)
+
+	before := expiredKeys
+	// 一轮在过期比例降到阈值以下时就结束, 剩下的留给后面几轮
+	activeExpireCycle()
+	if dbs[0].volatileKeys.len() == 250 {
+		t.Fatal("first cycle expired nothing")
+	}
+	for range 100 {
+		if dbs[0].volatileKeys.len() == 50 {
+			break
+		}
+		activeExpireCycle()
+	}
+	if dbs[0].volatileKeys.len() > 50+200*activeExpireStalePerc/100 {
+		t.Fatalf("%d volatile keys left", dbs[0].volatileKeys.len())
+	}
+	if dbs[0].store.Count() != dbs[0].volatileKeys.len()+1 {
+		t.Fatalf("store has %d keys, %d volatile", dbs[0].store.Count(), dbs[0].volatileKeys.len())
+	}
+	for i := range 50 {
+		if _, ok := dbs[0].store.Get("kept" + strconv.Itoa(i)); !ok {
+			t.Fatalf("kept%d was expired", i)
+		}
+	}
+	n := expiredKeys - before
+	if got := tc.do("INFO", "stats"); !strings.Contains(got, "expired_keys:"+strconv.FormatInt(expiredKeys, 10)+"\r\n") {
+		t.Fatalf("INFO stats: %q", got)
+	}
+
+	flushAOFAll()
+	f, err := os.Open(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if got := bytes.Count(data, []byte("$3\r\nDEL\r\n")); int64(got) != n {
+		t.Fatalf("AOF has %d DELs for %d expired keys", got, n)
+	}
+}
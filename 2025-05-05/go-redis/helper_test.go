@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func TestMain(m *testing.M) {
+	// AOF / RDB / ACL 文件都放在临时目录 (重写时的临时文件也建在同一目录)
+	dir, err := os.MkdirTemp("", "go-redis-*")
+	if err != nil {
+		panic(err)
+	}
+	if err := setServerDir(dir); err != nil {
+		panic(err)
+	}
+	if err := initServer(); err != nil {
+		panic(err)
+	}
+	resetKeyspace()
+	// 后台的主动过期会删 key、写 AOF, 需要的测试自己调用 activeExpireCycle
+	dbMu.Lock()
+	activeExpireEnabled = false
+	dbMu.Unlock()
+
+	code := m.Run()
+	aofFile.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func resetKeyspace() {
+	flushKeyspace()
+	// 测试会直接截断 AOF, 下一条写命令重新带上 SELECT
+	aofSelectedDB = -1
+}
+
+// testClient 绕过网络直接执行命令, 回复写进内存 buffer
+type testClient struct {
+	*client
+	buf *netpoll.LinkBuffer
+}
+
+func newTestClient() *testClient {
+	buf := netpoll.NewLinkBuffer()
+	return &testClient{client: &client{w: newReplyWriter(buf), db: dbs[0]}, buf: buf}
+}
+
+// do 执行一条命令, 返回原始 RESP 回复
+func (tc *testClient) do(args ...string) string {
+	tc.wmu.Lock()
+	defer tc.wmu.Unlock()
+	processCommand(tc.client, args)
+	tc.w.flush()
+	return tc.read()
+}
+
+// drain 读出异步推送过来的内容 (pub/sub 消息等)
+func (tc *testClient) drain() string {
+	tc.wmu.Lock()
+	defer tc.wmu.Unlock()
+	return tc.read()
+}
+
+func (tc *testClient) read() string {
+	s, _ := tc.buf.ReadString(tc.buf.Len())
+	tc.buf.Release()
+	return s
+}
+
+// replayFromStart 清空 keyspace 后从头重放测试用的 AOF
+func replayFromStart(t *testing.T) {
+	t.Helper()
+	resetKeyspace()
+	flushAOFAll()
+	if err := loadAppendOnlyFile(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectReply(t *testing.T, tc *testClient, want string, args ...string) {
+	t.Helper()
+	if got := tc.do(args...); got != want {
+		t.Fatalf("%q: got %q, want %q", args, got, want)
+	}
+}
+
+// startTestServer 在随机端口上启动一个真实的 netpoll 服务, 返回监听地址
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := netpoll.NewEventLoop(onRequest, netpoll.WithOnConnect(onConnect), netpoll.WithOnPrepare(onPrepare), netpoll.WithOnDisconnect(onClose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go el.Serve(ln)
+	t.Cleanup(func() { el.Shutdown(context.Background()) })
+	return ln.Addr().String()
+}
+
+// netClient 是走网络的测试客户端
+type netClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, addr string) *netClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &netClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do 发一条命令并读一个完整的回复, 连接被关闭时返回错误
+func (nc *netClient) do(args ...string) (string, error) {
+	if _, err := nc.conn.Write(encodeCommand(args)); err != nil {
+		return "", err
+	}
+	return nc.readReply()
+}
+
+// readReply 读一个完整的 RESP 回复, 返回原始字节
+func (nc *netClient) readReply() (string, error) {
+	nc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := nc.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	switch line[0] {
+	case '$', '=':
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		if n < 0 {
+			return line, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(nc.r, buf); err != nil {
+			return "", err
+		}
+		return line + string(buf), nil
+	case '*', '%', '~', '>':
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		if line[0] == '%' {
+			n *= 2
+		}
+		for range max(n, 0) {
+			elem, err := nc.readReply()
+			if err != nil {
+				return "", err
+			}
+			line += elem
+		}
+	}
+	return line, nil
+}
@@ -0,0 +1,412 @@
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog 和 Redis 的字节布局完全一致, 存成普通字符串, 可以和 Redis 互相 DUMP/RESTORE:
+// 16 字节头 ("HYLL" + 编码 + 3 字节保留 + 8 字节小端的基数缓存, 缓存最高位为 1 表示失效),
+// 之后是 16384 个 6 位寄存器, 稠密编码按位紧密排列, 稀疏编码是 ZERO / XZERO / VAL 游程。
+// 这里的 PFCOUNT 只读, 不回写缓存; PFADD / PFMERGE 修改后把缓存置为失效
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllPMask      = hllRegisters - 1
+	hllBits       = 6
+	hllRegMax     = 1<<hllBits - 1
+	hllHdrSize    = 16
+	hllDenseSize  = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense      = 0
+	hllSparse     = 1
+	hllAlphaInf   = 0.721347520444481703680 // 1 / (2 ln 2)
+	hllSparseVMax = 32                      // 稀疏编码 VAL 能表示的最大寄存器值
+
+	hllSparseXZeroMaxLen = 16384
+	hllSparseZeroMaxLen  = 64
+	hllSparseValMaxLen   = 4
+)
+
+// hllSparseMaxBytes 是稀疏编码的长度上限 (含头), 超过后转成稠密编码
+var hllSparseMaxBytes = 3000
+
+const (
+	errNotHLL     = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	errInvalidHLL = "INVALIDOBJ Corrupted HLL object detected"
+)
+
+// murmurHash64A 是 Redis 给 HyperLogLog 用的哈希, 按小端读取
+func murmurHash64A(key string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64([]byte(key[:8]))
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen 返回元素落在哪个寄存器, 以及哈希剩余部分末尾连续 0 的个数加一
+func hllPatLen(ele string) (int, uint8) {
+	hash := murmurHash64A(ele, 0xadc83b19)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ // 保证循环能结束, 计数最大为 Q+1
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// hllDenseGet / hllDenseSet 读写稠密编码里的第 i 个寄存器, 寄存器从低位开始排列
+func hllDenseGet(regs []byte, i int) uint8 {
+	byteIdx := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	b0 := uint(regs[byteIdx])
+	var b1 uint
+	if byteIdx+1 < len(regs) {
+		b1 = uint(regs[byteIdx+1])
+	}
+	return uint8((b0>>fb | b1<<(8-fb)) & hllRegMax)
+}
+
+func hllDenseSet(regs []byte, i int, v uint8) {
+	byteIdx := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	regs[byteIdx] &^= hllRegMax << fb
+	regs[byteIdx] |= v << fb
+	if byteIdx+1 < len(regs) {
+		regs[byteIdx+1] &^= hllRegMax >> (8 - fb)
+		regs[byteIdx+1] |= v >> (8 - fb)
+	}
+}
+
+// isHLL 检查字符串是否是合法的 HyperLogLog 头, 稀疏编码的内容在解码时再校验
+func isHLL(s string) bool {
+	if len(s) < hllHdrSize || s[:4] != "HYLL" {
+		return false
+	}
+	switch s[4] {
+	case hllDense:
+		return len(s) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// hllRegistersOf 把 HyperLogLog 展开成每个寄存器一个字节, 编码损坏时 ok 为 false。
+// 稠密编码的 6 位寄存器最大能存 63, 但合法的值不超过 hllQ+1
+func hllRegistersOf(s string) (regs []uint8, ok bool) {
+	regs = make([]uint8, hllRegisters)
+	body := []byte(s[hllHdrSize:])
+	if s[4] == hllDense {
+		for i := range regs {
+			if regs[i] = hllDenseGet(body, i); regs[i] > hllQ+1 {
+				return nil, false
+			}
+		}
+		return regs, true
+	}
+	idx := 0
+	for p := 0; p < len(body); p++ {
+		op := body[p]
+		var n int
+		var v uint8
+		switch {
+		case op&0xC0 == 0x00: // ZERO: 00xxxxxx
+			n = int(op&0x3f) + 1
+		case op&0xC0 == 0x40: // XZERO: 01xxxxxx yyyyyyyy
+			if p+1 >= len(body) {
+				return nil, false
+			}
+			n = (int(op&0x3f)<<8 | int(body[p+1])) + 1
+			p++
+		default: // VAL: 1vvvvvxx
+			v = (op>>2)&0x1f + 1
+			n = int(op&0x3) + 1
+		}
+		if idx+n > hllRegisters {
+			return nil, false
+		}
+		for range n {
+			regs[idx] = v
+			idx++
+		}
+	}
+	return regs, idx == hllRegisters
+}
+
+// hllEncode 按指定编码生成 HyperLogLog 字符串, 基数缓存置为失效。
+// 稀疏编码放不下 (寄存器值超过 32 或超过 hll-sparse-max-bytes) 时改用稠密编码
+func hllEncode(regs []uint8, dense bool) string {
+	if !dense {
+		if s, ok := hllEncodeSparse(regs); ok {
+			return s
+		}
+	}
+	buf := make([]byte, hllDenseSize)
+	hllHeader(buf, hllDense)
+	for i, v := range regs {
+		if v != 0 {
+			hllDenseSet(buf[hllHdrSize:], i, v)
+		}
+	}
+	return string(buf)
+}
+
+func hllHeader(buf []byte, encoding byte) {
+	copy(buf, "HYLL")
+	buf[4] = encoding
+	buf[15] = 1 << 7 // 缓存失效
+}
+
+func hllEncodeSparse(regs []uint8) (string, bool) {
+	buf := make([]byte, hllHdrSize, 64)
+	hllHeader(buf, hllSparse)
+	for i := 0; i < len(regs); {
+		v := regs[i]
+		if v > hllSparseVMax {
+			return "", false
+		}
+		run := 1
+		for i+run < len(regs) && regs[i+run] == v {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch {
+			case v != 0:
+				n := min(run, hllSparseValMaxLen)
+				buf = append(buf, 0x80|(v-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroMaxLen:
+				n := min(run, hllSparseXZeroMaxLen)
+				buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				buf = append(buf, byte(run-1))
+				run = 0
+			}
+		}
+		if len(buf) > hllSparseMaxBytes {
+			return "", false
+		}
+	}
+	return string(buf), true
+}
+
+// hllCount 按 Otmar Ertl 的改进估计 (arXiv:1702.01284) 计算基数, 同 Redis 的 hllCount
+func hllCount(regs []uint8) uint64 {
+	var histo [hllRegMax + 1]int // 同 Redis 的 reghisto[64], 按寄存器能存的最大值分配
+	for _, v := range regs {
+		histo[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCachedCount 返回头里仍然有效的基数缓存 (Redis 写入的 HyperLogLog 会带上)
+func hllCachedCount(s string) (uint64, bool) {
+	if s[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64([]byte(s[8:16])), true
+}
+
+// hllForRead 取 key 对应的 HyperLogLog, 不存在时返回 ""
+func hllForRead(db *DB, key string) (*object, string) {
+	o, err := stringForRead(db, key)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	if !isHLL(o.str()) {
+		return nil, errNotHLL
+	}
+	return o, ""
+}
+
+// PFADD key [element ...]
+func pfaddCommand(c *client, args []string) {
+	w := c.w
+	key := args[1]
+	o, err := hllForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	updated := o == nil
+	var s string
+	if o == nil {
+		s = hllEncode(make([]uint8, hllRegisters), false)
+	} else {
+		s = o.str()
+	}
+
+	if s[4] == hllDense {
+		// 稠密编码直接改寄存器
+		buf := []byte(s)
+		regs := buf[hllHdrSize:]
+		for _, ele := range args[2:] {
+			i, count := hllPatLen(ele)
+			if count > hllDenseGet(regs, i) {
+				hllDenseSet(regs, i, count)
+				updated = true
+			}
+		}
+		if updated {
+			buf[15] |= 1 << 7
+			s = string(buf)
+		}
+	} else {
+		regs, ok := hllRegistersOf(s)
+		if !ok {
+			w.writeError(errInvalidHLL)
+			return
+		}
+		changed := false
+		for _, ele := range args[2:] {
+			i, count := hllPatLen(ele)
+			if count > regs[i] {
+				regs[i] = count
+				changed = true
+			}
+		}
+		if changed {
+			s = hllEncode(regs, false)
+			updated = true
+		}
+	}
+	if updated {
+		setStringValue(c.db, key, o, s)
+		dirty++
+	}
+	w.writeInt(int64(boolInt(updated)))
+}
+
+// PFCOUNT key [key ...], 多个 key 时返回并集的基数
+func pfcountCommand(c *client, args []string) {
+	w := c.w
+	var merged []uint8
+	for _, key := range args[1:] {
+		o, err := hllForRead(c.db, key)
+		if err != "" {
+			w.writeError(err)
+			return
+		}
+		if o == nil {
+			continue
+		}
+		if len(args) == 2 {
+			if n, ok := hllCachedCount(o.str()); ok {
+				w.writeInt(int64(n))
+				return
+			}
+		}
+		regs, ok := hllRegistersOf(o.str())
+		if !ok {
+			w.writeError(errInvalidHLL)
+			return
+		}
+		if merged == nil {
+			merged = regs
+			continue
+		}
+		for i, v := range regs {
+			merged[i] = max(merged[i], v)
+		}
+	}
+	if merged == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(hllCount(merged)))
+}
+
+// PFMERGE destkey [sourcekey ...]
+// 目标存在时也参与合并; 任一输入是稠密编码时结果用稠密编码
+func pfmergeCommand(c *client, args []string) {
+	w := c.w
+	merged := make([]uint8, hllRegisters)
+	dense := false
+	var dest *object
+	for i, key := range args[1:] {
+		o, err := hllForRead(c.db, key)
+		if err != "" {
+			w.writeError(err)
+			return
+		}
+		if o == nil {
+			continue
+		}
+		if i == 0 {
+			dest = o
+		}
+		if o.str()[4] == hllDense {
+			dense = true
+		}
+		regs, ok := hllRegistersOf(o.str())
+		if !ok {
+			w.writeError(errInvalidHLL)
+			return
+		}
+		for j, v := range regs {
+			merged[j] = max(merged[j], v)
+		}
+	}
+	setStringValue(c.db, args[1], dest, hllEncode(merged, dense))
+	dirty++
+	w.writeOK()
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPFAddCount(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":1\r\n", "PFADD", "hll", "a", "b", "c", "d", "e", "f", "g")
+	expectReply(t, tc, ":0\r\n", "PFADD", "hll", "a", "c")
+	expectReply(t, tc, ":7\r\n", "PFCOUNT", "hll")
+	expectReply(t, tc, ":0\r\n", "PFCOUNT", "missing")
+	// 不带元素时只创建 key
+	expectReply(t, tc, ":1\r\n", "PFADD", "empty")
+	expectReply(t, tc, ":0\r\n", "PFADD", "empty")
+	expectReply(t, tc, ":0\r\n", "PFCOUNT", "empty")
+	// 空的稀疏编码: 头 + 一个覆盖全部寄存器的 XZERO
+	expectReply(t, tc, "$18\r\nHYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xff\r\n", "GET", "empty")
+
+	tc.do("SET", "str", "hello")
+	expectReply(t, tc, "-"+errNotHLL+"\r\n", "PFADD", "str", "x")
+	expectReply(t, tc, "-"+errNotHLL+"\r\n", "PFCOUNT", "hll", "str")
+	tc.do("RPUSH", "l", "a")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "PFCOUNT", "l")
+
+	// 寄存器总数不对的稀疏编码
+	tc.do("SET", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x00")
+	expectReply(t, tc, "-"+errInvalidHLL+"\r\n", "PFCOUNT", "bad")
+	expectReply(t, tc, "-"+errInvalidHLL+"\r\n", "PFADD", "bad", "x")
+}
+
+func TestPFCountErrorBound(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	// 标准误差是 1.04/sqrt(16384) ≈ 0.81%, 这里留足余量
+	n := 0
+	for _, target := range []int{100, 1000, 10000, 100000} {
+		for n < target {
+			args := []string{"PFADD", "hll"}
+			for range min(1000, target-n) {
+				args = append(args, "ele:"+strconv.Itoa(n))
+				n++
+			}
+			tc.do(args...)
+		}
+		got, err := strconv.Atoi(strings.Trim(tc.do("PFCOUNT", "hll"), ":\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := math.Abs(float64(got-n)) / float64(n); e > 0.03 {
+			t.Fatalf("PFCOUNT after %d adds = %d, error %.4f", n, got, e)
+		}
+	}
+	// 10 万个不同元素会有寄存器超过稀疏编码能表示的范围, 必然转成稠密编码
+	expectReply(t, tc, "$1\r\n\x00\r\n", "GETRANGE", "hll", "4", "4")
+	expectReply(t, tc, ":"+strconv.Itoa(hllDenseSize)+"\r\n", "STRLEN", "hll")
+}
+
+func TestPFSparsePromotion(t *testing.T) {
+	restoreConfig(t)
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "+OK\r\n", "CONFIG", "SET", "hll-sparse-max-bytes", "40")
+	args := []string{"PFADD", "hll"}
+	for i := range 5 {
+		args = append(args, "x"+strconv.Itoa(i))
+	}
+	tc.do(args...)
+	expectReply(t, tc, "$1\r\n\x01\r\n", "GETRANGE", "hll", "4", "4")
+	before := tc.do("PFCOUNT", "hll")
+
+	args = args[:2]
+	for i := 5; i < 50; i++ {
+		args = append(args, "x"+strconv.Itoa(i))
+	}
+	tc.do(args...)
+	expectReply(t, tc, "$1\r\n\x00\r\n", "GETRANGE", "hll", "4", "4")
+	expectReply(t, tc, ":50\r\n", "PFCOUNT", "hll")
+	if before != ":5\r\n" {
+		t.Fatalf("sparse PFCOUNT = %q", before)
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("PFADD", "h1", "a", "b", "c")
+	tc.do("PFADD", "h2", "b", "c", "d")
+	expectReply(t, tc, ":4\r\n", "PFCOUNT", "h1", "h2", "missing")
+	expectReply(t, tc, ":3\r\n", "PFCOUNT", "h1")
+
+	tc.do("PFADD", "dst", "e")
+	tc.do("EXPIRE", "dst", "100")
+	expectReply(t, tc, "+OK\r\n", "PFMERGE", "dst", "h1", "h2")
+	expectReply(t, tc, ":5\r\n", "PFCOUNT", "dst")
+	expectReply(t, tc, ":100\r\n", "TTL", "dst")
+	expectReply(t, tc, "+OK\r\n", "PFMERGE", "new")
+	expectReply(t, tc, ":0\r\n", "PFCOUNT", "new")
+
+	// 任一输入是稠密编码时结果也是稠密编码
+	b := make([]byte, hllDenseSize)
+	hllHeader(b, hllDense)
+	tc.do("SET", "dense", string(b))
+	expectReply(t, tc, "+OK\r\n", "PFMERGE", "m", "h1", "dense")
+	expectReply(t, tc, ":"+strconv.Itoa(hllDenseSize)+"\r\n", "STRLEN", "m")
+	expectReply(t, tc, ":3\r\n", "PFCOUNT", "m")
+
+	// 重放 AOF 得到同样的结果
+	want := tc.do("GET", "dst")
+	replayFromStart(t)
+	if got := tc.do("GET", "dst"); got != want {
+		t.Fatalf("after replay: %q, want %q", got, want)
+	}
+}
+
+func TestHLLEncoding(t *testing.T) {
+	regs := make([]uint8, hllRegisters)
+	regs[0], regs[1], regs[5], regs[hllRegisters-1] = 3, 3, 32, 1
+	s := hllEncode(regs, false)
+	// VAL(3,2) ZERO(3) VAL(32,1) XZERO(16377) VAL(1,1)
+	if got, want := s[hllHdrSize:], "\x89\x02\xfc\x7f\xf8\x80"; got != want {
+		t.Fatalf("sparse body %q, want %q", got, want)
+	}
+	for _, dense := range []bool{false, true} {
+		got, ok := hllRegistersOf(hllEncode(regs, dense))
+		if !ok || string(got) != string(regs) {
+			t.Fatalf("dense=%v: registers don't round-trip", dense)
+		}
+	}
+	regs[7] = 33
+	if s := hllEncode(regs, false); s[4] != hllDense {
+		t.Fatal("register > 32 should force dense encoding")
+	}
+}
+
+func TestHLLCorruptedDense(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	// 稠密编码, 缓存失效, 所有寄存器都是 63 (超过 hllQ+1)
+	buf := make([]byte, hllDenseSize)
+	hllHeader(buf, hllDense)
+	for i := hllHdrSize; i < len(buf); i++ {
+		buf[i] = 0xff
+	}
+	tc.do("SET", "h", string(buf))
+	expectReply(t, tc, "-"+errInvalidHLL+"\r\n", "PFCOUNT", "h")
+	expectReply(t, tc, "-"+errInvalidHLL+"\r\n", "PFMERGE", "dst", "h")
+
+	// 直接计数也不越界
+	regs := make([]uint8, hllRegisters)
+	for i := range regs {
+		regs[i] = hllRegMax
+	}
+	hllCount(regs)
+}
@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	serverStartTime = time.Now()
+	serverRunID     = newReplID() // 每次启动随机生成, 同 Redis 的 run_id
+
+	// 以下由 dbMu 保护
+	totalConnections int64
+	totalCommands    int64
+)
+
+// infoSection 是 INFO 输出的一节, gen 在持有 dbMu 时调用
+type infoSection struct {
+	name string
+	gen  func(b *strings.Builder)
+}
+
+var infoSections = []infoSection{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"memory", infoMemory},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"replication", infoReplication},
+	{"cluster", infoCluster},
+	{"keyspace", infoKeyspace},
+}
+
+// infoField 写一行 "name:value"
+func infoField(b *strings.Builder, name string, v any) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, v)
+}
+
+// INFO [section ...]
+func infoCommand(c *client, args []string) {
+	all := len(args) == 1
+	want := make(map[string]bool)
+	for _, a := range args[1:] {
+		switch s := strings.ToLower(a); s {
+		case "all", "default", "everything":
+			all = true
+		default:
+			want[s] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && !want[sec.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(sec.name[:1]) + sec.name[1:] + "\r\n")
+		sec.gen(&b)
+	}
+	c.w.writeVerbatim("txt", b.String())
+}
+
+func infoPersistence(b *strings.Builder) {
+	infoField(b, "loading", boolInt(loading))
+	infoField(b, "rdb_changes_since_last_save", dirty)
+	infoField(b, "rdb_bgsave_in_progress", boolInt(rdbSaving))
+	infoField(b, "rdb_last_save_time", lastSave.Unix())
+	infoField(b, "rdb_last_bgsave_status", okOrErr(lastBgsaveOK))
+	infoField(b, "aof_enabled", 1)
+	infoField(b, "aof_rewrite_in_progress", boolInt(aofRewriting))
+	infoField(b, "aof_rewrite_scheduled", boolInt(aofRewriteScheduled))
+	infoField(b, "aof_use_rdb_preamble", boolInt(aofUseRDBPreamble))
+	infoField(b, "aof_current_size", aofCurrentSize)
+	infoField(b, "aof_base_size", aofBaseSize)
+	infoField(b, "aof_fsync_policy", fsyncPolicyNames[appendfsync])
+	infoField(b, "aof_buffer_length", len(aofBuf))
+	infoField(b, "aof_rewrite_buffer_length", len(aofRewriteBuf))
+	infoField(b, "aof_fsync_count", aofFsyncCount.Load())
+	infoField(b, "aof_last_fsync_time", aofLastFsync.Load()/1000)
+}
+
+// infoKeyspace 只列出非空的库, 同 Redis
+func infoKeyspace(b *strings.Builder) {
+	for _, db := range dbs {
+		if n := db.size(); n > 0 {
+			infoField(b, fmt.Sprintf("db%d", db.id), fmt.Sprintf("keys=%d,expires=%d", n, db.expires.Count()))
+		}
+	}
+}
+
+func infoServer(b *strings.Builder) {
+	uptime := time.Since(serverStartTime)
+	infoField(b, "redis_version", serverVersion)
+	infoField(b, "redis_mode", "standalone")
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "arch_bits", strconv.IntSize)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "process_id", os.Getpid())
+	infoField(b, "run_id", serverRunID)
+	infoField(b, "tcp_port", port)
+	infoField(b, "hz", hz)
+	infoField(b, "config_file", configFile)
+	infoField(b, "server_time_usec", time.Now().UnixMicro())
+	infoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	infoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+}
+
+func infoClients(b *strings.Builder) {
+	blocked, pubsub := 0, 0
+	pubsubMu.Lock()
+	for _, c := range clients {
+		if c.blocked != nil {
+			blocked++
+		}
+		if c.subscriptions() > 0 {
+			pubsub++
+		}
+	}
+	pubsubMu.Unlock()
+	infoField(b, "connected_clients", len(clients))
+	infoField(b, "blocked_clients", blocked)
+	infoField(b, "pubsub_clients", pubsub)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func okOrErr(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+func infoStats(b *strings.Builder) {
+	infoField(b, "total_connections_received", totalConnections)
+	infoField(b, "total_commands_processed", totalCommands)
+	infoField(b, "expired_keys", expiredKeys)
+	infoField(b, "expired_stale_perc", fmt.Sprintf("%.2f", expiredStalePerc*100))
+	infoField(b, "expired_time_cap_reached_count", expiredTimeCapReached)
+	infoField(b, "expire_cycle_cpu_milliseconds", expireCycleCPUMillis)
+	infoField(b, "evicted_keys", evictedKeys)
+}
@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// intset 的元素宽度, 和 Redis 一样按需从 int16 升级到 int32 / int64
+const (
+	intsetEncInt16 = 2
+	intsetEncInt32 = 4
+	intsetEncInt64 = 8
+)
+
+// intset 是有序、去重的整数数组, 所有元素按同一宽度小端存放在 contents 里。
+// 查找用二分 O(log n), 插入删除要搬移 O(n), 只用于小集合
+type intset struct {
+	encoding int
+	contents []byte
+}
+
+func newIntset() *intset {
+	return &intset{encoding: intsetEncInt16}
+}
+
+func intsetValueEncoding(v int64) int {
+	switch {
+	case v < math.MinInt32 || v > math.MaxInt32:
+		return intsetEncInt64
+	case v < math.MinInt16 || v > math.MaxInt16:
+		return intsetEncInt32
+	}
+	return intsetEncInt16
+}
+
+func (is *intset) len() int { return len(is.contents) / is.encoding }
+
+func (is *intset) get(i int) int64 {
+	return getEncoded(is.contents, i, is.encoding)
+}
+
+func getEncoded(b []byte, i, enc int) int64 {
+	switch enc {
+	case intsetEncInt64:
+		return int64(binary.LittleEndian.Uint64(b[i*8:]))
+	case intsetEncInt32:
+		return int64(int32(binary.LittleEndian.Uint32(b[i*4:])))
+	}
+	return int64(int16(binary.LittleEndian.Uint16(b[i*2:])))
+}
+
+func (is *intset) set(i int, v int64) {
+	switch is.encoding {
+	case intsetEncInt64:
+		binary.LittleEndian.PutUint64(is.contents[i*8:], uint64(v))
+	case intsetEncInt32:
+		binary.LittleEndian.PutUint32(is.contents[i*4:], uint32(v))
+	default:
+		binary.LittleEndian.PutUint16(is.contents[i*2:], uint16(v))
+	}
+}
+
+// search 二分查找 v, 返回是否存在以及应该插入的位置
+func (is *intset) search(v int64) (int, bool) {
+	n := is.len()
+	i := sort.Search(n, func(i int) bool { return is.get(i) >= v })
+	return i, i < n && is.get(i) == v
+}
+
+func (is *intset) find(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		return false
+	}
+	_, ok := is.search(v)
+	return ok
+}
+
+// upgradeAndAdd 把整个数组升级到更宽的编码再插入 v。
+// 触发升级的值一定比现有元素都大或都小, 所以只会放在头或尾
+func (is *intset) upgradeAndAdd(v int64) {
+	old, oldEnc, n := is.contents, is.encoding, is.len()
+	is.encoding = intsetValueEncoding(v)
+	is.contents = make([]byte, (n+1)*is.encoding)
+	prepend := 0
+	if v < 0 {
+		prepend = 1
+	}
+	for i := n - 1; i >= 0; i-- {
+		is.set(i+prepend, getEncoded(old, i, oldEnc))
+	}
+	if prepend == 1 {
+		is.set(0, v)
+	} else {
+		is.set(n, v)
+	}
+}
+
+// add 插入 v, 返回是否新增
+func (is *intset) add(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		is.upgradeAndAdd(v)
+		return true
+	}
+	i, ok := is.search(v)
+	if ok {
+		return false
+	}
+	is.contents = append(is.contents, make([]byte, is.encoding)...)
+	copy(is.contents[(i+1)*is.encoding:], is.contents[i*is.encoding:])
+	is.set(i, v)
+	return true
+}
+
+// remove 删除 v, 返回是否存在 (不会降级编码, 同 Redis)
+func (is *intset) remove(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		return false
+	}
+	i, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	copy(is.contents[i*is.encoding:], is.contents[(i+1)*is.encoding:])
+	is.contents = is.contents[:len(is.contents)-is.encoding]
+	return true
+}
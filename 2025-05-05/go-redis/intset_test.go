@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestIntsetUpgrade(t *testing.T) {
+	is := newIntset()
+	for _, v := range []int64{5, 1, 3} {
+		is.add(v)
+	}
+	if is.encoding != intsetEncInt16 || len(is.contents) != 3*2 {
+		t.Fatalf("expected 3 int16 entries, got enc=%d bytes=%d", is.encoding, len(is.contents))
+	}
+	is.add(math.MaxInt32 + 1)
+	is.add(-70000)
+	if is.encoding != intsetEncInt64 {
+		t.Fatalf("expected int64 encoding, got %d", is.encoding)
+	}
+	var got []int64
+	for i := range is.len() {
+		got = append(got, is.get(i))
+	}
+	want := []int64{-70000, 1, 3, 5, math.MaxInt32 + 1}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if is.add(3) || !is.remove(3) || is.find(3) || is.remove(math.MaxInt64) {
+		t.Fatal("add/remove/find mismatch")
+	}
+}
+
+func TestIntsetRandom(t *testing.T) {
+	is := newIntset()
+	ref := make(map[int64]bool)
+	for range 2000 {
+		v := rand.Int64N(1<<20) - 1<<19
+		if rand.IntN(3) == 0 {
+			if is.remove(v) != ref[v] {
+				t.Fatalf("remove(%d) mismatch", v)
+			}
+			delete(ref, v)
+		} else {
+			if is.add(v) == ref[v] {
+				t.Fatalf("add(%d) mismatch", v)
+			}
+			ref[v] = true
+		}
+	}
+	if is.len() != len(ref) {
+		t.Fatalf("len %d, want %d", is.len(), len(ref))
+	}
+	for i := 1; i < is.len(); i++ {
+		if is.get(i-1) >= is.get(i) {
+			t.Fatal("intset not sorted")
+		}
+	}
+}
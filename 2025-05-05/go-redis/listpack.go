@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// listpack 是 Redis 紧凑存放小容器的格式, 这里只用来读写 RDB 里的 stream 节点。
+// 布局: 4 字节总长 + 2 字节元素个数 + 元素... + 0xFF,
+// 每个元素是 编码头 + 数据 + 反向长度 (backlen, 供从尾部往前遍历)
+const (
+	lpHeaderSize = 6
+	lpEOF        = 0xFF
+
+	lp16BitInt = 0xF1
+	lp24BitInt = 0xF2
+	lp32BitInt = 0xF3
+	lp64BitInt = 0xF4
+	lp32BitStr = 0xF0
+)
+
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{buf: make([]byte, lpHeaderSize, 256)}
+}
+
+// appendInt 按能容纳 v 的最小整数编码追加
+func (lp *listpackWriter) appendInt(v int64) {
+	start := len(lp.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		lp.buf = append(lp.buf, byte(u>>8)|0xC0, byte(u))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		lp.buf = append(lp.buf, lp16BitInt, byte(v), byte(v>>8))
+	case v >= -1<<23 && v < 1<<23:
+		lp.buf = append(lp.buf, lp24BitInt, byte(v), byte(v>>8), byte(v>>16))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		lp.buf = binary.LittleEndian.AppendUint32(append(lp.buf, lp32BitInt), uint32(v))
+	default:
+		lp.buf = binary.LittleEndian.AppendUint64(append(lp.buf, lp64BitInt), uint64(v))
+	}
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendString 追加字符串, 规范的整数同 Redis 一样按整数编码存放
+func (lp *listpackWriter) appendString(s string) {
+	if v, ok := canonicalInt(s); ok {
+		lp.appendInt(v)
+		return
+	}
+	start := len(lp.buf)
+	switch n := len(s); {
+	case n < 64:
+		lp.buf = append(lp.buf, 0x80|byte(n))
+	case n < 4096:
+		lp.buf = append(lp.buf, 0xE0|byte(n>>8), byte(n))
+	default:
+		lp.buf = binary.LittleEndian.AppendUint32(append(lp.buf, lp32BitStr), uint32(n))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendBacklen 写元素的反向长度: 每字节 7 位, 除最高位所在的第一个字节外都置上最高位
+func (lp *listpackWriter) appendBacklen(l int) {
+	n := lpBacklenSize(l)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(l>>(7*i)) & 127
+		if i < n-1 {
+			b |= 128
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.count++
+}
+
+func lpBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// bytes 补上头部和结尾, 返回完整的 listpack
+func (lp *listpackWriter) bytes() []byte {
+	buf := append(lp.buf, lpEOF)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(min(lp.count, math.MaxUint16)))
+	return buf
+}
+
+// listpackEntries 解析 listpack, 整数元素转成十进制字符串
+func listpackEntries(b []byte) ([]string, bool) {
+	if len(b) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != lpEOF {
+		return nil, false
+	}
+	var out []string
+	p := lpHeaderSize
+	for p < len(b)-1 {
+		v, n, ok := lpDecode(b[p : len(b)-1])
+		if !ok {
+			return nil, false
+		}
+		out = append(out, v)
+		p += n + lpBacklenSize(n)
+	}
+	return out, p == len(b)-1
+}
+
+// lpDecode 解析一个元素, 返回值和编码头加数据的长度 (不含 backlen)
+func lpDecode(b []byte) (string, int, bool) {
+	str := func(hdr, n int) (string, int, bool) {
+		if n < 0 || len(b) < hdr+n {
+			return "", 0, false
+		}
+		return string(b[hdr : hdr+n]), hdr + n, true
+	}
+	fixed := func(n int) bool { return len(b) >= 1+n }
+	c := b[0]
+	switch {
+	case c&0x80 == 0:
+		return strconv.Itoa(int(c)), 1, true
+	case c&0xC0 == 0x80:
+		return str(1, int(c&0x3f))
+	case c&0xE0 == 0xC0:
+		if !fixed(1) {
+			break
+		}
+		v := int64(c&0x1f)<<8 | int64(b[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return strconv.FormatInt(v, 10), 2, true
+	case c&0xF0 == 0xE0:
+		if !fixed(1) {
+			break
+		}
+		return str(2, int(c&0x0f)<<8|int(b[1]))
+	case c == lp16BitInt && fixed(2):
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b[1:])))), 3, true
+	case c == lp24BitInt && fixed(3):
+		u := uint32(b[1]) | uint32(b[2])<<8 | uint32(b[3])<<16
+		return strconv.Itoa(int(int32(u<<8) >> 8)), 4, true
+	case c == lp32BitInt && fixed(4):
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b[1:])))), 5, true
+	case c == lp64BitInt && fixed(8):
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b[1:])), 10), 9, true
+	case c == lp32BitStr && fixed(4):
+		n := binary.LittleEndian.Uint32(b[1:])
+		if n > maxBulkLen {
+			break
+		}
+		return str(5, int(n))
+	}
+	return "", 0, false
+}
@@ -1,60 +1,100 @@
 package redis
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudwego/netpoll"
-	cmap "github.com/orcaman/concurrent-map/v2"
 )
 
 var (
-	store     cmap.ConcurrentMap[string, string]
-	expireMap cmap.ConcurrentMap[string, time.Time]
-	aofFile   *os.File
-)
-
-func init() {
-	store = cmap.New[string]()
-	expireMap = cmap.New[time.Time]()
+	// dbMu 串行化所有命令的执行, 和 Redis 的单线程模型一致:
+	// 容器类型 (hash 等) 的值原地修改, 不需要再各自加锁
+	dbMu sync.Mutex
 
-	var err error
-	aofFile, err = os.OpenFile("appendonly.aof", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	if err != nil {
-		log.Fatalf("open AOF error: %v", err)
-	}
-	replayAOF()
-	go expireCleaner()
+	port = 6379
+)
 
+// server 是启动好的服务: 配置已应用、数据已加载, serve 开始接受连接
+type server struct {
+	listener  netpoll.Listener
+	eventLoop netpoll.EventLoop
 }
 
-func main() {
-	listener, err := netpoll.CreateListener("tcp", ":6379")
+// newServer 加载配置文件 (可以为空) 和命令行覆盖, 初始化各个模块并开始监听
+func newServer(file string, overrides []string) (*server, error) {
+	if err := loadServerConfig(file, overrides); err != nil {
+		return nil, err
+	}
+	if err := initServer(); err != nil {
+		return nil, err
+	}
+	listener, err := netpoll.CreateListener("tcp", listenAddr())
 	if err != nil {
-		log.Fatalf("listen error: %v", err)
+		return nil, fmt.Errorf("listen error: %v", err)
 	}
-
 	eventLoop, err := netpoll.NewEventLoop(
 		onRequest,
 		netpoll.WithOnConnect(onConnect),
 		netpoll.WithOnDisconnect(onClose),
 		netpoll.WithOnPrepare(onPrepare),
-		netpoll.WithReadTimeout(10*time.Second),
+		netpoll.WithReadTimeout(readTimeout),
 	)
 	if err != nil {
-		log.Fatalf("create event loop error: %v", err)
+		listener.Close()
+		return nil, fmt.Errorf("create event loop error: %v", err)
 	}
-	log.Println("GO-Redis server listening on:6379")
-	if err := eventLoop.Serve(listener); err != nil {
-		log.Fatalf("serve error: %v", err)
+	return &server{listener: listener, eventLoop: eventLoop}, nil
+}
+
+// initServer 按当前配置创建数据库、加载 ACL 和 AOF, 启动后台任务
+func initServer() error {
+	initDatabases()
+	if err := initACL(); err != nil {
+		return err
 	}
+	if err := loadAOF(); err != nil {
+		return fmt.Errorf("open AOF error: %v", err)
+	}
+	go activeExpireCron()
+	go saveCron()
+	go replicationCron()
+	if clusterEnabled {
+		if err := loadClusterConfig(); err != nil {
+			return fmt.Errorf("load cluster config error: %v", err)
+		}
+	}
+	if masterHost != "" {
+		dbMu.Lock()
+		replicationSetMaster(masterHost, masterPort)
+		dbMu.Unlock()
+	}
+	return nil
+}
 
+func (s *server) serve() error {
+	log.Printf("GO-Redis server listening on %s", s.listener.Addr())
+	return s.eventLoop.Serve(s.listener)
+}
+
+// main 的参数同 redis-server: [/path/to/redis.conf] [--name value ...]
+func main() {
+	file, overrides := "", os.Args[1:]
+	if len(overrides) > 0 && !strings.HasPrefix(overrides[0], "--") {
+		file, overrides = overrides[0], overrides[1:]
+	}
+	s, err := newServer(file, overrides)
+	if err != nil {
+		log.Fatalf("start server error: %v", err)
+	}
+	if err := s.serve(); err != nil {
+		log.Fatalf("serve error: %v", err)
+	}
 }
 
 func onPrepare(conn netpoll.Connection) context.Context {
@@ -63,186 +103,218 @@ func onPrepare(conn netpoll.Connection) context.Context {
 
 func onConnect(ctx context.Context, conn netpoll.Connection) context.Context {
 	fmt.Printf("[CONNECT] %s\n", conn.RemoteAddr())
-	conn.AddCloseCallback(func(c netpoll.Connection) error {
-		fmt.Printf("[CLOSE] %s\n", c.RemoteAddr())
+	c := newClient(conn)
+	dbMu.Lock()
+	clients[c.id] = c
+	totalConnections++
+	initClientAuth(c)
+	dbMu.Unlock()
+	conn.AddCloseCallback(func(conn netpoll.Connection) error {
+		fmt.Printf("[CLOSE] %s\n", conn.RemoteAddr())
+		pubsubUnsubscribeAll(c)
+		dbMu.Lock()
+		delete(clients, c.id)
+		removeMonitor(c)
+		unwatchAllKeys(c)
+		removeReplica(c)
+		dbMu.Unlock()
 		return nil
 	})
-	return ctx
+	return withClient(ctx, c)
 }
 
 func onRequest(ctx context.Context, conn netpoll.Connection) error {
-	reader, writer := conn.Reader(), conn.Writer()
-	defer reader.Release()
+	c := clientFrom(ctx)
+	reader := conn.Reader()
 
-	msg, err := reader.ReadString(reader.Len())
+	// 把本次可读的字节全部搬进解析缓冲; onRequest 返回前必须读空 reader,
+	// 否则 netpoll 会一直重复回调
+	buf, err := reader.Next(reader.Len())
 	if err != nil {
 		fmt.Printf("read error: %v\n", err)
 		return err
 	}
-	fmt.Printf("[RECV] %s", msg)
+	c.dec.feed(buf)
+	reader.Release()
 
-	args, err := parseRESP(msg)
-	if err != nil {
-		writer.WriteString("-ERR invalid protocol\r\n")
-		writer.Flush()
-		return nil
-	}
-	if len(args) == 0 {
-		writer.WriteString("-ERR empty command\r\n")
-		writer.Flush()
-		return nil
-	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 
-	switch cmd := strings.ToUpper(args[0]); cmd {
-	case "PING":
-		if len(args) == 2 {
-			bulk := args[1]
-			writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(bulk), bulk))
-		} else {
-			writer.WriteString("+PONG\r\n")
+	// 流水线: 按顺序执行缓冲区里每一条完整的命令, 半包留到下次
+	for {
+		args, err := c.dec.next()
+		if err == errIncomplete {
+			break
 		}
-	case "ECHO":
-		if len(args) != 2 {
-			writer.WriteString("-ERR wrong number of args for 'ECHO'\r\n")
-		} else {
-			msg := args[1]
-			writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(msg), msg))
-		}
-	case "SET":
-		if len(args) != 3 {
-			writer.WriteString("-ERR wrong number of args for 'SET'\r\n")
-		} else {
-			key, val := args[1], args[2]
-			store.Set(key, val)
-			recordAOF(args)
-			writer.WriteString("+OK\r\n")
-		}
-	case "GET":
-		if len(args) != 2 {
-			writer.WriteString("-ERR wrong number of args for 'GET'\r\n")
-		} else {
-			key := args[1]
-			if t, ok := expireMap.Get(key); ok && time.Now().After(t) {
-				store.Remove(key)
-				expireMap.Remove(key)
-				writer.WriteString("$-1\r\n")
-			} else if val, ok := store.Get(key); !ok {
-				writer.WriteString("$-1\r\n")
-			} else {
-				writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
-			}
+		if err != nil {
+			c.w.writeError("ERR " + err.Error())
+			c.w.flush()
+			return conn.Close()
 		}
-	case "EXPIRE":
-		if len(args) != 3 {
-			writer.WriteString("-ERR wrong number of args for 'EXPIRE'\r\n")
-		} else {
-			key := args[1]
-			seconds, err := strconv.Atoi(args[2])
-			if err != nil {
-				writer.WriteString("-ERR invalid expire time\r\n")
-			} else {
-				expireMap.Set(key, time.Now().Add(time.Duration(seconds)*time.Second))
-				recordAOF(args)
-				writer.WriteString("+OK\r\n")
-			}
+		if len(args) == 0 {
+			continue
 		}
-	case "TTL":
-		if len(args) != 2 {
-			writer.WriteString("-ERR wrong number of args for 'TTL'\r\n")
-		} else {
-			key := args[1]
-			if t, ok := expireMap.Get(key); !ok {
-				writer.WriteString(":-1\r\n")
-			} else {
-				rem := int(time.Until(t).Seconds())
-				if rem < 0 {
-					rem = -2 // already
-				}
-				writer.WriteString(fmt.Sprintf(":%d\r\n", rem))
-			}
+		processCommand(c, args)
+		if c.closeAfterReply {
+			break
 		}
-
-	default:
-		writer.WriteString(fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0]))
 	}
 
-	writer.Flush()
+	c.w.flush()
+	if c.closeAfterReply {
+		return conn.Close()
+	}
 	return nil
+}
 
+// processCommand 执行一条命令, 调用方持有 c.wmu。
+// 如果命令把连接阻塞了 (BLPOP、XREAD BLOCK 等), 在释放 dbMu 和 wmu 之后原地等待,
+// 流水线里后面的命令也随之等待
+func processCommand(c *client, args []string) {
+	execCommand(c, args)
+	for c.blocked != nil {
+		bs := c.blocked
+		c.w.flush()
+		c.wmu.Unlock()
+		kv, ok := waitBlocked(c)
+		c.wmu.Lock()
+		if ok && bs.retry != nil {
+			retryBlockedStream(c, bs)
+		} else if ok {
+			// 唤醒时弹出的元素已经由别的连接记进了 AOF, 同样要等它写出
+			dbMu.Lock()
+			c.aofOffset = aofBufEnd
+			dbMu.Unlock()
+			c.w.writeBulks(kv[0], kv[1])
+		} else {
+			c.w.writeNullArray()
+		}
+	}
+	// 回复之前先把这条命令产生的 AOF 写出去 (always 策略下还要 fsync)
+	if c.aofOffset > 0 {
+		flushAOF(c.aofOffset)
+		c.aofOffset = 0
+	}
 }
 
-func onClose(ctx context.Context, conn netpoll.Connection) {
-	return
+// execCommand 执行一条命令, 回复写入 c.w (由调用方 Flush)
+func execCommand(c *client, args []string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	execCommandLocked(c, args)
 }
 
-// 记录到 AOF
-func recordAOF(args []string) {
-	line := "" + fmt.Sprintf("*%d\r\n", len(args))
-	for _, a := range args {
-		line += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+// execCommandLocked 同 execCommand, 调用方持有 dbMu
+func execCommandLocked(c *client, args []string) {
+	if c.master {
+		applyingMaster = true
+		defer func() { applyingMaster = false }()
 	}
-	aofFile.WriteString(line)
-	aofFile.Sync()
-}
 
-// 重放 AOF
-func replayAOF() {
-	scanner := bufio.NewScanner(aofFile)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// 直接 parseRESP 并执行 SET/EXPIRE 不输出
-		args, err := parseRESP(line + "\r\n")
-		if err != nil || len(args) < 1 {
-			continue
+	w := c.w
+	name := strings.ToUpper(args[0])
+	c.lastInteraction = time.Now()
+	c.lastCmd = strings.ToLower(name)
+	if len(args) > 1 && hasSubcommands(name) {
+		c.lastCmd += "|" + strings.ToLower(args[1])
+	}
+	totalCommands++
+	// ASKING 只对紧接着的一条命令有效
+	if c.asking && name != "ASKING" {
+		defer func() { c.asking = false }()
+	}
+	cmd := commands[name]
+	if authRequired(c) && (cmd == nil || cmd.flags&cmdNoAuth == 0) {
+		w.writeError(errNoAuth)
+		return
+	}
+	// 未知命令和参数个数错误在事务里出现时让 EXEC 整体放弃
+	errStr := ""
+	if cmd == nil {
+		errStr = errUnknownCommand(args)
+	} else {
+		errStr = cmd.checkArity(args)
+	}
+	if errStr != "" {
+		if c.multi {
+			c.multiDirty = true
+		}
+		w.writeError(errStr)
+		return
+	}
+	// RESP2 的连接进入订阅模式后只能执行订阅相关命令, RESP3 可以混用
+	if w.proto == resp2 && c.subscriptions() > 0 && cmd.flags&cmdPubsubOK == 0 {
+		w.writeError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(args[0])))
+		return
+	}
+	if cmd.flags&cmdWrite != 0 && isReadonlyReplica(c) {
+		w.writeError("READONLY You can't write against a read only replica.")
+		return
+	}
+	if errStr := aclCheckCommand(c, cmd, args); errStr != "" {
+		// 事务里被拒绝的命令同样让 EXEC 整体放弃
+		if c.multi && cmd.flags&cmdTxControl == 0 {
+			c.multiDirty = true
 		}
-		switch strings.ToUpper(args[0]) {
-		case "SET":
-			store.Set(args[1], args[2])
-		case "EXPIRE":
-			sec, _ := strconv.Atoi(args[2])
-			expireMap.Set(args[1], time.Now().Add(time.Duration(sec)*time.Second))
+		w.writeError(errStr)
+		return
+	}
+	if errStr := clusterRedirect(c, cmd, args); errStr != "" {
+		if c.multi && cmd.flags&cmdTxControl == 0 {
+			c.multiDirty = true
 		}
+		w.writeError(errStr)
+		return
+	}
+	// MULTI 之后除了事务控制命令, 其余命令只入队不执行
+	if c.multi && cmd.flags&cmdTxControl == 0 {
+		queueMultiCommand(c, cmd, args)
+		return
 	}
-}
 
-// 过期清理
-func expireCleaner() {
-	ticker := time.NewTicker(time.Second * 5)
-	defer ticker.Stop()
-	for range ticker.C {
-		now := time.Now()
-		expireMap.IterCb(func(key string, t time.Time) {
-			if now.After(t) {
-				store.Remove(key)
-				expireMap.Remove(key)
-			}
-		})
+	if rejectOnOOM(c, cmd) {
+		w.writeError(errOOM)
+		return
 	}
-}
 
-func parseRESP(msg string) ([]string, error) {
-	lines := strings.Split(msg, "\r\n")
-	if len(lines) < 3 || !strings.HasPrefix(lines[0], "*") {
-		return nil, fmt.Errorf("short or invalid message")
+	before := aofBufEnd
+	call(c, cmd, args)
+	updateMemoryAccounting()
+
+	if len(readyKeys) > 0 {
+		serveBlockedClients()
+	}
+	if aofBufEnd > before {
+		c.aofOffset = aofBufEnd
+	}
+	if aofRewriteScheduled {
+		startAOFRewrite()
 	}
+	if rdbBgsaveScheduled {
+		startBGSave()
+	}
+}
 
-	cnt, err := strconv.Atoi(lines[0][1:])
-	if err != nil || cnt < 1 {
-		return nil, fmt.Errorf("invalid count")
+// call 执行一条命令, 调用方持有 dbMu。
+// 写命令改动了数据 (dirty 增加) 又没有自己用 recordAOF 记录改写后的命令时, 原样写进 AOF 和复制流。
+// EXEC 里的命令算在 EXEC 的执行时间里, 不单独记慢查询
+func call(c *client, cmd *redisCommand, args []string) {
+	start := time.Now()
+	dirtyBefore, propagatedBefore := dirty, cmdPropagated
+	cmdPropagated = false
+	cmd.proc(c, args)
+	if cmd.flags&cmdWrite != 0 && dirty > dirtyBefore && !cmdPropagated {
+		feedAOF(c.db, args)
 	}
-	args := make([]string, 0, cnt)
-	idx := 1
-	for range cnt {
-		if idx >= len(lines) || !strings.HasPrefix(lines[idx], "$") {
-			return nil, fmt.Errorf("expected bulk string header")
-		}
-		// lengthLine := lines[idx]
-		idx++
-		if idx >= len(lines) {
-			return nil, fmt.Errorf("missing bulk data")
-		}
-		args = append(args, lines[idx])
-		idx++
+	cmdPropagated = propagatedBefore
+	if !loading && !c.inExec {
+		slowlogPushEntryIfNeeded(c, cmd.name, args, start, time.Since(start))
+	}
+	if len(monitors) > 0 && !loading {
+		feedMonitors(c, cmd, args, start)
 	}
-	return args, nil
+}
+
+func onClose(ctx context.Context, conn netpoll.Connection) {
+	return
 }
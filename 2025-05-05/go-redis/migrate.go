@@ -0,0 +1,275 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUMP 的序列化格式同 Redis: 类型字节 + RDB 编码的值 + 2 字节 RDB 版本 + 8 字节 CRC64, 都是小端
+
+// dumpPayload 序列化一个值
+func dumpPayload(o *object) []byte {
+	var buf bytes.Buffer
+	e := &rdbEncoder{w: bufio.NewWriter(&buf)}
+	e.saveType(o)
+	e.saveValue(o)
+	var tail [2]byte
+	binary.LittleEndian.PutUint16(tail[:], rdbVersion)
+	e.write(tail[:])
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc)
+	e.w.Write(sum[:])
+	e.w.Flush()
+	return buf.Bytes()
+}
+
+// loadPayload 校验并反序列化 DUMP 的结果
+func loadPayload(p string) (*object, bool) {
+	n := len(p)
+	if n < 10 {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint16([]byte(p[n-10:])) > rdbVersion ||
+		binary.LittleEndian.Uint64([]byte(p[n-8:])) != crc64Update(0, []byte(p[:n-8])) {
+		return nil, false
+	}
+	d := &rdbDecoder{r: bufio.NewReader(strings.NewReader(p[:n-10]))}
+	o := d.loadObject(d.readByte())
+	if d.err != nil || d.n != int64(n-10) {
+		return nil, false
+	}
+	return o, true
+}
+
+// DUMP key
+func dumpCommand(c *client, args []string) {
+	w := c.w
+	o, ok := c.db.lookupKey(args[1])
+	if !ok {
+		w.writeNull()
+		return
+	}
+	w.writeBulk(string(dumpPayload(o)))
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// RESTORE-ASKING 是 MIGRATE 发给目标节点的同一条命令, 在迁入中的 slot 上不需要先发 ASKING
+func restoreCommand(c *client, args []string) {
+	w := c.w
+	if len(args) < 4 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	key := args[1]
+	replace, absttl := false, false
+	for _, a := range args[4:] {
+		switch strings.ToUpper(a) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absttl = true
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	ttl, ok := parseInt64(args[2])
+	if !ok || ttl < 0 {
+		w.writeError("ERR Invalid TTL value, must be >= 0")
+		return
+	}
+	if _, exists := c.db.lookupKeyNoTouch(key); exists && !replace {
+		w.writeError("BUSYKEY Target key name already exists.")
+		return
+	}
+	o, ok := loadPayload(args[3])
+	if !ok {
+		w.writeError("ERR DUMP payload version or checksum are wrong")
+		return
+	}
+	// 传播时换成绝对过期时间并带上 REPLACE, 重放和 replica 的结果与这里一致
+	prop := []string{"RESTORE", key, "0", args[3], "REPLACE"}
+	if ttl > 0 {
+		at := ttl
+		if !absttl {
+			at = time.Now().UnixMilli() + ttl
+		}
+		if at <= time.Now().UnixMilli() {
+			// 已经过期, 相当于删掉原来的 key
+			if c.db.removeKey(key) {
+				recordAOF(c.db, []string{"DEL", key})
+			}
+			w.writeOK()
+			return
+		}
+		c.db.setKey(key, o)
+		c.db.setExpire(key, time.UnixMilli(at))
+		prop = append(prop[:2], strconv.FormatInt(at, 10), args[3], "REPLACE", "ABSTTL")
+	} else {
+		c.db.setKey(key, o)
+	}
+	recordAOF(c.db, prop)
+	w.writeOK()
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...]
+// 同 Redis 一样同步执行: 持有 dbMu 把 key 用 RESTORE-ASKING 发给目标节点, 成功后删除本地的 key
+func migrateCommand(c *client, args []string) {
+	w := c.w
+	dbid, ok1 := parseInt64(args[4])
+	timeout, ok2 := parseInt64(args[5])
+	if !ok1 || !ok2 {
+		w.writeError(errNotInt)
+		return
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	copyKeys, replace := false, false
+	var auth []string
+	keys := []string{args[3]}
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COPY":
+			copyKeys = true
+		case opt == "REPLACE":
+			replace = true
+		case opt == "AUTH" && i+1 < len(args):
+			auth = []string{"AUTH", args[i+1]}
+			i++
+		case opt == "AUTH2" && i+2 < len(args):
+			auth = []string{"AUTH", args[i+1], args[i+2]}
+			i += 2
+		case opt == "KEYS":
+			if args[3] != "" {
+				w.writeError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+
+	// 只迁移还存在的 key
+	type migrating struct {
+		key     string
+		payload string
+		ttl     int64
+	}
+	var items []migrating
+	for _, k := range keys {
+		o, ok := c.db.lookupKeyNoTouch(k)
+		if !ok {
+			continue
+		}
+		var ttl int64
+		if t, ok := c.db.expires.Get(k); ok {
+			ttl = max(time.Until(t).Milliseconds(), 1)
+		}
+		items = append(items, migrating{k, string(dumpPayload(o)), ttl})
+	}
+	if len(items) == 0 {
+		w.writeSimple("NOKEY")
+		return
+	}
+
+	d := time.Duration(timeout) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(args[1], args[2]), d)
+	if err != nil {
+		w.writeError("IOERR error or timeout connecting to the client")
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(d))
+
+	// 所有命令一次写出, 再按顺序读回复
+	var out bytes.Buffer
+	if auth != nil {
+		out.Write(encodeCommand(auth))
+	}
+	out.Write(encodeCommand([]string{"SELECT", strconv.FormatInt(dbid, 10)}))
+	for _, it := range items {
+		cmd := []string{"RESTORE-ASKING", it.key, strconv.FormatInt(it.ttl, 10), it.payload}
+		if replace {
+			cmd = append(cmd, "REPLACE")
+		}
+		out.Write(encodeCommand(cmd))
+	}
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		w.writeError("IOERR error or timeout writing to target instance")
+		return
+	}
+	r := bufio.NewReader(conn)
+	replies := 1 // SELECT
+	if auth != nil {
+		replies++
+	}
+	for range replies {
+		if _, err := readSimpleReply(r); err != nil {
+			migrateReplyError(w, err)
+			return
+		}
+	}
+
+	var moved []string
+	var replyErr error
+	for _, it := range items {
+		if _, err := readSimpleReply(r); err != nil {
+			if _, ok := err.(replyError); !ok {
+				replyErr = err
+				break
+			}
+			// 目标节点拒绝了这个 key (BUSYKEY 等), 本地保留, 其余的照常迁移
+			if replyErr == nil {
+				replyErr = err
+			}
+			continue
+		}
+		moved = append(moved, it.key)
+	}
+	if !copyKeys && len(moved) > 0 {
+		for _, k := range moved {
+			c.db.removeKey(k)
+		}
+		recordAOF(c.db, append([]string{"DEL"}, moved...))
+	}
+	if replyErr != nil {
+		migrateReplyError(w, replyErr)
+		return
+	}
+	w.writeOK()
+}
+
+func migrateReplyError(w *replyWriter, err error) {
+	if e, ok := err.(replyError); ok {
+		w.writeError("ERR Target instance replied with error: " + string(e))
+	} else {
+		w.writeError("IOERR error or timeout reading to target instance")
+	}
+}
+
+// readSimpleReply 读一行回复, 错误回复转成 replyError
+func readSimpleReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return "", replyError(line[1:])
+	}
+	return line, nil
+}
+
+// replyError 是对端回复的错误, 和网络错误区分开
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
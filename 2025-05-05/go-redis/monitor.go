@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// monitors 是执行过 MONITOR 的连接, 由 dbMu 保护
+var monitors []*client
+
+// MONITOR
+// 之后服务端执行的每条命令都以一行文本推给这个连接, 直到断开
+func monitorCommand(c *client, args []string) {
+	if c.monitor {
+		c.w.writeOK()
+		return
+	}
+	c.monitor = true
+	monitors = append(monitors, c)
+	c.w.writeOK()
+}
+
+// removeMonitor 在连接关闭时调用, 调用方持有 dbMu
+func removeMonitor(c *client) {
+	if i := slices.Index(monitors, c); i >= 0 {
+		monitors = slices.Delete(monitors, i, i+1)
+	}
+}
+
+// feedMonitors 把执行完的命令推给所有 MONITOR 连接, 格式同 Redis:
+// +<unix 时间> [<db> <addr>] "cmd" "arg" ...
+// 管理类命令 (@admin) 不推送, AUTH 的密码隐去
+func feedMonitors(c *client, cmd *redisCommand, args []string, start time.Time) {
+	if cmd.flags&cmdAdmin != 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [%d %s]", start.Unix(), start.Nanosecond()/1000, c.db.id, c.addr)
+	for _, a := range redactArgs(cmd.name, args) {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(a))
+	}
+	b.WriteString("\r\n")
+	msg := []byte(b.String())
+	for _, m := range monitors {
+		m.pushAsync(msg)
+	}
+}
+
+// quoteArg 同 Redis 的 sdscatrepr: 加双引号, 不可打印字符转义
+func quoteArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if ch < ' ' || ch > '~' {
+				b.WriteString(`\x`)
+				b.WriteString(strconv.FormatUint(uint64(ch)>>4, 16))
+				b.WriteString(strconv.FormatUint(uint64(ch)&0xf, 16))
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,180 @@
+package redis
+
+// arityOK 按命令表的约定检查参数个数 (含命令名): 正数表示必须正好这么多, 负数表示至少 -arity 个
+func arityOK(arity, n int) bool {
+	return (arity > 0 && n == arity) || (arity < 0 && n >= -arity)
+}
+
+// watchedKey 记录一个被 WATCH 的 key 的版本号, 每次修改加一
+type watchedKey struct {
+	version uint64
+	clients int
+}
+
+// watchState 是客户端 WATCH 时看到的 key 状态
+type watchState struct {
+	version uint64
+	expired bool // WATCH 时 key 已经逻辑过期
+}
+
+// watchedKeys 只为被 WATCH 的 key 维护版本号, 没人 WATCH 时不占内存
+var watchedKeys = make(map[dbKey]*watchedKey)
+
+// signalModifiedKey 在 key 被修改时调用, 让 WATCH 了它的事务失效
+func (db *DB) signalModifiedKey(key string) {
+	k := dbKey{db.id, key}
+	memDirtyKeys[k] = struct{}{}
+	if wk, ok := watchedKeys[k]; ok {
+		wk.version++
+	}
+}
+
+// touchAllWatchedKeysInDB 让 WATCH 了库 id 中任意 key 的事务失效, 用于 FLUSHDB / SWAPDB
+func touchAllWatchedKeysInDB(id int) {
+	for k, wk := range watchedKeys {
+		if k.db == id {
+			wk.version++
+		}
+	}
+}
+
+func unwatchAllKeys(c *client) {
+	for k := range c.watched {
+		if wk := watchedKeys[k]; wk != nil {
+			if wk.clients--; wk.clients == 0 {
+				delete(watchedKeys, k)
+			}
+		}
+	}
+	c.watched = nil
+}
+
+// watchedKeysChanged 判断 WATCH 之后是否有 key 被改过 (包括期间过期的 key)
+func watchedKeysChanged(c *client) bool {
+	for k, ws := range c.watched {
+		if watchedKeys[k].version != ws.version {
+			return true
+		}
+		if !ws.expired && dbs[k.db].keyLogicallyExpired(k.key) {
+			return true
+		}
+	}
+	return false
+}
+
+func discardTransaction(c *client) {
+	c.multi, c.multiDirty, c.queued = false, false, nil
+	unwatchAllKeys(c)
+}
+
+// queueMultiCommand 把命令放进事务队列; 内存超限时入队就报错, 并让后面的 EXEC 整体放弃
+func queueMultiCommand(c *client, cmd *redisCommand, args []string) {
+	w := c.w
+	if rejectOnOOM(c, cmd) {
+		c.multiDirty = true
+		w.writeError(errOOM)
+		return
+	}
+	c.queued = append(c.queued, args)
+	w.writeSimple("QUEUED")
+}
+
+// MULTI
+func multiCommand(c *client, args []string) {
+	if c.multi {
+		c.w.writeError("ERR MULTI calls can not be nested")
+		return
+	}
+	c.multi = true
+	c.w.writeOK()
+}
+
+// DISCARD
+func discardCommand(c *client, args []string) {
+	if !c.multi {
+		c.w.writeError("ERR DISCARD without MULTI")
+		return
+	}
+	discardTransaction(c)
+	c.w.writeOK()
+}
+
+// EXEC
+// 在持有 dbMu 的情况下依次执行队列里的命令, 其他连接的命令不会穿插进来
+func execTxCommand(c *client, args []string) {
+	w := c.w
+	if !c.multi {
+		w.writeError("ERR EXEC without MULTI")
+		return
+	}
+	if c.multiDirty {
+		discardTransaction(c)
+		w.writeError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	if errStr := clusterRedirectTx(c, c.queued); errStr != "" {
+		discardTransaction(c)
+		w.writeError(errStr)
+		return
+	}
+	if watchedKeysChanged(c) {
+		discardTransaction(c)
+		w.writeNullArray()
+		return
+	}
+
+	queued := c.queued
+	discardTransaction(c)
+
+	// 事务里的写命令在 AOF 中用 MULTI/EXEC 包起来, 重放时同样原子
+	aofMulti = true
+	c.inExec = true
+	w.writeArray(len(queued))
+	for _, q := range queued {
+		// 入队之后用户的权限可能被改过, 执行前再查一次
+		cmd := lookupCommand(q[0])
+		if errStr := aclCheckCommand(c, cmd, q); errStr != "" {
+			w.writeError(errStr)
+			continue
+		}
+		call(c, cmd, q)
+	}
+	c.inExec = false
+	aofMulti = false
+	if aofMultiEmitted {
+		aofMultiEmitted = false
+		recordAOF(nil, []string{"EXEC"})
+	}
+}
+
+// WATCH key [key ...]
+func watchCommand(c *client, args []string) {
+	w := c.w
+	if c.multi {
+		w.writeError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	if c.watched == nil {
+		c.watched = make(map[dbKey]watchState)
+	}
+	for _, key := range args[1:] {
+		k := dbKey{c.db.id, key}
+		if _, ok := c.watched[k]; ok {
+			continue
+		}
+		wk := watchedKeys[k]
+		if wk == nil {
+			wk = &watchedKey{}
+			watchedKeys[k] = wk
+		}
+		wk.clients++
+		c.watched[k] = watchState{version: wk.version, expired: c.db.keyLogicallyExpired(key)}
+	}
+	w.writeOK()
+}
+
+// UNWATCH
+func unwatchCommand(c *client, args []string) {
+	unwatchAllKeys(c)
+	c.w.writeOK()
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultiExec(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, "+OK\r\n", "MULTI")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "a", "1")
+	expectReply(t, tc, "+QUEUED\r\n", "HINCRBY", "a", "f", "1")
+	expectReply(t, tc, "+QUEUED\r\n", "GET", "a")
+	// 运行时错误不影响事务里的其他命令
+	expectReply(t, tc, "*3\r\n+OK\r\n-"+errWrongType+"\r\n$1\r\n1\r\n", "EXEC")
+	expectReply(t, tc, "-ERR EXEC without MULTI\r\n", "EXEC")
+
+	expectReply(t, tc, "+OK\r\n", "MULTI")
+	expectReply(t, tc, "+QUEUED\r\n", "SET", "a", "2")
+	expectReply(t, tc, "+OK\r\n", "DISCARD")
+	expectReply(t, tc, "$1\r\n1\r\n", "GET", "a")
+}
+
+func TestMultiQueueErrorAbortsExec(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	tc.do("MULTI")
+	tc.do("SET", "a", "1")
+	if got := tc.do("NOSUCHCMD"); !strings.HasPrefix(got, "-ERR unknown command") {
+		t.Fatalf("unknown command in MULTI: %q", got)
+	}
+	expectReply(t, tc, "-"+errWrongArgs("GET")+"\r\n", "GET")
+	expectReply(t, tc, "-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+	expectReply(t, tc, "$-1\r\n", "GET", "a")
+}
+
+func TestWatch(t *testing.T) {
+	resetKeyspace()
+	tc, other := newTestClient(), newTestClient()
+
+	tc.do("SET", "balance", "100")
+	expectReply(t, tc, "+OK\r\n", "WATCH", "balance")
+	other.do("SET", "balance", "50")
+	tc.do("MULTI")
+	tc.do("SET", "balance", "90")
+	expectReply(t, tc, "*-1\r\n", "EXEC")
+	expectReply(t, tc, "$2\r\n50\r\n", "GET", "balance")
+
+	// EXEC 之后 WATCH 自动解除, 再次 WATCH 未被修改的 key 可以提交
+	tc.do("WATCH", "balance", "list")
+	other.do("SET", "unrelated", "x")
+	tc.do("MULTI")
+	tc.do("SET", "balance", "40")
+	expectReply(t, tc, "*1\r\n+OK\r\n", "EXEC")
+
+	// 容器类型的原地修改同样会让 WATCH 失效
+	tc.do("WATCH", "list")
+	other.do("RPUSH", "list", "x")
+	tc.do("MULTI")
+	tc.do("LPOP", "list")
+	expectReply(t, tc, "*-1\r\n", "EXEC")
+	if len(watchedKeys) != 0 {
+		t.Fatalf("watched keys leaked: %v", watchedKeys)
+	}
+}
+
+func TestMultiAOFReplay(t *testing.T) {
+	resetKeyspace()
+	if err := aofFile.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	tc := newTestClient()
+	tc.do("MULTI")
+	tc.do("RPUSH", "q", "a", "b")
+	tc.do("LPOP", "q")
+	tc.do("EXEC")
+
+	aofFile.Seek(0, 0)
+	buf := make([]byte, 256)
+	n, _ := aofFile.Read(buf)
+	// SELECT 在 MULTI 之前, 同 Redis
+	if !strings.HasPrefix(string(buf[:n]), "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*1\r\n$5\r\nMULTI\r\n") || !strings.HasSuffix(string(buf[:n]), "*1\r\n$4\r\nEXEC\r\n") {
+		t.Fatalf("transaction not wrapped in MULTI/EXEC: %q", buf[:n])
+	}
+	replayFromStart(t)
+	expectReply(t, tc, "*1\r\n$1\r\nb\r\n", "LRANGE", "q", "0", "-1")
+}
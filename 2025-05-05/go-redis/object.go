@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"strings"
+	"time"
+)
+
+type objType uint8
+
+const (
+	typeString objType = iota
+	typeHash
+	typeList
+	typeZSet
+	typeSet
+	typeStream
+)
+
+func (t objType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeHash:
+		return "hash"
+	case typeList:
+		return "list"
+	case typeZSet:
+		return "zset"
+	case typeSet:
+		return "set"
+	case typeStream:
+		return "stream"
+	}
+	return "none"
+}
+
+// object 是 keyspace 里的值, 对应 Redis 的 redisObject:
+// typ 决定 val 的具体类型 (string / map[string]string ...)
+type object struct {
+	typ  objType
+	val  any
+	lru  uint32 // LRU 时钟或 LFU 计数, 见 evict.go
+	size int64  // 计入 usedMemory 的估算内存
+}
+
+func newStringObject(s string) *object {
+	return &object{typ: typeString, val: s}
+}
+
+func (o *object) hash() *hashValue { return o.val.(*hashValue) }
+func (o *object) list() *deque     { return o.val.(*deque) }
+func (o *object) zset() *zset      { return o.val.(*zset) }
+func (o *object) set() *setValue   { return o.val.(*setValue) }
+func (o *object) stream() *stream  { return o.val.(*stream) }
+
+// str 返回字符串的值。APPEND 过的字符串存成 *strings.Builder, 追加是均摊 O(追加长度);
+// String() 不复制, 已经写入的字节不会再改, 之前返回的 string 一直有效
+func (o *object) str() string {
+	if b, ok := o.val.(*strings.Builder); ok {
+		return b.String()
+	}
+	return o.val.(string)
+}
+
+// encoding 返回对象的底层编码名, 沿用 Redis OBJECT ENCODING 的叫法
+func (o *object) encoding() string {
+	switch o.typ {
+	case typeString:
+		// 同 Redis, APPEND 过的字符串总是 raw
+		if _, ok := o.val.(*strings.Builder); ok {
+			return "raw"
+		}
+		s := o.str()
+		if _, ok := canonicalInt(s); ok {
+			return "int"
+		}
+		if len(s) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case typeHash:
+		return "hashtable"
+	case typeList:
+		return "quicklist"
+	case typeSet:
+		return o.set().encoding()
+	case typeZSet:
+		return "skiplist"
+	case typeStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// OBJECT ENCODING|IDLETIME|FREQ key
+func objectCommand(c *client, args []string) {
+	w := c.w
+	switch sub := strings.ToUpper(args[1]); sub {
+	case "ENCODING":
+		if len(args) != 3 {
+			w.writeError(errWrongArgs("OBJECT|ENCODING"))
+			return
+		}
+		o, ok := c.db.lookupKeyNoTouch(args[2])
+		if !ok {
+			w.writeNull()
+			return
+		}
+		w.writeBulk(o.encoding())
+	case "IDLETIME", "FREQ":
+		if len(args) != 3 {
+			w.writeError(errWrongArgs("OBJECT|" + sub))
+			return
+		}
+		o, ok := c.db.lookupKeyNoTouch(args[2])
+		if !ok {
+			w.writeNull()
+			return
+		}
+		if sub == "IDLETIME" {
+			if lfuPolicy() {
+				w.writeError("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+				return
+			}
+			w.writeInt(int64(o.idleTime() / time.Second))
+			return
+		}
+		if !lfuPolicy() {
+			w.writeError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		w.writeInt(int64(o.lfuDecrAndReturn()))
+	default:
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try OBJECT HELP.")
+	}
+}
+
+// dup 深拷贝一个值, 用于后台重写等需要快照的场景
+func (o *object) dup() *object {
+	switch o.typ {
+	case typeHash:
+		src := o.hash()
+		h := newHashValue(src.len())
+		for f, v := range src.fields {
+			h.fields[f] = v
+		}
+		h.scan = src.scan.clone()
+		return &object{typ: typeHash, val: h}
+	case typeList:
+		l := o.list()
+		return &object{typ: typeList, val: &deque{buf: append([]string(nil), l.buf...), head: l.head, n: l.n}}
+	case typeSet:
+		s := o.set()
+		cp := &setValue{}
+		if s.is != nil {
+			cp.is = &intset{encoding: s.is.encoding, contents: append([]byte(nil), s.is.contents...)}
+		} else {
+			cp.dict = make(map[string]struct{}, len(s.dict))
+			for m := range s.dict {
+				cp.dict[m] = struct{}{}
+			}
+			cp.scan = s.scan.clone()
+		}
+		return &object{typ: typeSet, val: cp}
+	case typeZSet:
+		cp := newZSetObject()
+		z := cp.zset()
+		for x := o.zset().zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			z.zsl.insert(x.score, x.member)
+			z.dict[x.member] = x.score
+		}
+		return cp
+	case typeStream:
+		return &object{typ: typeStream, val: o.stream().dup()}
+	}
+	// string 是不可变的, 直接共享; APPEND 用的 Builder 不能共用, 否则之后的追加会改到副本
+	return &object{typ: o.typ, val: o.str()}
+}
@@ -0,0 +1,315 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 订阅连接的输出缓冲上限, 对应 client-output-buffer-limit pubsub 32mb 8mb 60:
+// 超过硬上限立即断开; 超过软上限并持续 60s 也断开
+const (
+	pubsubHardLimit   = 32 << 20
+	pubsubSoftLimit   = 8 << 20
+	pubsubSoftSeconds = 60 * time.Second
+)
+
+var (
+	pubsubMu sync.Mutex
+	// channel -> 订阅者; pattern -> 订阅者
+	pubsubChannels = make(map[string]map[*client]struct{})
+	pubsubPatterns = make(map[string]map[*client]struct{})
+)
+
+// subscriptions 返回连接订阅的 channel + pattern 总数
+func (c *client) subscriptions() int {
+	return len(c.subs) + len(c.psubs)
+}
+
+// encodePush 按订阅者的协议编码一条 pub/sub 消息, 由 bulk string 组成
+func encodePush(proto int, parts ...string) []byte {
+	var b bytes.Buffer
+	if proto == resp3 {
+		b.WriteByte('>')
+	} else {
+		b.WriteByte('*')
+	}
+	b.WriteString(strconv.Itoa(len(parts)))
+	b.WriteString("\r\n")
+	for _, p := range parts {
+		b.WriteString("$" + strconv.Itoa(len(p)) + "\r\n")
+		b.WriteString(p)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+// outputLimits 按连接类型返回异步输出缓冲的硬上限、软上限和软上限的持续时间
+func (c *client) outputLimits() (int, int, time.Duration) {
+	if c.repl != nil {
+		return replicaHardLimit, replicaSoftLimit, replicaSoftSeconds
+	}
+	return pubsubHardLimit, pubsubSoftLimit, pubsubSoftSeconds
+}
+
+// pushAsync 把消息放进连接的异步输出缓冲, 由单独的协程写出, 发布者不会被慢订阅者拖住。
+// 缓冲超限时断开连接 (订阅者或 replica)
+func (c *client) pushAsync(msg []byte) {
+	c.outMu.Lock()
+	if c.outClosed {
+		c.outMu.Unlock()
+		return
+	}
+	c.out = append(c.out, msg...)
+	hard, soft, softSeconds := c.outputLimits()
+	n := len(c.out) + c.outFlushing
+	overLimit := n > hard
+	if n > soft {
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = time.Now()
+		} else if time.Since(c.softLimitSince) > softSeconds {
+			overLimit = true
+		}
+	} else {
+		c.softLimitSince = time.Time{}
+	}
+	if overLimit {
+		c.out, c.outClosed = nil, true
+		c.outMu.Unlock()
+		// 调用方 (PUBLISH、MONITOR、复制流) 持有 dbMu 或 pubsubMu, 不能同步关闭
+		c.closeAsync()
+		return
+	}
+	if !c.flushing {
+		c.flushing = true
+		go c.flushAsync()
+	}
+	c.outMu.Unlock()
+}
+
+// flushAsync 把异步缓冲写进 netpoll writer, 和正常回复共用 wmu 避免交错
+func (c *client) flushAsync() {
+	for {
+		c.outMu.Lock()
+		buf := c.out
+		c.out = nil
+		c.outFlushing = len(buf)
+		if len(buf) == 0 || c.outClosed {
+			c.flushing = false
+			c.outMu.Unlock()
+			return
+		}
+		c.outMu.Unlock()
+
+		c.wmu.Lock()
+		c.w.w.WriteBinary(buf)
+		err := c.w.flush()
+		c.wmu.Unlock()
+		if err != nil {
+			c.outMu.Lock()
+			c.out, c.outClosed, c.flushing = nil, true, false
+			c.outMu.Unlock()
+			return
+		}
+	}
+}
+
+func subscribe(c *client, channel string) {
+	if _, ok := c.subs[channel]; ok {
+		return
+	}
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[channel] = struct{}{}
+	subs := pubsubChannels[channel]
+	if subs == nil {
+		subs = make(map[*client]struct{})
+		pubsubChannels[channel] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func unsubscribe(c *client, channel string) {
+	delete(c.subs, channel)
+	if subs := pubsubChannels[channel]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(pubsubChannels, channel)
+		}
+	}
+}
+
+func psubscribe(c *client, pattern string) {
+	if _, ok := c.psubs[pattern]; ok {
+		return
+	}
+	if c.psubs == nil {
+		c.psubs = make(map[string]struct{})
+	}
+	c.psubs[pattern] = struct{}{}
+	subs := pubsubPatterns[pattern]
+	if subs == nil {
+		subs = make(map[*client]struct{})
+		pubsubPatterns[pattern] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func punsubscribe(c *client, pattern string) {
+	delete(c.psubs, pattern)
+	if subs := pubsubPatterns[pattern]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(pubsubPatterns, pattern)
+		}
+	}
+}
+
+// pubsubUnsubscribeAll 在连接关闭时退订全部 channel 和 pattern
+func pubsubUnsubscribeAll(c *client) {
+	unsubscribeAll(c)
+	c.outMu.Lock()
+	c.out, c.outClosed = nil, true
+	c.outMu.Unlock()
+}
+
+// unsubscribeAll 退订全部 channel 和 pattern, 不发确认消息
+func unsubscribeAll(c *client) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	for ch := range c.subs {
+		unsubscribe(c, ch)
+	}
+	for p := range c.psubs {
+		punsubscribe(c, p)
+	}
+}
+
+// writeSubReply 写 subscribe / unsubscribe 类的确认消息
+func writeSubReply(c *client, kind string, name *string) {
+	w := c.w
+	w.writePush(3)
+	w.writeBulk(kind)
+	if name == nil {
+		w.writeNull()
+	} else {
+		w.writeBulk(*name)
+	}
+	w.writeInt(int64(c.subscriptions()))
+}
+
+// SUBSCRIBE channel [channel ...]
+func subscribeCommand(c *client, args []string) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	for _, ch := range args[1:] {
+		subscribe(c, ch)
+		writeSubReply(c, "subscribe", &ch)
+	}
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribeCommand(c *client, args []string) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	for _, p := range args[1:] {
+		psubscribe(c, p)
+		writeSubReply(c, "psubscribe", &p)
+	}
+}
+
+// UNSUBSCRIBE [channel ...], 不带参数时退订全部
+func unsubscribeCommand(c *client, args []string) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	channels := args[1:]
+	if len(channels) == 0 {
+		for ch := range c.subs {
+			channels = append(channels, ch)
+		}
+		if len(channels) == 0 {
+			writeSubReply(c, "unsubscribe", nil)
+			return
+		}
+	}
+	for _, ch := range channels {
+		unsubscribe(c, ch)
+		writeSubReply(c, "unsubscribe", &ch)
+	}
+}
+
+// PUNSUBSCRIBE [pattern ...], 不带参数时退订全部
+func punsubscribeCommand(c *client, args []string) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	patterns := args[1:]
+	if len(patterns) == 0 {
+		for p := range c.psubs {
+			patterns = append(patterns, p)
+		}
+		if len(patterns) == 0 {
+			writeSubReply(c, "punsubscribe", nil)
+			return
+		}
+	}
+	for _, p := range patterns {
+		punsubscribe(c, p)
+		writeSubReply(c, "punsubscribe", &p)
+	}
+}
+
+// publish 把消息投递给 channel 和匹配的 pattern 订阅者, 返回接收者数量
+func publish(channel, message string) int {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	n := 0
+	for sub := range pubsubChannels[channel] {
+		sub.pushAsync(encodePush(sub.w.proto, "message", channel, message))
+		n++
+	}
+	for pattern, subs := range pubsubPatterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			sub.pushAsync(encodePush(sub.w.proto, "pmessage", pattern, channel, message))
+			n++
+		}
+	}
+	return n
+}
+
+// PUBLISH channel message
+func publishCommand(c *client, args []string) {
+	c.w.writeInt(int64(publish(args[1], args[2])))
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCommand(c *client, args []string) {
+	w := c.w
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "CHANNELS" && len(args) <= 3:
+		var out []string
+		for ch := range pubsubChannels {
+			if len(args) == 2 || globMatch(args[2], ch) {
+				out = append(out, ch)
+			}
+		}
+		w.writeBulks(out...)
+	case sub == "NUMSUB":
+		w.writeMap(len(args) - 2)
+		for _, ch := range args[2:] {
+			w.writeBulk(ch)
+			w.writeInt(int64(len(pubsubChannels[ch])))
+		}
+	case sub == "NUMPAT" && len(args) == 2:
+		w.writeInt(int64(len(pubsubPatterns)))
+	default:
+		w.writeError("ERR unknown subcommand or wrong number of arguments for '" + args[1] + "'. Try PUBSUB HELP.")
+	}
+}
@@ -0,0 +1,102 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitPushed 等待异步推送的消息到达订阅者
+func waitPushed(t *testing.T, tc *testClient, want string) {
+	t.Helper()
+	var got string
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		got += tc.drain()
+		if got == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("pushed %q, want %q", got, want)
+}
+
+func TestPubSub(t *testing.T) {
+	sub, pub := newTestClient(), newTestClient()
+
+	expectReply(t, sub, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+	expectReply(t, sub, "*3\r\n$10\r\npsubscribe\r\n$3\r\nn.*\r\n:2\r\n", "PSUBSCRIBE", "n.*")
+
+	// RESP2 订阅模式下不能执行普通命令
+	if got := sub.do("GET", "k"); !strings.HasPrefix(got, "-ERR Can't execute 'get'") {
+		t.Fatalf("GET in subscribed mode: %q", got)
+	}
+	expectReply(t, sub, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", "PING")
+
+	expectReply(t, pub, ":1\r\n", "PUBLISH", "news", "hello")
+	waitPushed(t, sub, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	expectReply(t, pub, ":1\r\n", "PUBLISH", "n.sport", "goal")
+	waitPushed(t, sub, "*4\r\n$8\r\npmessage\r\n$3\r\nn.*\r\n$7\r\nn.sport\r\n$4\r\ngoal\r\n")
+
+	expectReply(t, sub, "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n", "UNSUBSCRIBE")
+	expectReply(t, sub, "*3\r\n$12\r\npunsubscribe\r\n$3\r\nn.*\r\n:0\r\n", "PUNSUBSCRIBE")
+	expectReply(t, pub, ":0\r\n", "PUBLISH", "news", "nobody")
+	expectReply(t, sub, "$-1\r\n", "GET", "k")
+}
+
+func TestPubSubRESP3Push(t *testing.T) {
+	sub, pub := newTestClient(), newTestClient()
+	sub.w.proto = resp3
+
+	expectReply(t, sub, ">3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:1\r\n", "SUBSCRIBE", "c")
+	// RESP3 下订阅后仍然可以执行普通命令
+	expectReply(t, sub, "_\r\n", "GET", "missing")
+	pub.do("PUBLISH", "c", "m")
+	waitPushed(t, sub, ">3\r\n$7\r\nmessage\r\n$1\r\nc\r\n$1\r\nm\r\n")
+	pubsubUnsubscribeAll(sub.client)
+}
+
+func TestPubSubOutputLimit(t *testing.T) {
+	sub := newTestClient()
+	sub.outFlushing = pubsubHardLimit // 模拟一个一直写不出去的慢订阅者
+	sub.flushing = true
+	expectReply(t, sub, "*3\r\n$9\r\nsubscribe\r\n$4\r\nslow\r\n:1\r\n", "SUBSCRIBE", "slow")
+
+	publish("slow", "x")
+	sub.outMu.Lock()
+	closed := sub.outClosed
+	sub.outMu.Unlock()
+	if !closed {
+		t.Fatal("subscriber over the output buffer limit should be dropped")
+	}
+	pubsubUnsubscribeAll(sub.client)
+}
+
+func TestPubSubSlowSubscriberDropped(t *testing.T) {
+	addr := startTestServer(t)
+	sub, pub := dialTestServer(t, addr), dialTestServer(t, addr)
+	if got, _ := sub.do("SUBSCRIBE", "slow"); got != "*3\r\n$9\r\nsubscribe\r\n$4\r\nslow\r\n:1\r\n" {
+		t.Fatalf("SUBSCRIBE: %q", got)
+	}
+
+	// sub 不再读, 消息堆在输出缓冲里直到超过硬上限被断开
+	msg := strings.Repeat("x", 1<<20)
+	pub.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	dropped := false
+	for range 2 * pubsubHardLimit >> 20 {
+		got, err := pub.do("PUBLISH", "slow", msg)
+		if err != nil {
+			t.Fatalf("PUBLISH to a slow subscriber hung: %v", err)
+		}
+		if got == ":0\r\n" {
+			dropped = true
+			break
+		}
+	}
+	if !dropped {
+		t.Fatal("slow subscriber was not dropped")
+	}
+	if got, err := dialTestServer(t, addr).do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("server stopped answering after dropping a subscriber: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,928 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 快照沿用 Redis RDB 的布局 (版本 9, 长度编码、整数编码字符串、CRC64 校验),
+// 只用到其中最基础的几种类型 (list 用老的 RDB_TYPE_LIST, 不写 quicklist/listpack);
+// stream 没有非 listpack 的格式, 按 RDB_TYPE_STREAM_LISTPACKS 存放
+const (
+	rdbVersion = 9
+
+	rdbTypeString    = 0
+	rdbTypeList      = 1
+	rdbTypeSet       = 2
+	rdbTypeHash      = 4
+	rdbTypeZSet2     = 5 // 分数按 8 字节二进制 double 存放
+	rdbTypeSetIntset = 11
+	rdbTypeStream    = 15 // RDB_TYPE_STREAM_LISTPACKS
+
+	rdbOpcodeIdle         = 248
+	rdbOpcodeFreq         = 249
+	rdbOpcodeAux          = 250
+	rdbOpcodeResizeDB     = 251
+	rdbOpcodeExpireTimeMs = 252
+	rdbOpcodeExpireTime   = 253
+	rdbOpcodeSelectDB     = 254
+	rdbOpcodeEOF          = 255
+
+	// 长度编码: 最高两位区分 6 位 / 14 位 / 32 位 / 64 位长度, 11 表示特殊编码的字符串
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var rdbMagic = []byte("REDIS")
+
+// saveParam 对应一条 save <seconds> <changes> 规则
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+var (
+	rdbPath = "dump.rdb"
+
+	// 默认规则同 Redis: 1 小时内 1 次修改、5 分钟内 100 次、1 分钟内 10000 次
+	saveParams = []saveParam{{3600, 1}, {300, 100}, {60, 10000}}
+
+	// 以下状态都由 dbMu 保护
+	dirty              int64 // 上次保存以来的修改次数
+	lastSave           = time.Now()
+	lastBgsaveTry      time.Time
+	lastBgsaveOK       = true
+	rdbSaving          bool // BGSAVE 进行中
+	rdbBgsaveScheduled bool
+)
+
+// 无效的快照文件
+var errRDBCorrupt = errors.New("RDB file corrupted")
+
+// crc64 是 Redis 用的 CRC-64/Jones (反射, 初值 0, 不取反), 标准库的 hash/crc64 会取反, 不能直接用
+var crc64Table = func() (t [256]uint64) {
+	for i := range t {
+		c := uint64(i)
+		for range 8 {
+			if c&1 == 1 {
+				c = c>>1 ^ 0x95ac9329ac4bc9b5
+			} else {
+				c >>= 1
+			}
+		}
+		t[i] = c
+	}
+	return
+}()
+
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// rdbEncoder 序列化快照, 同时累计校验和
+type rdbEncoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func (e *rdbEncoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crc64Update(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *rdbEncoder) writeByte(b byte) { e.write([]byte{b}) }
+
+func (e *rdbEncoder) saveLen(n uint64) {
+	var buf [9]byte
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n) | rdb6BitLen<<6)
+	case n < 1<<14:
+		e.write([]byte{byte(n>>8) | rdb14BitLen<<6, byte(n)})
+	case n <= math.MaxUint32:
+		buf[0] = rdb32BitLen
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.write(buf[:5])
+	default:
+		buf[0] = rdb64BitLen
+		binary.BigEndian.PutUint64(buf[1:], n)
+		e.write(buf[:9])
+	}
+}
+
+// saveString 写字符串; 能无损表示成 int32 以内整数的短字符串按整数编码存放
+func (e *rdbEncoder) saveString(s string) {
+	if len(s) <= 11 {
+		if v, ok := canonicalInt(s); ok && v >= math.MinInt32 && v <= math.MaxInt32 {
+			var buf [5]byte
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				buf[0] = rdbEncVal<<6 | rdbEncInt8
+				buf[1] = byte(v)
+				e.write(buf[:2])
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				buf[0] = rdbEncVal<<6 | rdbEncInt16
+				binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+				e.write(buf[:3])
+			default:
+				buf[0] = rdbEncVal<<6 | rdbEncInt32
+				binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+				e.write(buf[:5])
+			}
+			return
+		}
+	}
+	e.saveLen(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *rdbEncoder) saveDouble(f float64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	e.write(buf[:])
+}
+
+func (e *rdbEncoder) saveAux(k, v string) {
+	e.writeByte(rdbOpcodeAux)
+	e.saveString(k)
+	e.saveString(v)
+}
+
+// saveType 写值的类型字节
+func (e *rdbEncoder) saveType(o *object) {
+	switch o.typ {
+	case typeString:
+		e.writeByte(rdbTypeString)
+	case typeList:
+		e.writeByte(rdbTypeList)
+	case typeSet:
+		if o.set().is != nil {
+			e.writeByte(rdbTypeSetIntset)
+		} else {
+			e.writeByte(rdbTypeSet)
+		}
+	case typeHash:
+		e.writeByte(rdbTypeHash)
+	case typeZSet:
+		e.writeByte(rdbTypeZSet2)
+	case typeStream:
+		e.writeByte(rdbTypeStream)
+	}
+}
+
+func (e *rdbEncoder) saveValue(o *object) {
+	switch o.typ {
+	case typeString:
+		e.saveString(o.str())
+	case typeList:
+		l := o.list()
+		e.saveLen(uint64(l.len()))
+		for i := range l.len() {
+			e.saveString(l.at(i))
+		}
+	case typeSet:
+		s := o.set()
+		if s.is != nil {
+			// intset 整块存放: 4 字节宽度 + 4 字节个数 + 小端的元素
+			blob := make([]byte, 8+len(s.is.contents))
+			binary.LittleEndian.PutUint32(blob, uint32(s.is.encoding))
+			binary.LittleEndian.PutUint32(blob[4:], uint32(s.is.len()))
+			copy(blob[8:], s.is.contents)
+			e.saveLen(uint64(len(blob)))
+			e.write(blob)
+			return
+		}
+		e.saveLen(uint64(len(s.dict)))
+		for m := range s.dict {
+			e.saveString(m)
+		}
+	case typeHash:
+		h := o.hash()
+		e.saveLen(uint64(h.len()))
+		for f, v := range h.fields {
+			e.saveString(f)
+			e.saveString(v)
+		}
+	case typeZSet:
+		z := o.zset()
+		e.saveLen(uint64(z.len()))
+		for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			e.saveString(x.member)
+			e.saveDouble(x.score)
+		}
+	case typeStream:
+		e.saveStream(o.stream())
+	}
+}
+
+// stream 每个 listpack 节点最多放的条目数, 同 Redis 的 stream-node-max-entries 默认值
+const streamNodeMaxEntries = 100
+
+// encodeStreamID 把 ID 编码成 16 字节大端, 用作节点 key 和 PEL 里的 ID
+func encodeStreamID(id streamID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.ms)
+	binary.BigEndian.PutUint64(buf[8:], id.seq)
+	return buf
+}
+
+func decodeStreamID(b []byte) streamID {
+	return streamID{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}
+}
+
+func (e *rdbEncoder) saveMillis(ms int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(ms))
+	e.write(buf[:])
+}
+
+// saveStream 按 RDB_TYPE_STREAM_LISTPACKS 写 stream: listpack 节点, 长度和 last ID, 再是消费组
+func (e *rdbEncoder) saveStream(s *stream) {
+	e.saveLen(uint64((s.len() + streamNodeMaxEntries - 1) / streamNodeMaxEntries))
+	for i := 0; i < s.len(); i += streamNodeMaxEntries {
+		node := s.entries[i:min(i+streamNodeMaxEntries, s.len())]
+		e.saveString(string(encodeStreamID(node[0].id)))
+		e.saveString(string(streamNodeListpack(node)))
+	}
+	e.saveLen(uint64(s.len()))
+	e.saveLen(s.lastID.ms)
+	e.saveLen(s.lastID.seq)
+	e.saveLen(uint64(len(s.groups)))
+	for _, g := range s.sortedGroups() {
+		e.saveString(g.name)
+		e.saveLen(g.lastID.ms)
+		e.saveLen(g.lastID.seq)
+		e.saveLen(uint64(len(g.pel)))
+		for _, id := range sortedPEL(g.pel) {
+			nack := g.pel[id]
+			e.write(encodeStreamID(id))
+			e.saveMillis(nack.deliveryTime)
+			e.saveLen(uint64(nack.deliveryCount))
+		}
+		e.saveLen(uint64(len(g.consumers)))
+		for _, sc := range g.sortedConsumers() {
+			e.saveString(sc.name)
+			e.saveMillis(sc.seenTime)
+			e.saveLen(uint64(len(sc.pel)))
+			for _, id := range sortedPEL(sc.pel) {
+				e.write(encodeStreamID(id))
+			}
+		}
+	}
+}
+
+// streamNodeListpack 把一组条目编码成 Redis 的 stream 节点:
+// 主条目 (条目数, 已删除数, 首条的字段名, 0) 之后, 每个条目是
+// flags, 与首条 ID 的差值, 字段 (和主条目字段名相同时只存值), 最后是这个条目的元素个数
+func streamNodeListpack(node []streamEntry) []byte {
+	const flagSameFields = 2
+	lp := newListpackWriter()
+	master := node[0]
+	lp.appendInt(int64(len(node)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(master.fields) / 2))
+	for i := 0; i < len(master.fields); i += 2 {
+		lp.appendString(master.fields[i])
+	}
+	lp.appendInt(0)
+	for _, ent := range node {
+		same := len(ent.fields) == len(master.fields)
+		for i := 0; same && i < len(ent.fields); i += 2 {
+			same = ent.fields[i] == master.fields[i]
+		}
+		n := len(ent.fields) / 2
+		if same {
+			lp.appendInt(flagSameFields)
+		} else {
+			lp.appendInt(0)
+		}
+		lp.appendInt(int64(ent.id.ms - master.id.ms))
+		lp.appendInt(int64(ent.id.seq - master.id.seq))
+		if same {
+			for i := 1; i < len(ent.fields); i += 2 {
+				lp.appendString(ent.fields[i])
+			}
+			lp.appendInt(int64(n + 3))
+			continue
+		}
+		lp.appendInt(int64(n))
+		for _, f := range ent.fields {
+			lp.appendString(f)
+		}
+		lp.appendInt(int64(2*n + 4))
+	}
+	return lp.bytes()
+}
+
+// streamNodeEntries 解析 stream 节点, 跳过标记为已删除的条目
+func streamNodeEntries(master streamID, blob []byte) ([]streamEntry, bool) {
+	const flagDeleted, flagSameFields = 1, 2
+	items, ok := listpackEntries(blob)
+	if !ok {
+		return nil, false
+	}
+	p := 0
+	next := func() string {
+		if p >= len(items) {
+			ok = false
+			return ""
+		}
+		p++
+		return items[p-1]
+	}
+	nextInt := func() int64 {
+		v, err := strconv.ParseInt(next(), 10, 64)
+		if err != nil {
+			ok = false
+		}
+		return v
+	}
+	count := nextInt()
+	nextInt() // 已删除的条目数
+	nf := nextInt()
+	if !ok || nf < 0 || nf > int64(len(items)-p) {
+		return nil, false
+	}
+	masterFields := items[p : p+int(nf)]
+	p += int(nf)
+	if nextInt() != 0 {
+		return nil, false
+	}
+	var out []streamEntry
+	for ok && p < len(items) {
+		flags := nextInt()
+		id := streamID{master.ms + uint64(nextInt()), master.seq + uint64(nextInt())}
+		var fields []string
+		if flags&flagSameFields != 0 {
+			for _, f := range masterFields {
+				fields = append(fields, f, next())
+			}
+		} else {
+			n := nextInt()
+			if n <= 0 || n > int64(len(items)-p) {
+				return nil, false
+			}
+			for range 2 * n {
+				fields = append(fields, next())
+			}
+		}
+		nextInt() // 条目的元素个数, 只在反向遍历时有用
+		if ok && flags&flagDeleted == 0 {
+			out = append(out, streamEntry{id, fields})
+		}
+	}
+	if !ok || int64(len(out)) != count {
+		return nil, false
+	}
+	return out, true
+}
+
+// writeRDB 把快照写成 RDB 格式
+func writeRDB(w io.Writer, entries []snapshotEntry, aofBase bool) error {
+	e := &rdbEncoder{w: bufio.NewWriterSize(w, 64*1024)}
+	e.write(fmt.Appendf(nil, "%s%04d", rdbMagic, rdbVersion))
+	e.saveAux("redis-ver", serverVersion)
+	e.saveAux("redis-bits", "64")
+	e.saveAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	e.saveAux("aof-base", strconv.Itoa(boolInt(aofBase)))
+
+	// entries 按库排好序, 每个库前面写 SELECTDB 和 RESIZEDB
+	for i, ent := range entries {
+		if i == 0 || ent.db != entries[i-1].db {
+			var size, expires uint64
+			for _, next := range entries[i:] {
+				if next.db != ent.db {
+					break
+				}
+				size++
+				if !next.expireAt.IsZero() {
+					expires++
+				}
+			}
+			e.writeByte(rdbOpcodeSelectDB)
+			e.saveLen(uint64(ent.db))
+			e.writeByte(rdbOpcodeResizeDB)
+			e.saveLen(size)
+			e.saveLen(expires)
+		}
+		if !ent.expireAt.IsZero() {
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], uint64(ent.expireAt.UnixMilli()))
+			e.writeByte(rdbOpcodeExpireTimeMs)
+			e.write(buf[:])
+		}
+		e.saveType(ent.obj)
+		e.saveString(ent.key)
+		e.saveValue(ent.obj)
+	}
+	e.writeByte(rdbOpcodeEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc)
+	e.write(sum[:])
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// rdbDecoder 解析快照; 出错后 err 不再为 nil, 之后的读取都返回零值
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+	n   int64 // 已消费的字节数, AOF 前导快照之后就是命令部分
+	err error
+}
+
+func (d *rdbDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.err = errRDBCorrupt
+		return nil
+	}
+	d.crc = crc64Update(d.crc, buf)
+	d.n += int64(n)
+	return buf
+}
+
+func (d *rdbDecoder) readByte() byte {
+	if b := d.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// loadLen 读长度, encoded 为 true 时 n 是特殊编码的类型
+func (d *rdbDecoder) loadLen() (n uint64, encoded bool) {
+	b := d.readByte()
+	switch b >> 6 {
+	case rdb6BitLen:
+		return uint64(b & 0x3f), false
+	case rdb14BitLen:
+		return uint64(b&0x3f)<<8 | uint64(d.readByte()), false
+	case rdbEncVal:
+		return uint64(b & 0x3f), true
+	}
+	switch b {
+	case rdb32BitLen:
+		if p := d.read(4); p != nil {
+			return uint64(binary.BigEndian.Uint32(p)), false
+		}
+	case rdb64BitLen:
+		if p := d.read(8); p != nil {
+			return binary.BigEndian.Uint64(p), false
+		}
+	default:
+		d.err = errRDBCorrupt
+	}
+	return 0, false
+}
+
+// loadCount 读一个元素个数, 顺带挡住明显不合理的长度
+func (d *rdbDecoder) loadCount() int {
+	n, enc := d.loadLen()
+	if enc || n > maxBulkLen {
+		d.err = errRDBCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+func (d *rdbDecoder) loadString() string {
+	n, enc := d.loadLen()
+	if !enc {
+		if n > maxBulkLen {
+			d.err = errRDBCorrupt
+			return ""
+		}
+		return string(d.read(int(n)))
+	}
+	switch n {
+	case rdbEncInt8:
+		return strconv.Itoa(int(int8(d.readByte())))
+	case rdbEncInt16:
+		if p := d.read(2); p != nil {
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p))))
+		}
+	case rdbEncInt32:
+		if p := d.read(4); p != nil {
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p))))
+		}
+	default:
+		// LZF 压缩的字符串只会出现在 Redis 生成的文件里, 这里不支持
+		if d.err == nil {
+			d.err = fmt.Errorf("unsupported string encoding %d in RDB", n)
+		}
+	}
+	return ""
+}
+
+func (d *rdbDecoder) loadDouble() float64 {
+	if p := d.read(8); p != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (d *rdbDecoder) loadMillis() int64 {
+	if p := d.read(8); p != nil {
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (d *rdbDecoder) loadStreamID() streamID {
+	if p := d.read(16); p != nil {
+		return decodeStreamID(p)
+	}
+	return streamID{}
+}
+
+// loadStream 读 RDB_TYPE_STREAM_LISTPACKS 格式的 stream
+func (d *rdbDecoder) loadStream() *object {
+	o := newStreamObject()
+	s := o.stream()
+	for range d.loadCount() {
+		key, blob := d.loadString(), d.loadString()
+		if d.err != nil {
+			return nil
+		}
+		if len(key) != 16 {
+			d.err = errRDBCorrupt
+			return nil
+		}
+		entries, ok := streamNodeEntries(decodeStreamID([]byte(key)), []byte(blob))
+		if !ok {
+			d.err = errRDBCorrupt
+			return nil
+		}
+		for _, ent := range entries {
+			if n := s.len(); n > 0 && !s.entries[n-1].id.less(ent.id) {
+				d.err = errRDBCorrupt
+				return nil
+			}
+			s.entries = append(s.entries, ent)
+		}
+	}
+	length, _ := d.loadLen()
+	ms, _ := d.loadLen()
+	seq, _ := d.loadLen()
+	s.lastID = streamID{ms, seq}
+	s.entriesAdded = int64(s.len())
+	if n := s.len(); length != uint64(n) || n > 0 && s.lastID.less(s.entries[n-1].id) {
+		d.err = errRDBCorrupt
+		return nil
+	}
+	for range d.loadCount() {
+		name := d.loadString()
+		ms, _ := d.loadLen()
+		seq, _ := d.loadLen()
+		g := s.createGroup(name, streamID{ms, seq}, -1)
+		if g == nil {
+			d.err = errRDBCorrupt
+			return nil
+		}
+		// 组的 PEL 先建出来, 读到消费者时再挂到对应的消费者上
+		for range d.loadCount() {
+			id := d.loadStreamID()
+			t := d.loadMillis()
+			cnt, _ := d.loadLen()
+			g.pel[id] = &streamNACK{deliveryTime: t, deliveryCount: int64(cnt)}
+		}
+		for range d.loadCount() {
+			sc := g.createConsumer(d.loadString(), 0)
+			if sc == nil {
+				d.err = errRDBCorrupt
+				return nil
+			}
+			sc.seenTime = d.loadMillis()
+			for range d.loadCount() {
+				id := d.loadStreamID()
+				nack, ok := g.pel[id]
+				if !ok || nack.consumer != nil {
+					d.err = errRDBCorrupt
+					return nil
+				}
+				nack.consumer = sc
+				sc.pel[id] = nack
+			}
+		}
+		for _, nack := range g.pel {
+			if nack.consumer == nil {
+				d.err = errRDBCorrupt
+				return nil
+			}
+		}
+		if d.err != nil {
+			return nil
+		}
+	}
+	return o
+}
+
+func (d *rdbDecoder) loadObject(typ byte) *object {
+	switch typ {
+	case rdbTypeString:
+		return newStringObject(d.loadString())
+	case rdbTypeList:
+		l := &deque{}
+		for range d.loadCount() {
+			l.pushBack(d.loadString())
+		}
+		return &object{typ: typeList, val: l}
+	case rdbTypeSet:
+		n := d.loadCount()
+		members := make([]string, 0, n)
+		for range n {
+			members = append(members, d.loadString())
+		}
+		return newSetObjectFrom(members)
+	case rdbTypeSetIntset:
+		blob := []byte(d.loadString())
+		if len(blob) < 8 {
+			break
+		}
+		enc := int(binary.LittleEndian.Uint32(blob))
+		n := int(binary.LittleEndian.Uint32(blob[4:]))
+		if enc != intsetEncInt16 && enc != intsetEncInt32 && enc != intsetEncInt64 || len(blob)-8 != n*enc {
+			break
+		}
+		return &object{typ: typeSet, val: &setValue{is: &intset{encoding: enc, contents: blob[8:]}}}
+	case rdbTypeHash:
+		n := d.loadCount()
+		h := newHashValue(n)
+		for range n {
+			f := d.loadString()
+			h.set(f, d.loadString())
+		}
+		return &object{typ: typeHash, val: h}
+	case rdbTypeZSet2:
+		o := newZSetObject()
+		z := o.zset()
+		for range d.loadCount() {
+			m := d.loadString()
+			score := d.loadDouble()
+			if _, dup := z.dict[m]; dup || math.IsNaN(score) {
+				d.err = errRDBCorrupt
+				break
+			}
+			z.zsl.insert(score, m)
+			z.dict[m] = score
+		}
+		return o
+	case rdbTypeStream:
+		return d.loadStream()
+	}
+	if d.err == nil {
+		d.err = errRDBCorrupt
+	}
+	return nil
+}
+
+// loadRDB 把快照加载进 keyspace, 返回快照占用的字节数和加载的 key 数。
+// 已经过期的 key 直接跳过
+func loadRDB(r io.Reader) (int64, int, error) {
+	defer updateMemoryAccounting()
+	d := &rdbDecoder{r: bufio.NewReaderSize(r, 64*1024)}
+	header := d.read(9)
+	if header == nil || string(header[:5]) != string(rdbMagic) {
+		return 0, 0, errRDBCorrupt
+	}
+	if ver, err := strconv.Atoi(string(header[5:])); err != nil || ver < 1 || ver > rdbVersion {
+		return 0, 0, fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+
+	now := time.Now()
+	keys := 0
+	db := dbs[0]
+	var expireAt time.Time
+	for d.err == nil {
+		typ := d.readByte()
+		switch typ {
+		case rdbOpcodeExpireTimeMs:
+			if p := d.read(8); p != nil {
+				expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(p)))
+			}
+			continue
+		case rdbOpcodeExpireTime:
+			if p := d.read(4); p != nil {
+				expireAt = time.Unix(int64(binary.LittleEndian.Uint32(p)), 0)
+			}
+			continue
+		case rdbOpcodeSelectDB:
+			id := d.loadCount()
+			if d.err != nil {
+				break
+			}
+			if db = selectDB(int64(id)); db == nil {
+				return d.n, keys, fmt.Errorf("RDB selects DB %d, but only %d databases are configured", id, len(dbs))
+			}
+			continue
+		case rdbOpcodeResizeDB:
+			d.loadCount()
+			d.loadCount()
+			continue
+		case rdbOpcodeAux:
+			d.loadString()
+			d.loadString()
+			continue
+		case rdbOpcodeIdle:
+			d.loadLen()
+			continue
+		case rdbOpcodeFreq:
+			d.readByte()
+			continue
+		case rdbOpcodeEOF:
+			sum := d.crc
+			p := d.read(8)
+			if d.err != nil {
+				return d.n, keys, d.err
+			}
+			// 校验和为 0 表示生成方关闭了校验
+			if v := binary.LittleEndian.Uint64(p); v != 0 && v != sum {
+				return d.n, keys, errors.New("wrong RDB checksum")
+			}
+			return d.n, keys, nil
+		}
+
+		key := d.loadString()
+		o := d.loadObject(typ)
+		if d.err != nil {
+			continue
+		}
+		if expireAt.IsZero() || expireAt.After(now) {
+			db.setKey(key, o)
+			if !expireAt.IsZero() {
+				db.setExpire(key, expireAt)
+			}
+			keys++
+		}
+		expireAt = time.Time{}
+	}
+	return d.n, keys, d.err
+}
+
+// loadRDBFile 加载快照文件, 文件不存在不算错误
+func loadRDBFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	_, keys, err := loadRDB(f)
+	return keys, err
+}
+
+// rdbSave 把快照写到临时文件, fsync 后原子地替换 rdbPath
+func rdbSave(entries []snapshotEntry) error {
+	tmpPath := filepath.Join(filepath.Dir(rdbPath), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err = writeRDB(f, entries, false); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, rdbPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// startBGSave 开始后台保存, 调用方持有 dbMu
+func startBGSave() {
+	rdbBgsaveScheduled = false
+	if rdbSaving {
+		return
+	}
+	rdbSaving = true
+	lastBgsaveTry = time.Now()
+	dirtyBefore := dirty
+	entries := snapshotKeyspace()
+	rebase := beginAOFRebase()
+	go func() {
+		err := rdbSave(entries)
+		finishAOFRebase(rebase, entries, err)
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		rdbSaving = false
+		lastBgsaveOK = err == nil
+		if err != nil {
+			log.Printf("Background saving error: %v", err)
+			return
+		}
+		// 保存期间的修改不在快照里, 留给下一次
+		dirty -= dirtyBefore
+		lastSave = time.Now()
+		log.Printf("Background saving terminated with success, %d keys", len(entries))
+	}()
+}
+
+// saveCron 按 save 规则定期触发 BGSAVE
+func saveCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		dbMu.Lock()
+		if !rdbSaving && saveRuleMatched(time.Now()) {
+			startBGSave()
+		}
+		dbMu.Unlock()
+	}
+}
+
+func saveRuleMatched(now time.Time) bool {
+	// 上次失败后 5 秒内不重试, 同 Redis CONFIG_BGSAVE_RETRY_DELAY
+	if !lastBgsaveOK && now.Sub(lastBgsaveTry) < 5*time.Second {
+		return false
+	}
+	for _, p := range saveParams {
+		if dirty >= p.changes && now.Sub(lastSave) >= time.Duration(p.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// SAVE
+func saveCommand(c *client, args []string) {
+	w := c.w
+	if rdbSaving {
+		w.writeError("ERR Background save already in progress")
+		return
+	}
+	entries := snapshotKeyspace()
+	if err := rdbSave(entries); err != nil {
+		log.Printf("SAVE error: %v", err)
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	// 一直持有 dbMu, 快照之后还没有新的写入, AOF 在后台换成这份快照
+	if beginAOFRebase() {
+		go finishAOFRebase(true, entries, nil)
+	}
+	dirty = 0
+	lastSave = time.Now()
+	w.writeOK()
+}
+
+// BGSAVE [SCHEDULE]
+func bgsaveCommand(c *client, args []string) {
+	w := c.w
+	if len(args) > 2 || len(args) == 2 && !strings.EqualFold(args[1], "SCHEDULE") {
+		w.writeError(errSyntax)
+		return
+	}
+	switch {
+	case rdbSaving:
+		w.writeError("ERR Background save already in progress")
+	case c.inExec:
+		// 和 BGREWRITEAOF 一样, 不在事务中间拍快照
+		rdbBgsaveScheduled = true
+		w.writeSimple("Background saving scheduled")
+	default:
+		startBGSave()
+		w.writeSimple("Background saving started")
+	}
+}
+
+// LASTSAVE
+func lastsaveCommand(c *client, args []string) {
+	c.w.writeInt(lastSave.Unix())
+}
@@ -0,0 +1,219 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64Jones(t *testing.T) {
+	// Redis crc64.c 里的测试向量
+	if got := crc64Update(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64 = %x", got)
+	}
+}
+
+func TestRDBSaveLoad(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	big := strings.Repeat("x", 20000) // 32 位长度编码
+	tc.do("SET", "big", big)
+	tc.do("SET", "i8", "-7")
+	tc.do("SET", "i32", "1234567")
+	tc.do("SET", "notint", "007")
+	tc.do("RPUSH", "l", "a", "b", "c")
+	tc.do("HSET", "h", "f", "v")
+	tc.do("SADD", "is", "1", "70000", "-3")
+	tc.do("SADD", "hs", "x", "y")
+	tc.do("ZADD", "z", "1.5", "a", "-inf", "b", "3", "c")
+	tc.do("SET", "ttl", "v")
+	tc.do("EXPIRE", "ttl", "100")
+
+	expectReply(t, tc, "+OK\r\n", "SAVE")
+	if got := tc.do("INFO", "persistence"); !strings.Contains(got, "rdb_changes_since_last_save:0\r\n") {
+		t.Fatalf("dirty not reset:\n%s", got)
+	}
+	resetKeyspace()
+	keys, err := loadRDBFile(rdbPath)
+	if err != nil || keys != 10 {
+		t.Fatalf("loaded %d keys, err %v", keys, err)
+	}
+	expectReply(t, tc, "$20000\r\n"+big+"\r\n", "GET", "big")
+	expectReply(t, tc, "$2\r\n-7\r\n", "GET", "i8")
+	expectReply(t, tc, "$7\r\n1234567\r\n", "GET", "i32")
+	expectReply(t, tc, "$3\r\n007\r\n", "GET", "notint")
+	expectReply(t, tc, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", "LRANGE", "l", "0", "-1")
+	expectReply(t, tc, "$1\r\nv\r\n", "HGET", "h", "f")
+	expectReply(t, tc, "$6\r\nintset\r\n", "OBJECT", "ENCODING", "is")
+	expectReply(t, tc, "*3\r\n$2\r\n-3\r\n$1\r\n1\r\n$5\r\n70000\r\n", "SMEMBERS", "is")
+	expectReply(t, tc, ":1\r\n", "SISMEMBER", "hs", "y")
+	expectReply(t, tc, "*6\r\n$1\r\nb\r\n$4\r\n-inf\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nc\r\n$1\r\n3\r\n", "ZRANGE", "z", "0", "-1", "WITHSCORES")
+	if got := tc.do("TTL", "ttl"); got != ":100\r\n" && got != ":99\r\n" {
+		t.Fatalf("TTL after load: %q", got)
+	}
+}
+
+func TestRDBSkipsExpiredAndChecksCRC(t *testing.T) {
+	entries := []snapshotEntry{
+		{key: "live", obj: newStringObject("1")},
+		{key: "dead", obj: newStringObject("2"), expireAt: time.Now().Add(-time.Second)},
+	}
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	resetKeyspace()
+	n, keys, err := loadRDB(bytes.NewReader(data))
+	if err != nil || keys != 1 || n != int64(len(data)) {
+		t.Fatalf("n=%d keys=%d err=%v", n, keys, err)
+	}
+	if _, ok := dbs[0].store.Get("dead"); ok {
+		t.Fatal("expired key loaded")
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, _, err := loadRDB(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected checksum error")
+	}
+	if _, _, err := loadRDB(bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Fatal("expected error on truncated file")
+	}
+}
+
+func waitBgsave(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dbMu.Lock()
+		saving := rdbSaving
+		dbMu.Unlock()
+		if !saving {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("BGSAVE did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBgsave(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+	before := lastSave
+	time.Sleep(time.Second - time.Duration(time.Now().Nanosecond()))
+
+	expectReply(t, tc, "+Background saving started\r\n", "BGSAVE")
+	waitBgsave(t)
+	if got := tc.do("LASTSAVE"); got == ":"+strconv.FormatInt(before.Unix(), 10)+"\r\n" {
+		t.Fatalf("LASTSAVE not updated: %q", got)
+	}
+	resetKeyspace()
+	if keys, err := loadRDBFile(rdbPath); err != nil || keys != 1 {
+		t.Fatalf("loaded %d keys, err %v", keys, err)
+	}
+}
+
+func TestBgsaveRebasesAOF(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	for range 100 {
+		tc.do("INCR", "counter")
+	}
+	tc.do("RPUSH", "l", "a", "b")
+	expectReply(t, tc, "+Background saving started\r\n", "BGSAVE")
+	waitBgsave(t)
+	waitRewrite(t)
+	tc.do("SET", "after", "1")
+	tc.do("INCR", "counter")
+
+	// AOF 变成快照加上 BGSAVE 之后的命令, 之前的 100 条 INCR 不再需要重放
+	flushAOFAll()
+	data, err := os.ReadFile(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := string(encodeCommand([]string{"SELECT", "0"})) +
+		string(encodeCommand([]string{"SET", "after", "1"})) +
+		string(encodeCommand([]string{"INCR", "counter"}))
+	if !bytes.HasPrefix(data, []byte("REDIS")) || !strings.HasSuffix(string(data), tail) {
+		t.Fatalf("AOF is not snapshot + tail: %q", data)
+	}
+	if n := strings.Count(string(data), "INCR"); n != 1 {
+		t.Fatalf("AOF still has %d INCR commands", n)
+	}
+
+	// 重启: 先加载快照, 再重放尾巴
+	replayFromStart(t)
+	expectReply(t, tc, "$3\r\n101\r\n", "GET", "counter")
+	expectReply(t, tc, ":2\r\n", "LLEN", "l")
+	expectReply(t, tc, "$1\r\n1\r\n", "GET", "after")
+
+	// SAVE 同样把 AOF 换成快照
+	expectReply(t, tc, "+OK\r\n", "SAVE")
+	waitRewrite(t)
+	flushAOFAll()
+	if data, err = os.ReadFile(aofPath); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "INCR") {
+		t.Fatalf("AOF after SAVE still has the tail: %q", data)
+	}
+	replayFromStart(t)
+	expectReply(t, tc, "$3\r\n101\r\n", "GET", "counter")
+}
+
+func TestSaveRules(t *testing.T) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	oldDirty, oldLast := dirty, lastSave
+	defer func() { dirty, lastSave = oldDirty, oldLast }()
+
+	now := time.Now()
+	lastSave = now.Add(-61 * time.Second)
+	dirty = 9999
+	if saveRuleMatched(now) {
+		t.Fatal("60 10000 should not match with 9999 changes")
+	}
+	dirty = 10000
+	if !saveRuleMatched(now) {
+		t.Fatal("60 10000 should match")
+	}
+	lastSave, dirty = now.Add(-3599*time.Second), 1
+	if saveRuleMatched(now) {
+		t.Fatal("3600 1 should not match before an hour")
+	}
+}
+
+func TestAOFRDBPreamble(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "a", "1")
+	tc.do("BGREWRITEAOF")
+	waitRewrite(t)
+	tc.do("SET", "b", "2")
+	flushAOFAll()
+
+	f, err := os.Open(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	tail := encodeCommand([]string{"SET", "b", "2"})
+	if !bytes.HasPrefix(data, []byte("REDIS0009")) || !bytes.HasSuffix(data, tail) {
+		t.Fatalf("unexpected AOF layout: %q", data)
+	}
+	replayFromStart(t)
+	expectReply(t, tc, "$1\r\n1\r\n", "GET", "a")
+	expectReply(t, tc, "$1\r\n2\r\n", "GET", "b")
+}
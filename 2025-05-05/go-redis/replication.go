@@ -0,0 +1,654 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// 主从复制, 流程和 Redis 的 PSYNC2 一致:
+//   - replica 握手 PING / REPLCONF / PSYNC <replid> <offset>
+//   - master 能从 backlog 里补齐时回 +CONTINUE 并发送缺的部分, 否则回
+//     +FULLRESYNC <replid> <offset>, 接着发送 RDB 快照和快照之后的写命令
+//   - 之后 master 把写命令原样推给 replica, replica 每秒回 REPLCONF ACK <offset>
+//
+// replica 按收到的字节数推进复制偏移, 并把原始字节写进自己的 backlog,
+// 这样下级 replica 看到的偏移和 replid 与 master 完全一致
+
+const (
+	replBacklogSize   = 1 << 20
+	replPingPeriod    = 10 * time.Second // master 在复制流里发 PING 的周期
+	replTimeout       = 60 * time.Second // 超过这么久没收到 master 的数据就重连
+	replReconnectWait = time.Second
+)
+
+// replica 连接的输出缓冲上限, 对应 client-output-buffer-limit replica 256mb 64mb 60
+const (
+	replicaHardLimit   = 256 << 20
+	replicaSoftLimit   = 64 << 20
+	replicaSoftSeconds = 60 * time.Second
+)
+
+// master 眼中 replica 连接的状态
+const (
+	replicaWaitBgsave = iota // 正在生成快照, 写命令先攒在 pending 里
+	replicaOnline
+)
+
+// replica 自己到 master 的连接状态
+const (
+	replNone       = iota
+	replConnect    // 等待建立连接
+	replConnecting // 握手 / 全量同步中
+	replConnected
+)
+
+// replicaState 是 master 上一个 replica 连接的复制状态
+type replicaState struct {
+	state         int
+	attached      bool   // 已经执行过 PSYNC, 在 replicas 列表里
+	pending       []byte // 快照生成期间的写命令
+	listeningPort int
+	ackOffset     int64
+	ackTime       time.Time
+}
+
+// replBacklog 是复制积压缓冲: 定长的环形数组, 保存最近写出的复制流
+type replBacklog struct {
+	buf     []byte
+	idx     int   // 下一个写入位置
+	histlen int   // 有效数据长度
+	offset  int64 // 第一个有效字节的复制偏移
+}
+
+func newReplBacklog(size int) *replBacklog {
+	return &replBacklog{buf: make([]byte, size), offset: masterReplOffset + 1}
+}
+
+func (b *replBacklog) write(p []byte) {
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+		p = p[n:]
+	}
+	b.offset = masterReplOffset - int64(b.histlen) + 1
+}
+
+// covers 判断从偏移 off 开始的数据是否都还在 backlog 里
+func (b *replBacklog) covers(off int64) bool {
+	return off >= b.offset && off <= b.offset+int64(b.histlen)
+}
+
+// from 返回从偏移 off 开始到最新的数据, 调用方先用 covers 检查
+func (b *replBacklog) from(off int64) []byte {
+	skip := int(off - b.offset)
+	n := b.histlen - skip
+	out := make([]byte, 0, n)
+	start := (b.idx - b.histlen + skip + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		return append(out, b.buf[start:start+n]...)
+	}
+	out = append(out, b.buf[start:]...)
+	return append(out, b.buf[:n-(len(b.buf)-start)]...)
+}
+
+// 复制状态, 都由 dbMu 保护
+var (
+	replID           = newReplID()
+	replID2          = strings.Repeat("0", 40) // 上一任 master 的 replid, 切换角色后还能接受它的 PSYNC
+	secondReplOffset = int64(-1)
+	masterReplOffset int64 // 复制流最后一个字节的偏移
+	backlog          *replBacklog
+	replicas         []*client
+	replCronLoops    int
+
+	// 作为 replica 时的状态
+	masterHost   string // 非空表示当前是 replica
+	masterPort   int
+	masterauth   string // master 要求认证时, 握手阶段用它 AUTH
+	masteruser   string // 为空时以 default 用户 AUTH
+	replState    int
+	replEpoch    int // 每次 REPLICAOF 加一, 旧的同步协程发现不一致就退出
+	masterLink   net.Conn
+	masterLastIO time.Time
+	// masterDB 是复制流当前选中的库, 断线重连后部分同步接着用
+	masterDB int
+	// applyingMaster 为 true 时正在执行 master 发来的命令, 这些命令的原始字节
+	// 由 replicaApply 转发, propagate 不再重复写入复制流
+	applyingMaster bool
+)
+
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// feedReplicationStream 把一段复制流写进 backlog 并推给所有 replica, 调用方持有 dbMu
+func feedReplicationStream(p []byte) {
+	masterReplOffset += int64(len(p))
+	if backlog != nil {
+		backlog.write(p)
+	}
+	for _, r := range replicas {
+		switch r.repl.state {
+		case replicaWaitBgsave:
+			r.repl.pending = append(r.repl.pending, p...)
+		case replicaOnline:
+			r.pushAsync(p)
+		}
+	}
+}
+
+// removeReplica 在 replica 连接断开时调用, 调用方持有 dbMu
+func removeReplica(c *client) {
+	if c.repl == nil || !c.repl.attached {
+		return
+	}
+	for i, r := range replicas {
+		if r == c {
+			replicas = append(replicas[:i], replicas[i+1:]...)
+			break
+		}
+	}
+	c.repl.attached = false
+	c.repl.pending = nil
+}
+
+// ---------------- master 端 ----------------
+
+// REPLCONF <option> <value> [<option> <value> ...]
+func replconfCommand(c *client, args []string) {
+	w := c.w
+	if len(args)%2 == 0 {
+		w.writeError(errSyntax)
+		return
+	}
+	if c.repl == nil {
+		c.repl = &replicaState{}
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "listening-port":
+			p, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				w.writeError(errNotInt)
+				return
+			}
+			c.repl.listeningPort = p
+		case "capa":
+			// 只支持 psync2, 其余能力忽略
+		case "ack":
+			// ACK 不回复
+			if off, ok := parseInt64(args[i+1]); ok && off > c.repl.ackOffset {
+				c.repl.ackOffset = off
+			}
+			c.repl.ackTime = time.Now()
+			return
+		default:
+			w.writeError("ERR Unrecognized REPLCONF option: " + args[i])
+			return
+		}
+	}
+	w.writeOK()
+}
+
+// PSYNC <replid> <offset>
+func psyncCommand(c *client, args []string) {
+	w := c.w
+	if c.repl != nil && c.repl.attached {
+		return
+	}
+	if masterHost != "" && replState != replConnected {
+		w.writeError("NOMASTERLINK Can't SYNC while not connected with my master")
+		return
+	}
+	if c.repl == nil {
+		c.repl = &replicaState{}
+	}
+	if off, ok := parseInt64(args[2]); ok && tryPartialResync(c, args[1], off) {
+		return
+	}
+	fullResync(c)
+}
+
+func attachReplica(c *client, state int) {
+	c.repl.state = state
+	c.repl.attached = true
+	c.repl.ackTime = time.Now()
+	replicas = append(replicas, c)
+}
+
+func tryPartialResync(c *client, id string, off int64) bool {
+	if backlog == nil {
+		return false
+	}
+	if id != replID && (id != replID2 || off > secondReplOffset) {
+		return false
+	}
+	if !backlog.covers(off) {
+		return false
+	}
+	c.w.writeSimple("CONTINUE " + replID)
+	attachReplica(c, replicaOnline)
+	if data := backlog.from(off); len(data) > 0 {
+		c.pushAsync(data)
+	}
+	log.Printf("Partial resynchronization accepted, sending %d bytes of backlog", masterReplOffset-off+1)
+	return true
+}
+
+func fullResync(c *client) {
+	if backlog == nil {
+		backlog = newReplBacklog(replBacklogSize)
+	}
+	c.w.writeSimple(fmt.Sprintf("FULLRESYNC %s %d", replID, masterReplOffset))
+	attachReplica(c, replicaWaitBgsave)
+	// replica 从快照开始时选中的是 0 号库, 之后的第一条命令要重新 SELECT
+	aofSelectedDB = -1
+	entries := snapshotKeyspace()
+	// 回复在 onRequest 返回前随 c.w 一起写出, sendSnapshot 拿到 wmu 时它已经发出去了
+	go sendSnapshot(c, entries)
+}
+
+// sendSnapshot 生成快照并以 $<len>\r\n<rdb> 的形式发给 replica, 随后补发快照期间的写命令
+func sendSnapshot(c *client, entries []snapshotEntry) {
+	var buf bytes.Buffer
+	err := writeRDB(&buf, entries, false)
+	if err == nil {
+		c.wmu.Lock()
+		c.w.w.WriteString("$" + strconv.Itoa(buf.Len()) + "\r\n")
+		c.w.w.WriteBinary(buf.Bytes())
+		err = c.w.flush()
+		c.wmu.Unlock()
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if c.repl == nil || !c.repl.attached {
+		return
+	}
+	if err != nil {
+		log.Printf("Full resync failed: %v", err)
+		removeReplica(c)
+		c.closeAsync()
+		return
+	}
+	c.repl.state = replicaOnline
+	if len(c.repl.pending) > 0 {
+		c.pushAsync(c.repl.pending)
+		c.repl.pending = nil
+	}
+	log.Printf("Synchronization with replica succeeded, %d keys", len(entries))
+}
+
+// ---------------- replica 端 ----------------
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func replicaofCommand(c *client, args []string) {
+	w := c.w
+	if len(args) != 3 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		if masterHost != "" {
+			replicationUnsetMaster()
+			log.Printf("MASTER MODE enabled")
+		}
+		w.writeOK()
+		return
+	}
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port <= 0 || port > 65535 {
+		w.writeError("ERR Invalid master port")
+		return
+	}
+	if masterHost == args[1] && masterPort == port {
+		w.writeSimple("OK Already connected to specified master")
+		return
+	}
+	replicationSetMaster(args[1], port)
+	log.Printf("REPLICAOF %s:%d enabled", args[1], port)
+	w.writeOK()
+}
+
+func replicationSetMaster(host string, port int) {
+	masterHost, masterPort = host, port
+	replEpoch++
+	if masterLink != nil {
+		masterLink.Close()
+		masterLink = nil
+	}
+	replState = replConnect
+	go replicationLoop(replEpoch)
+}
+
+// replicationUnsetMaster 提升为 master: 换一个新的 replid,
+// 旧的留作 replID2, 原来跟着同一个 master 的 replica 仍然可以部分重同步
+func replicationUnsetMaster() {
+	masterHost, masterPort = "", 0
+	replEpoch++
+	if masterLink != nil {
+		masterLink.Close()
+		masterLink = nil
+	}
+	replState = replNone
+	replID2 = replID
+	secondReplOffset = masterReplOffset + 1
+	replID = newReplID()
+	// 下游 replica 收到的是原 master 的流, 当前库未必和 aofSelectedDB 一致, 下次写入强制 SELECT
+	aofSelectedDB = -1
+}
+
+// replicationLoop 维持到 master 的连接, 断开后重连, REPLICAOF 变化后退出
+func replicationLoop(epoch int) {
+	for {
+		dbMu.Lock()
+		stale := epoch != replEpoch
+		if !stale {
+			replState = replConnect
+		}
+		addr := net.JoinHostPort(masterHost, strconv.Itoa(masterPort))
+		dbMu.Unlock()
+		if stale {
+			return
+		}
+		if err := syncWithMaster(epoch, addr); err != nil {
+			log.Printf("Replication with %s: %v", addr, err)
+		}
+		time.Sleep(replReconnectWait)
+	}
+}
+
+// masterReader 按行或按长度读 master 的回复
+type masterReader struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (m *masterReader) send(args ...string) error {
+	m.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	_, err := m.conn.Write(encodeCommand(args))
+	return err
+}
+
+func (m *masterReader) readLine() (string, error) {
+	m.conn.SetReadDeadline(time.Now().Add(replTimeout))
+	line, err := m.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command 发一条命令并读单行回复, 错误回复转成 error
+func (m *masterReader) command(args ...string) (string, error) {
+	if err := m.send(args...); err != nil {
+		return "", err
+	}
+	line, err := m.readLine()
+	if err == nil && strings.HasPrefix(line, "-") {
+		err = errors.New(line[1:])
+	}
+	return line, err
+}
+
+func syncWithMaster(epoch int, addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, replTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dbMu.Lock()
+	if epoch != replEpoch {
+		dbMu.Unlock()
+		return nil
+	}
+	masterLink = conn
+	replState = replConnecting
+	psyncID, psyncOffset := replID, strconv.FormatInt(masterReplOffset+1, 10)
+	dbMu.Unlock()
+
+	m := &masterReader{conn: conn, r: bufio.NewReaderSize(conn, 64*1024)}
+	// master 要求认证时 PING 会回 NOAUTH, 同 Redis 视为连接正常
+	if _, err := m.command("PING"); err != nil && !strings.HasPrefix(err.Error(), "NOAUTH") && !strings.HasPrefix(err.Error(), "NOPERM") {
+		return err
+	}
+	if masterauth != "" {
+		auth := []string{"AUTH", masterauth}
+		if masteruser != "" {
+			auth = []string{"AUTH", masteruser, masterauth}
+		}
+		if _, err := m.command(auth...); err != nil {
+			return fmt.Errorf("unable to AUTH to MASTER: %v", err)
+		}
+	}
+	// 这两条出错不影响同步, 同 Redis
+	m.command("REPLCONF", "listening-port", strconv.Itoa(port))
+	m.command("REPLCONF", "capa", "psync2")
+
+	line, err := m.command("PSYNC", psyncID, psyncOffset)
+	if err != nil {
+		return err
+	}
+	switch fields := strings.Fields(line); {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		off, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC reply %q", line)
+		}
+		if err := fullSyncFromMaster(epoch, m, fields[1], off); err != nil {
+			return err
+		}
+	case len(fields) >= 1 && fields[0] == "+CONTINUE":
+		dbMu.Lock()
+		if len(fields) == 2 && fields[1] != replID {
+			// master 换过 replid (例如它自己被提升过), 沿用新的, 旧的留作 replID2
+			replID2, secondReplOffset, replID = replID, masterReplOffset+1, fields[1]
+		}
+		if backlog == nil {
+			backlog = newReplBacklog(replBacklogSize)
+		}
+		dbMu.Unlock()
+		log.Printf("Successful partial resynchronization with master")
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %q", line)
+	}
+
+	dbMu.Lock()
+	if epoch != replEpoch {
+		dbMu.Unlock()
+		return nil
+	}
+	replState = replConnected
+	masterLastIO = time.Now()
+	dbMu.Unlock()
+	return replicaApply(epoch, m)
+}
+
+// fullSyncFromMaster 读取 master 发来的快照, 清空本地数据后加载
+func fullSyncFromMaster(epoch int, m *masterReader, id string, off int64) error {
+	// 快照生成期间 master 可能发 \n 保活
+	var line string
+	for line == "" {
+		var err error
+		if line, err = m.readLine(); err != nil {
+			return err
+		}
+	}
+	if line[0] != '$' {
+		return fmt.Errorf("bad bulk payload header %q", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("bad bulk payload header %q", line)
+	}
+	payload := make([]byte, size)
+	m.conn.SetReadDeadline(time.Now().Add(replTimeout))
+	if _, err := io.ReadFull(m.r, payload); err != nil {
+		return err
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if epoch != replEpoch {
+		return nil
+	}
+	flushKeyspace()
+	loading = true
+	_, keys, err := loadRDB(bytes.NewReader(payload))
+	loading = false
+	if err != nil {
+		return fmt.Errorf("load snapshot from master: %w", err)
+	}
+	replID, masterReplOffset = id, off
+	masterDB = 0
+	replID2, secondReplOffset = strings.Repeat("0", 40), -1
+	backlog = newReplBacklog(replBacklogSize)
+	// 本地 AOF 还是同步前的数据, 重写一次让它和新数据集一致
+	startAOFRewrite()
+	log.Printf("MASTER <-> REPLICA sync: loaded %d keys from %d bytes", keys, size)
+	return nil
+}
+
+// replicaApply 执行 master 推来的复制流, 并原样转发进自己的 backlog
+func replicaApply(epoch int, m *masterReader) error {
+	mc := &client{master: true, w: newReplyWriter(netpoll.NewWriter(io.Discard))}
+	dbMu.Lock()
+	mc.db = dbs[masterDB]
+	dbMu.Unlock()
+	// 从 m.r 而不是 conn 读: 握手时 bufio 里可能已经缓冲了复制流的开头
+	var dec decoder
+	chunk := make([]byte, 64*1024)
+	for {
+		m.conn.SetReadDeadline(time.Now().Add(replTimeout))
+		n, err := m.r.Read(chunk)
+		if err != nil {
+			return err
+		}
+		dec.feed(chunk[:n])
+		for {
+			start := dec.pos
+			args, err := dec.next()
+			if err == errIncomplete {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			raw := dec.buf[start:dec.pos]
+
+			dbMu.Lock()
+			if epoch != replEpoch {
+				dbMu.Unlock()
+				return nil
+			}
+			if len(args) > 0 {
+				execCommandLocked(mc, args)
+				mc.w.flush()
+				masterDB = mc.db.id
+			}
+			feedReplicationStream(raw)
+			masterLastIO = time.Now()
+			dbMu.Unlock()
+		}
+	}
+}
+
+// replicationCron 每秒执行: master 定期往复制流里发 PING, replica 回 ACK 并检测超时
+func replicationCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		dbMu.Lock()
+		replCronLoops++
+		if masterHost == "" && len(replicas) > 0 && replCronLoops%int(replPingPeriod/time.Second) == 0 {
+			feedReplicationStream(encodeCommand([]string{"PING"}))
+		}
+		var link net.Conn
+		var ack []byte
+		if masterHost != "" && replState == replConnected && masterLink != nil {
+			if time.Since(masterLastIO) > replTimeout {
+				log.Printf("MASTER timeout: no data nor PING received")
+				masterLink.Close()
+			} else {
+				link = masterLink
+				ack = encodeCommand([]string{"REPLCONF", "ACK", strconv.FormatInt(masterReplOffset, 10)})
+			}
+		}
+		dbMu.Unlock()
+		if link != nil {
+			link.SetWriteDeadline(time.Now().Add(time.Second))
+			link.Write(ack)
+		}
+	}
+}
+
+// isReadonlyReplica 判断当前是否应该拒绝普通客户端的写命令
+func isReadonlyReplica(c *client) bool {
+	return masterHost != "" && !c.master && !loading
+}
+
+func infoReplication(b *strings.Builder) {
+	if masterHost == "" {
+		infoField(b, "role", "master")
+	} else {
+		infoField(b, "role", "slave")
+		infoField(b, "master_host", masterHost)
+		infoField(b, "master_port", masterPort)
+		link := "down"
+		if replState == replConnected {
+			link = "up"
+		}
+		infoField(b, "master_link_status", link)
+		lastIO := -1
+		if !masterLastIO.IsZero() {
+			lastIO = int(time.Since(masterLastIO).Seconds())
+		}
+		infoField(b, "master_last_io_seconds_ago", lastIO)
+		infoField(b, "master_sync_in_progress", boolInt(replState == replConnecting))
+		infoField(b, "slave_repl_offset", masterReplOffset)
+		infoField(b, "slave_read_only", 1)
+	}
+	infoField(b, "connected_slaves", len(replicas))
+	for i, r := range replicas {
+		ip := "?"
+		if r.conn != nil {
+			if host, _, err := net.SplitHostPort(r.conn.RemoteAddr().String()); err == nil {
+				ip = host
+			}
+		}
+		state := "wait_bgsave"
+		if r.repl.state == replicaOnline {
+			state = "online"
+		}
+		fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, ip, r.repl.listeningPort, state, r.repl.ackOffset, int(time.Since(r.repl.ackTime).Seconds()))
+	}
+	infoField(b, "master_replid", replID)
+	infoField(b, "master_replid2", replID2)
+	infoField(b, "master_repl_offset", masterReplOffset)
+	infoField(b, "second_repl_offset", secondReplOffset)
+	if backlog != nil {
+		infoField(b, "repl_backlog_active", 1)
+		infoField(b, "repl_backlog_size", len(backlog.buf))
+		infoField(b, "repl_backlog_first_byte_offset", backlog.offset)
+		infoField(b, "repl_backlog_histlen", backlog.histlen)
+	} else {
+		infoField(b, "repl_backlog_active", 0)
+	}
+}
@@ -0,0 +1,304 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplBacklogWrap(t *testing.T) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	saved := masterReplOffset
+	defer func() { masterReplOffset = saved }()
+
+	masterReplOffset = 100
+	b := newReplBacklog(8)
+	feed := func(s string) {
+		masterReplOffset += int64(len(s))
+		b.write([]byte(s))
+	}
+	feed("abcde")
+	if !b.covers(101) || b.covers(100) || string(b.from(103)) != "cde" {
+		t.Fatalf("offset=%d histlen=%d from(103)=%q", b.offset, b.histlen, b.from(103))
+	}
+	// 绕回开头, 最早的数据被覆盖
+	feed("fghij")
+	if b.offset != 103 || b.covers(102) || string(b.from(103)) != "cdefghij" || string(b.from(110)) != "j" {
+		t.Fatalf("offset=%d from(103)=%q", b.offset, b.from(103))
+	}
+	// 正好追上最新偏移: 可以续传, 只是没有数据要补
+	if !b.covers(111) || len(b.from(111)) != 0 || b.covers(112) {
+		t.Fatal("bad coverage at the end of the backlog")
+	}
+}
+
+// waitFor 轮询直到 cond (在 dbMu 下执行) 为真
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dbMu.Lock()
+		ok := cond()
+		dbMu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPsyncFullAndPartial(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SET", "k", "v")
+
+	r1 := newTestClient()
+	expectReply(t, r1, "+OK\r\n", "REPLCONF", "listening-port", "7001")
+	reply := r1.do("PSYNC", "?", "-1")
+	var id string
+	var off int64
+	if _, err := fmt.Sscanf(reply, "+FULLRESYNC %s %d\r\n", &id, &off); err != nil {
+		t.Fatalf("PSYNC reply %q", reply)
+	}
+	defer func() {
+		dbMu.Lock()
+		removeReplica(r1.client)
+		dbMu.Unlock()
+	}()
+	waitFor(t, "full sync", func() bool { return r1.repl.state == replicaOnline })
+
+	// 快照之后的写命令跟在 RDB 后面推给 replica
+	tc.do("SET", "after", "1")
+	waitFor(t, "propagation", func() bool { return masterReplOffset > off })
+	time.Sleep(20 * time.Millisecond)
+	got := r1.drain()
+	// 全量同步之后的第一条写命令前面带 SELECT, replica 不用假设自己当前在哪个库
+	setCmd := string(encodeCommand([]string{"SELECT", "0"})) + string(encodeCommand([]string{"SET", "after", "1"}))
+	if !strings.HasPrefix(got, "$") || !strings.Contains(got, "REDIS0009") || !strings.HasSuffix(got, setCmd) {
+		t.Fatalf("unexpected replication stream %q", got)
+	}
+	size, _ := strconv.Atoi(got[1:strings.Index(got, "\r\n")])
+	rdb := got[strings.Index(got, "\r\n")+2:][:size]
+	resetKeyspace()
+	if _, keys, err := loadRDB(strings.NewReader(rdb)); err != nil || keys != 1 {
+		t.Fatalf("snapshot: %d keys, %v", keys, err)
+	}
+
+	// 第二个 replica 从 off+1 续传, 只会收到之后的写命令
+	r2 := newTestClient()
+	expectReply(t, r2, "+CONTINUE "+id+"\r\n", "PSYNC", id, strconv.FormatInt(off+1, 10))
+	defer func() {
+		dbMu.Lock()
+		removeReplica(r2.client)
+		dbMu.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if got := r2.drain(); got != setCmd {
+		t.Fatalf("partial resync sent %q", got)
+	}
+	// 未知的 replid 只能全量同步
+	r3 := newTestClient()
+	if got := r3.do("PSYNC", "0123", "1"); !strings.HasPrefix(got, "+FULLRESYNC") {
+		t.Fatalf("PSYNC with unknown replid: %q", got)
+	}
+	dbMu.Lock()
+	removeReplica(r3.client)
+	dbMu.Unlock()
+
+	r1.do("REPLCONF", "ACK", strconv.FormatInt(masterReplOffset, 10))
+	info := tc.do("INFO", "replication")
+	for _, want := range []string{"role:master\r\n", "connected_slaves:2\r\n", "slave0:ip=?,port=7001,state=online,offset=" + strconv.FormatInt(masterReplOffset, 10)} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("INFO missing %q:\n%s", want, info)
+		}
+	}
+}
+
+func TestReplicaOutputLimit(t *testing.T) {
+	resetKeyspace()
+	addr := startTestServer(t)
+	r, tc := dialTestServer(t, addr), dialTestServer(t, addr)
+	if got, _ := r.do("PSYNC", "?", "-1"); !strings.HasPrefix(got, "+FULLRESYNC") {
+		t.Fatalf("PSYNC: %q", got)
+	}
+	var rc *client
+	waitFor(t, "full sync", func() bool {
+		for _, c := range clients {
+			if c.repl != nil && c.repl.attached && c.repl.state == replicaOnline {
+				rc = c
+			}
+		}
+		return rc != nil
+	})
+	// 模拟一个一直不读的 replica: 输出缓冲已经到了硬上限
+	rc.outMu.Lock()
+	rc.outFlushing, rc.flushing = replicaHardLimit, true
+	rc.outMu.Unlock()
+
+	tc.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if got, err := tc.do("SET", "k", "v"); got != "+OK\r\n" {
+		t.Fatalf("SET with a stuck replica: %q, %v", got, err)
+	}
+	waitFor(t, "replica drop", func() bool { return len(replicas) == 0 })
+	if got, err := dialTestServer(t, addr).do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("master stopped answering after dropping a replica: %q, %v", got, err)
+	}
+}
+
+func TestHelloRole(t *testing.T) {
+	tc := newTestClient()
+	if got := tc.do("HELLO", "2"); !strings.Contains(got, "$4\r\nrole\r\n$6\r\nmaster\r\n") {
+		t.Fatalf("HELLO on a master: %q", got)
+	}
+	dbMu.Lock()
+	masterHost = "127.0.0.1"
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		masterHost = ""
+		dbMu.Unlock()
+	}()
+	if got := tc.do("HELLO", "2"); !strings.Contains(got, "$4\r\nrole\r\n$7\r\nreplica\r\n") {
+		t.Fatalf("HELLO on a replica: %q", got)
+	}
+}
+
+// fakeMaster 在本地端口上模拟一个 master, 每个连接交给 serve 处理
+func fakeMaster(t *testing.T, serve func(conn net.Conn, r *bufio.Reader)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// readCommand 读一条 RESP 命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var d decoder
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		d.feed([]byte{b})
+		if args, err := d.next(); err != errIncomplete {
+			return args, err
+		}
+	}
+}
+
+func TestReplicaSyncFromMaster(t *testing.T) {
+	resetKeyspace()
+	masterID := strings.Repeat("ab", 20)
+	setA := encodeCommand([]string{"SET", "a", "1"})
+	setB := encodeCommand([]string{"SET", "b", "2"})
+	acks := make(chan string, 16)
+	psyncs := make(chan []string, 4)
+	conns := make(chan net.Conn, 4)
+
+	port := fakeMaster(t, func(conn net.Conn, r *bufio.Reader) {
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "PING":
+				conn.Write([]byte("+PONG\r\n"))
+			case "REPLCONF":
+				if strings.EqualFold(args[1], "ACK") {
+					acks <- args[2]
+				} else {
+					conn.Write([]byte("+OK\r\n"))
+				}
+			case "PSYNC":
+				psyncs <- args
+				conns <- conn
+				if args[1] == "?" || args[1] != masterID {
+					var rdb bytes.Buffer
+					writeRDB(&rdb, []snapshotEntry{{key: "k", obj: newStringObject("v")}}, false)
+					fmt.Fprintf(conn, "+FULLRESYNC %s 100\r\n\n$%d\r\n", masterID, rdb.Len())
+					conn.Write(rdb.Bytes())
+					conn.Write(setA)
+				} else {
+					fmt.Fprintf(conn, "+CONTINUE %s\r\n", masterID)
+					conn.Write(setB)
+				}
+			}
+		}
+	})
+
+	tc := newTestClient()
+	expectReply(t, tc, "+OK\r\n", "REPLICAOF", "127.0.0.1", strconv.Itoa(port))
+	defer tc.do("REPLICAOF", "NO", "ONE")
+	waitFor(t, "full sync", func() bool {
+		o, ok := dbs[0].store.Get("a")
+		return ok && o.str() == "1"
+	})
+	expectReply(t, tc, "$1\r\nv\r\n", "GET", "k")
+	expectReply(t, tc, "-READONLY You can't write against a read only replica.\r\n", "SET", "x", "y")
+
+	wantOffset := 100 + int64(len(setA))
+	info := tc.do("INFO", "replication")
+	for _, want := range []string{"role:slave\r\n", "master_link_status:up\r\n", "slave_repl_offset:" + strconv.FormatInt(wantOffset, 10), "master_replid:" + masterID} {
+		if !strings.Contains(info, want) {
+			t.Fatalf("INFO missing %q:\n%s", want, info)
+		}
+	}
+	select {
+	case ack := <-acks:
+		if ack != strconv.FormatInt(wantOffset, 10) {
+			t.Fatalf("ACK %s, want %d", ack, wantOffset)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no REPLCONF ACK from replica")
+	}
+
+	// 断线后用 replid 和 offset+1 续传
+	<-psyncs
+	(<-conns).Close()
+	select {
+	case args := <-psyncs:
+		if args[1] != masterID || args[2] != strconv.FormatInt(wantOffset+1, 10) {
+			t.Fatalf("reconnect PSYNC %q", args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not reconnect")
+	}
+	waitFor(t, "partial resync", func() bool {
+		_, ok := dbs[0].store.Get("b")
+		return ok
+	})
+	expectReply(t, tc, "$1\r\n1\r\n", "GET", "a")
+
+	expectReply(t, tc, "+OK\r\n", "REPLICAOF", "NO", "ONE")
+	// 全量同步后触发的 AOF 重写不要影响后面的测试
+	waitRewrite(t)
+	expectReply(t, tc, "+OK\r\n", "SET", "x", "y")
+	if info := tc.do("INFO", "replication"); !strings.Contains(info, "master_replid2:"+masterID) {
+		t.Fatalf("promoted replica should keep the old replid:\n%s", info)
+	}
+}
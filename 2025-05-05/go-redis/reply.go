@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/netpoll"
+)
+
+const (
+	resp2 = 2
+	resp3 = 3
+)
+
+// replyWriter 把回复编码成 RESP2 或 RESP3。
+// RESP3 独有的类型 (map/set/double/bool/null/verbatim/push) 在 RESP2 下退化成
+// 旧客户端能理解的形式, 命令实现只需要按语义调用, 不关心连接用的哪个协议。
+type replyWriter struct {
+	w     netpoll.Writer
+	proto int
+}
+
+func newReplyWriter(w netpoll.Writer) *replyWriter {
+	return &replyWriter{w: w, proto: resp2}
+}
+
+func (r *replyWriter) header(prefix byte, n int) {
+	r.w.WriteByte(prefix)
+	r.w.WriteString(strconv.Itoa(n))
+	r.w.WriteString("\r\n")
+}
+
+func (r *replyWriter) writeOK() { r.w.WriteString("+OK\r\n") }
+
+// writeSimple 写简单字符串, s 不能包含 \r\n
+func (r *replyWriter) writeSimple(s string) {
+	r.w.WriteByte('+')
+	r.w.WriteString(s)
+	r.w.WriteString("\r\n")
+}
+
+// writeError 写错误, msg 需带错误前缀, 如 "ERR xxx"、"WRONGTYPE xxx"。
+// 错误里可能带着用户输入 (命令名、选项等), 同 Redis 的 addReplyErrorLength 把换行替换成空格,
+// 免得客户端把后半截当成另一条回复
+func (r *replyWriter) writeError(msg string) {
+	if strings.ContainsAny(msg, "\r\n") {
+		msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	}
+	r.w.WriteByte('-')
+	r.w.WriteString(msg)
+	r.w.WriteString("\r\n")
+}
+
+func (r *replyWriter) writeInt(n int64) {
+	r.w.WriteByte(':')
+	r.w.WriteString(strconv.FormatInt(n, 10))
+	r.w.WriteString("\r\n")
+}
+
+func (r *replyWriter) writeBulk(s string) {
+	r.header('$', len(s))
+	r.w.WriteString(s)
+	r.w.WriteString("\r\n")
+}
+
+// writeBulks 写一个由 bulk string 组成的数组
+func (r *replyWriter) writeBulks(ss ...string) {
+	r.writeArray(len(ss))
+	for _, s := range ss {
+		r.writeBulk(s)
+	}
+}
+
+// writeNull 写空值: RESP2 是 null bulk, RESP3 是 _
+func (r *replyWriter) writeNull() {
+	if r.proto == resp3 {
+		r.w.WriteString("_\r\n")
+		return
+	}
+	r.w.WriteString("$-1\r\n")
+}
+
+// writeNullArray 写空数组 (如 BLPOP 超时、EXEC 被 WATCH 打断): RESP2 是 *-1
+func (r *replyWriter) writeNullArray() {
+	if r.proto == resp3 {
+		r.w.WriteString("_\r\n")
+		return
+	}
+	r.w.WriteString("*-1\r\n")
+}
+
+func (r *replyWriter) writeArray(n int) { r.header('*', n) }
+
+// writeMap 写 n 个 kv 对的 map 头, RESP2 下是 2n 个元素的数组
+func (r *replyWriter) writeMap(n int) {
+	if r.proto == resp3 {
+		r.header('%', n)
+		return
+	}
+	r.header('*', 2*n)
+}
+
+// writeSet 写集合头, RESP2 下是普通数组
+func (r *replyWriter) writeSet(n int) {
+	if r.proto == resp3 {
+		r.header('~', n)
+		return
+	}
+	r.header('*', n)
+}
+
+// writePush 写 push 消息头 (pub/sub 等带外消息), RESP2 下是普通数组
+func (r *replyWriter) writePush(n int) {
+	if r.proto == resp3 {
+		r.header('>', n)
+		return
+	}
+	r.header('*', n)
+}
+
+// writeDouble 写浮点数, RESP2 下是 bulk string
+func (r *replyWriter) writeDouble(f float64) {
+	if r.proto == resp3 {
+		r.w.WriteByte(',')
+		r.w.WriteString(formatFloat(f))
+		r.w.WriteString("\r\n")
+		return
+	}
+	r.writeBulk(formatFloat(f))
+}
+
+// writeBool 写布尔值, RESP2 下是整数 1/0
+func (r *replyWriter) writeBool(b bool) {
+	if r.proto == resp3 {
+		if b {
+			r.w.WriteString("#t\r\n")
+		} else {
+			r.w.WriteString("#f\r\n")
+		}
+		return
+	}
+	if b {
+		r.writeInt(1)
+	} else {
+		r.writeInt(0)
+	}
+}
+
+// writeVerbatim 写带格式的文本 (format 为 3 个字符, 如 "txt"), RESP2 下是 bulk string
+func (r *replyWriter) writeVerbatim(format, s string) {
+	if r.proto == resp3 {
+		r.header('=', len(s)+4)
+		r.w.WriteString(format)
+		r.w.WriteByte(':')
+		r.w.WriteString(s)
+		r.w.WriteString("\r\n")
+		return
+	}
+	r.writeBulk(s)
+}
+
+func (r *replyWriter) flush() error { return r.w.Flush() }
+
+// formatFloat 按 Redis 的习惯格式化浮点数: 最短表示, 无穷写成 inf/-inf
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// 常用错误回复
+const (
+	errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errNotInt    = "ERR value is not an integer or out of range"
+	errSyntax    = "ERR syntax error"
+	errOverflow  = "ERR increment or decrement would overflow"
+)
+
+func errWrongArgs(cmd string) string {
+	return "ERR wrong number of args for '" + cmd + "'"
+}
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func encodeReply(proto int, fn func(w *replyWriter)) string {
+	buf := netpoll.NewLinkBuffer()
+	w := newReplyWriter(buf)
+	w.proto = proto
+	fn(w)
+	w.flush()
+	s, _ := buf.ReadString(buf.Len())
+	return s
+}
+
+func TestReplyWriterProtocols(t *testing.T) {
+	cases := []struct {
+		name         string
+		fn           func(w *replyWriter)
+		resp2, resp3 string
+	}{
+		{"null", func(w *replyWriter) { w.writeNull() }, "$-1\r\n", "_\r\n"},
+		{"null array", func(w *replyWriter) { w.writeNullArray() }, "*-1\r\n", "_\r\n"},
+		{"bool", func(w *replyWriter) { w.writeBool(true) }, ":1\r\n", "#t\r\n"},
+		{"double", func(w *replyWriter) { w.writeDouble(1.5) }, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"inf", func(w *replyWriter) { w.writeDouble(math.Inf(-1)) }, "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"verbatim", func(w *replyWriter) { w.writeVerbatim("txt", "hi") }, "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{"map", func(w *replyWriter) {
+			w.writeMap(1)
+			w.writeBulk("k")
+			w.writeInt(1)
+		}, "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"set", func(w *replyWriter) {
+			w.writeSet(1)
+			w.writeBulk("a")
+		}, "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{"push", func(w *replyWriter) { w.writePush(0) }, "*0\r\n", ">0\r\n"},
+	}
+	for _, c := range cases {
+		if got := encodeReply(resp2, c.fn); got != c.resp2 {
+			t.Errorf("%s RESP2: got %q, want %q", c.name, got, c.resp2)
+		}
+		if got := encodeReply(resp3, c.fn); got != c.resp3 {
+			t.Errorf("%s RESP3: got %q, want %q", c.name, got, c.resp3)
+		}
+	}
+}
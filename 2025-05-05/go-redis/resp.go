@@ -0,0 +1,238 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxInlineLen    = 64 * 1024         // inline 命令单行上限, 同 Redis PROTO_INLINE_MAX_SIZE
+	maxMultibulkLen = 1024 * 1024       // 一条命令最多参数个数
+	maxBulkLen      = 512 * 1024 * 1024 // 单个 bulk 上限 512MB
+)
+
+// errIncomplete 表示缓冲区里还没有一个完整的帧, 需要等更多字节到达
+var errIncomplete = errors.New("incomplete frame")
+
+// protocolError 是不可恢复的协议错误, 回复后应关闭连接
+type protocolError string
+
+func (e protocolError) Error() string { return "Protocol error: " + string(e) }
+
+// decoder 是增量 RESP 解析器:
+// 每次 feed 追加新到达的字节, next 按帧取出命令;
+// 半包留在 buf 里, 等下一次 feed 再继续解析。
+// multibulk 半包已经解析出的参数也保留下来, 下次从断开的位置接着解析, 不再从帧头重来。
+type decoder struct {
+	buf []byte
+	pos int // buf[pos:] 是尚未消费的字节, 即当前帧的开头
+
+	args []string // 当前 multibulk 帧已经取出的参数
+	left int      // 当前 multibulk 帧还差几个参数, 0 表示不在帧中间
+	off  int      // 下一个参数从 buf[off:] 开始
+}
+
+func (d *decoder) feed(p []byte) {
+	// 前面消费掉的空间回收一下, 避免 buf 无限增长
+	if d.pos > 0 && d.pos == len(d.buf) {
+		d.buf, d.pos = d.buf[:0], 0
+	} else if d.pos > len(d.buf)/2 {
+		n := copy(d.buf, d.buf[d.pos:])
+		if d.left > 0 {
+			d.off -= d.pos
+		}
+		d.buf, d.pos = d.buf[:n], 0
+	}
+	d.buf = append(d.buf, p...)
+}
+
+// buffered 返回还没解析完的字节数
+func (d *decoder) buffered() int { return len(d.buf) - d.pos }
+
+// next 解析出下一条命令。
+// 返回 errIncomplete 时 pos 不动 (buf[pos:] 仍是完整的帧开头); 空命令 (空行 / *0) 返回 nil, nil。
+func (d *decoder) next() ([]string, error) {
+	if d.left == 0 {
+		data := d.buf[d.pos:]
+		if len(data) == 0 {
+			return nil, errIncomplete
+		}
+		if data[0] != '*' {
+			args, n, err := parseInline(data)
+			if err != nil {
+				return nil, err
+			}
+			d.pos += n
+			return args, nil
+		}
+		line, n, err := readLine(data, maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		cnt, ok := parseInt(line[1:])
+		if !ok || cnt > maxMultibulkLen {
+			return nil, protocolError("invalid multibulk length")
+		}
+		if cnt <= 0 {
+			d.pos += n
+			return nil, nil
+		}
+		// 参数个数是客户端声明的, 预分配设个上限, 免得一个 *N 头就占掉大量内存
+		d.args = make([]string, 0, min(cnt, 1024))
+		d.left, d.off = cnt, d.pos+n
+	}
+	for d.left > 0 {
+		arg, n, err := parseBulk(d.buf[d.off:])
+		if err != nil {
+			if err != errIncomplete {
+				d.args, d.left = nil, 0
+			}
+			return nil, err
+		}
+		d.args = append(d.args, arg)
+		d.off += n
+		d.left--
+	}
+	args := d.args
+	d.pos, d.args = d.off, nil
+	return args, nil
+}
+
+// readLine 读一行 (不含 \r\n), 返回行内容和包括 \r\n 在内的长度
+func readLine(data []byte, limit int) ([]byte, int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		if len(data) > limit {
+			return nil, 0, protocolError("too big line")
+		}
+		return nil, 0, errIncomplete
+	}
+	if i == 0 || data[i-1] != '\r' {
+		return nil, 0, protocolError("expected '\\r\\n'")
+	}
+	return data[:i-1], i + 1, nil
+}
+
+// parseInt 解析 *N / $N 头里的整数
+func parseInt(b []byte) (int, bool) {
+	n, err := strconv.Atoi(string(b))
+	return n, err == nil
+}
+
+// parseBulk 解析 multibulk 里的一个参数 $len\r\n<bytes>\r\n, 返回参数和消费的字节数
+func parseBulk(data []byte) (string, int, error) {
+	line, pos, err := readLine(data, maxInlineLen)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", 0, protocolError(fmt.Sprintf("expected '$', got '%c'", firstByte(line)))
+	}
+	size, ok := parseInt(line[1:])
+	if !ok || size < 0 || size > maxBulkLen {
+		return "", 0, protocolError("invalid bulk length")
+	}
+	// 按 $len 取值, 值本身可以包含 \r\n
+	if len(data)-pos < size+2 {
+		return "", 0, errIncomplete
+	}
+	if data[pos+size] != '\r' || data[pos+size+1] != '\n' {
+		return "", 0, protocolError("bulk length mismatch")
+	}
+	return string(data[pos : pos+size]), pos + size + 2, nil
+}
+
+// parseInline 解析 telnet 风格的 inline 命令, 如 "PING\r\n"、"SET k v\n"
+func parseInline(data []byte) ([]string, int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		if len(data) > maxInlineLen {
+			return nil, 0, protocolError("too big inline request")
+		}
+		return nil, 0, errIncomplete
+	}
+	line := strings.TrimSuffix(string(data[:i]), "\r")
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, 0, err
+	}
+	return args, i + 1, nil
+}
+
+// splitArgs 按空白切分 inline 参数, 支持 "..." 和 '...' 引号
+func splitArgs(line string) ([]string, error) {
+	var (
+		args []string
+		cur  strings.Builder
+	)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			break
+		}
+		cur.Reset()
+		switch q := line[i]; q {
+		case '"', '\'':
+			i++
+			closed := false
+			for i < len(line) {
+				ch := line[i]
+				if ch == '\\' && q == '"' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						cur.WriteByte('\n')
+					case 'r':
+						cur.WriteByte('\r')
+					case 't':
+						cur.WriteByte('\t')
+					default:
+						cur.WriteByte(line[i])
+					}
+				} else if ch == q {
+					closed = true
+					i++
+					break
+				} else {
+					cur.WriteByte(ch)
+				}
+				i++
+			}
+			if !closed || (i < len(line) && line[i] != ' ' && line[i] != '\t') {
+				return nil, protocolError("unbalanced quotes in request")
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				cur.WriteByte(line[i])
+				i++
+			}
+		}
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+func firstByte(b []byte) byte {
+	if len(b) == 0 {
+		return ' '
+	}
+	return b[0]
+}
+
+// encodeCommand 把参数编码成 RESP 数组, 用于 AOF 等场景
+func encodeCommand(args []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n")
+		b.WriteString(a)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecoderPipeline(t *testing.T) {
+	var d decoder
+	d.feed([]byte("*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\nPING hello\r\n"))
+
+	want := [][]string{{"PING"}, {"SET", "k", "a\r\nb"}, {"PING", "hello"}}
+	for i, w := range want {
+		got, err := d.next()
+		if err != nil {
+			t.Fatalf("cmd %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("cmd %d: got %q, want %q", i, got, w)
+		}
+	}
+	if _, err := d.next(); err != errIncomplete {
+		t.Fatalf("expected errIncomplete, got %v", err)
+	}
+}
+
+func TestDecoderPartialReads(t *testing.T) {
+	frame := "*2\r\n$4\r\nECHO\r\n$11\r\nhello world\r\n"
+	var d decoder
+	// 一个字节一个字节地喂, 只有最后一个字节到达时才能解析出命令
+	for i := 0; i < len(frame); i++ {
+		d.feed([]byte{frame[i]})
+		args, err := d.next()
+		if i < len(frame)-1 {
+			if err != errIncomplete {
+				t.Fatalf("byte %d: expected errIncomplete, got %v %q", i, err, args)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(args, []string{"ECHO", "hello world"}) {
+			t.Fatalf("got %q, %v", args, err)
+		}
+	}
+	if d.buffered() != 0 {
+		t.Fatalf("expected empty buffer, %d bytes left", d.buffered())
+	}
+}
+
+func TestDecoderResume(t *testing.T) {
+	var d decoder
+	// 前一条命令消费之后 feed 会把 buf 前移, 半包里已解析的参数要跟着平移
+	d.feed([]byte("*2\r\n$4\r\nECHO\r\n$20\r\naaaaaaaaaaaaaaaaaaaa\r\n*3\r\n$3\r\nSET\r\n$1\r\nk"))
+	if args, err := d.next(); err != nil || !reflect.DeepEqual(args, []string{"ECHO", "aaaaaaaaaaaaaaaaaaaa"}) {
+		t.Fatalf("got %q, %v", args, err)
+	}
+	if _, err := d.next(); err != errIncomplete {
+		t.Fatalf("expected errIncomplete, got %v", err)
+	}
+	if len(d.args) != 1 || d.left != 2 {
+		t.Fatalf("partial state: args %q, left %d", d.args, d.left)
+	}
+	start := d.pos
+	d.feed([]byte("\r\n$1\r\nv\r\n"))
+	if d.pos != 0 || start == 0 {
+		t.Fatalf("buffer was not compacted: pos %d -> %d", start, d.pos)
+	}
+	args, err := d.next()
+	if err != nil || !reflect.DeepEqual(args, []string{"SET", "k", "v"}) {
+		t.Fatalf("got %q, %v", args, err)
+	}
+	if d.buffered() != 0 {
+		t.Fatalf("expected empty buffer, %d bytes left", d.buffered())
+	}
+
+	// 声明了很多参数但还没到达时不按声明的个数预分配
+	d.feed([]byte("*1048576\r\n$1\r\n"))
+	if _, err := d.next(); err != errIncomplete {
+		t.Fatalf("expected errIncomplete, got %v", err)
+	}
+	if cap(d.args) > 1024 {
+		t.Fatalf("preallocated %d args", cap(d.args))
+	}
+}
+
+func TestDecoderInline(t *testing.T) {
+	var d decoder
+	d.feed([]byte("set k \"a b\\n\"\n\r\n"))
+	args, err := d.next()
+	if err != nil || !reflect.DeepEqual(args, []string{"set", "k", "a b\n"}) {
+		t.Fatalf("got %q, %v", args, err)
+	}
+	// 空行被当作空命令跳过
+	args, err = d.next()
+	if err != nil || len(args) != 0 {
+		t.Fatalf("got %q, %v", args, err)
+	}
+}
+
+func TestDecoderProtocolError(t *testing.T) {
+	cases := []string{
+		"*1\r\n+PING\r\n",
+		"*x\r\n",
+		"*1\r\n$2\r\nabc\r\n",
+		"set \"k\r\n",
+	}
+	for _, c := range cases {
+		var d decoder
+		d.feed([]byte(c))
+		if _, err := d.next(); err == nil || err == errIncomplete {
+			t.Errorf("%q: expected protocol error, got %v", c, err)
+		}
+	}
+}
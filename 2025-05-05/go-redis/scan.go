@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var scanSeed = maphash.MakeSeed()
+
+// scanOptions 是 SCAN 家族共用的 MATCH / COUNT 参数
+type scanOptions struct {
+	match string
+	count int
+}
+
+// parseScanOptions 解析 args 里 MATCH / COUNT 之外的选项交给 extra 处理,
+// extra 返回消耗的参数个数, 0 表示不认识
+func parseScanOptions(args []string, extra func(args []string) int) (scanOptions, string) {
+	opts := scanOptions{count: 10}
+	for i := 0; i < len(args); {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "MATCH" && i+1 < len(args):
+			opts.match = args[i+1]
+			i += 2
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				return opts, errNotInt
+			}
+			if n < 1 {
+				return opts, errSyntax
+			}
+			opts.count = int(n)
+			i += 2
+		default:
+			n := 0
+			if extra != nil {
+				n = extra(args[i:])
+			}
+			if n == 0 {
+				return opts, errSyntax
+			}
+			i += n
+		}
+	}
+	return opts, ""
+}
+
+func parseCursor(s string) (uint64, bool) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// scanIndex 给 SCAN 家族用的桶索引: 成员按 hash & mask 分进 2^k 个桶, 桶数随成员个数翻倍或减半。
+// Go map 和 cmap 都不暴露自己的桶, 所以在集合旁边单独维护一份, 增删时同步更新。
+//
+// 扩缩容同 Redis 的 dict 渐进式 rehash: 新表放在 tables[1], 之后每次增删顺带搬一个旧桶,
+// 搬完后新表换到 tables[0], 单次增删的代价不随成员个数增长。
+//
+// 游标用 Redis dictScan 的反向二进制顺序按桶递增 (高位先加), 这样即使两次调用之间
+// 桶数变了, 整个遍历期间一直存在的成员也一定会被返回 (可能重复)。
+type scanIndex struct {
+	tables    [2][][]string // tables[1] 非 nil 表示正在 rehash
+	rehashIdx int           // rehash 时旧表里下一个要搬的桶
+	n         int
+}
+
+// scanRehashEmptyVisits 是一次 rehash 最多跳过的空桶数, 同 Redis 的 empty_visits
+const scanRehashEmptyVisits = 10
+
+func scanBucket(t [][]string, m string) uint64 {
+	return maphash.String(scanSeed, m) & uint64(len(t)-1)
+}
+
+func (x *scanIndex) rehashing() bool { return x.tables[1] != nil }
+
+// add 加入一个新成员, 调用方保证 m 不在索引里
+func (x *scanIndex) add(m string) {
+	if x.rehashing() {
+		x.rehashStep()
+	} else if x.n >= len(x.tables[0]) {
+		x.resize(max(4, 2*len(x.tables[0])))
+	}
+	// rehash 期间新成员直接放进新表
+	t := x.tables[0]
+	if x.rehashing() {
+		t = x.tables[1]
+	}
+	b := scanBucket(t, m)
+	t[b] = append(t[b], m)
+	x.n++
+}
+
+func (x *scanIndex) remove(m string) {
+	if x.n == 0 {
+		return
+	}
+	if x.rehashing() {
+		x.rehashStep()
+	}
+	for _, t := range x.tables {
+		if len(t) == 0 {
+			continue
+		}
+		b := scanBucket(t, m)
+		bk := t[b]
+		for i, v := range bk {
+			if v != m {
+				continue
+			}
+			bk[i] = bk[len(bk)-1]
+			bk[len(bk)-1] = ""
+			t[b] = bk[:len(bk)-1]
+			x.n--
+			if x.n == 0 {
+				x.reset()
+				return
+			}
+			// 装载因子低于 1/4 时缩容到刚好放得下的大小, 保证按桶遍历时空桶不会太多
+			if size := len(x.tables[0]); !x.rehashing() && size > 4 && x.n < size/4 {
+				x.resize(max(4, 1<<bits.Len(uint(x.n))))
+			}
+			return
+		}
+	}
+}
+
+// resize 开始渐进式 rehash 到 size 个桶; 空索引直接换表
+func (x *scanIndex) resize(size int) {
+	if x.n == 0 {
+		x.tables[0] = make([][]string, size)
+		return
+	}
+	x.tables[1] = make([][]string, size)
+	x.rehashIdx = 0
+}
+
+// rehashStep 把旧表的一个非空桶搬进新表, 最多跳过 scanRehashEmptyVisits 个空桶
+func (x *scanIndex) rehashStep() {
+	old, cur := x.tables[0], x.tables[1]
+	for visits := 0; x.rehashIdx < len(old) && visits < scanRehashEmptyVisits; visits++ {
+		bk := old[x.rehashIdx]
+		old[x.rehashIdx] = nil
+		x.rehashIdx++
+		for _, m := range bk {
+			b := scanBucket(cur, m)
+			cur[b] = append(cur[b], m)
+		}
+		if len(bk) > 0 {
+			break
+		}
+	}
+	if x.rehashIdx == len(old) {
+		x.tables = [2][][]string{cur, nil}
+		x.rehashIdx = 0
+	}
+}
+
+func (x *scanIndex) reset() { *x = scanIndex{} }
+
+// clone 复制一份索引, 用于 dup 出来的快照
+func (x *scanIndex) clone() scanIndex {
+	cp := scanIndex{rehashIdx: x.rehashIdx, n: x.n}
+	for i, t := range x.tables {
+		if t == nil {
+			continue
+		}
+		cp.tables[i] = make([][]string, len(t))
+		for j, bk := range t {
+			cp.tables[i][j] = append([]string(nil), bk...)
+		}
+	}
+	return cp
+}
+
+// scanNext 是反向二进制加一: 把 mask 以外的位置 1, 反转, 加一, 再反转回来
+func scanNext(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// scan 从 cursor 指向的桶开始按反向二进制顺序取桶, 凑够 count 个成员就停下,
+// 返回新的游标 (0 表示遍历结束) 和这次取到的成员。装载因子不低于 1/4, 每次调用是 O(count)。
+// rehash 期间同 dictScan: 先取小表的桶, 再取大表里由它展开出来的所有桶
+func (x *scanIndex) scan(cursor uint64, count int) (uint64, []string) {
+	if x.n == 0 {
+		return 0, nil
+	}
+	var out []string
+	for {
+		if !x.rehashing() {
+			mask := uint64(len(x.tables[0]) - 1)
+			out = append(out, x.tables[0][cursor&mask]...)
+			cursor = scanNext(cursor, mask)
+		} else {
+			small, large := x.tables[0], x.tables[1]
+			if len(small) > len(large) {
+				small, large = large, small
+			}
+			m0, m1 := uint64(len(small)-1), uint64(len(large)-1)
+			out = append(out, small[cursor&m0]...)
+			for {
+				out = append(out, large[cursor&m1]...)
+				cursor = scanNext(cursor, m1)
+				if cursor&(m0^m1) == 0 {
+					break
+				}
+			}
+		}
+		if cursor == 0 || len(out) >= count {
+			return cursor, out
+		}
+	}
+}
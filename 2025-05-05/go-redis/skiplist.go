@@ -0,0 +1,304 @@
+package redis
+
+import (
+	"math/rand/v2"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25 // 节点晋升到上一层的概率, 和 Redis 一致
+)
+
+// zskiplist 是按 (score, member) 排序的跳表, 照着 Redis 的 t_zset.c 实现:
+// 每层记录 span (跨过的节点数), 用来在 O(log n) 内算排名
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// less 判断节点是否排在 (score, member) 之前
+func (x *zskiplistNode) less(score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+// insert 插入一个新节点, 调用方保证 member 不存在
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// 新节点没够到的层, span 也要加一
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := range zsl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// delete 删除 (score, member) 对应的节点, 返回是否找到
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		zsl.deleteNode(x, update)
+		return true
+	}
+	return false
+}
+
+// updateScore 修改节点分数; 新位置没变时原地改, 否则删掉重插
+func (zsl *zskiplist) updateScore(curScore float64, member string, newScore float64) *zskiplistNode {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(curScore, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+
+	if (x.backward == nil || x.backward.less(newScore, member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.less(newScore, member)) {
+		x.score = newScore
+		return x
+	}
+	zsl.deleteNode(x, update)
+	return zsl.insert(newScore, member)
+}
+
+// getRank 返回 (score, member) 的排名, 从 1 开始; 不存在返回 0
+func (zsl *zskiplist) getRank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.less(score, member) ||
+				(x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank 返回排名为 rank (从 1 开始) 的节点
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange 返回第一个落在分数区间内的节点
+func (zsl *zskiplist) firstInRange(r *zrangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange 返回最后一个落在分数区间内的节点
+func (zsl *zskiplist) lastInRange(r *zrangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// firstInLexRange / lastInLexRange 只在所有分数相同时有意义, 同 Redis
+func (zsl *zskiplist) firstInLexRange(r *zlexRangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *zskiplist) lastInLexRange(r *zlexRangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x.member) {
+		return nil
+	}
+	return x
+}
+
+// zrangeSpec 是分数区间, minex/maxex 表示开区间 (对应 "(1.5" 的写法)
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *zrangeSpec) gteMin(v float64) bool {
+	if r.minex {
+		return v > r.min
+	}
+	return v >= r.min
+}
+
+func (r *zrangeSpec) lteMax(v float64) bool {
+	if r.maxex {
+		return v < r.max
+	}
+	return v <= r.max
+}
+
+// zlexRangeSpec 是字典序区间; "-" / "+" 分别表示负无穷和正无穷
+type zlexRangeSpec struct {
+	min, max       string
+	minex, maxex   bool
+	minInf, maxInf bool // minInf: min 是 "-"; maxInf: max 是 "+"
+	empty          bool // min 是 "+" 或 max 是 "-", 区间必为空
+}
+
+func (r *zlexRangeSpec) gteMin(v string) bool {
+	switch {
+	case r.empty:
+		return false
+	case r.minInf:
+		return true
+	case r.minex:
+		return v > r.min
+	}
+	return v >= r.min
+}
+
+func (r *zlexRangeSpec) lteMax(v string) bool {
+	switch {
+	case r.empty:
+		return false
+	case r.maxInf:
+		return true
+	case r.maxex:
+		return v < r.max
+	}
+	return v <= r.max
+}
@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+type scored struct {
+	member string
+	score  float64
+}
+
+func cmpScored(a, b scored) int {
+	switch {
+	case a.score < b.score:
+		return -1
+	case a.score > b.score:
+		return 1
+	case a.member < b.member:
+		return -1
+	case a.member > b.member:
+		return 1
+	}
+	return 0
+}
+
+// 随机增删改, 和排好序的切片逐项对比顺序、排名和 span
+func TestSkiplistAgainstSortedSlice(t *testing.T) {
+	zsl := newZskiplist()
+	scores := make(map[string]float64)
+	for i := range 5000 {
+		m := "m" + strconv.Itoa(rand.IntN(300))
+		s := float64(rand.IntN(50))
+		cur, ok := scores[m]
+		switch {
+		case !ok:
+			zsl.insert(s, m)
+			scores[m] = s
+		case i%3 == 0:
+			if !zsl.delete(cur, m) {
+				t.Fatalf("delete %s failed", m)
+			}
+			delete(scores, m)
+		default:
+			zsl.updateScore(cur, m, s)
+			scores[m] = s
+		}
+	}
+
+	var want []scored
+	for m, s := range scores {
+		want = append(want, scored{m, s})
+	}
+	slices.SortFunc(want, cmpScored)
+
+	if zsl.length != len(want) {
+		t.Fatalf("length %d, want %d", zsl.length, len(want))
+	}
+	x := zsl.header.level[0].forward
+	for i, w := range want {
+		if x == nil || x.member != w.member || x.score != w.score {
+			t.Fatalf("position %d: got %+v, want %+v", i, x, w)
+		}
+		if r := zsl.getRank(w.score, w.member); r != i+1 {
+			t.Fatalf("rank of %s: got %d, want %d", w.member, r, i+1)
+		}
+		if n := zsl.byRank(i + 1); n != x {
+			t.Fatalf("byRank(%d) mismatch", i+1)
+		}
+		x = x.level[0].forward
+	}
+	if len(want) > 0 && zsl.tail.member != want[len(want)-1].member {
+		t.Fatal("tail mismatch")
+	}
+}
+
+func TestSkiplistScoreRange(t *testing.T) {
+	zsl := newZskiplist()
+	for i := range 10 {
+		zsl.insert(float64(i), "m"+strconv.Itoa(i))
+	}
+	r := &zrangeSpec{min: 3, max: 7, minex: true}
+	if x := zsl.firstInRange(r); x == nil || x.score != 4 {
+		t.Fatalf("firstInRange: %+v", x)
+	}
+	if x := zsl.lastInRange(r); x == nil || x.score != 7 {
+		t.Fatalf("lastInRange: %+v", x)
+	}
+	if x := zsl.firstInRange(&zrangeSpec{min: 20, max: 30}); x != nil {
+		t.Fatalf("expected empty range, got %+v", x)
+	}
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 慢查询日志, 同 Redis 的 SLOWLOG: 执行时间超过 slowlogLogSlowerThan 的命令记进一个
+// 定长的队列, 最新的在前, 超过 slowlogMaxLen 时丢掉最旧的
+const (
+	slowlogMaxArgc   = 32  // 每条记录最多保存的参数个数
+	slowlogMaxArgLen = 128 // 每个参数最多保存的字节数
+)
+
+var (
+	// 以下都由 dbMu 保护
+	slowlogLogSlowerThan int64 = 10000 // 微秒, 负数表示关闭, 0 表示记录所有命令
+	slowlogMaxLen              = 128
+	slowlog              []slowlogEntry
+	slowlogNextID        int64
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     int64 // 开始执行的 unix 秒
+	duration int64 // 微秒
+	args     []string
+	addr     string
+	name     string
+}
+
+// redactArgs 隐去 AUTH / HELLO AUTH / CONFIG SET / MIGRATE AUTH 里的密码和 ACL SETUSER 的规则,
+// 给慢查询日志和 MONITOR 用
+func redactArgs(cmd string, args []string) []string {
+	switch cmd {
+	case "AUTH":
+		out := []string{args[0]}
+		for range args[1:] {
+			out = append(out, "(redacted)")
+		}
+		return out
+	case "HELLO":
+		out := append([]string(nil), args...)
+		for i := 1; i < len(out); i++ {
+			if strings.EqualFold(out[i], "AUTH") && i+2 < len(out) {
+				out[i+1], out[i+2] = "(redacted)", "(redacted)"
+				i += 2
+			}
+		}
+		return out
+	case "CONFIG":
+		out := append([]string(nil), args...)
+		if len(out) > 2 && strings.EqualFold(out[1], "SET") {
+			for i := 2; i+1 < len(out); i += 2 {
+				if name := strings.ToLower(out[i]); name == "requirepass" || name == "masterauth" {
+					out[i+1] = "(redacted)"
+				}
+			}
+		}
+		return out
+	case "ACL":
+		// 同 Redis, SETUSER 的每条规则都隐去, 不去分辨哪条是密码
+		out := append([]string(nil), args...)
+		if len(out) > 2 && strings.EqualFold(out[1], "SETUSER") {
+			for i := 3; i < len(out); i++ {
+				out[i] = "(redacted)"
+			}
+		}
+		return out
+	case "MIGRATE":
+		// KEYS 之后都是 key, 不再当作选项
+		out := append([]string(nil), args...)
+		for i := 6; i < len(out) && !strings.EqualFold(out[i], "KEYS"); i++ {
+			switch {
+			case strings.EqualFold(out[i], "AUTH") && i+1 < len(out):
+				out[i+1] = "(redacted)"
+				i++
+			case strings.EqualFold(out[i], "AUTH2") && i+2 < len(out):
+				out[i+1], out[i+2] = "(redacted)", "(redacted)"
+				i += 2
+			}
+		}
+		return out
+	}
+	return args
+}
+
+// slowlogPushEntryIfNeeded 在命令执行完后调用, 执行时间超过阈值时记一条
+func slowlogPushEntryIfNeeded(c *client, cmd string, args []string, start time.Time, d time.Duration) {
+	us := d.Microseconds()
+	if slowlogLogSlowerThan < 0 || us < slowlogLogSlowerThan {
+		return
+	}
+	args = redactArgs(cmd, args)
+	n := min(len(args), slowlogMaxArgc)
+	saved := make([]string, n)
+	for i := range n {
+		a := args[i]
+		if i == slowlogMaxArgc-1 && len(args) > slowlogMaxArgc {
+			a = "... (" + strconv.Itoa(len(args)-slowlogMaxArgc+1) + " more arguments)"
+		} else if len(a) > slowlogMaxArgLen {
+			a = a[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(a)-slowlogMaxArgLen) + " more bytes)"
+		}
+		saved[i] = a
+	}
+	e := slowlogEntry{id: slowlogNextID, time: start.Unix(), duration: us, args: saved, addr: c.addr, name: c.name}
+	slowlogNextID++
+	slowlog = append([]slowlogEntry{e}, slowlog...)
+	if len(slowlog) > slowlogMaxLen {
+		slowlog = slowlog[:slowlogMaxLen]
+	}
+}
+
+// SLOWLOG GET [count] | LEN | RESET
+func slowlogCommand(c *client, args []string) {
+	w := c.w
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "GET" && len(args) <= 3:
+		count := 10
+		if len(args) == 3 {
+			n, ok := parseInt64(args[2])
+			if !ok || n < -1 {
+				w.writeError("ERR count should be greater than or equal to -1")
+				return
+			}
+			if n == -1 {
+				n = int64(len(slowlog))
+			}
+			count = int(min(n, int64(len(slowlog))))
+		}
+		entries := slowlog[:min(count, len(slowlog))]
+		w.writeArray(len(entries))
+		for _, e := range entries {
+			w.writeArray(6)
+			w.writeInt(e.id)
+			w.writeInt(e.time)
+			w.writeInt(e.duration)
+			w.writeBulks(e.args...)
+			w.writeBulk(e.addr)
+			w.writeBulk(e.name)
+		}
+	case sub == "LEN" && len(args) == 2:
+		w.writeInt(int64(len(slowlog)))
+	case sub == "RESET" && len(args) == 2:
+		slowlog = nil
+		w.writeOK()
+	case sub == "GET" || sub == "LEN" || sub == "RESET":
+		w.writeError(errWrongArgs("SLOWLOG|" + sub))
+	default:
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try SLOWLOG HELP.")
+	}
+}
@@ -0,0 +1,406 @@
+package redis
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// streamID 是 stream 条目的 ID: <毫秒时间戳>-<同一毫秒内的序号>
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || id.ms == o.ms && id.seq < o.seq
+}
+
+func (id streamID) compare(o streamID) int {
+	switch {
+	case id.less(o):
+		return -1
+	case o.less(id):
+		return 1
+	}
+	return 0
+}
+
+// incr 返回紧接着的下一个 ID, 已经是最大值时 ok 为 false
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// decr 返回紧挨着的上一个 ID, 已经是 0-0 时 ok 为 false
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+const errInvalidStreamID = "ERR Invalid stream ID specified as stream command argument"
+
+// parseStreamID 解析 <ms>-<seq>, 只给了 <ms> 时序号取 missingSeq
+func parseStreamID(s string, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms, seq}, true
+}
+
+// parseRangeID 解析 XRANGE 一类命令的区间端点: - / + 是最小 / 最大 ID, ( 前缀表示开区间,
+// 只给毫秒时起点的序号补 0、终点补最大值
+func parseRangeID(s string, start bool) (streamID, string) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	var id streamID
+	switch s {
+	case "-":
+	case "+":
+		id = maxStreamID
+	default:
+		var missing uint64
+		if !start {
+			missing = math.MaxUint64
+		}
+		var ok bool
+		if id, ok = parseStreamID(s, missing); !ok {
+			return id, errInvalidStreamID
+		}
+	}
+	if exclusive {
+		ok := s != "-" && s != "+"
+		if ok && start {
+			id, ok = id.incr()
+		} else if ok {
+			id, ok = id.decr()
+		}
+		if !ok {
+			if start {
+				return id, "ERR invalid start ID for the interval"
+			}
+			return id, "ERR invalid end ID for the interval"
+		}
+	}
+	return id, ""
+}
+
+// streamEntry 是 stream 里的一个条目, fields 按 field, value 交替排列。
+// fields 创建后不再修改, 快照可以直接共享
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// stream 是 stream 类型的值: 条目按 ID 递增存放在切片里。
+// 头部裁剪只移动切片起点, 之后 append 扩容时被裁掉的部分自然释放
+type stream struct {
+	entries      []streamEntry
+	lastID       streamID // 出现过的最大 ID, 条目被删光后依然保留
+	maxDeletedID streamID // XDEL 删掉的最大 ID
+	entriesAdded int64    // 累计添加过的条目数
+	groups       map[string]*streamGroup
+}
+
+// streamGroup 是消费组
+type streamGroup struct {
+	name        string
+	lastID      streamID // 最后一个投递给组内消费者的 ID
+	entriesRead int64    // 组已经读过的条目数, -1 表示未知
+	pel         map[streamID]*streamNACK
+	consumers   map[string]*streamConsumer
+}
+
+// streamConsumer 是组内的消费者, pel 是组 PEL 里属于它的部分
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // 最近一次尝试读取或认领的毫秒时间
+	activeTime int64 // 最近一次真正读到或认领到条目的毫秒时间, -1 表示从未
+	pel        map[streamID]*streamNACK
+}
+
+// streamNACK 是一条已投递未确认的记录, 同时挂在组和所属消费者的 PEL 上
+type streamNACK struct {
+	consumer      *streamConsumer
+	deliveryTime  int64 // 毫秒时间戳
+	deliveryCount int64
+}
+
+func newStreamObject() *object {
+	return &object{typ: typeStream, val: &stream{groups: make(map[string]*streamGroup)}}
+}
+
+func (s *stream) len() int { return len(s.entries) }
+
+// seek 返回第一个 ID 不小于 id 的条目下标
+func (s *stream) seek(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(id) })
+}
+
+// find 返回 ID 恰好等于 id 的条目下标
+func (s *stream) find(id streamID) (int, bool) {
+	i := s.seek(id)
+	return i, i < len(s.entries) && s.entries[i].id == id
+}
+
+// rangeOf 返回 [start, end] 内的条目, rev 时从 end 往前; count 为 0 表示不限
+func (s *stream) rangeOf(start, end streamID, count int64, rev bool) []streamEntry {
+	if end.less(start) {
+		return nil
+	}
+	lo, hi := s.seek(start), len(s.entries)
+	if next, ok := end.incr(); ok {
+		hi = s.seek(next)
+	}
+	n := int64(hi - lo)
+	if count > 0 && count < n {
+		n = count
+	}
+	res := make([]streamEntry, 0, n)
+	for i := range n {
+		if rev {
+			res = append(res, s.entries[hi-1-int(i)])
+		} else {
+			res = append(res, s.entries[lo+int(i)])
+		}
+	}
+	return res
+}
+
+// nextID 生成自动 ID: 时间没有前进时沿用上一个 ID 的毫秒部分, 序号加一
+func (s *stream) nextID(ms uint64) (streamID, bool) {
+	if ms > s.lastID.ms {
+		return streamID{ms, 0}, true
+	}
+	return s.lastID.incr()
+}
+
+// add 追加一个条目, 调用方保证 id 大于 lastID
+func (s *stream) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id, fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// delete 删除一个条目, 返回是否存在
+func (s *stream) delete(id streamID) bool {
+	i, ok := s.find(id)
+	if !ok {
+		return false
+	}
+	if i == 0 {
+		s.entries[0] = streamEntry{}
+		s.entries = s.entries[1:]
+	} else {
+		s.entries = slices.Delete(s.entries, i, i+1)
+	}
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// streamTrim 是 MAXLEN / MINID 裁剪条件。
+// ~ 近似裁剪在这里也按精确裁剪执行, 只是允许用 LIMIT 限制一次删除的条目数
+type streamTrim struct {
+	maxLen  int64 // -1 表示不按长度裁剪
+	minID   streamID
+	byMinID bool
+	approx  bool
+	limit   int64 // 0 表示不限
+}
+
+// parseStreamTrim 解析 args[i] 开始的 MAXLEN|MINID [=|~] threshold, 返回下一个参数的下标
+func parseStreamTrim(t *streamTrim, args []string, i int) (int, string) {
+	if t.maxLen >= 0 || t.byMinID {
+		return i, "ERR syntax error, MAXLEN and MINID options at the same time are not compatible"
+	}
+	byMinID := strings.EqualFold(args[i], "MINID")
+	i++
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		t.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return i, errSyntax
+	}
+	if byMinID {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return i, errInvalidStreamID
+		}
+		t.minID, t.byMinID = id, true
+	} else {
+		n, ok := parseInt64(args[i])
+		if !ok {
+			return i, errNotInt
+		}
+		if n < 0 {
+			return i, "ERR The MAXLEN argument must be >= 0."
+		}
+		t.maxLen = n
+	}
+	return i + 1, ""
+}
+
+// trim 按条件从头部删除条目, 返回删除的个数
+func (s *stream) trim(t *streamTrim) int64 {
+	n := 0
+	for n < len(s.entries) {
+		if t.limit > 0 && int64(n) >= t.limit {
+			break
+		}
+		if t.byMinID {
+			if !s.entries[n].id.less(t.minID) {
+				break
+			}
+		} else if int64(len(s.entries)-n) <= t.maxLen {
+			break
+		}
+		n++
+	}
+	clear(s.entries[:n])
+	s.entries = s.entries[n:]
+	return int64(n)
+}
+
+// createGroup 创建消费组, 已经存在时返回 nil
+func (s *stream) createGroup(name string, id streamID, entriesRead int64) *streamGroup {
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	g := &streamGroup{
+		name:        name,
+		lastID:      id,
+		entriesRead: entriesRead,
+		pel:         make(map[streamID]*streamNACK),
+		consumers:   make(map[string]*streamConsumer),
+	}
+	s.groups[name] = g
+	return g
+}
+
+// createConsumer 创建消费者, 已经存在时返回 nil
+func (g *streamGroup) createConsumer(name string, now int64) *streamConsumer {
+	if _, ok := g.consumers[name]; ok {
+		return nil
+	}
+	sc := &streamConsumer{name: name, seenTime: now, activeTime: -1, pel: make(map[streamID]*streamNACK)}
+	g.consumers[name] = sc
+	return sc
+}
+
+// deleteConsumer 删除消费者和它名下的待确认记录, 返回删掉的记录数
+func (g *streamGroup) deleteConsumer(sc *streamConsumer) int {
+	for id := range sc.pel {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, sc.name)
+	return len(sc.pel)
+}
+
+// ack 确认一条记录, 返回它是否在 PEL 里
+func (g *streamGroup) ack(id streamID) bool {
+	nack, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(g.pel, id)
+	delete(nack.consumer.pel, id)
+	return true
+}
+
+// assign 把记录交给 sc, 不在 PEL 里时新建
+func (g *streamGroup) assign(id streamID, sc *streamConsumer) *streamNACK {
+	nack, ok := g.pel[id]
+	if !ok {
+		nack = &streamNACK{}
+		g.pel[id] = nack
+	} else if nack.consumer != sc {
+		delete(nack.consumer.pel, id)
+	}
+	nack.consumer = sc
+	sc.pel[id] = nack
+	return nack
+}
+
+// sortedPEL 按 ID 顺序返回 PEL 里的记录 ID
+func sortedPEL(pel map[streamID]*streamNACK) []streamID {
+	ids := make([]streamID, 0, len(pel))
+	for id := range pel {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, streamID.compare)
+	return ids
+}
+
+// sortedConsumers 按名字顺序返回消费者
+func (g *streamGroup) sortedConsumers() []*streamConsumer {
+	cs := make([]*streamConsumer, 0, len(g.consumers))
+	for _, sc := range g.consumers {
+		cs = append(cs, sc)
+	}
+	slices.SortFunc(cs, func(a, b *streamConsumer) int { return strings.Compare(a.name, b.name) })
+	return cs
+}
+
+// sortedGroups 按名字顺序返回消费组
+func (s *stream) sortedGroups() []*streamGroup {
+	gs := make([]*streamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		gs = append(gs, g)
+	}
+	slices.SortFunc(gs, func(a, b *streamGroup) int { return strings.Compare(a.name, b.name) })
+	return gs
+}
+
+// dup 深拷贝 stream, 条目的 fields 不会被修改, 可以共享
+func (s *stream) dup() *stream {
+	cp := &stream{
+		entries:      slices.Clone(s.entries),
+		lastID:       s.lastID,
+		maxDeletedID: s.maxDeletedID,
+		entriesAdded: s.entriesAdded,
+		groups:       make(map[string]*streamGroup, len(s.groups)),
+	}
+	for name, g := range s.groups {
+		ng := cp.createGroup(name, g.lastID, g.entriesRead)
+		for cname, sc := range g.consumers {
+			nc := ng.createConsumer(cname, sc.seenTime)
+			nc.activeTime = sc.activeTime
+		}
+		for id, nack := range g.pel {
+			n := ng.assign(id, ng.consumers[nack.consumer.name])
+			n.deliveryTime, n.deliveryCount = nack.deliveryTime, nack.deliveryCount
+		}
+	}
+	return cp
+}
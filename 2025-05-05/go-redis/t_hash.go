@@ -0,0 +1,316 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// hashValue 是 hash 类型的值, scan 是 HSCAN 用的字段桶索引。
+// 方法都可以在 nil 上调用, 当作空 hash
+type hashValue struct {
+	fields map[string]string
+	scan   scanIndex
+}
+
+func newHashValue(n int) *hashValue {
+	return &hashValue{fields: make(map[string]string, n)}
+}
+
+func newHashObject() *object {
+	return &object{typ: typeHash, val: newHashValue(0)}
+}
+
+func (h *hashValue) len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+func (h *hashValue) get(f string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	v, ok := h.fields[f]
+	return v, ok
+}
+
+// set 设置字段, 返回是否是新字段
+func (h *hashValue) set(f, v string) bool {
+	_, exists := h.fields[f]
+	h.fields[f] = v
+	if !exists {
+		h.scan.add(f)
+	}
+	return !exists
+}
+
+func (h *hashValue) del(f string) bool {
+	if _, ok := h.get(f); !ok {
+		return false
+	}
+	delete(h.fields, f)
+	h.scan.remove(f)
+	return true
+}
+
+func (h *hashValue) each(fn func(f, v string)) {
+	if h == nil {
+		return
+	}
+	for f, v := range h.fields {
+		fn(f, v)
+	}
+}
+
+// hashForWrite 取 key 对应的 hash, 不存在时创建
+func hashForWrite(db *DB, key string) (*hashValue, string) {
+	o, err := db.lookupKeyType(key, typeHash)
+	if err != "" {
+		return nil, err
+	}
+	if o == nil {
+		o = newHashObject()
+		db.setKey(key, o)
+	}
+	return o.hash(), ""
+}
+
+// hashForRead 取 key 对应的 hash, 不存在时返回 nil (可以当空 hash 读)
+func hashForRead(db *DB, key string) (*hashValue, string) {
+	o, err := db.lookupKeyType(key, typeHash)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	return o.hash(), ""
+}
+
+// HSET key field value [field value ...]
+func hsetCommand(c *client, args []string) {
+	w := c.w
+	if len(args) < 4 || len(args)%2 != 0 {
+		w.writeError(errWrongArgs("HSET"))
+		return
+	}
+	h, err := hashForWrite(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	added := 0
+	for i := 2; i < len(args); i += 2 {
+		if h.set(args[i], args[i+1]) {
+			added++
+		}
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeInt(int64(added))
+}
+
+// HGET key field
+func hgetCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if v, ok := h.get(args[2]); ok {
+		w.writeBulk(v)
+	} else {
+		w.writeNull()
+	}
+}
+
+// HMGET key field [field ...]
+func hmgetCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeArray(len(args) - 2)
+	for _, f := range args[2:] {
+		if v, ok := h.get(f); ok {
+			w.writeBulk(v)
+		} else {
+			w.writeNull()
+		}
+	}
+}
+
+// HDEL key field [field ...]
+func hdelCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	deleted := 0
+	for _, f := range args[2:] {
+		if h.del(f) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		// 删空的 hash 连同 key 一起删掉
+		if h.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(deleted))
+}
+
+// HEXISTS key field
+func hexistsCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if _, ok := h.get(args[2]); ok {
+		w.writeInt(1)
+	} else {
+		w.writeInt(0)
+	}
+}
+
+// HLEN key
+func hlenCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeInt(int64(h.len()))
+}
+
+// HGETALL key
+func hgetallCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeMap(h.len())
+	h.each(func(f, v string) {
+		w.writeBulk(f)
+		w.writeBulk(v)
+	})
+}
+
+// HKEYS key
+func hkeysCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeArray(h.len())
+	h.each(func(f, _ string) {
+		w.writeBulk(f)
+	})
+}
+
+// HVALS key
+func hvalsCommand(c *client, args []string) {
+	w := c.w
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeArray(h.len())
+	h.each(func(_, v string) {
+		w.writeBulk(v)
+	})
+}
+
+// HINCRBY key field increment
+func hincrbyCommand(c *client, args []string) {
+	w := c.w
+	incr, ok := parseInt64(args[3])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	h, err := hashForWrite(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var cur int64
+	if v, exists := h.get(args[2]); exists {
+		if cur, ok = parseInt64(v); !ok {
+			w.writeError("ERR hash value is not an integer")
+			return
+		}
+	}
+	if (incr > 0 && cur > math.MaxInt64-incr) || (incr < 0 && cur < math.MinInt64-incr) {
+		w.writeError(errOverflow)
+		return
+	}
+	cur += incr
+	h.set(args[2], strconv.FormatInt(cur, 10))
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeInt(cur)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func hscanCommand(c *client, args []string) {
+	w := c.w
+	cursor, ok := parseCursor(args[2])
+	if !ok {
+		w.writeError("ERR invalid cursor")
+		return
+	}
+	novalues := false
+	opts, perr := parseScanOptions(args[3:], func(rest []string) int {
+		if strings.ToUpper(rest[0]) == "NOVALUES" {
+			novalues = true
+			return 1
+		}
+		return 0
+	})
+	if perr != "" {
+		w.writeError(perr)
+		return
+	}
+	h, err := hashForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+
+	var next uint64
+	var fields []string
+	if h != nil {
+		next, fields = h.scan.scan(cursor, opts.count)
+	}
+
+	var out []string
+	for _, f := range fields {
+		if opts.match != "" && !globMatch(opts.match, f) {
+			continue
+		}
+		out = append(out, f)
+		if !novalues {
+			v, _ := h.get(f)
+			out = append(out, v)
+		}
+	}
+	w.writeArray(2)
+	w.writeBulk(strconv.FormatUint(next, 10))
+	w.writeBulks(out...)
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, ":2\r\n", "HSET", "h", "a", "1", "b", "2")
+	expectReply(t, tc, ":0\r\n", "HSET", "h", "a", "3")
+	expectReply(t, tc, "$1\r\n3\r\n", "HGET", "h", "a")
+	expectReply(t, tc, "*2\r\n$1\r\n2\r\n$-1\r\n", "HMGET", "h", "b", "c")
+	expectReply(t, tc, ":5\r\n", "HINCRBY", "h", "b", "3")
+	expectReply(t, tc, ":1\r\n", "HEXISTS", "h", "b")
+	expectReply(t, tc, ":2\r\n", "HLEN", "h")
+	expectReply(t, tc, ":1\r\n", "HDEL", "h", "a", "zz")
+	expectReply(t, tc, ":1\r\n", "HSET", "h", "s", "x")
+	expectReply(t, tc, "-ERR hash value is not an integer\r\n", "HINCRBY", "h", "s", "1")
+
+	expectReply(t, tc, "+OK\r\n", "SET", "str", "v")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "HGET", "str", "a")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "GET", "h")
+
+	// 删光所有 field 后 key 也随之消失
+	expectReply(t, tc, ":2\r\n", "HDEL", "h", "b", "s")
+	expectReply(t, tc, ":0\r\n", "HLEN", "h")
+	if dbs[0].store.Has("h") {
+		t.Fatal("empty hash should be removed")
+	}
+}
+
+func TestHashAOFReplay(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("HSET", "user", "name", "a\r\nb", "age", "10")
+	tc.do("HINCRBY", "user", "age", "5")
+	tc.do("HDEL", "user", "name")
+
+	replayFromStart(t)
+	expectReply(t, tc, "*2\r\n$3\r\nage\r\n$2\r\n15\r\n", "HGETALL", "user")
+}
+
+func TestHashScanReturnsAllFields(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	for i := range 300 {
+		tc.do("HSET", "big", "f"+strconv.Itoa(i), "v")
+	}
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := tc.do("HSCAN", "big", cursor, "COUNT", "20", "NOVALUES")
+		lines := strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n")
+		cursor = lines[2]
+		for i := 5; i < len(lines); i += 2 {
+			seen[lines[i]] = true
+		}
+		// 遍历过程中删掉一些 field, 不应影响其他 field 被返回
+		tc.do("HDEL", "big", "f"+strconv.Itoa(len(seen)%300))
+		if cursor == "0" {
+			break
+		}
+	}
+	var missing []string
+	for f := range dbs[0].store.Items()["big"].hash().fields {
+		if !seen[f] {
+			missing = append(missing, f)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Fatalf("HSCAN missed fields: %v", missing)
+	}
+}
@@ -0,0 +1,354 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func newListObject() *object {
+	return &object{typ: typeList, val: &deque{}}
+}
+
+func listForRead(db *DB, key string) (*deque, string) {
+	o, err := db.lookupKeyType(key, typeList)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	return o.list(), ""
+}
+
+// listPop 从 list 的一端弹出一个元素, 弹空后删除 key
+func listPop(db *DB, key string, l *deque, left bool) string {
+	var v string
+	if left {
+		v = l.popFront()
+	} else {
+		v = l.popBack()
+	}
+	if l.len() == 0 {
+		db.removeKey(key)
+	}
+	return v
+}
+
+// normalizeRange 把 Redis 风格的 [start, stop] (支持负数下标) 规范化成合法区间,
+// 区间为空时返回 ok=false
+func normalizeRange(start, stop int64, n int) (int, int, bool) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop || start >= int64(n) {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
+// LPUSH / RPUSH key element [element ...]
+func pushCommand(c *client, args []string, left bool) {
+	w := c.w
+	if len(args) < 3 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	o, err := c.db.lookupKeyType(args[1], typeList)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		o = newListObject()
+		c.db.setKey(args[1], o)
+	}
+	l := o.list()
+	for _, v := range args[2:] {
+		if left {
+			l.pushFront(v)
+		} else {
+			l.pushBack(v)
+		}
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	c.db.signalKeyAsReady(args[1])
+	w.writeInt(int64(l.len()))
+}
+
+// LPOP / RPOP key [count]
+func popCommand(c *client, args []string, left bool) {
+	w := c.w
+	if len(args) != 2 && len(args) != 3 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	count := int64(-1)
+	if len(args) == 3 {
+		n, ok := parseInt64(args[2])
+		if !ok || n < 0 {
+			w.writeError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		if count < 0 {
+			w.writeNull()
+		} else {
+			w.writeNullArray()
+		}
+		return
+	}
+	if count < 0 {
+		w.writeBulk(listPop(c.db, args[1], l, left))
+		c.db.signalModifiedKey(args[1])
+		dirty++
+		return
+	}
+	n := min(int(count), l.len())
+	out := make([]string, 0, n)
+	for range n {
+		out = append(out, listPop(c.db, args[1], l, left))
+	}
+	if n > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeBulks(out...)
+}
+
+// LLEN key
+func llenCommand(c *client, args []string) {
+	w := c.w
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(l.len()))
+}
+
+// LRANGE key start stop
+func lrangeCommand(c *client, args []string) {
+	w := c.w
+	start, ok1 := parseInt64(args[2])
+	stop, ok2 := parseInt64(args[3])
+	if !ok1 || !ok2 {
+		w.writeError(errNotInt)
+		return
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeArray(0)
+		return
+	}
+	s, e, ok := normalizeRange(start, stop, l.len())
+	if !ok {
+		w.writeArray(0)
+		return
+	}
+	w.writeBulks(l.rangeOf(s, e)...)
+}
+
+// LINDEX key index
+func lindexCommand(c *client, args []string) {
+	w := c.w
+	idx, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeNull()
+		return
+	}
+	if idx < 0 {
+		idx += int64(l.len())
+	}
+	if idx < 0 || idx >= int64(l.len()) {
+		w.writeNull()
+		return
+	}
+	w.writeBulk(l.at(int(idx)))
+}
+
+// LSET key index element
+func lsetCommand(c *client, args []string) {
+	w := c.w
+	idx, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeError("ERR no such key")
+		return
+	}
+	if idx < 0 {
+		idx += int64(l.len())
+	}
+	if idx < 0 || idx >= int64(l.len()) {
+		w.writeError("ERR index out of range")
+		return
+	}
+	l.set(int(idx), args[3])
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeOK()
+}
+
+// LTRIM key start stop
+func ltrimCommand(c *client, args []string) {
+	w := c.w
+	start, ok1 := parseInt64(args[2])
+	stop, ok2 := parseInt64(args[3])
+	if !ok1 || !ok2 {
+		w.writeError(errNotInt)
+		return
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeOK()
+		return
+	}
+	s, e, ok := normalizeRange(start, stop, l.len())
+	if !ok {
+		c.db.removeKey(args[1])
+	} else {
+		l.filter(func(i int, _ string) bool { return i >= s && i <= e })
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeOK()
+}
+
+// LREM key count element
+// count > 0 从头删前 count 个, count < 0 从尾删, count = 0 全删
+func lremCommand(c *client, args []string) {
+	w := c.w
+	count, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	l, err := listForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if l == nil {
+		w.writeInt(0)
+		return
+	}
+
+	// 先确定要删哪些下标, 再统一过滤
+	drop := make(map[int]bool)
+	if count >= 0 {
+		for i := 0; i < l.len() && (count == 0 || int64(len(drop)) < count); i++ {
+			if l.at(i) == args[3] {
+				drop[i] = true
+			}
+		}
+	} else {
+		for i := l.len() - 1; i >= 0 && int64(len(drop)) < -count; i-- {
+			if l.at(i) == args[3] {
+				drop[i] = true
+			}
+		}
+	}
+	if len(drop) > 0 {
+		l.filter(func(i int, _ string) bool { return !drop[i] })
+		if l.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(len(drop)))
+}
+
+// BLPOP / BRPOP key [key ...] timeout
+// 有非空 list 时立即弹出; 否则把连接挂起, 直到别的客户端 push 或超时
+func bpopCommand(c *client, args []string, left bool) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	if len(args) < 3 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	secs, perr := strconv.ParseFloat(args[len(args)-1], 64)
+	if perr != nil || math.IsNaN(secs) {
+		w.writeError("ERR timeout is not a float or out of range")
+		return
+	}
+	if secs < 0 {
+		w.writeError("ERR timeout is negative")
+		return
+	}
+	// 换算成 time.Duration 会溢出的超时直接拒绝, 否则 deadline 变成负数或乱值
+	if secs >= math.MaxInt64/float64(time.Second) {
+		w.writeError("ERR timeout is out of range")
+		return
+	}
+	keys := args[1 : len(args)-1]
+	for _, key := range keys {
+		l, err := listForRead(c.db, key)
+		if err != "" {
+			w.writeError(err)
+			return
+		}
+		if l == nil {
+			continue
+		}
+		// 传播成非阻塞的 LPOP/RPOP, 重放时不会阻塞
+		v := listPop(c.db, key, l, left)
+		c.db.signalModifiedKey(key)
+		recordAOF(c.db, []string{popName(left), key})
+		w.writeBulks(key, v)
+		return
+	}
+	blockForKeys(c, keys, left, secs)
+}
+
+func popName(left bool) string {
+	if left {
+		return "LPOP"
+	}
+	return "RPOP"
+}
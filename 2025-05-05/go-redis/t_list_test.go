@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, ":3\r\n", "RPUSH", "l", "a", "b", "c")
+	expectReply(t, tc, ":5\r\n", "LPUSH", "l", "y", "x")
+	expectReply(t, tc, "*5\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", "LRANGE", "l", "0", "-1")
+	expectReply(t, tc, "$1\r\nc\r\n", "LINDEX", "l", "-1")
+	expectReply(t, tc, "+OK\r\n", "LSET", "l", "1", "a")
+	expectReply(t, tc, ":2\r\n", "LREM", "l", "0", "a")
+	expectReply(t, tc, "*3\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\nc\r\n", "LRANGE", "l", "0", "10")
+	expectReply(t, tc, "+OK\r\n", "LTRIM", "l", "1", "-1")
+	expectReply(t, tc, "$1\r\nb\r\n", "LPOP", "l")
+	expectReply(t, tc, "*1\r\n$1\r\nc\r\n", "RPOP", "l", "5")
+	expectReply(t, tc, ":0\r\n", "LLEN", "l")
+	expectReply(t, tc, "$-1\r\n", "LPOP", "l")
+	expectReply(t, tc, "-ERR no such key\r\n", "LSET", "l2", "0", "x")
+}
+
+func TestBlockingPopJobQueue(t *testing.T) {
+	resetKeyspace()
+	producer, consumer := newTestClient(), newTestClient()
+
+	done := make(chan string)
+	go func() { done <- consumer.do("BLPOP", "jobs", "other", "0") }()
+
+	// 等 consumer 真正挂起后再投递任务
+	for {
+		dbMu.Lock()
+		n := len(blockingKeys[dbKey{0, "jobs"}])
+		dbMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectReply(t, producer, ":1\r\n", "RPUSH", "jobs", "job-1")
+
+	select {
+	case got := <-done:
+		if want := "*2\r\n$4\r\njobs\r\n$5\r\njob-1\r\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPOP was not woken up by RPUSH")
+	}
+	// 元素被直接交给了阻塞的客户端, list 里不再保留
+	expectReply(t, producer, ":0\r\n", "LLEN", "jobs")
+
+	// 重放后 push 和阻塞弹出相互抵消
+	replayFromStart(t)
+	expectReply(t, producer, ":0\r\n", "LLEN", "jobs")
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	start := time.Now()
+	expectReply(t, tc, "*-1\r\n", "BRPOP", "empty", "0.05")
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("BRPOP returned before timeout")
+	}
+	if len(blockingKeys) != 0 {
+		t.Fatalf("blocked client not cleaned up: %v", blockingKeys)
+	}
+}
+
+func TestBlockingPopTimeoutRange(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "-ERR timeout is out of range\r\n", "BLPOP", "empty", "1e12")
+	expectReply(t, tc, "-ERR timeout is out of range\r\n", "BLPOP", "empty", "inf")
+	expectReply(t, tc, "-ERR timeout is not a float or out of range\r\n", "BLPOP", "empty", "nan")
+	expectReply(t, tc, "-ERR timeout is negative\r\n", "BLPOP", "empty", "-1")
+	if len(blockingKeys) != 0 {
+		t.Fatalf("rejected BLPOP left a blocked client: %v", blockingKeys)
+	}
+	// 范围内的大超时照常阻塞, 直到有数据
+	done := make(chan string)
+	go func() { done <- tc.do("BLPOP", "l", "1e9") }()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case got := <-done:
+		t.Fatalf("BLPOP with a large timeout returned %q without blocking", got)
+	default:
+	}
+	newTestClient().do("RPUSH", "l", "a")
+	select {
+	case got := <-done:
+		if want := "*2\r\n$1\r\nl\r\n$1\r\na\r\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPOP was not woken up by RPUSH")
+	}
+}
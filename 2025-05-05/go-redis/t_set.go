@@ -0,0 +1,518 @@
+package redis
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// setMaxIntsetEntries 对应 set-max-intset-entries: 超过后 intset 转成 hashtable
+const setMaxIntsetEntries = 512
+
+// setValue 是 set 类型的值: 小的全整数集合用 intset, 否则用 hashtable (map)。
+// scan 是 hashtable 编码下 SSCAN 用的桶索引
+type setValue struct {
+	is   *intset
+	dict map[string]struct{}
+	scan scanIndex
+}
+
+func newSetObject() *object {
+	return &object{typ: typeSet, val: &setValue{is: newIntset()}}
+}
+
+// newSetObjectFrom 根据成员选择合适的编码, 用于 SINTERSTORE 等整体构造的场景
+func newSetObjectFrom(members []string) *object {
+	o := newSetObject()
+	s := o.set()
+	for _, m := range members {
+		s.add(m)
+	}
+	return o
+}
+
+// canonicalInt 判断 member 能否无损地存成整数 ("007"、"+1" 这类不行)
+func canonicalInt(s string) (int64, bool) {
+	v, ok := parseInt64(s)
+	if !ok || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+func (s *setValue) encoding() string {
+	if s.is != nil {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+func (s *setValue) len() int {
+	if s.is != nil {
+		return s.is.len()
+	}
+	return len(s.dict)
+}
+
+// convert 把 intset 转成 hashtable, 不可逆
+func (s *setValue) convert() {
+	s.dict = make(map[string]struct{}, s.is.len()+1)
+	for i := range s.is.len() {
+		m := strconv.FormatInt(s.is.get(i), 10)
+		s.dict[m] = struct{}{}
+		s.scan.add(m)
+	}
+	s.is = nil
+}
+
+func (s *setValue) add(m string) bool {
+	if s.is != nil {
+		if v, ok := canonicalInt(m); ok {
+			if !s.is.add(v) {
+				return false
+			}
+			if s.is.len() > setMaxIntsetEntries {
+				s.convert()
+			}
+			return true
+		}
+		s.convert()
+	}
+	if _, ok := s.dict[m]; ok {
+		return false
+	}
+	s.dict[m] = struct{}{}
+	s.scan.add(m)
+	return true
+}
+
+func (s *setValue) remove(m string) bool {
+	if s.is != nil {
+		v, ok := canonicalInt(m)
+		return ok && s.is.remove(v)
+	}
+	if _, ok := s.dict[m]; !ok {
+		return false
+	}
+	delete(s.dict, m)
+	s.scan.remove(m)
+	return true
+}
+
+func (s *setValue) has(m string) bool {
+	if s.is != nil {
+		v, ok := canonicalInt(m)
+		return ok && s.is.find(v)
+	}
+	_, ok := s.dict[m]
+	return ok
+}
+
+func (s *setValue) each(fn func(m string)) {
+	if s.is != nil {
+		for i := range s.is.len() {
+			fn(strconv.FormatInt(s.is.get(i), 10))
+		}
+		return
+	}
+	for m := range s.dict {
+		fn(m)
+	}
+}
+
+func (s *setValue) members() []string {
+	out := make([]string, 0, s.len())
+	s.each(func(m string) { out = append(out, m) })
+	return out
+}
+
+// random 随机取一个成员; intset 直接按下标取, hashtable 只能 O(n) 走到随机位置
+func (s *setValue) random() string {
+	if s.is != nil {
+		return strconv.FormatInt(s.is.get(rand.IntN(s.is.len())), 10)
+	}
+	n := rand.IntN(len(s.dict))
+	for m := range s.dict {
+		if n == 0 {
+			return m
+		}
+		n--
+	}
+	return ""
+}
+
+func setForRead(db *DB, key string) (*setValue, string) {
+	o, err := db.lookupKeyType(key, typeSet)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	return o.set(), ""
+}
+
+// SADD key member [member ...]
+func saddCommand(c *client, args []string) {
+	w := c.w
+	o, err := c.db.lookupKeyType(args[1], typeSet)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		o = newSetObject()
+		c.db.setKey(args[1], o)
+	}
+	added := 0
+	for _, m := range args[2:] {
+		if o.set().add(m) {
+			added++
+		}
+	}
+	if added > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(added))
+}
+
+// SREM key member [member ...]
+func sremCommand(c *client, args []string) {
+	w := c.w
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	removed := 0
+	if s != nil {
+		for _, m := range args[2:] {
+			if s.remove(m) {
+				removed++
+			}
+		}
+		if s.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+	}
+	if removed > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(removed))
+}
+
+// SISMEMBER key member
+func sismemberCommand(c *client, args []string) {
+	w := c.w
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s != nil && s.has(args[2]) {
+		w.writeInt(1)
+	} else {
+		w.writeInt(0)
+	}
+}
+
+// SMISMEMBER key member [member ...]
+func smismemberCommand(c *client, args []string) {
+	w := c.w
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	w.writeArray(len(args) - 2)
+	for _, m := range args[2:] {
+		if s != nil && s.has(m) {
+			w.writeInt(1)
+		} else {
+			w.writeInt(0)
+		}
+	}
+}
+
+// SMEMBERS key
+func smembersCommand(c *client, args []string) {
+	w := c.w
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		w.writeSet(0)
+		return
+	}
+	writeSetMembers(w, s.members())
+}
+
+func writeSetMembers(w *replyWriter, members []string) {
+	w.writeSet(len(members))
+	for _, m := range members {
+		w.writeBulk(m)
+	}
+}
+
+// SCARD key
+func scardCommand(c *client, args []string) {
+	w := c.w
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(s.len()))
+}
+
+// SPOP key [count]
+// 随机结果不能直接写 AOF, 改写成确定性的 SREM
+func spopCommand(c *client, args []string) {
+	w := c.w
+	if len(args) != 2 && len(args) != 3 {
+		w.writeError(errWrongArgs("SPOP"))
+		return
+	}
+	count := int64(-1)
+	if len(args) == 3 {
+		n, ok := parseInt64(args[2])
+		if !ok || n < 0 {
+			w.writeError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		if count < 0 {
+			w.writeNull()
+		} else {
+			w.writeSet(0)
+		}
+		return
+	}
+
+	n := 1
+	if count >= 0 {
+		n = min(int(count), s.len())
+	}
+	popped := make([]string, 0, n)
+	for range n {
+		m := s.random()
+		s.remove(m)
+		popped = append(popped, m)
+	}
+	if s.len() == 0 {
+		c.db.removeKey(args[1])
+	}
+	if len(popped) > 0 {
+		c.db.signalModifiedKey(args[1])
+		recordAOF(c.db, append([]string{"SREM", args[1]}, popped...))
+	}
+	if count < 0 {
+		w.writeBulk(popped[0])
+		return
+	}
+	writeSetMembers(w, popped)
+}
+
+// SRANDMEMBER key [count]
+// count > 0 返回不重复的成员, count < 0 允许重复
+func srandmemberCommand(c *client, args []string) {
+	w := c.w
+	if len(args) != 2 && len(args) != 3 {
+		w.writeError(errWrongArgs("SRANDMEMBER"))
+		return
+	}
+	var count int64
+	hasCount := len(args) == 3
+	if hasCount {
+		n, ok := parseInt64(args[2])
+		if !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		count = n
+	}
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	switch {
+	case s == nil && !hasCount:
+		w.writeNull()
+	case !hasCount:
+		w.writeBulk(s.random())
+	case s == nil || count == 0:
+		w.writeArray(0)
+	case count < 0:
+		out := make([]string, 0, -count)
+		for range -count {
+			out = append(out, s.random())
+		}
+		w.writeBulks(out...)
+	case int(count) >= s.len():
+		w.writeBulks(s.members()...)
+	default:
+		// 洗牌后取前 count 个
+		members := s.members()
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		w.writeBulks(members[:count]...)
+	}
+}
+
+// set 运算的类型
+const (
+	setOpUnion = iota
+	setOpInter
+	setOpDiff
+)
+
+// setOperation 计算 keys 对应集合的并/交/差, 不存在的 key 当作空集
+func setOperation(db *DB, keys []string, op int) ([]string, string) {
+	sets := make([]*setValue, len(keys))
+	for i, k := range keys {
+		s, err := setForRead(db, k)
+		if err != "" {
+			return nil, err
+		}
+		sets[i] = s
+	}
+
+	var out []string
+	switch op {
+	case setOpUnion:
+		seen := make(map[string]struct{})
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			s.each(func(m string) {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					out = append(out, m)
+				}
+			})
+		}
+	case setOpInter:
+		// 从最小的集合出发逐个检查, 和 Redis 的做法一样
+		smallest := -1
+		for i, s := range sets {
+			if s == nil {
+				return nil, ""
+			}
+			if smallest < 0 || s.len() < sets[smallest].len() {
+				smallest = i
+			}
+		}
+		sets[smallest].each(func(m string) {
+			for i, s := range sets {
+				if i != smallest && !s.has(m) {
+					return
+				}
+			}
+			out = append(out, m)
+		})
+	case setOpDiff:
+		if sets[0] == nil {
+			return nil, ""
+		}
+		sets[0].each(func(m string) {
+			for _, s := range sets[1:] {
+				if s != nil && s.has(m) {
+					return
+				}
+			}
+			out = append(out, m)
+		})
+	}
+	return out, ""
+}
+
+// SINTER / SUNION / SDIFF key [key ...]
+func setOpCommand(c *client, args []string, op int) {
+	w := c.w
+	if len(args) < 2 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	members, err := setOperation(c.db, args[1:], op)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	writeSetMembers(w, members)
+}
+
+// SINTERSTORE / SUNIONSTORE / SDIFFSTORE destination key [key ...]
+func setOpStoreCommand(c *client, args []string, op int) {
+	w := c.w
+	if len(args) < 3 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	members, err := setOperation(c.db, args[2:], op)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	// 结果为空时删除目标 key
+	c.db.removeKey(args[1])
+	if len(members) > 0 {
+		c.db.setKey(args[1], newSetObjectFrom(members))
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeInt(int64(len(members)))
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func sscanCommand(c *client, args []string) {
+	w := c.w
+	cursor, ok := parseCursor(args[2])
+	if !ok {
+		w.writeError("ERR invalid cursor")
+		return
+	}
+	opts, perr := parseScanOptions(args[3:], nil)
+	if perr != "" {
+		w.writeError(perr)
+		return
+	}
+	s, err := setForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+
+	var next uint64
+	var members []string
+	switch {
+	case s == nil:
+	case s.is != nil:
+		// intset 很小, 和 Redis 一样一次全部返回
+		members = s.members()
+	default:
+		next, members = s.scan.scan(cursor, opts.count)
+	}
+
+	out := members[:0]
+	for _, m := range members {
+		if opts.match == "" || globMatch(opts.match, m) {
+			out = append(out, m)
+		}
+	}
+	w.writeArray(2)
+	w.writeBulk(strconv.FormatUint(next, 10))
+	w.writeBulks(out...)
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, ":3\r\n", "SADD", "a", "1", "2", "3")
+	expectReply(t, tc, "$6\r\nintset\r\n", "OBJECT", "ENCODING", "a")
+	expectReply(t, tc, ":1\r\n", "SISMEMBER", "a", "2")
+	expectReply(t, tc, "*2\r\n:1\r\n:0\r\n", "SMISMEMBER", "a", "3", "4")
+	expectReply(t, tc, ":2\r\n", "SADD", "b", "3", "x")
+	expectReply(t, tc, "$9\r\nhashtable\r\n", "OBJECT", "ENCODING", "b")
+	expectReply(t, tc, "*1\r\n$1\r\n3\r\n", "SINTER", "a", "b")
+	expectReply(t, tc, "*2\r\n$1\r\n1\r\n$1\r\n2\r\n", "SDIFF", "a", "b")
+	expectReply(t, tc, ":4\r\n", "SUNIONSTORE", "u", "a", "b")
+	expectReply(t, tc, ":4\r\n", "SCARD", "u")
+	expectReply(t, tc, ":0\r\n", "SINTERSTORE", "u", "a", "missing")
+	expectReply(t, tc, ":0\r\n", "SCARD", "u")
+	expectReply(t, tc, ":1\r\n", "SREM", "b", "x")
+	// RESP3 下 SMEMBERS 是 set 类型
+	tc.w.proto = resp3
+	expectReply(t, tc, "~1\r\n$1\r\n3\r\n", "SMEMBERS", "b")
+}
+
+func TestSetIntsetConversion(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	for i := range setMaxIntsetEntries {
+		tc.do("SADD", "nums", strconv.Itoa(i))
+	}
+	expectReply(t, tc, "$6\r\nintset\r\n", "OBJECT", "ENCODING", "nums")
+	tc.do("SADD", "nums", strconv.Itoa(setMaxIntsetEntries))
+	expectReply(t, tc, "$9\r\nhashtable\r\n", "OBJECT", "ENCODING", "nums")
+
+	// 非规范整数不能进 intset
+	tc.do("SADD", "odd", "1", "007")
+	expectReply(t, tc, "$9\r\nhashtable\r\n", "OBJECT", "ENCODING", "odd")
+}
+
+func TestSetPopReplay(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("SADD", "s", "a", "b", "c", "d")
+	popped := tc.do("SPOP", "s", "2")
+
+	// SPOP 以 SREM 的形式写入 AOF, 重放后剩下的成员一致
+	before := tc.do("SCARD", "s")
+	replayFromStart(t)
+	if after := tc.do("SCARD", "s"); after != before || before != ":2\r\n" {
+		t.Fatalf("SCARD before %q, after %q (popped %q)", before, after, popped)
+	}
+	s, _ := setForRead(dbs[0], "s")
+	for _, m := range s.members() {
+		if strings.Contains(popped, "\r\n"+m+"\r\n") {
+			t.Fatalf("popped member %s came back after replay", m)
+		}
+	}
+}
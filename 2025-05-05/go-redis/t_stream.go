@@ -0,0 +1,1188 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamForRead 取 key 对应的 stream, 不存在时返回 nil
+func streamForRead(db *DB, key string) (*stream, string) {
+	o, err := db.lookupKeyType(key, typeStream)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	return o.stream(), ""
+}
+
+// writeStreamEntry 写一个 [id, [field, value ...]] 条目, fields 为 nil 表示条目已被删除
+func writeStreamEntry(w *replyWriter, e streamEntry) {
+	w.writeArray(2)
+	w.writeBulk(e.id.String())
+	if e.fields == nil {
+		w.writeNullArray()
+		return
+	}
+	w.writeBulks(e.fields...)
+}
+
+func writeStreamEntries(w *replyWriter, entries []streamEntry) {
+	w.writeArray(len(entries))
+	for _, e := range entries {
+		writeStreamEntry(w, e)
+	}
+}
+
+func errNoGroup(key, group string) string {
+	return fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func xaddCommand(c *client, args []string) {
+	w := c.w
+	key := args[1]
+	nomkstream := false
+	trim := streamTrim{maxLen: -1}
+	limitGiven := false
+	i := 2
+options:
+	for i < len(args) {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NOMKSTREAM":
+			nomkstream = true
+			i++
+		case "MAXLEN", "MINID":
+			var err string
+			if i, err = parseStreamTrim(&trim, args, i); err != "" {
+				w.writeError(err)
+				return
+			}
+		case "LIMIT":
+			if i+1 >= len(args) {
+				w.writeError(errSyntax)
+				return
+			}
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError(errNotInt)
+				return
+			}
+			if n < 0 {
+				w.writeError("ERR The LIMIT argument must be >= 0.")
+				return
+			}
+			trim.limit, limitGiven = n, true
+			i += 2
+		default:
+			break options
+		}
+	}
+	if limitGiven && !trim.approx {
+		w.writeError("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		return
+	}
+	fields := args[min(i+1, len(args)):]
+	if i >= len(args) || len(fields) == 0 || len(fields)%2 != 0 {
+		w.writeError(errWrongArgs("XADD"))
+		return
+	}
+
+	o, err := c.db.lookupKeyType(key, typeStream)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil && nomkstream {
+		w.writeNull()
+		return
+	}
+	created := o == nil
+	if created {
+		o = newStreamObject()
+	}
+	s := o.stream()
+
+	// * 自动生成整个 ID, <ms>-* 只自动生成序号
+	var id streamID
+	now := uint64(time.Now().UnixMilli())
+	switch ms, ok := strings.CutSuffix(args[i], "-*"); {
+	case args[i] == "*":
+		if id, ok = s.nextID(now); !ok {
+			w.writeError("ERR The stream has exhausted the last possible ID, unable to add more items")
+			return
+		}
+	case ok:
+		msv, perr := strconv.ParseUint(ms, 10, 64)
+		if perr != nil {
+			w.writeError(errInvalidStreamID)
+			return
+		}
+		switch {
+		case msv > s.lastID.ms:
+			id = streamID{msv, 0}
+		case msv == s.lastID.ms && s.lastID.seq < math.MaxUint64:
+			id = streamID{msv, s.lastID.seq + 1}
+		default:
+			w.writeError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			return
+		}
+	default:
+		if id, ok = parseStreamID(args[i], 0); !ok {
+			w.writeError(errInvalidStreamID)
+			return
+		}
+		if id == (streamID{}) {
+			w.writeError("ERR The ID specified in XADD must be greater than 0-0")
+			return
+		}
+		if !s.lastID.less(id) {
+			w.writeError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			return
+		}
+	}
+
+	if created {
+		c.db.setKey(key, o)
+	}
+	s.add(id, slices.Clone(fields))
+	if trim.maxLen >= 0 || trim.byMinID {
+		s.trim(&trim)
+	}
+	c.db.signalModifiedKey(key)
+	c.db.signalKeyAsReady(key)
+	// 传播时换成生成的 ID, 重放和 replica 上得到同样的条目
+	prop := slices.Clone(args)
+	prop[i] = id.String()
+	recordAOF(c.db, prop)
+	w.writeBulk(id.String())
+}
+
+// XRANGE key start end [COUNT count] / XREVRANGE key end start [COUNT count]
+func xrangeCommand(c *client, args []string, rev bool) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	if len(args) != 4 && len(args) != 6 {
+		if len(args) > 6 || len(args) == 5 {
+			w.writeError(errSyntax)
+		} else {
+			w.writeError(errWrongArgs(name))
+		}
+		return
+	}
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, true)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	end, err := parseRangeID(endArg, false)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var count int64
+	if len(args) == 6 {
+		if !strings.EqualFold(args[4], "COUNT") {
+			w.writeError(errSyntax)
+			return
+		}
+		n, ok := parseInt64(args[5])
+		if !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		if n <= 0 {
+			w.writeArray(0)
+			return
+		}
+		count = n
+	}
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		w.writeArray(0)
+		return
+	}
+	writeStreamEntries(w, s.rangeOf(start, end, count, rev))
+}
+
+// XLEN key
+func xlenCommand(c *client, args []string) {
+	w := c.w
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(s.len()))
+}
+
+// XDEL key id [id ...]
+func xdelCommand(c *client, args []string) {
+	w := c.w
+	// 先校验所有 ID, 出错时什么都不删
+	ids := make([]streamID, 0, len(args)-2)
+	for _, a := range args[2:] {
+		id, ok := parseStreamID(a, 0)
+		if !ok {
+			w.writeError(errInvalidStreamID)
+			return
+		}
+		ids = append(ids, id)
+	}
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	deleted := 0
+	if s != nil {
+		for _, id := range ids {
+			if s.delete(id) {
+				deleted++
+			}
+		}
+	}
+	if deleted > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(deleted))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func xtrimCommand(c *client, args []string) {
+	w := c.w
+	trim := streamTrim{maxLen: -1}
+	opt := strings.ToUpper(args[2])
+	if opt != "MAXLEN" && opt != "MINID" {
+		w.writeError(errSyntax)
+		return
+	}
+	i, err := parseStreamTrim(&trim, args, 2)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if i < len(args) {
+		if !strings.EqualFold(args[i], "LIMIT") || i+2 != len(args) {
+			w.writeError(errSyntax)
+			return
+		}
+		n, ok := parseInt64(args[i+1])
+		if !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		if n < 0 {
+			w.writeError("ERR The LIMIT argument must be >= 0.")
+			return
+		}
+		if !trim.approx {
+			w.writeError("ERR syntax error, LIMIT cannot be used without the special ~ option")
+			return
+		}
+		trim.limit = n
+	}
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var n int64
+	if s != nil {
+		n = s.trim(&trim)
+	}
+	if n > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(n)
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func xsetidCommand(c *client, args []string) {
+	w := c.w
+	id, ok := parseStreamID(args[2], 0)
+	if !ok {
+		w.writeError(errInvalidStreamID)
+		return
+	}
+	entriesAdded := int64(-1)
+	var maxDeleted streamID
+	maxDeletedGiven := false
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError(errSyntax)
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError(errNotInt)
+				return
+			}
+			if n < 0 {
+				w.writeError("ERR entries_added must be positive")
+				return
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			if maxDeleted, ok = parseStreamID(args[i+1], 0); !ok {
+				w.writeError(errInvalidStreamID)
+				return
+			}
+			maxDeletedGiven = true
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	if maxDeletedGiven && id.less(maxDeleted) {
+		w.writeError("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+		return
+	}
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if s == nil {
+		w.writeError("ERR no such key")
+		return
+	}
+	if n := s.len(); n > 0 && id.less(s.entries[n-1].id) {
+		w.writeError("ERR The ID specified in XSETID is smaller than the target stream top item")
+		return
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.len()) {
+		w.writeError("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		return
+	}
+	s.lastID = id
+	if entriesAdded >= 0 {
+		s.entriesAdded = entriesAdded
+	}
+	if maxDeletedGiven {
+		s.maxDeletedID = maxDeleted
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeOK()
+}
+
+// lookupConsumer 取组内的消费者, 不存在时创建并传播 XGROUP CREATECONSUMER
+func lookupConsumer(c *client, key string, g *streamGroup, name string, now int64) *streamConsumer {
+	if sc, ok := g.consumers[name]; ok {
+		sc.seenTime = now
+		return sc
+	}
+	sc := g.createConsumer(name, now)
+	recordAOF(c.db, []string{"XGROUP", "CREATECONSUMER", key, g.name, name})
+	return sc
+}
+
+// propagateXCLAIM 把一条待确认记录的当前状态传播成 XCLAIM, 重放和 replica 上由 FORCE 建出同样的记录。
+// 条目已经不在 stream 里时重放的 XCLAIM 会把记录从 PEL 删掉, 效果也一致
+func propagateXCLAIM(c *client, key string, g *streamGroup, consumer string, id streamID, nack *streamNACK) {
+	recordAOF(c.db, []string{"XCLAIM", key, g.name, consumer, "0", id.String(),
+		"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", g.lastID.String()})
+}
+
+// propagateGroupSetID 传播消费组的 last ID, 用在只移动了 last ID 没有新 PEL 记录的场景
+func propagateGroupSetID(c *client, key string, g *streamGroup) {
+	recordAOF(c.db, []string{"XGROUP", "SETID", key, g.name, g.lastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10)})
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func xreadCommand(c *client, args []string) {
+	w := c.w
+	group := strings.EqualFold(args[0], "XREADGROUP")
+	var count int64
+	block := int64(-1)
+	noack := false
+	var groupName, consumerName string
+	streams := -1
+	for i := 1; i < len(args) && streams < 0; i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError(errNotInt)
+				return
+			}
+			count = max(n, 0)
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError("ERR timeout is not an integer or out of range")
+				return
+			}
+			if n < 0 {
+				w.writeError("ERR timeout is negative")
+				return
+			}
+			if n > math.MaxInt64/int64(time.Millisecond) {
+				w.writeError("ERR timeout is out of range")
+				return
+			}
+			block = n
+			i++
+		case opt == "GROUP" && group && i+2 < len(args):
+			groupName, consumerName = args[i+1], args[i+2]
+			i += 2
+		case opt == "NOACK" && group:
+			noack = true
+		case opt == "STREAMS":
+			streams = i + 1
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	if streams < 0 {
+		w.writeError(errSyntax)
+		return
+	}
+	rest := args[streams:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		last := "$"
+		if group {
+			last = ">"
+		}
+		w.writeError(fmt.Sprintf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '%s' must be specified.",
+			strings.ToLower(args[0]), last))
+		return
+	}
+	if group && groupName == "" {
+		w.writeError("ERR Missing GROUP option for XREADGROUP")
+		return
+	}
+	n := len(rest) / 2
+	keys, ids := rest[:n], rest[n:]
+
+	// 先检查所有 key 和 ID, 出错时不产生任何副作用
+	type target struct {
+		s       *stream
+		g       *streamGroup
+		after   streamID // 只返回大于它的条目
+		newOnly bool     // XREADGROUP 的 >
+	}
+	targets := make([]target, n)
+	for i, key := range keys {
+		s, err := streamForRead(c.db, key)
+		if err != "" {
+			w.writeError(err)
+			return
+		}
+		t := &targets[i]
+		t.s = s
+		if group {
+			if s != nil {
+				t.g = s.groups[groupName]
+			}
+			if t.g == nil {
+				w.writeError(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, groupName))
+				return
+			}
+			if ids[i] == ">" {
+				t.newOnly = true
+				continue
+			}
+		} else if ids[i] == "$" {
+			if s != nil {
+				t.after = s.lastID
+			}
+			continue
+		}
+		id, ok := parseStreamID(ids[i], 0)
+		if !ok {
+			w.writeError(errInvalidStreamID)
+			return
+		}
+		t.after = id
+	}
+
+	type result struct {
+		key     string
+		entries []streamEntry
+	}
+	var results []result
+	history := false
+	now := time.Now().UnixMilli()
+	for i, t := range targets {
+		key := keys[i]
+		if group && !t.newOnly {
+			// 读消费者自己的历史: PEL 里大于给定 ID 的记录, 条目被删掉的返回 [id, nil]
+			history = true
+			sc := lookupConsumer(c, key, t.g, consumerName, now)
+			var entries []streamEntry
+			for _, id := range sortedPEL(sc.pel) {
+				if !t.after.less(id) {
+					continue
+				}
+				if count > 0 && int64(len(entries)) >= count {
+					break
+				}
+				j, ok := t.s.find(id)
+				if !ok {
+					entries = append(entries, streamEntry{id: id})
+					continue
+				}
+				nack := sc.pel[id]
+				nack.deliveryTime = now
+				nack.deliveryCount++
+				propagateXCLAIM(c, key, t.g, sc.name, id, nack)
+				entries = append(entries, t.s.entries[j])
+			}
+			results = append(results, result{key, entries})
+			continue
+		}
+		if t.s == nil {
+			continue
+		}
+		after := t.after
+		if t.newOnly {
+			after = t.g.lastID
+		}
+		start, ok := after.incr()
+		if !ok {
+			continue
+		}
+		entries := t.s.rangeOf(start, maxStreamID, count, false)
+		if !t.newOnly {
+			if len(entries) > 0 {
+				results = append(results, result{key, entries})
+			}
+			continue
+		}
+		sc := lookupConsumer(c, key, t.g, consumerName, now)
+		if len(entries) == 0 {
+			continue
+		}
+		// 新投递的条目记进 PEL, 组的 last ID 前移
+		sc.activeTime = now
+		for _, e := range entries {
+			t.g.lastID = e.id
+			if e.id == t.s.lastID {
+				t.g.entriesRead = t.s.entriesAdded
+			} else if t.g.entriesRead >= 0 {
+				t.g.entriesRead++
+			}
+			if noack {
+				continue
+			}
+			nack := t.g.assign(e.id, sc)
+			nack.deliveryTime, nack.deliveryCount = now, 1
+			propagateXCLAIM(c, key, t.g, sc.name, e.id, nack)
+		}
+		if noack {
+			propagateGroupSetID(c, key, t.g)
+		}
+		c.db.signalModifiedKey(key)
+		results = append(results, result{key, entries})
+	}
+
+	if len(results) == 0 && !history {
+		if block < 0 {
+			w.writeNullArray()
+			return
+		}
+		// 阻塞等待; $ 换成现在的最后一个 ID, 唤醒后重新执行时才不会漏掉期间写入的条目
+		retry := slices.Clone(args)
+		for i := range ids {
+			if !group && ids[i] == "$" {
+				retry[streams+n+i] = targets[i].after.String()
+			}
+		}
+		blockForStreams(c, keys, block, retry)
+		return
+	}
+	// RESP3 下是 key -> 条目列表的 map, RESP2 下是 [key, 条目列表] 的数组
+	if w.proto == resp3 {
+		w.writeMap(len(results))
+	} else {
+		w.writeArray(len(results))
+	}
+	for _, r := range results {
+		if w.proto != resp3 {
+			w.writeArray(2)
+		}
+		w.writeBulk(r.key)
+		writeStreamEntries(w, r.entries)
+	}
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func xgroupCommand(c *client, args []string) {
+	w := c.w
+	sub := strings.ToUpper(args[1])
+	switch sub {
+	case "CREATE", "SETID":
+		if len(args) < 5 {
+			w.writeError(errWrongArgs("XGROUP|" + sub))
+			return
+		}
+	case "DESTROY":
+		if len(args) != 4 {
+			w.writeError(errWrongArgs("XGROUP|" + sub))
+			return
+		}
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 5 {
+			w.writeError(errWrongArgs("XGROUP|" + sub))
+			return
+		}
+	default:
+		w.writeError("ERR unknown subcommand '" + args[1] + "'. Try XGROUP HELP.")
+		return
+	}
+	key, groupName := args[2], args[3]
+
+	mkstream := false
+	entriesRead := int64(-1)
+	if sub == "CREATE" || sub == "SETID" {
+		for i := 5; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i]); {
+			case opt == "MKSTREAM" && sub == "CREATE":
+				mkstream = true
+			case opt == "ENTRIESREAD" && i+1 < len(args):
+				n, ok := parseInt64(args[i+1])
+				if !ok {
+					w.writeError(errNotInt)
+					return
+				}
+				if n < -1 {
+					w.writeError("ERR value for ENTRIESREAD must be positive or -1")
+					return
+				}
+				entriesRead = n
+				i++
+			default:
+				w.writeError(errSyntax)
+				return
+			}
+		}
+	}
+
+	o, err := c.db.lookupKeyType(key, typeStream)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		if !mkstream {
+			w.writeError("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			return
+		}
+		o = newStreamObject()
+		c.db.setKey(key, o)
+	}
+	s := o.stream()
+	g := s.groups[groupName]
+	if g == nil && sub != "CREATE" {
+		w.writeError(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, key))
+		return
+	}
+
+	switch sub {
+	case "CREATE", "SETID":
+		var id streamID
+		if args[4] == "$" {
+			id = s.lastID
+			if entriesRead < 0 {
+				entriesRead = s.entriesAdded
+			}
+		} else {
+			var ok bool
+			if id, ok = parseStreamID(args[4], 0); !ok {
+				w.writeError(errInvalidStreamID)
+				return
+			}
+		}
+		if sub == "CREATE" {
+			if s.createGroup(groupName, id, entriesRead) == nil {
+				w.writeError("BUSYGROUP Consumer Group name already exists")
+				return
+			}
+		} else {
+			g.lastID, g.entriesRead = id, entriesRead
+		}
+		c.db.signalModifiedKey(key)
+		// $ 换成具体的 ID 再传播
+		prop := slices.Clone(args)
+		prop[4] = id.String()
+		recordAOF(c.db, prop)
+		w.writeOK()
+	case "DESTROY":
+		delete(s.groups, groupName)
+		c.db.signalModifiedKey(key)
+		dirty++
+		w.writeInt(1)
+	case "CREATECONSUMER":
+		if g.createConsumer(args[4], time.Now().UnixMilli()) == nil {
+			w.writeInt(0)
+			return
+		}
+		c.db.signalModifiedKey(key)
+		dirty++
+		w.writeInt(1)
+	case "DELCONSUMER":
+		sc, ok := g.consumers[args[4]]
+		if !ok {
+			w.writeInt(0)
+			return
+		}
+		pending := g.deleteConsumer(sc)
+		c.db.signalModifiedKey(key)
+		dirty++
+		w.writeInt(int64(pending))
+	}
+}
+
+// XACK key group id [id ...]
+func xackCommand(c *client, args []string) {
+	w := c.w
+	ids := make([]streamID, 0, len(args)-3)
+	for _, a := range args[3:] {
+		id, ok := parseStreamID(a, 0)
+		if !ok {
+			w.writeError(errInvalidStreamID)
+			return
+		}
+		ids = append(ids, id)
+	}
+	s, err := streamForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[args[2]]
+	}
+	acked := 0
+	if g != nil {
+		for _, id := range ids {
+			if g.ack(id) {
+				acked++
+			}
+		}
+	}
+	if acked > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(acked))
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xpendingCommand(c *client, args []string) {
+	w := c.w
+	key, groupName := args[1], args[2]
+	rest := args[3:]
+	var minIdle int64
+	if len(rest) > 0 && strings.EqualFold(rest[0], "IDLE") {
+		if len(rest) < 2 {
+			w.writeError(errSyntax)
+			return
+		}
+		n, ok := parseInt64(rest[1])
+		if !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		minIdle = n
+		rest = rest[2:]
+		if len(rest) == 0 {
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	extended := len(rest) > 0
+	var start, end streamID
+	var count int64
+	var consumer string
+	if extended {
+		if len(rest) != 3 && len(rest) != 4 {
+			w.writeError(errSyntax)
+			return
+		}
+		var err string
+		if start, err = parseRangeID(rest[0], true); err != "" {
+			w.writeError(err)
+			return
+		}
+		if end, err = parseRangeID(rest[1], false); err != "" {
+			w.writeError(err)
+			return
+		}
+		n, ok := parseInt64(rest[2])
+		if !ok {
+			w.writeError(errNotInt)
+			return
+		}
+		count = max(n, 0)
+		if len(rest) == 4 {
+			consumer = rest[3]
+		}
+	}
+
+	s, err := streamForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[groupName]
+	}
+	if g == nil {
+		w.writeError(errNoGroup(key, groupName))
+		return
+	}
+
+	if !extended {
+		// 汇总: 记录数、最小和最大 ID、每个消费者名下的记录数
+		if len(g.pel) == 0 {
+			w.writeArray(4)
+			w.writeInt(0)
+			w.writeNull()
+			w.writeNull()
+			w.writeNullArray()
+			return
+		}
+		ids := sortedPEL(g.pel)
+		w.writeArray(4)
+		w.writeInt(int64(len(ids)))
+		w.writeBulk(ids[0].String())
+		w.writeBulk(ids[len(ids)-1].String())
+		var owners []*streamConsumer
+		for _, sc := range g.sortedConsumers() {
+			if len(sc.pel) > 0 {
+				owners = append(owners, sc)
+			}
+		}
+		w.writeArray(len(owners))
+		for _, sc := range owners {
+			w.writeBulks(sc.name, strconv.Itoa(len(sc.pel)))
+		}
+		return
+	}
+
+	pel := g.pel
+	if consumer != "" {
+		sc, ok := g.consumers[consumer]
+		if !ok {
+			w.writeArray(0)
+			return
+		}
+		pel = sc.pel
+	}
+	now := time.Now().UnixMilli()
+	type pending struct {
+		id   streamID
+		nack *streamNACK
+	}
+	var res []pending
+	for _, id := range sortedPEL(pel) {
+		if int64(len(res)) >= count || end.less(id) {
+			break
+		}
+		nack := pel[id]
+		if id.less(start) || now-nack.deliveryTime < minIdle {
+			continue
+		}
+		res = append(res, pending{id, nack})
+	}
+	w.writeArray(len(res))
+	for _, p := range res {
+		w.writeArray(4)
+		w.writeBulk(p.id.String())
+		w.writeBulk(p.nack.consumer.name)
+		w.writeInt(max(now-p.nack.deliveryTime, 0))
+		w.writeInt(p.nack.deliveryCount)
+	}
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func xclaimCommand(c *client, args []string) {
+	w := c.w
+	key, groupName, consumer := args[1], args[2], args[3]
+	minIdle, ok := parseInt64(args[4])
+	if !ok {
+		w.writeError("ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+	minIdle = max(minIdle, 0)
+	// ID 一直读到第一个不是 ID 的参数, 后面是选项
+	i := 5
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		w.writeError(errInvalidStreamID)
+		return
+	}
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID streamID
+	lastIDGiven := false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		more := i+1 < len(args)
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case opt == "IDLE" && more:
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError("ERR Invalid IDLE option argument for XCLAIM")
+				return
+			}
+			deliveryTime = now - n
+			i++
+		case opt == "TIME" && more:
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError("ERR Invalid TIME option argument for XCLAIM")
+				return
+			}
+			deliveryTime = n
+			i++
+		case opt == "RETRYCOUNT" && more:
+			n, ok := parseInt64(args[i+1])
+			if !ok || n < 0 {
+				w.writeError("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+				return
+			}
+			retryCount = n
+			i++
+		case opt == "LASTID" && more:
+			if lastID, ok = parseStreamID(args[i+1], 0); !ok {
+				w.writeError(errInvalidStreamID)
+				return
+			}
+			lastIDGiven = true
+			i++
+		default:
+			w.writeError(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+			return
+		}
+	}
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	s, err := streamForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[groupName]
+	}
+	if g == nil {
+		w.writeError(errNoGroup(key, groupName))
+		return
+	}
+
+	changed := false
+	if lastIDGiven && g.lastID.less(lastID) {
+		g.lastID = lastID
+		changed = true
+	}
+	sc := lookupConsumer(c, key, g, consumer, now)
+	var claimed []streamEntry
+	propagated := false
+	for _, id := range ids {
+		nack := g.pel[id]
+		j, exists := s.find(id)
+		if !exists {
+			// 条目已经被删掉, 把残留的记录也清掉
+			if nack != nil {
+				propagateXCLAIM(c, key, g, consumer, id, nack)
+				g.ack(id)
+				changed, propagated = true, true
+			}
+			continue
+		}
+		if nack == nil {
+			if !force {
+				continue
+			}
+			nack = g.assign(id, sc)
+		} else if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+		g.assign(id, sc)
+		nack.deliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		} else if !justID {
+			nack.deliveryCount++
+		}
+		sc.activeTime = now
+		propagateXCLAIM(c, key, g, consumer, id, nack)
+		changed, propagated = true, true
+		claimed = append(claimed, s.entries[j])
+	}
+	if changed {
+		c.db.signalModifiedKey(key)
+	}
+	if changed && !propagated {
+		propagateGroupSetID(c, key, g)
+	}
+	w.writeArray(len(claimed))
+	for _, e := range claimed {
+		if justID {
+			w.writeBulk(e.id.String())
+		} else {
+			writeStreamEntry(w, e)
+		}
+	}
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 从 start 开始扫描 PEL, 认领空闲足够久的记录, 返回 [下次扫描的起点, 认领到的条目, 已被删除的 ID]
+func xautoclaimCommand(c *client, args []string) {
+	w := c.w
+	key, groupName, consumer := args[1], args[2], args[3]
+	minIdle, ok := parseInt64(args[4])
+	if !ok {
+		w.writeError("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+	minIdle = max(minIdle, 0)
+	start, err := parseRangeID(args[5], true)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	count := int64(100)
+	justID := false
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "JUSTID":
+			justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt64(args[i+1])
+			if !ok {
+				w.writeError(errNotInt)
+				return
+			}
+			if n < 1 || n > 1<<50 {
+				w.writeError("ERR COUNT must be > 0")
+				return
+			}
+			count = n
+			i++
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+
+	s, err := streamForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[groupName]
+	}
+	if g == nil {
+		w.writeError(errNoGroup(key, groupName))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	sc := lookupConsumer(c, key, g, consumer, now)
+	// 每认领一条最多检查 10 条记录, 避免 PEL 很大时一次扫太久
+	attempts := count * 10
+	var claimed []streamEntry
+	var deleted []streamID
+	next := streamID{}
+	ids := sortedPEL(g.pel)
+	k := sort.Search(len(ids), func(i int) bool { return !ids[i].less(start) })
+	for ; k < len(ids) && attempts > 0 && int64(len(claimed)) < count; k++ {
+		attempts--
+		id := ids[k]
+		nack := g.pel[id]
+		j, exists := s.find(id)
+		if !exists {
+			propagateXCLAIM(c, key, g, consumer, id, nack)
+			g.ack(id)
+			deleted = append(deleted, id)
+			continue
+		}
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+		g.assign(id, sc)
+		nack.deliveryTime = now
+		if !justID {
+			nack.deliveryCount++
+		}
+		sc.activeTime = now
+		propagateXCLAIM(c, key, g, consumer, id, nack)
+		claimed = append(claimed, s.entries[j])
+	}
+	if k < len(ids) {
+		next = ids[k]
+	}
+	if len(claimed) > 0 || len(deleted) > 0 {
+		c.db.signalModifiedKey(key)
+	}
+
+	w.writeArray(3)
+	w.writeBulk(next.String())
+	w.writeArray(len(claimed))
+	for _, e := range claimed {
+		if justID {
+			w.writeBulk(e.id.String())
+		} else {
+			writeStreamEntry(w, e)
+		}
+	}
+	w.writeArray(len(deleted))
+	for _, id := range deleted {
+		w.writeBulk(id.String())
+	}
+}
@@ -0,0 +1,334 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamAddRange(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, "$3\r\n1-1\r\n", "XADD", "s", "1-1", "a", "1")
+	expectReply(t, tc, "$3\r\n1-2\r\n", "XADD", "s", "1-*", "b", "2")
+	expectReply(t, tc, "$3\r\n5-0\r\n", "XADD", "s", "5", "c", "3")
+	expectReply(t, tc, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", "XADD", "s", "5-0", "d", "4")
+	expectReply(t, tc, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", "XADD", "s", "4-*", "d", "4")
+	expectReply(t, tc, "-ERR The ID specified in XADD must be greater than 0-0\r\n", "XADD", "new", "0-0", "d", "4")
+	expectReply(t, tc, "-"+errInvalidStreamID+"\r\n", "XADD", "s", "x-1", "d", "4")
+	expectReply(t, tc, "-"+errWrongArgs("XADD")+"\r\n", "XADD", "s", "*", "d")
+	expectReply(t, tc, "$-1\r\n", "XADD", "none", "NOMKSTREAM", "*", "a", "1")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "none")
+	expectReply(t, tc, "+stream\r\n", "TYPE", "s")
+
+	// 自动 ID 的毫秒部分不早于当前时间
+	before := time.Now().UnixMilli()
+	got := tc.do("XADD", "s", "*", "d", "4")
+	id, ok := parseStreamID(strings.Split(got, "\r\n")[1], 0)
+	if !ok || id.ms < uint64(before) || id.seq != 0 {
+		t.Fatalf("auto id %q", got)
+	}
+	expectReply(t, tc, ":4\r\n", "XLEN", "s")
+
+	entry := func(id, f, v string) string {
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*2\r\n$1\r\n%s\r\n$1\r\n%s\r\n", len(id), id, f, v)
+	}
+	expectReply(t, tc, "*3\r\n"+entry("1-1", "a", "1")+entry("1-2", "b", "2")+entry("5-0", "c", "3"), "XRANGE", "s", "-", "5")
+	expectReply(t, tc, "*2\r\n"+entry("1-2", "b", "2")+entry("5-0", "c", "3"), "XRANGE", "s", "(1-1", "5-0")
+	expectReply(t, tc, "*1\r\n"+entry("1-1", "a", "1"), "XRANGE", "s", "1", "+", "COUNT", "1")
+	expectReply(t, tc, "*2\r\n"+entry("5-0", "c", "3")+entry("1-2", "b", "2"), "XREVRANGE", "s", "5", "1-2")
+	expectReply(t, tc, "*0\r\n", "XRANGE", "s", "5", "1")
+	expectReply(t, tc, "-ERR invalid start ID for the interval\r\n", "XRANGE", "s", "(-", "+")
+
+	expectReply(t, tc, ":1\r\n", "XDEL", "s", "1-2", "9-9")
+	expectReply(t, tc, "*2\r\n"+entry("1-1", "a", "1")+entry("5-0", "c", "3"), "XRANGE", "s", "-", "5")
+	// 删掉的 ID 之后也不能再用
+	expectReply(t, tc, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n", "XADD", "s", "1-3", "x", "y")
+
+	expectReply(t, tc, ":1\r\n", "XTRIM", "s", "MAXLEN", "2")
+	expectReply(t, tc, "*1\r\n"+entry("5-0", "c", "3"), "XRANGE", "s", "-", "5")
+	expectReply(t, tc, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n", "XTRIM", "s", "MAXLEN", "0", "LIMIT", "1")
+	expectReply(t, tc, ":1\r\n", "XTRIM", "s", "MINID", "~", "6", "LIMIT", "1")
+	expectReply(t, tc, ":1\r\n", "XLEN", "s")
+
+	// XADD 带 MAXLEN 时加完再裁剪
+	for i := range 5 {
+		tc.do("XADD", "capped", "MAXLEN", "3", fmt.Sprint(i+1), "n", fmt.Sprint(i))
+	}
+	expectReply(t, tc, ":3\r\n", "XLEN", "capped")
+	expectReply(t, tc, "*1\r\n"+entry("3-0", "n", "2"), "XRANGE", "capped", "-", "+", "COUNT", "1")
+
+	// 清空后 stream 依然存在, last ID 不变
+	expectReply(t, tc, ":3\r\n", "XTRIM", "capped", "MAXLEN", "=", "0")
+	expectReply(t, tc, ":1\r\n", "EXISTS", "capped")
+	expectReply(t, tc, "-ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id\r\n", "XSETID", "capped", "5-0", "MAXDELETEDID", "6-0")
+	expectReply(t, tc, "+OK\r\n", "XSETID", "capped", "9-0")
+	expectReply(t, tc, "$3\r\n9-1\r\n", "XADD", "capped", "9-*", "n", "x")
+
+	tc.do("SET", "str", "v")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "XADD", "str", "*", "a", "1")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "XREAD", "STREAMS", "s", "str", "0", "0")
+}
+
+// waitBlockedOn 等到 key 上挂起了 n 个客户端
+func waitBlockedOn(t *testing.T, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		dbMu.Lock()
+		got := len(blockingKeys[dbKey{0, key}])
+		dbMu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked on %s, want %d", got, key, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamRead(t *testing.T) {
+	resetKeyspace()
+	tc, reader := newTestClient(), newTestClient()
+	tc.do("XADD", "a", "1-0", "f", "v")
+	tc.do("XADD", "b", "2-0", "g", "w")
+
+	expectReply(t, tc, "*2\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"+
+		"*2\r\n$1\r\nb\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\ng\r\n$1\r\nw\r\n",
+		"XREAD", "STREAMS", "a", "b", "0", "0")
+	// 没有更新的条目时只返回有数据的 stream, 全都没有时是空
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\nb\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\ng\r\n$1\r\nw\r\n",
+		"XREAD", "COUNT", "5", "STREAMS", "a", "b", "1-0", "1-0")
+	expectReply(t, tc, "*-1\r\n", "XREAD", "STREAMS", "a", "missing", "$", "0")
+	expectReply(t, tc, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n",
+		"XREAD", "STREAMS", "a", "b", "0")
+	expectReply(t, tc, "*-1\r\n", "XREAD", "BLOCK", "10", "STREAMS", "a", "$")
+	expectReply(t, tc, "-ERR timeout is out of range\r\n", "XREAD", "BLOCK", "9223372036854775807", "STREAMS", "a", "$")
+
+	// BLOCK 时 $ 是阻塞那一刻的最后一个 ID, 之后写入的条目唤醒读者
+	done := make(chan string)
+	go func() { done <- reader.do("XREAD", "BLOCK", "0", "STREAMS", "a", "$") }()
+	waitBlockedOn(t, "a", 1)
+	tc.do("XADD", "a", "3-0", "new", "1")
+	select {
+	case got := <-done:
+		if want := "*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$3\r\nnew\r\n$1\r\n1\r\n"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XREAD was not woken up by XADD")
+	}
+
+	// RESP3 下是 key -> 条目的 map
+	tc.do("HELLO", "3")
+	expectReply(t, tc, "%1\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$3\r\nnew\r\n$1\r\n1\r\n", "XREAD", "STREAMS", "a", "2")
+}
+
+func TestStreamConsumerGroup(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n",
+		"XGROUP", "CREATE", "s", "g", "$")
+	expectReply(t, tc, "+OK\r\n", "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	expectReply(t, tc, "-BUSYGROUP Consumer Group name already exists\r\n", "XGROUP", "CREATE", "s", "g", "0")
+	for i := 1; i <= 3; i++ {
+		tc.do("XADD", "s", fmt.Sprintf("%d-0", i), "n", fmt.Sprint(i))
+	}
+
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nn\r\n$1\r\n1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nn\r\n$1\r\n2\r\n",
+		"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nn\r\n$1\r\n3\r\n",
+		"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	expectReply(t, tc, "*-1\r\n", "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	expectReply(t, tc, "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option\r\n",
+		"XREADGROUP", "GROUP", "nope", "bob", "STREAMS", "s", ">")
+
+	expectReply(t, tc, "*4\r\n:3\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n",
+		"XPENDING", "s", "g")
+	got := tc.do("XPENDING", "s", "g", "-", "+", "10", "alice")
+	if !strings.HasPrefix(got, "*2\r\n*4\r\n$3\r\n1-0\r\n$5\r\nalice\r\n:") || !strings.HasSuffix(got, ":1\r\n") {
+		t.Fatalf("XPENDING extended: %q", got)
+	}
+
+	expectReply(t, tc, ":1\r\n", "XACK", "s", "g", "1-0", "1-0", "9-0")
+	// 历史读取只看自己的 PEL; 条目被删掉后返回 [id, nil]
+	tc.do("XDEL", "s", "2-0")
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*-1\r\n",
+		"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+
+	// XCLAIM: 空闲时间不够时不认领, 认领后投递次数加一
+	expectReply(t, tc, "*0\r\n", "XCLAIM", "s", "g", "alice", "3600000", "3-0")
+	expectReply(t, tc, "*1\r\n$3\r\n3-0\r\n", "XCLAIM", "s", "g", "alice", "0", "3-0", "JUSTID")
+	expectReply(t, tc, "*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nn\r\n$1\r\n3\r\n", "XCLAIM", "s", "g", "carol", "0", "3-0", "RETRYCOUNT", "7")
+	got = tc.do("XPENDING", "s", "g", "IDLE", "0", "3-0", "3-0", "1")
+	if !strings.HasPrefix(got, "*1\r\n*4\r\n$3\r\n3-0\r\n$5\r\ncarol\r\n:") || !strings.HasSuffix(got, ":7\r\n") {
+		t.Fatalf("XPENDING after XCLAIM: %q", got)
+	}
+
+	// XAUTOCLAIM 清掉已删除条目的记录, 认领其余的
+	expectReply(t, tc, "*3\r\n$3\r\n0-0\r\n*1\r\n$3\r\n3-0\r\n*1\r\n$3\r\n2-0\r\n",
+		"XAUTOCLAIM", "s", "g", "dave", "0", "-", "JUSTID")
+	expectReply(t, tc, "*4\r\n:1\r\n$3\r\n3-0\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$4\r\ndave\r\n$1\r\n1\r\n", "XPENDING", "s", "g")
+
+	expectReply(t, tc, ":1\r\n", "XGROUP", "DELCONSUMER", "s", "g", "dave")
+	expectReply(t, tc, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", "XPENDING", "s", "g")
+	expectReply(t, tc, ":1\r\n", "XGROUP", "CREATECONSUMER", "s", "g", "erin")
+	expectReply(t, tc, ":0\r\n", "XGROUP", "CREATECONSUMER", "s", "g", "erin")
+	expectReply(t, tc, "+OK\r\n", "XGROUP", "SETID", "s", "g", "2-0")
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nn\r\n$1\r\n3\r\n",
+		"XREADGROUP", "GROUP", "g", "erin", "NOACK", "STREAMS", "s", ">")
+	expectReply(t, tc, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", "XPENDING", "s", "g")
+	expectReply(t, tc, ":1\r\n", "XGROUP", "DESTROY", "s", "g")
+	expectReply(t, tc, "-NOGROUP No such key 's' or consumer group 'g'\r\n", "XPENDING", "s", "g")
+}
+
+func TestStreamBlockingReadGroup(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("XGROUP", "CREATE", "jobs", "workers", "$", "MKSTREAM")
+
+	done := make(chan string, 2)
+	for _, name := range []string{"w1", "w2"} {
+		w := newTestClient()
+		go func() { done <- w.do("XREADGROUP", "GROUP", "workers", name, "BLOCK", "0", "STREAMS", "jobs", ">") }()
+	}
+	waitBlockedOn(t, "jobs", 2)
+
+	// 两个消费者都被唤醒, 只有一个拿到条目, 另一个继续阻塞
+	tc.do("XADD", "jobs", "1-0", "job", "a")
+	var first string
+	select {
+	case first = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("XREADGROUP was not woken up by XADD")
+	}
+	waitBlockedOn(t, "jobs", 1)
+	tc.do("XADD", "jobs", "2-0", "job", "b")
+	var second string
+	select {
+	case second = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second XREADGROUP was not woken up")
+	}
+	if !strings.Contains(first, "1-0") || !strings.Contains(second, "2-0") {
+		t.Fatalf("got %q and %q", first, second)
+	}
+	if got := tc.do("XPENDING", "jobs", "workers"); !strings.HasPrefix(got, "*4\r\n:2\r\n") {
+		t.Fatalf("XPENDING: %q", got)
+	}
+}
+
+// streamState 把 stream 的内容和消费组状态格式化出来, 用于比较持久化前后是否一致
+func streamState(t *testing.T, key string) string {
+	t.Helper()
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	s, err := streamForRead(dbs[0], key)
+	if err != "" || s == nil {
+		t.Fatalf("no stream %s: %s", key, err)
+	}
+	var b strings.Builder
+	for _, e := range s.entries {
+		fmt.Fprintf(&b, "%s %v\n", e.id, e.fields)
+	}
+	fmt.Fprintf(&b, "last %s\n", s.lastID)
+	for _, g := range s.sortedGroups() {
+		fmt.Fprintf(&b, "group %s %s\n", g.name, g.lastID)
+		for _, id := range sortedPEL(g.pel) {
+			nack := g.pel[id]
+			fmt.Fprintf(&b, "  %s %s %d %d\n", id, nack.consumer.name, nack.deliveryTime, nack.deliveryCount)
+		}
+		for _, sc := range g.sortedConsumers() {
+			fmt.Fprintf(&b, "  consumer %s %d\n", sc.name, len(sc.pel))
+		}
+	}
+	return b.String()
+}
+
+func TestStreamPersistence(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	for i := range 250 {
+		// 字段名不同的条目不能和节点的主条目共用字段名
+		f := "f"
+		if i%7 == 0 {
+			f = "other"
+		}
+		tc.do("XADD", "s", fmt.Sprintf("%d-%d", 1000+i/3, i%3), f, fmt.Sprint(i), "big", strings.Repeat("x", i*20))
+	}
+	tc.do("XDEL", "s", "1000-1", "1050-0")
+	tc.do("XGROUP", "CREATE", "s", "g1", "0")
+	tc.do("XGROUP", "CREATE", "s", "g2", "$")
+	tc.do("XREADGROUP", "GROUP", "g1", "c1", "COUNT", "3", "STREAMS", "s", ">")
+	tc.do("XREADGROUP", "GROUP", "g1", "c2", "COUNT", "2", "STREAMS", "s", ">")
+	tc.do("XGROUP", "CREATECONSUMER", "s", "g2", "idle")
+	tc.do("XADD", "empty", "MAXLEN", "0", "7-7", "a", "b")
+	want := streamState(t, "s")
+	wantEmpty := streamState(t, "empty")
+
+	check := func(how string) {
+		t.Helper()
+		if got := streamState(t, "s"); got != want {
+			t.Fatalf("after %s:\n%s\nwant:\n%s", how, got, want)
+		}
+		if got := streamState(t, "empty"); got != wantEmpty {
+			t.Fatalf("empty stream after %s:\n%s\nwant:\n%s", how, got, wantEmpty)
+		}
+	}
+
+	replayFromStart(t)
+	check("AOF replay")
+
+	dbMu.Lock()
+	o, _ := dbs[0].lookupKeyNoTouch("s")
+	cmds := append(rewriteObject("s", o, time.Time{}), rewriteObject("empty", mustLookup("empty"), time.Time{})...)
+	entriesAdded := o.stream().entriesAdded
+	dbMu.Unlock()
+	resetKeyspace()
+	for _, cmd := range cmds {
+		if got := tc.do(cmd...); strings.HasPrefix(got, "-") {
+			t.Fatalf("%q: %s", cmd, got)
+		}
+	}
+	check("rewrite")
+	dbMu.Lock()
+	if o, _ := dbs[0].lookupKeyNoTouch("s"); o.stream().entriesAdded != entriesAdded {
+		t.Errorf("entries added %d, want %d", o.stream().entriesAdded, entriesAdded)
+	}
+	dbMu.Unlock()
+
+	expectReply(t, tc, "+OK\r\n", "SAVE")
+	resetKeyspace()
+	if _, err := loadRDBFile(rdbPath); err != nil {
+		t.Fatal(err)
+	}
+	check("RDB load")
+
+	payload := tc.do("DUMP", "s")
+	payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+	tc.do("DEL", "s")
+	expectReply(t, tc, "+OK\r\n", "RESTORE", "s", "0", payload)
+	check("RESTORE")
+}
+
+func mustLookup(key string) *object {
+	o, _ := dbs[0].lookupKeyNoTouch(key)
+	return o
+}
+
+func TestListpackIntegers(t *testing.T) {
+	lp := newListpackWriter()
+	values := []string{"0", "127", "128", "-1", "4095", "-4096", "32767", "-32768", "8388607", "-8388608",
+		"2147483647", "-2147483648", "9223372036854775807", "-9223372036854775808", "007", "", strings.Repeat("s", 5000)}
+	for _, v := range values {
+		lp.appendString(v)
+	}
+	got, ok := listpackEntries(lp.bytes())
+	if !ok || fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Fatalf("got %q, ok=%v", got, ok)
+	}
+}
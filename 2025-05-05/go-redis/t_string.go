@@ -0,0 +1,489 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 写进 AOF 的字符串命令都是重放安全的: 相对过期时间换算成绝对的 PXAT / PEXPIREAT,
+// 结果依赖浮点运算的 INCRBYFLOAT 直接记录运算结果
+
+const maxStringLen = 512 * 1024 * 1024 // 同 Redis proto-max-bulk-len
+
+// stringForRead 取 key 对应的字符串, 类型不符返回 errWrongType
+func stringForRead(db *DB, key string) (*object, string) {
+	return db.lookupKeyType(key, typeString)
+}
+
+// setStringValue 修改字符串 key 的值, o 为 nil 时新建, 否则原地修改并保留过期时间
+func setStringValue(db *DB, key string, o *object, s string) {
+	if o == nil {
+		db.setKey(key, newStringObject(s))
+		return
+	}
+	o.val = s
+	db.signalModifiedKey(key)
+}
+
+// parseExpireOption 把 EX / PX / EXAT / PXAT 的参数换算成绝对毫秒时间戳
+func parseExpireOption(opt, arg, cmd string) (int64, string) {
+	n, ok := parseInt64(arg)
+	if !ok {
+		return 0, errNotInt
+	}
+	invalid := "ERR invalid expire time in '" + cmd + "' command"
+	if n <= 0 {
+		return 0, invalid
+	}
+	if opt == "EX" || opt == "EXAT" {
+		if n > math.MaxInt64/1000 {
+			return 0, invalid
+		}
+		n *= 1000
+	}
+	if opt == "EX" || opt == "PX" {
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return 0, invalid
+		}
+		n += now
+	}
+	return n, ""
+}
+
+// GET key
+func getCommand(c *client, args []string) {
+	w := c.w
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+	} else if o == nil {
+		w.writeNull()
+	} else {
+		w.writeBulk(o.str())
+	}
+}
+
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func setCommand(c *client, args []string) {
+	w := c.w
+	key, val := args[1], args[2]
+	var nx, xx, get, keepTTL bool
+	expireOpt := ""
+	var expireAt int64
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expireOpt != "" || i+1 == len(args) {
+				w.writeError(errSyntax)
+				return
+			}
+			ms, err := parseExpireOption(opt, args[i+1], "set")
+			if err != "" {
+				w.writeError(err)
+				return
+			}
+			expireOpt, expireAt = opt, ms
+			i++
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && expireOpt != "") {
+		w.writeError(errSyntax)
+		return
+	}
+
+	// 没有 GET 时可以覆盖任何类型的值, NX / XX 也只看 key 是否存在
+	old, err := stringForRead(c.db, key)
+	if err != "" && get {
+		w.writeError(err)
+		return
+	}
+	_, exists := c.db.store.Get(key)
+	if (nx && exists) || (xx && !exists) {
+		if get && old != nil {
+			w.writeBulk(old.str())
+		} else {
+			w.writeNull()
+		}
+		return
+	}
+
+	at, hadTTL := c.db.expires.Get(key)
+	c.db.setKey(key, newStringObject(val))
+	aof := []string{"SET", key, val}
+	switch {
+	case expireOpt != "":
+		c.db.setExpireAt(key, expireAt)
+		aof = append(aof, "PXAT", strconv.FormatInt(expireAt, 10))
+	case keepTTL && hadTTL:
+		c.db.setExpire(key, at)
+		aof = append(aof, "KEEPTTL")
+	}
+	recordAOF(c.db, aof)
+
+	if !get {
+		w.writeOK()
+	} else if old != nil {
+		w.writeBulk(old.str())
+	} else {
+		w.writeNull()
+	}
+}
+
+// SETNX key value
+func setnxCommand(c *client, args []string) {
+	w := c.w
+	if _, ok := c.db.lookupKey(args[1]); ok {
+		w.writeInt(0)
+		return
+	}
+	c.db.setKey(args[1], newStringObject(args[2]))
+	recordAOF(c.db, []string{"SET", args[1], args[2]})
+	w.writeInt(1)
+}
+
+// GETDEL key
+func getdelCommand(c *client, args []string) {
+	w := c.w
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeNull()
+		return
+	}
+	c.db.removeKey(args[1])
+	recordAOF(c.db, []string{"DEL", args[1]})
+	w.writeBulk(o.str())
+}
+
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func getexCommand(c *client, args []string) {
+	w := c.w
+	key := args[1]
+	expireOpt := ""
+	var expireAt int64
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case expireOpt != "":
+			w.writeError(errSyntax)
+			return
+		case opt == "PERSIST":
+			expireOpt = opt
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") && i+1 < len(args):
+			ms, err := parseExpireOption(opt, args[i+1], "getex")
+			if err != "" {
+				w.writeError(err)
+				return
+			}
+			expireOpt, expireAt = opt, ms
+			i++
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+
+	o, err := stringForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeNull()
+		return
+	}
+	switch expireOpt {
+	case "":
+	case "PERSIST":
+		if c.db.removeExpire(key) {
+			c.db.signalModifiedKey(key)
+			recordAOF(c.db, []string{"PERSIST", key})
+		}
+	default:
+		c.db.setExpireAt(key, expireAt)
+		if _, ok := c.db.store.Get(key); ok {
+			recordAOF(c.db, []string{"PEXPIREAT", key, strconv.FormatInt(expireAt, 10)})
+		} else {
+			recordAOF(c.db, []string{"DEL", key})
+		}
+	}
+	w.writeBulk(o.str())
+}
+
+// MGET key [key ...], 不是字符串的 key 当作不存在
+func mgetCommand(c *client, args []string) {
+	w := c.w
+	w.writeArray(len(args) - 1)
+	for _, key := range args[1:] {
+		if o, ok := c.db.lookupKey(key); ok && o.typ == typeString {
+			w.writeBulk(o.str())
+		} else {
+			w.writeNull()
+		}
+	}
+}
+
+// MSET key value [key value ...] / MSETNX key value [key value ...]
+// MSETNX 只要有一个 key 已存在就什么都不写
+func msetCommand(c *client, args []string, nx bool) {
+	w := c.w
+	if len(args) < 3 || len(args)%2 == 0 {
+		w.writeError(errWrongArgs(strings.ToUpper(args[0])))
+		return
+	}
+	if nx {
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := c.db.lookupKey(args[i]); ok {
+				w.writeInt(0)
+				return
+			}
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		c.db.setKey(args[i], newStringObject(args[i+1]))
+	}
+	// 条件已经检查过, 重放时统一按 MSET 执行
+	recordAOF(c.db, append([]string{"MSET"}, args[1:]...))
+	if nx {
+		w.writeInt(1)
+	} else {
+		w.writeOK()
+	}
+}
+
+// incrDecrCommand 实现 INCR / DECR / INCRBY / DECRBY
+func incrDecrCommand(c *client, args []string, incr int64) {
+	w := c.w
+	key := args[1]
+	o, err := stringForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var cur int64
+	if o != nil {
+		var ok bool
+		if cur, ok = parseInt64(o.str()); !ok {
+			w.writeError(errNotInt)
+			return
+		}
+	}
+	if (incr > 0 && cur > math.MaxInt64-incr) || (incr < 0 && cur < math.MinInt64-incr) {
+		w.writeError(errOverflow)
+		return
+	}
+	cur += incr
+	setStringValue(c.db, key, o, strconv.FormatInt(cur, 10))
+	dirty++
+	w.writeInt(cur)
+}
+
+// INCR key / DECR key / INCRBY key increment / DECRBY key decrement
+func incrCommand(c *client, args []string) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	var incr int64 = 1
+	switch name {
+	case "INCR", "DECR":
+		if len(args) != 2 {
+			w.writeError(errWrongArgs(name))
+			return
+		}
+	default:
+		if len(args) != 3 {
+			w.writeError(errWrongArgs(name))
+			return
+		}
+		var ok bool
+		if incr, ok = parseInt64(args[2]); !ok {
+			w.writeError(errNotInt)
+			return
+		}
+	}
+	if name == "DECR" || name == "DECRBY" {
+		if incr == math.MinInt64 {
+			w.writeError("ERR decrement would overflow")
+			return
+		}
+		incr = -incr
+	}
+	incrDecrCommand(c, args, incr)
+}
+
+// INCRBYFLOAT key increment
+func incrbyfloatCommand(c *client, args []string) {
+	w := c.w
+	key := args[1]
+	incr, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		w.writeError("ERR value is not a valid float")
+		return
+	}
+	o, errStr := stringForRead(c.db, key)
+	if errStr != "" {
+		w.writeError(errStr)
+		return
+	}
+	var cur float64
+	if o != nil {
+		cur, err = strconv.ParseFloat(o.str(), 64)
+		if err != nil || math.IsNaN(cur) || math.IsInf(cur, 0) {
+			w.writeError("ERR value is not a valid float")
+			return
+		}
+	}
+	cur += incr
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		w.writeError("ERR increment would produce NaN or Infinity")
+		return
+	}
+	s := strconv.FormatFloat(cur, 'f', -1, 64)
+	setStringValue(c.db, key, o, s)
+	// 浮点运算结果可能因平台而异, AOF 里直接记录结果
+	recordAOF(c.db, []string{"SET", key, s, "KEEPTTL"})
+	w.writeBulk(s)
+}
+
+// APPEND key value
+func appendCommand(c *client, args []string) {
+	w := c.w
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		setStringValue(c.db, args[1], nil, args[2])
+		dirty++
+		w.writeInt(int64(len(args[2])))
+		return
+	}
+	cur := o.str()
+	if len(cur)+len(args[2]) > maxStringLen {
+		w.writeError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+		return
+	}
+	// 第一次 APPEND 时换成 Builder, 之后原地追加, 不再每次复制整个值
+	b, ok := o.val.(*strings.Builder)
+	if !ok {
+		b = &strings.Builder{}
+		b.Grow(len(cur) + len(args[2]))
+		b.WriteString(cur)
+		o.val = b
+	}
+	b.WriteString(args[2])
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeInt(int64(b.Len()))
+}
+
+// STRLEN key
+func strlenCommand(c *client, args []string) {
+	w := c.w
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(len(o.str())))
+}
+
+// GETRANGE key start end, 负数下标从末尾数起
+func getrangeCommand(c *client, args []string) {
+	w := c.w
+	start, ok1 := parseInt64(args[2])
+	end, ok2 := parseInt64(args[3])
+	if !ok1 || !ok2 {
+		w.writeError(errNotInt)
+		return
+	}
+	o, err := stringForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		w.writeBulk("")
+		return
+	}
+	s := o.str()
+	n := int64(len(s))
+	if start < 0 && end < 0 && start > end {
+		w.writeBulk("")
+		return
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		w.writeBulk("")
+		return
+	}
+	w.writeBulk(s[start : end+1])
+}
+
+// SETRANGE key offset value, 不足的部分用 \x00 补齐
+func setrangeCommand(c *client, args []string) {
+	w := c.w
+	offset, ok := parseInt64(args[2])
+	if !ok {
+		w.writeError(errNotInt)
+		return
+	}
+	if offset < 0 {
+		w.writeError("ERR offset is out of range")
+		return
+	}
+	key, val := args[1], args[3]
+	o, err := stringForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	cur := ""
+	if o != nil {
+		cur = o.str()
+	}
+	if val == "" {
+		// 空值不创建 key, 也不改变原值
+		w.writeInt(int64(len(cur)))
+		return
+	}
+	if offset+int64(len(val)) > maxStringLen {
+		w.writeError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+		return
+	}
+	b := []byte(cur)
+	if need := int(offset) + len(val); need > len(b) {
+		b = append(b, make([]byte, need-len(b))...)
+	}
+	copy(b[offset:], val)
+	setStringValue(c.db, key, o, string(b))
+	dirty++
+	w.writeInt(int64(len(b)))
+}
@@ -0,0 +1,212 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetOptions(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, "$-1\r\n", "SET", "k", "v1", "XX")
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v1", "NX")
+	expectReply(t, tc, "$-1\r\n", "SET", "k", "v2", "NX")
+	expectReply(t, tc, "$2\r\nv1\r\n", "SET", "k", "v2", "XX", "GET")
+	expectReply(t, tc, "$2\r\nv2\r\n", "SET", "k", "v3", "NX", "GET")
+	expectReply(t, tc, "$2\r\nv2\r\n", "GET", "k")
+
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v", "EX", "100")
+	expectReply(t, tc, ":100\r\n", "TTL", "k")
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v", "KEEPTTL")
+	expectReply(t, tc, ":100\r\n", "TTL", "k")
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v")
+	expectReply(t, tc, ":-1\r\n", "TTL", "k")
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v", "PX", "5000")
+	expectReply(t, tc, ":5\r\n", "TTL", "k")
+	at := time.Now().Add(time.Hour).Unix()
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v", "EXAT", strconv.FormatInt(at, 10))
+	expectReply(t, tc, ":"+strconv.FormatInt(at, 10)+"\r\n", "EXPIRETIME", "k")
+	expectReply(t, tc, "+OK\r\n", "SET", "k", "v", "PXAT", "1")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "k")
+
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "SET", "k", "v", "NX", "XX")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "SET", "k", "v", "EX", "10", "PX", "10")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "SET", "k", "v", "EX", "10", "KEEPTTL")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "SET", "k", "v", "EX")
+	expectReply(t, tc, "-ERR invalid expire time in 'set' command\r\n", "SET", "k", "v", "EX", "0")
+	expectReply(t, tc, "-"+errNotInt+"\r\n", "SET", "k", "v", "EX", "ten")
+
+	// 没有 GET 时可以覆盖其他类型, 有 GET 时报类型错误
+	tc.do("RPUSH", "l", "a")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "SET", "l", "v", "GET")
+	expectReply(t, tc, "$-1\r\n", "SET", "l", "v", "NX")
+	expectReply(t, tc, "+OK\r\n", "SET", "l", "v")
+	expectReply(t, tc, "+string\r\n", "TYPE", "l")
+}
+
+func TestStringCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":1\r\n", "SETNX", "k", "a")
+	expectReply(t, tc, ":0\r\n", "SETNX", "k", "b")
+	expectReply(t, tc, ":3\r\n", "APPEND", "k", "bc")
+	expectReply(t, tc, ":3\r\n", "STRLEN", "k")
+	expectReply(t, tc, ":0\r\n", "STRLEN", "missing")
+
+	tc.do("SET", "s", "This is a string")
+	expectReply(t, tc, "$4\r\nThis\r\n", "GETRANGE", "s", "0", "3")
+	expectReply(t, tc, "$3\r\ning\r\n", "GETRANGE", "s", "-3", "-1")
+	expectReply(t, tc, "$16\r\nThis is a string\r\n", "GETRANGE", "s", "0", "-1")
+	expectReply(t, tc, "$6\r\nstring\r\n", "GETRANGE", "s", "10", "100")
+	expectReply(t, tc, "$0\r\n\r\n", "GETRANGE", "s", "5", "3")
+	expectReply(t, tc, "$0\r\n\r\n", "GETRANGE", "s", "-1", "-5")
+
+	tc.do("SET", "r", "Hello World")
+	expectReply(t, tc, ":11\r\n", "SETRANGE", "r", "6", "Redis")
+	expectReply(t, tc, "$11\r\nHello Redis\r\n", "GET", "r")
+	expectReply(t, tc, ":8\r\n", "SETRANGE", "pad", "5", "abc")
+	expectReply(t, tc, "$8\r\n\x00\x00\x00\x00\x00abc\r\n", "GET", "pad")
+	expectReply(t, tc, ":0\r\n", "SETRANGE", "empty", "5", "")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "empty")
+	expectReply(t, tc, "-ERR offset is out of range\r\n", "SETRANGE", "r", "-1", "x")
+
+	expectReply(t, tc, "+OK\r\n", "MSET", "a", "1", "b", "2")
+	expectReply(t, tc, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n", "MGET", "a", "missing", "b")
+	expectReply(t, tc, ":0\r\n", "MSETNX", "a", "x", "c", "3")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "c")
+	expectReply(t, tc, ":1\r\n", "MSETNX", "c", "3", "d", "4")
+	expectReply(t, tc, "-"+errWrongArgs("MSET")+"\r\n", "MSET", "a", "1", "b")
+
+	expectReply(t, tc, "$1\r\n1\r\n", "GETDEL", "a")
+	expectReply(t, tc, "$-1\r\n", "GETDEL", "a")
+
+	tc.do("SET", "e", "v")
+	expectReply(t, tc, "$1\r\nv\r\n", "GETEX", "e", "EX", "100")
+	expectReply(t, tc, ":100\r\n", "TTL", "e")
+	expectReply(t, tc, "$1\r\nv\r\n", "GETEX", "e", "PERSIST")
+	expectReply(t, tc, ":-1\r\n", "TTL", "e")
+	expectReply(t, tc, "$1\r\nv\r\n", "GETEX", "e", "PXAT", "1")
+	expectReply(t, tc, ":0\r\n", "EXISTS", "e")
+	expectReply(t, tc, "-"+errSyntax+"\r\n", "GETEX", "e", "EX", "1", "PERSIST")
+}
+
+func TestAppendInPlace(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":2\r\n", "APPEND", "k", "12")
+	expectReply(t, tc, "$3\r\nint\r\n", "OBJECT", "ENCODING", "k")
+	expectReply(t, tc, ":4\r\n", "APPEND", "k", "34")
+	expectReply(t, tc, "$3\r\nraw\r\n", "OBJECT", "ENCODING", "k")
+
+	dbMu.Lock()
+	o, _ := tc.client.db.lookupKeyNoTouch("k")
+	b, ok := o.val.(*strings.Builder)
+	before, snapshot := o.str(), o.dup()
+	dbMu.Unlock()
+	if !ok {
+		t.Fatalf("APPEND did not switch to an in-place buffer: %T", o.val)
+	}
+	for range 1000 {
+		tc.do("APPEND", "k", "x")
+	}
+	dbMu.Lock()
+	same := o.val == any(b)
+	dbMu.Unlock()
+	// 追加一直写同一个 Builder, 之前取出的值和副本都不受影响
+	if !same {
+		t.Fatal("APPEND replaced the buffer")
+	}
+	if before != "1234" || snapshot.str() != "1234" {
+		t.Fatalf("earlier value changed: %q, %q", before, snapshot.str())
+	}
+	expectReply(t, tc, ":1004\r\n", "STRLEN", "k")
+	expectReply(t, tc, "$3\r\n4xx\r\n", "GETRANGE", "k", "3", "5")
+
+	// SET 之后回到普通字符串
+	tc.do("SET", "k", "v")
+	expectReply(t, tc, ":2\r\n", "APPEND", "k", "w")
+	expectReply(t, tc, "$2\r\nvw\r\n", "GET", "k")
+}
+
+func TestIncrDecr(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	expectReply(t, tc, ":1\r\n", "INCR", "n")
+	expectReply(t, tc, ":11\r\n", "INCRBY", "n", "10")
+	expectReply(t, tc, ":10\r\n", "DECR", "n")
+	expectReply(t, tc, ":-5\r\n", "DECRBY", "n", "15")
+	tc.do("EXPIRE", "n", "100")
+	tc.do("INCR", "n")
+	expectReply(t, tc, ":100\r\n", "TTL", "n")
+
+	tc.do("SET", "max", "9223372036854775807")
+	expectReply(t, tc, "-"+errOverflow+"\r\n", "INCR", "max")
+	expectReply(t, tc, "-ERR decrement would overflow\r\n", "DECRBY", "n", "-9223372036854775808")
+	tc.do("SET", "s", "abc")
+	expectReply(t, tc, "-"+errNotInt+"\r\n", "INCR", "s")
+	tc.do("SET", "s", " 1")
+	expectReply(t, tc, "-"+errNotInt+"\r\n", "INCR", "s")
+	tc.do("RPUSH", "l", "a")
+	expectReply(t, tc, "-"+errWrongType+"\r\n", "INCR", "l")
+
+	expectReply(t, tc, "$4\r\n10.5\r\n", "INCRBYFLOAT", "f", "10.5")
+	expectReply(t, tc, "$3\r\n5.5\r\n", "INCRBYFLOAT", "f", "-5")
+	tc.do("SET", "f", "5.0e3")
+	expectReply(t, tc, "$4\r\n5200\r\n", "INCRBYFLOAT", "f", "2.0e2")
+	expectReply(t, tc, "-ERR value is not a valid float\r\n", "INCRBYFLOAT", "f", "abc")
+	expectReply(t, tc, "-ERR value is not a valid float\r\n", "INCRBYFLOAT", "s", "1")
+	tc.do("SET", "big", "1.7e308")
+	expectReply(t, tc, "-ERR increment would produce NaN or Infinity\r\n", "INCRBYFLOAT", "big", "1.7e308")
+}
+
+func TestStringAOFReplay(t *testing.T) {
+	resetKeyspace()
+	aofFile.Truncate(0)
+	tc := newTestClient()
+	tc.do("SET", "ex", "v", "EX", "100")
+	tc.do("SET", "keep", "v", "PX", "100000")
+	tc.do("SET", "keep", "v2", "KEEPTTL")
+	tc.do("INCRBYFLOAT", "f", "0.1")
+	tc.do("INCRBYFLOAT", "f", "0.2")
+	tc.do("MSETNX", "m1", "a", "m2", "b")
+	tc.do("SETNX", "nx", "v")
+	tc.do("GETEX", "nx", "EX", "200")
+	tc.do("GETDEL", "m1")
+	tc.do("SETRANGE", "r", "2", "xy")
+	tc.do("APPEND", "r", "z")
+	flushAOFAll()
+
+	f, err := os.Open(aofPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	for _, relative := range []string{"$2\r\nEX\r\n", "$2\r\nPX\r\n", "INCRBYFLOAT", "MSETNX", "SETNX", "GETEX"} {
+		if bytes.Contains(data, []byte(relative)) {
+			t.Fatalf("AOF contains %q: %q", relative, data)
+		}
+	}
+
+	want := make(map[string]string)
+	ttls := make(map[string]int64)
+	for _, key := range []string{"ex", "keep", "f", "m1", "m2", "nx", "r"} {
+		want[key] = tc.do("GET", key)
+		at, _ := dbs[0].expires.Get(key)
+		ttls[key] = at.UnixMilli()
+	}
+	replayFromStart(t)
+	for key, v := range want {
+		if got := tc.do("GET", key); got != v {
+			t.Fatalf("%s after replay: got %q, want %q", key, got, v)
+		}
+		if at, _ := dbs[0].expires.Get(key); at.UnixMilli() != ttls[key] {
+			t.Fatalf("%s deadline changed across replay", key)
+		}
+	}
+}
@@ -0,0 +1,667 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// zset = 跳表 + 字典: 字典 O(1) 按 member 查分数, 跳表负责按分数排序和范围查询
+type zset struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+func newZSetObject() *object {
+	return &object{typ: typeZSet, val: &zset{dict: make(map[string]float64), zsl: newZskiplist()}}
+}
+
+func (z *zset) len() int { return len(z.dict) }
+
+// ZADD 的条件标志
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddINCR
+)
+
+// add 按 flags 添加或更新 member, 返回最终分数, 以及是否新增 / 是否修改了分数;
+// 条件不满足时 ok 为 false。incr 结果为 NaN 时 nan 为 true
+func (z *zset) add(member string, score float64, flags int) (newScore float64, added, updated, ok, nan bool) {
+	cur, exists := z.dict[member]
+	if exists {
+		if flags&zaddNX != 0 {
+			return cur, false, false, false, false
+		}
+		if flags&zaddINCR != 0 {
+			score += cur
+			if math.IsNaN(score) {
+				return 0, false, false, false, true
+			}
+		}
+		if (flags&zaddGT != 0 && score <= cur) || (flags&zaddLT != 0 && score >= cur) {
+			return cur, false, false, false, false
+		}
+		if score != cur {
+			z.zsl.updateScore(cur, member, score)
+			z.dict[member] = score
+			return score, false, true, true, false
+		}
+		return score, false, false, true, false
+	}
+	if flags&zaddXX != 0 {
+		return 0, false, false, false, false
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return score, true, false, true, false
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// rank 返回 member 从 0 开始的排名, reverse 时按分数从大到小
+func (z *zset) rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	r := z.zsl.getRank(score, member)
+	if reverse {
+		return z.len() - r, true
+	}
+	return r - 1, true
+}
+
+func zsetForRead(db *DB, key string) (*zset, string) {
+	o, err := db.lookupKeyType(key, typeZSet)
+	if err != "" || o == nil {
+		return nil, err
+	}
+	return o.zset(), ""
+}
+
+// parseScore 解析分数, 支持 inf / +inf / -inf
+func parseScore(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseRangeItem 解析分数区间的一端, "(" 前缀表示开区间
+func parseRangeItem(s string) (float64, bool, bool) {
+	if strings.HasPrefix(s, "(") {
+		f, ok := parseScore(s[1:])
+		return f, true, ok
+	}
+	f, ok := parseScore(s)
+	return f, false, ok
+}
+
+func parseRange(min, max string) (*zrangeSpec, bool) {
+	r := &zrangeSpec{}
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseRangeItem(min)
+	r.max, r.maxex, ok2 = parseRangeItem(max)
+	return r, ok1 && ok2
+}
+
+// parseLexRange 解析字典序区间: "[a" 闭、"(a" 开、"-" 负无穷、"+" 正无穷
+func parseLexRange(min, max string) (*zlexRangeSpec, bool) {
+	r := &zlexRangeSpec{}
+	item := func(s string, minSide bool) bool {
+		switch {
+		case s == "-":
+			if minSide {
+				r.minInf = true
+			} else {
+				r.empty = true
+			}
+		case s == "+":
+			if minSide {
+				r.empty = true
+			} else {
+				r.maxInf = true
+			}
+		case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "("):
+			if minSide {
+				r.min, r.minex = s[1:], s[0] == '('
+			} else {
+				r.max, r.maxex = s[1:], s[0] == '('
+			}
+		default:
+			return false
+		}
+		return true
+	}
+	return r, item(min, true) && item(max, false)
+}
+
+// writeScored 写带分数的结果: RESP2 是平铺的 [m1, s1, m2, s2...], RESP3 是 [[m1, s1], ...]
+func writeScored(w *replyWriter, nodes []*zskiplistNode, withScores bool) {
+	if !withScores {
+		w.writeArray(len(nodes))
+		for _, n := range nodes {
+			w.writeBulk(n.member)
+		}
+		return
+	}
+	if w.proto == resp3 {
+		w.writeArray(len(nodes))
+		for _, n := range nodes {
+			w.writeArray(2)
+			w.writeBulk(n.member)
+			w.writeDouble(n.score)
+		}
+		return
+	}
+	w.writeArray(2 * len(nodes))
+	for _, n := range nodes {
+		w.writeBulk(n.member)
+		w.writeDouble(n.score)
+	}
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zaddCommand(c *client, args []string) {
+	w := c.w
+	flags, ch := 0, false
+	i := 2
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			ch = true
+		case "INCR":
+			flags |= zaddINCR
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		w.writeError(errSyntax)
+		return
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		w.writeError("ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if (flags&zaddGT != 0 && flags&zaddNX != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) ||
+		(flags&zaddGT != 0 && flags&zaddLT != 0) {
+		w.writeError("ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if flags&zaddINCR != 0 && len(pairs) > 2 {
+		w.writeError("ERR INCR option supports a single increment-element pair")
+		return
+	}
+	// 先把分数全部解析完, 出错时不做任何修改
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		f, ok := parseScore(pairs[j])
+		if !ok {
+			w.writeError("ERR value is not a valid float")
+			return
+		}
+		scores = append(scores, f)
+	}
+
+	o, err := c.db.lookupKeyType(args[1], typeZSet)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		if flags&zaddXX != 0 {
+			// XX 不会创建新 key
+			if flags&zaddINCR != 0 {
+				w.writeNull()
+			} else {
+				w.writeInt(0)
+			}
+			return
+		}
+		o = newZSetObject()
+		c.db.setKey(args[1], o)
+	}
+	z := o.zset()
+
+	added, changed := 0, 0
+	var last float64
+	var lastOK bool
+	for j, score := range scores {
+		s, isNew, isUpdated, ok, nan := z.add(pairs[2*j+1], score, flags)
+		if nan {
+			if z.len() == 0 {
+				c.db.removeKey(args[1])
+			}
+			w.writeError("ERR resulting score is not a number (NaN)")
+			return
+		}
+		if isNew {
+			added++
+		}
+		if isUpdated {
+			changed++
+		}
+		last, lastOK = s, ok
+	}
+	if z.len() == 0 {
+		c.db.removeKey(args[1])
+	}
+	if added+changed > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+
+	if flags&zaddINCR != 0 {
+		if lastOK {
+			w.writeDouble(last)
+		} else {
+			w.writeNull()
+		}
+		return
+	}
+	if ch {
+		w.writeInt(int64(added + changed))
+	} else {
+		w.writeInt(int64(added))
+	}
+}
+
+// ZINCRBY key increment member
+func zincrbyCommand(c *client, args []string) {
+	w := c.w
+	incr, ok := parseScore(args[2])
+	if !ok {
+		w.writeError("ERR value is not a valid float")
+		return
+	}
+	o, err := c.db.lookupKeyType(args[1], typeZSet)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if o == nil {
+		o = newZSetObject()
+		c.db.setKey(args[1], o)
+	}
+	z := o.zset()
+	score, _, _, _, nan := z.add(args[3], incr, zaddINCR)
+	if nan {
+		if z.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+		w.writeError("ERR resulting score is not a number (NaN)")
+		return
+	}
+	c.db.signalModifiedKey(args[1])
+	dirty++
+	w.writeDouble(score)
+}
+
+// ZREM key member [member ...]
+func zremCommand(c *client, args []string) {
+	w := c.w
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	removed := 0
+	if z != nil {
+		for _, m := range args[2:] {
+			if z.remove(m) {
+				removed++
+			}
+		}
+		if z.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+	}
+	if removed > 0 {
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	w.writeInt(int64(removed))
+}
+
+// ZCARD key
+func zcardCommand(c *client, args []string) {
+	w := c.w
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if z == nil {
+		w.writeInt(0)
+		return
+	}
+	w.writeInt(int64(z.len()))
+}
+
+// ZSCORE key member
+func zscoreCommand(c *client, args []string) {
+	w := c.w
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if z == nil {
+		w.writeNull()
+		return
+	}
+	if score, ok := z.dict[args[2]]; ok {
+		w.writeDouble(score)
+	} else {
+		w.writeNull()
+	}
+}
+
+// ZRANK / ZREVRANK key member [WITHSCORE]
+func zrankCommand(c *client, args []string, reverse bool) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	if len(args) != 3 && len(args) != 4 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	withScore := false
+	if len(args) == 4 {
+		if strings.ToUpper(args[3]) != "WITHSCORE" {
+			w.writeError(errSyntax)
+			return
+		}
+		withScore = true
+	}
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var rank int
+	ok := false
+	if z != nil {
+		rank, ok = z.rank(args[2], reverse)
+	}
+	if !ok {
+		if withScore {
+			w.writeNullArray()
+		} else {
+			w.writeNull()
+		}
+		return
+	}
+	if withScore {
+		w.writeArray(2)
+		w.writeInt(int64(rank))
+		w.writeDouble(z.dict[args[2]])
+		return
+	}
+	w.writeInt(int64(rank))
+}
+
+// ZCOUNT key min max
+func zcountCommand(c *client, args []string) {
+	w := c.w
+	r, ok := parseRange(args[2], args[3])
+	if !ok {
+		w.writeError("ERR min or max is not a float")
+		return
+	}
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if z == nil {
+		w.writeInt(0)
+		return
+	}
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		w.writeInt(0)
+		return
+	}
+	last := z.zsl.lastInRange(r)
+	count := z.zsl.getRank(last.score, last.member) - z.zsl.getRank(first.score, first.member) + 1
+	w.writeInt(int64(count))
+}
+
+// zrange 的取值方式
+const (
+	zrangeRank = iota
+	zrangeScore
+	zrangeLex
+)
+
+// zrangeGeneric 实现 ZRANGE 以及 ZREVRANGE / ZRANGEBYSCORE / ZRANGEBYLEX 等旧命令。
+// args 从 key 开始; by/rev 是旧命令名隐含的模式, ZRANGE 本身由选项决定
+func zrangeGeneric(c *client, name string, args []string, by int, rev bool) {
+	w := c.w
+	if len(args) < 3 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	key, min, max := args[0], args[1], args[2]
+	withScores := false
+	offset, limit := int64(0), int64(-1)
+	hasLimit := false
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "WITHSCORES":
+			withScores = true
+		case opt == "LIMIT" && i+2 < len(args):
+			o, ok1 := parseInt64(args[i+1])
+			l, ok2 := parseInt64(args[i+2])
+			if !ok1 || !ok2 {
+				w.writeError(errNotInt)
+				return
+			}
+			offset, limit, hasLimit = o, l, true
+			i += 2
+		case opt == "BYSCORE" && name == "ZRANGE" && by == zrangeRank:
+			by = zrangeScore
+		case opt == "BYLEX" && name == "ZRANGE" && by == zrangeRank:
+			by = zrangeLex
+		case opt == "REV" && name == "ZRANGE":
+			rev = true
+		default:
+			w.writeError(errSyntax)
+			return
+		}
+	}
+	if hasLimit && by == zrangeRank {
+		w.writeError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		return
+	}
+	if withScores && by == zrangeLex {
+		w.writeError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+		return
+	}
+	// 倒序按分数/字典序取时, 参数顺序是 max min
+	if rev && by != zrangeRank {
+		min, max = max, min
+	}
+
+	var (
+		sr    *zrangeSpec
+		lr    *zlexRangeSpec
+		start int64
+		stop  int64
+	)
+	switch by {
+	case zrangeRank:
+		var ok1, ok2 bool
+		start, ok1 = parseInt64(min)
+		stop, ok2 = parseInt64(max)
+		if !ok1 || !ok2 {
+			w.writeError(errNotInt)
+			return
+		}
+	case zrangeScore:
+		var ok bool
+		if sr, ok = parseRange(min, max); !ok {
+			w.writeError("ERR min or max is not a float")
+			return
+		}
+	case zrangeLex:
+		var ok bool
+		if lr, ok = parseLexRange(min, max); !ok {
+			w.writeError("ERR min or max not valid string range item")
+			return
+		}
+	}
+
+	z, err := zsetForRead(c.db, key)
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	if z == nil {
+		w.writeArray(0)
+		return
+	}
+
+	var nodes []*zskiplistNode
+	if by == zrangeRank {
+		s, e, ok := normalizeRange(start, stop, z.len())
+		if ok {
+			// 倒序时第 i 名对应正序的 len-1-i
+			var x *zskiplistNode
+			if rev {
+				x = z.zsl.byRank(z.len() - s)
+			} else {
+				x = z.zsl.byRank(s + 1)
+			}
+			for i := s; i <= e && x != nil; i++ {
+				nodes = append(nodes, x)
+				if rev {
+					x = x.backward
+				} else {
+					x = x.level[0].forward
+				}
+			}
+		}
+		writeScored(w, nodes, withScores)
+		return
+	}
+
+	var x *zskiplistNode
+	inRange := func(n *zskiplistNode) bool {
+		if by == zrangeScore {
+			return sr.gteMin(n.score) && sr.lteMax(n.score)
+		}
+		return lr.gteMin(n.member) && lr.lteMax(n.member)
+	}
+	switch {
+	case by == zrangeScore && rev:
+		x = z.zsl.lastInRange(sr)
+	case by == zrangeScore:
+		x = z.zsl.firstInRange(sr)
+	case rev:
+		x = z.zsl.lastInLexRange(lr)
+	default:
+		x = z.zsl.firstInLexRange(lr)
+	}
+	if offset < 0 {
+		x = nil
+	}
+	for ; x != nil && offset > 0; offset-- {
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	for x != nil && limit != 0 && inRange(x) {
+		nodes = append(nodes, x)
+		limit--
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	writeScored(w, nodes, withScores)
+}
+
+// ZPOPMIN / ZPOPMAX key [count]
+func zpopCommand(c *client, args []string, max bool) {
+	w := c.w
+	name := strings.ToUpper(args[0])
+	if len(args) != 2 && len(args) != 3 {
+		w.writeError(errWrongArgs(name))
+		return
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		n, ok := parseInt64(args[2])
+		if !ok || n < 0 {
+			w.writeError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	z, err := zsetForRead(c.db, args[1])
+	if err != "" {
+		w.writeError(err)
+		return
+	}
+	var nodes []*zskiplistNode
+	for z != nil && int64(len(nodes)) < count && z.len() > 0 {
+		var x *zskiplistNode
+		if max {
+			x = z.zsl.tail
+		} else {
+			x = z.zsl.header.level[0].forward
+		}
+		nodes = append(nodes, x)
+		z.remove(x.member)
+	}
+	if len(nodes) > 0 {
+		if z.len() == 0 {
+			c.db.removeKey(args[1])
+		}
+		c.db.signalModifiedKey(args[1])
+		dirty++
+	}
+	if len(args) == 2 {
+		// 不带 count 时 RESP3 下也是平铺的 [member, score]
+		w.writeArray(2 * len(nodes))
+		for _, n := range nodes {
+			w.writeBulk(n.member)
+			w.writeDouble(n.score)
+		}
+		return
+	}
+	writeScored(w, nodes, true)
+}
@@ -0,0 +1,47 @@
+package redis
+
+import "testing"
+
+func TestZSetCommands(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+
+	expectReply(t, tc, ":3\r\n", "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	expectReply(t, tc, ":0\r\n", "ZADD", "z", "NX", "10", "a")
+	expectReply(t, tc, ":1\r\n", "ZADD", "z", "GT", "CH", "5", "a", "0", "b")
+	expectReply(t, tc, "$-1\r\n", "ZADD", "z", "XX", "INCR", "1", "nope")
+	expectReply(t, tc, "$3\r\n6.5\r\n", "ZADD", "z", "INCR", "1.5", "a")
+	expectReply(t, tc, "$1\r\n4\r\n", "ZINCRBY", "z", "2", "b")
+	// c=3 b=4 a=6.5
+	expectReply(t, tc, ":0\r\n", "ZRANK", "z", "c")
+	expectReply(t, tc, ":0\r\n", "ZREVRANK", "z", "a")
+	expectReply(t, tc, ":2\r\n", "ZCOUNT", "z", "(3", "+inf")
+	expectReply(t, tc, "*4\r\n$1\r\nb\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n",
+		"ZRANGE", "z", "(6.5", "-inf", "BYSCORE", "REV", "WITHSCORES")
+	expectReply(t, tc, "*1\r\n$1\r\nb\r\n", "ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", "1", "1")
+	expectReply(t, tc, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "ZREVRANGE", "z", "0", "1")
+	expectReply(t, tc, "*2\r\n$1\r\nc\r\n$1\r\n3\r\n", "ZPOPMIN", "z")
+	expectReply(t, tc, "*2\r\n$1\r\na\r\n$3\r\n6.5\r\n", "ZPOPMAX", "z", "1")
+	expectReply(t, tc, ":1\r\n", "ZREM", "z", "b", "x")
+	expectReply(t, tc, ":0\r\n", "ZCARD", "z")
+
+	expectReply(t, tc, ":4\r\n", "ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+	expectReply(t, tc, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", "ZRANGE", "lex", "[b", "(d", "BYLEX")
+	expectReply(t, tc, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n", "ZREVRANGEBYLEX", "lex", "+", "-", "LIMIT", "0", "2")
+
+	// RESP3 下 WITHSCORES 返回 [member, score] 对
+	tc.w.proto = resp3
+	expectReply(t, tc, "*1\r\n*2\r\n$1\r\na\r\n,0\r\n", "ZRANGE", "lex", "0", "0", "WITHSCORES")
+}
+
+func TestZSetAOFReplay(t *testing.T) {
+	resetKeyspace()
+	tc := newTestClient()
+	tc.do("ZADD", "board", "10", "alice", "20", "bob", "30", "carol")
+	tc.do("ZINCRBY", "board", "15", "alice")
+	tc.do("ZPOPMAX", "board")
+	tc.do("ZREM", "board", "bob")
+
+	replayFromStart(t)
+	expectReply(t, tc, "*2\r\n$5\r\nalice\r\n$2\r\n25\r\n", "ZRANGE", "board", "0", "-1", "WITHSCORES")
+}
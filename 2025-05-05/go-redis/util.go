@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"strconv"
+)
+
+// globMatch 实现 Redis 的 glob 匹配 (stringmatchlen): * ? [abc] [^a] [a-z] 和 \ 转义
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	// 回溯点: 最近一个 * 的位置, 以及它当前吞到 s 的哪里
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starI = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if n, ok := matchClass(pattern[p:], s[i]); n > 0 && ok {
+					p += n
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// 让上一个 * 多吞一个字符再试
+		starI++
+		p, i = starP+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass 匹配 [...] 字符类, 返回字符类在 pattern 中的长度和是否匹配
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	not := false
+	if i < len(pattern) && pattern[i] == '^' {
+		not = true
+		i++
+	}
+	match := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == c {
+				match = true
+			}
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			i += 2
+		default:
+			if pattern[i] == c {
+				match = true
+			}
+		}
+	}
+	if i == len(pattern) {
+		// 没有闭合的 ], 按 Redis 的处理当作字符类一直延伸到结尾
+		return i, match != not
+	}
+	return i + 1, match != not
+}
+
+// parseInt64 解析命令参数中的整数, 不接受前导空格和 +
+func parseInt64(s string) (int64, bool) {
+	if len(s) == 0 || s[0] == '+' || s[0] == ' ' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}